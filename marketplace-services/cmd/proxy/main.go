@@ -1,14 +1,47 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"marketplace-services/pkg/proxy"
 	"os"
+	"time"
 )
 
+const newMasterKeyEnv = "PROXY_WALLET_NEW_MASTER_KEY"
+
 func main() {
 	configFile := flag.String("config", "./configs/proxy/config.json", "Config file")
+	rotateWalletKey := flag.Bool(
+		"rotate-wallet-key",
+		false,
+		"Re-seal all wallet passphrases with the master key in "+newMasterKeyEnv+" and exit",
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [migrate status|up [-to version]|down [-steps n]]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.Arg(0) == "migrate" {
+		if err := migrate(*configFile, flag.Args()[1:]); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *rotateWalletKey {
+		err := proxy.RotateWalletMasterKey(os.Getenv(newMasterKeyEnv), proxy.WithConfigFile(*configFile))
+		if err != nil {
+			fmt.Printf("%v", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Rotated wallet master key, configure the proxy with the new key\n")
+		return
+	}
+
 	p, err := proxy.New(proxy.WithConfigFile(*configFile))
 	if err != nil {
 		fmt.Printf("%v", err)
@@ -18,3 +51,57 @@ func main() {
 		fmt.Printf("%v", err)
 	}
 }
+
+func migrate(configFile string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing migrate command, expected status, up or down")
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	switch args[0] {
+	case "status":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		statuses, err := proxy.MigrationStatus(proxy.WithConfigFile(configFile))
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-30s %s\n", s.Version, s.Name, appliedAt)
+		}
+	case "up":
+		target := flags.Int("to", 0, "Version to migrate to, 0 applies all pending migrations")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *target < 0 {
+			return fmt.Errorf("invalid version %d, must not be negative", *target)
+		}
+		count, err := proxy.MigrateUp(*target, proxy.WithConfigFile(configFile))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", count)
+	case "down":
+		steps := flags.Int("steps", 1, "Number of migrations to roll back")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *steps < 1 {
+			return fmt.Errorf("invalid number of steps %d, must be positive", *steps)
+		}
+		count, err := proxy.MigrateDown(*steps, proxy.WithConfigFile(configFile))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back %d migrations\n", count)
+	default:
+		return fmt.Errorf("unknown migrate command %s, expected status, up or down", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+// Command oidc_issuer is a stand-in OpenID Connect provider for testing the proxy login locally. It
+// approves every authorization request for a single configured identity without asking for
+// credentials, so it must never be exposed.
+//
+// Start it, enable the oidcConfig of the proxy with the issuer, client id and secret below and call
+// BeginOidcLogin. Opening the returned URL redirects to the redirect URL with a code and state for
+// CompleteOidcLogin. GET /id-token returns an ID token for ExchangeOidcToken directly.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"github.com/dgrijalva/jwt-go"
+	logger "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const keyID = "stand-in"
+
+type authorization struct {
+	nonce       string
+	challenge   string
+	redirectURI string
+	expiresAt   time.Time
+}
+
+type issuer struct {
+	url          string
+	clientID     string
+	clientSecret string
+	subject      string
+	username     string
+	groups       []string
+	key          *ecdsa.PrivateKey
+	codes        map[string]*authorization
+	sync.Mutex
+}
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:25590", "Listen address")
+	clientID := flag.String("client-id", "proxy", "Client id of the proxy")
+	clientSecret := flag.String("client-secret", "proxy-secret", "Client secret of the proxy")
+	subject := flag.String("subject", "b6f1c1f4-7d65-4c1b-9a44-3f0e8d2a7c11", "Subject of the identity")
+	username := flag.String("username", "oidc-admin", "Preferred username of the identity")
+	groups := flag.String("groups", "proxy-admins", "Comma separated groups of the identity")
+	flag.Parse()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		logger.Fatalf("generate key: %+v", err)
+	}
+
+	i := &issuer{
+		url:          "http://" + *addr,
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		subject:      *subject,
+		username:     *username,
+		groups:       strings.Split(*groups, ","),
+		key:          key,
+		codes:        make(map[string]*authorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.handleDiscovery)
+	mux.HandleFunc("/jwks", i.handleJwks)
+	mux.HandleFunc("/authorize", i.handleAuthorize)
+	mux.HandleFunc("/token", i.handleToken)
+	mux.HandleFunc("/id-token", i.handleIDToken)
+
+	logger.Infof("Stand-in issuer %s for identity %s (%s)", i.url, i.username, i.subject)
+	logger.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func (i *issuer) handleDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                i.url,
+		"authorization_endpoint":                i.url + "/authorize",
+		"token_endpoint":                        i.url + "/token",
+		"jwks_uri":                              i.url + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"ES256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (i *issuer) handleJwks(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "EC",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "ES256",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(pad(i.key.X.Bytes())),
+			"y":   base64.RawURLEncoding.EncodeToString(pad(i.key.Y.Bytes())),
+		}},
+	})
+}
+
+func (i *issuer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != i.clientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("redirect_uri") == "" {
+		http.Error(w, "invalid redirect uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	i.Lock()
+	i.codes[code] = &authorization{
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+		redirectURI: query.Get("redirect_uri"),
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	i.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (i *issuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != i.clientID || clientSecret != i.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	i.Lock()
+	auth, ok := i.codes[r.PostForm.Get("code")]
+	delete(i.codes, r.PostForm.Get("code"))
+	i.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok ||
+		time.Now().After(auth.expiresAt) ||
+		auth.redirectURI != r.PostForm.Get("redirect_uri") ||
+		auth.challenge != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := i.idToken(auth.nonce)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (i *issuer) handleIDToken(w http.ResponseWriter, _ *http.Request) {
+	idToken, err := i.idToken("")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(idToken))
+}
+
+func (i *issuer) idToken(nonce string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                i.url,
+		"sub":                i.subject,
+		"aud":                i.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"preferred_username": i.username,
+		"groups":             i.groups,
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = keyID
+	return token.SignedString(i.key)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Errorf("%+v", err)
+	}
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		logger.Fatalf("%+v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func pad(b []byte) []byte {
+	padded := make([]byte, 32)
+	copy(padded[32-len(b):], b)
+	return padded
+}
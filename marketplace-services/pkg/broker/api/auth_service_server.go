@@ -0,0 +1,30 @@
+package api
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/broker/services"
+)
+
+type authServiceServer struct {
+	UnimplementedAuthServiceServer
+	authService services.AuthService
+}
+
+func NewAuthServiceServer(authService services.AuthService) *authServiceServer {
+	return &authServiceServer{authService: authService}
+}
+
+func (s *authServiceServer) GetChallenge(ctx context.Context, req *GetChallengeRequest) (*GetChallengeResponse, error) {
+	if !common.IsHexAddress(req.Address) {
+		return &GetChallengeResponse{}, status.Errorf(codes.InvalidArgument, "invalid address %q", req.Address)
+	}
+	challenge, err := s.authService.CreateChallenge(common.HexToAddress(req.Address))
+	return &GetChallengeResponse{Challenge: challenge}, err
+}
+
+func (s *authServiceServer) AuthFuncOverride(ctx context.Context, fullMethodName string) (context.Context, error) {
+	return ctx, nil
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"marketplace-services/pkg/broker/services"
 )
 
@@ -19,10 +20,12 @@ func (s *discoveryServiceServer) SearchProduct(
 ) error {
 	ctx := stream.Context()
 	sink, errc := s.discoveryService.SearchProduct(ctx, ProductSearchQueryFromGrpcProductSearchQuery(req.Query))
-	for p := range sink {
-		product := ProductToGrpcProduct(p)
+	for result := range sink {
+		product := ProductToGrpcProduct(result.Product)
 		if err := stream.Send(&SearchProductResponse{
-			Product: product,
+			Product:       product,
+			Rating:        result.Rating,
+			NextPageToken: result.NextPageToken,
 		}); err != nil {
 			return err
 		}
@@ -32,3 +35,7 @@ func (s *discoveryServiceServer) SearchProduct(
 	}
 	return nil
 }
+
+func (s *discoveryServiceServer) AuthFuncOverride(ctx context.Context, fullMethodName string) (context.Context, error) {
+	return ctx, nil
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"context"
+	"marketplace-services/pkg/broker/services"
+)
+
+type logServiceServer struct {
+	UnimplementedLogServiceServer
+	logService services.LogService
+}
+
+func NewLogServiceServer(logService services.LogService) *logServiceServer {
+	return &logServiceServer{logService: logService}
+}
+
+func (s *logServiceServer) GetInclusionProof(
+	ctx context.Context,
+	req *GetInclusionProofRequest,
+) (*GetInclusionProofResponse, error) {
+	proof, err := s.logService.FindInclusionProof(ctx, req.TradeId, req.Seq, req.Count)
+	if err != nil {
+		return &GetInclusionProofResponse{}, err
+	}
+	return &GetInclusionProofResponse{Proof: InclusionProofToGrpcInclusionProof(proof)}, nil
+}
+
+func (s *logServiceServer) GetResolution(ctx context.Context, req *GetResolutionRequest) (*GetResolutionResponse, error) {
+	resolution, err := s.logService.FindResolution(ctx, req.TradeId)
+	if err != nil {
+		return &GetResolutionResponse{}, err
+	}
+	return &GetResolutionResponse{Resolution: ResolutionToGrpcResolution(resolution)}, nil
+}
@@ -5,40 +5,61 @@ import (
 	"marketplace-services/pkg/broker/services"
 	"marketplace-services/pkg/contracts"
 	"marketplace-services/pkg/domain"
+	"marketplace-services/pkg/marketplace"
 	"math/big"
 )
 
 func MessageFromGrpcMessage(message *domain.Message) *services.Message {
 	return &services.Message{
-		TradeId: message.TradeId,
-		Payload: message.Payload,
+		TradeId:   message.TradeId,
+		Payload:   message.Payload,
+		Seq:       message.Seq,
+		Signature: message.Signature,
 	}
 }
 
 func MessageToGrpcMessage(message *services.Message) *domain.Message {
 	return &domain.Message{
-		TradeId: message.TradeId,
-		Payload: message.Payload,
+		TradeId:   message.TradeId,
+		Payload:   message.Payload,
+		Seq:       message.Seq,
+		Signature: message.Signature,
 	}
 }
 
-func ProductSearchQueryFromGrpcProductSearchQuery(query *domain.ProductSearchQuery) *services.ProductSearchQuery {
-	return &services.ProductSearchQuery{
+func ProductSearchQueryFromGrpcProductSearchQuery(query *domain.ProductSearchQuery) *marketplace.ProductSearchQuery {
+	return &marketplace.ProductSearchQuery{
 		DataType:     query.DataType,
 		MinCost:      query.MinCost,
 		MaxCost:      query.MaxCost,
 		MinFrequency: query.MinFrequency,
 		MaxFrequency: query.MaxFrequency,
+		Text:         query.Text,
+		MinRating:    query.MinRating,
+		Device:       query.Device,
+		User:         query.User,
+		SortBy:       marketplace.ProductSortField(query.SortBy),
+		Descending:   query.Descending,
+		PageSize:     query.PageSize,
+		PageToken:    query.PageToken,
 	}
 }
 
-func ProductSearchQueryToGrpcProductSearchQuery(query *services.ProductSearchQuery) *domain.ProductSearchQuery {
+func ProductSearchQueryToGrpcProductSearchQuery(query *marketplace.ProductSearchQuery) *domain.ProductSearchQuery {
 	return &domain.ProductSearchQuery{
 		DataType:     query.DataType,
 		MinCost:      query.MinCost,
 		MaxCost:      query.MaxCost,
 		MinFrequency: query.MinFrequency,
 		MaxFrequency: query.MaxFrequency,
+		Text:         query.Text,
+		MinRating:    query.MinRating,
+		Device:       query.Device,
+		User:         query.User,
+		SortBy:       domain.ProductSortField(query.SortBy),
+		Descending:   query.Descending,
+		PageSize:     query.PageSize,
+		PageToken:    query.PageToken,
 	}
 }
 
@@ -67,3 +88,25 @@ func ProductToGrpcProduct(product *contracts.Product) *domain.Product {
 		Deleted:     product.Deleted,
 	}
 }
+
+func InclusionProofToGrpcInclusionProof(proof *services.InclusionProof) *InclusionProof {
+	return &InclusionProof{
+		TradeId: proof.TradeId,
+		Seq:     proof.Seq,
+		Index:   proof.Index,
+		Count:   proof.Count,
+		Leaf:    proof.Leaf,
+		Chain:   proof.Chain,
+		Root:    proof.Root,
+		Proof:   proof.Proof,
+	}
+}
+
+func ResolutionToGrpcResolution(resolution *services.Resolution) *Resolution {
+	return &Resolution{
+		TradeId:    resolution.TradeId,
+		Counter:    resolution.Counter,
+		Root:       resolution.Root,
+		ResolvedAt: resolution.ResolvedAt,
+	}
+}
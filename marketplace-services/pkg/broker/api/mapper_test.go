@@ -0,0 +1,29 @@
+package api
+
+import (
+	"marketplace-services/pkg/marketplace"
+	"reflect"
+	"testing"
+)
+
+func TestProductSearchQueryRoundTrip(t *testing.T) {
+	query := &marketplace.ProductSearchQuery{
+		DataType:     "temperature",
+		MinCost:      1,
+		MaxCost:      2,
+		MinFrequency: 3,
+		MaxFrequency: 4,
+		Text:         "roof",
+		MinRating:    5,
+		Device:       "0x00000000000000000000000000000000000000d1",
+		User:         "0x00000000000000000000000000000000000000a1",
+		SortBy:       marketplace.SortByRating,
+		Descending:   true,
+		PageSize:     6,
+		PageToken:    marketplace.EncodePageToken(6),
+	}
+	mapped := ProductSearchQueryFromGrpcProductSearchQuery(ProductSearchQueryToGrpcProductSearchQuery(query))
+	if !reflect.DeepEqual(mapped, query) {
+		t.Fatalf("mapped query %+v, want %+v", mapped, query)
+	}
+}
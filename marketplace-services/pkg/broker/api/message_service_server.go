@@ -2,20 +2,29 @@ package api
 
 import (
 	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
 	"marketplace-services/pkg/broker/services"
 )
 
 type messageServiceServer struct {
 	UnimplementedMessageServiceServer
+	ctx            context.Context
 	messageService services.MessageService
 }
 
-func NewMessageServiceServer(messageService services.MessageService) *messageServiceServer {
-	return &messageServiceServer{messageService: messageService}
+// NewMessageServiceServer creates a message service server whose subscriptions end with ctx.
+func NewMessageServiceServer(ctx context.Context, messageService services.MessageService) *messageServiceServer {
+	return &messageServiceServer{ctx: ctx, messageService: messageService}
 }
 
 func (s *messageServiceServer) PushMessage(ctx context.Context, req *PushMessageRequest) (*PushMessageResponse, error) {
 	err := s.messageService.PushMessage(ctx, MessageFromGrpcMessage(req.Message))
+	if errors.Is(err, services.ErrQueueFull) {
+		return &PushMessageResponse{}, status.Errorf(codes.ResourceExhausted, "%s", err)
+	}
 	if err != nil {
 		return &PushMessageResponse{}, err
 	}
@@ -29,3 +38,100 @@ func (s *messageServiceServer) PullMessage(ctx context.Context, req *PullMessage
 	}
 	return &PullMessageResponse{Message: MessageToGrpcMessage(msg)}, err
 }
+
+func (s *messageServiceServer) PublishMessages(stream MessageService_PublishMessagesServer) error {
+	ctx := stream.Context()
+	counter := uint64(0)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&PublishMessagesResponse{Counter: counter})
+		}
+		if err != nil {
+			return err
+		}
+		err = s.messageService.PushMessage(ctx, MessageFromGrpcMessage(req.Message))
+		if errors.Is(err, services.ErrQueueFull) {
+			return status.Errorf(codes.ResourceExhausted, "%s", err)
+		}
+		if err != nil {
+			return err
+		}
+		counter++
+	}
+}
+
+// SubscribeMessages delivers the messages of the trade selected by the first request. A message is
+// only removed from the queue once the subscriber acknowledges its sequence number, so messages
+// lost with a dropped subscription are delivered again.
+func (s *messageServiceServer) SubscribeMessages(stream MessageService_SubscribeMessagesServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	acks := make(chan uint64)
+	recvErrc := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErrc <- err
+				return
+			}
+			select {
+			case acks <- req.AckSeq:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	sink, errc := s.messageService.SubscribeMessages(ctx, req.TradeId)
+	for delivery := range sink {
+		if err := stream.Send(&SubscribeMessagesResponse{Message: MessageToGrpcMessage(delivery.Message)}); err != nil {
+			return err
+		}
+		select {
+		case seq := <-acks:
+			if seq != delivery.Seq {
+				return status.Errorf(codes.InvalidArgument, "acknowledged message %d instead of %d", seq, delivery.Seq)
+			}
+			if err := delivery.Ack(); err != nil {
+				return err
+			}
+		case err := <-recvErrc:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			return status.Errorf(codes.Canceled, "%s", ctx.Err())
+		}
+	}
+	if err := <-errc; err != nil {
+		if ctx.Err() != nil {
+			return status.Errorf(codes.Canceled, "%s", ctx.Err())
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *messageServiceServer) FindSequence(ctx context.Context, req *FindSequenceRequest) (*FindSequenceResponse, error) {
+	seq, err := s.messageService.FindSequence(ctx, req.TradeId)
+	if err != nil {
+		return &FindSequenceResponse{}, err
+	}
+	return &FindSequenceResponse{Seq: seq}, nil
+}
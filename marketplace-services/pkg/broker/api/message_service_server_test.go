@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"io"
+	"marketplace-services/pkg/broker/services"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeMessageService struct {
+	services.MessageService
+	acks int32
+}
+
+func (s *fakeMessageService) SubscribeMessages(ctx context.Context, tradeId uint64) (<-chan *services.Delivery, <-chan error) {
+	sink := make(chan *services.Delivery)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(sink)
+		defer close(errc)
+		delivery := services.NewDelivery(&services.Message{TradeId: tradeId, Seq: 1}, func() error {
+			atomic.AddInt32(&s.acks, 1)
+			return nil
+		})
+		select {
+		case sink <- delivery:
+		case <-ctx.Done():
+			errc <- ctx.Err()
+			return
+		}
+		<-ctx.Done()
+		errc <- ctx.Err()
+	}()
+	return sink, errc
+}
+
+// fakeSubscribeStream receives the requests given on reqs and records the sent messages.
+type fakeSubscribeStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	reqs chan *SubscribeMessagesRequest
+	sent chan *SubscribeMessagesResponse
+}
+
+func newFakeSubscribeStream(ctx context.Context) *fakeSubscribeStream {
+	return &fakeSubscribeStream{
+		ctx:  ctx,
+		reqs: make(chan *SubscribeMessagesRequest, 2),
+		sent: make(chan *SubscribeMessagesResponse, 2),
+	}
+}
+
+func (s *fakeSubscribeStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeSubscribeStream) Send(res *SubscribeMessagesResponse) error {
+	s.sent <- res
+	return nil
+}
+
+func (s *fakeSubscribeStream) Recv() (*SubscribeMessagesRequest, error) {
+	select {
+	case req, ok := <-s.reqs:
+		if !ok {
+			return nil, io.EOF
+		}
+		return req, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func subscribe(server *messageServiceServer, stream *fakeSubscribeStream) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- server.SubscribeMessages(stream)
+	}()
+	stream.reqs <- &SubscribeMessagesRequest{TradeId: 7}
+	<-stream.sent
+	return done
+}
+
+func awaitSubscription(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription didn't end")
+		return nil
+	}
+}
+
+func TestSubscribeMessagesAcksOnRequest(t *testing.T) {
+	messageService := &fakeMessageService{}
+	server := NewMessageServiceServer(context.Background(), messageService)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := newFakeSubscribeStream(ctx)
+
+	done := subscribe(server, stream)
+	if acks := atomic.LoadInt32(&messageService.acks); acks != 0 {
+		t.Fatal("message acknowledged before the subscriber acknowledged it")
+	}
+	stream.reqs <- &SubscribeMessagesRequest{AckSeq: 1}
+	for atomic.LoadInt32(&messageService.acks) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	awaitSubscription(t, done)
+}
+
+func TestSubscribeMessagesKeepsUnackedMessages(t *testing.T) {
+	messageService := &fakeMessageService{}
+	server := NewMessageServiceServer(context.Background(), messageService)
+	stream := newFakeSubscribeStream(context.Background())
+
+	done := subscribe(server, stream)
+	close(stream.reqs)
+	if err := awaitSubscription(t, done); err != nil {
+		t.Fatalf("subscription ended with %v", err)
+	}
+	if acks := atomic.LoadInt32(&messageService.acks); acks != 0 {
+		t.Fatal("message of dropped subscriber acknowledged")
+	}
+}
+
+func TestSubscribeMessagesEndsWithServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := NewMessageServiceServer(ctx, &fakeMessageService{})
+	stream := newFakeSubscribeStream(context.Background())
+
+	done := subscribe(server, stream)
+	cancel()
+	if err := awaitSubscription(t, done); err == nil {
+		t.Fatal("subscription ended without error")
+	}
+}
@@ -7,27 +7,37 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_middleware_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
 	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
 	"google.golang.org/grpc"
 	"marketplace-services/pkg/broker/api"
 	"marketplace-services/pkg/broker/services"
 	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/watchdog"
 	"net"
 	"os"
 	"os/signal"
 	"strconv"
 	"syscall"
+	"time"
 )
 
 type broker struct {
-	opts           options
-	grpcServer     *grpc.Server
-	ethClient      *ethclient.Client
-	disputeService services.DisputeService
-	logger         logrus.FieldLogger
-	running        bool
-	quit           chan bool
+	opts                options
+	db                  *leveldb.DB
+	grpcServer          *grpc.Server
+	ethClient           *ethclient.Client
+	disputeService      services.DisputeService
+	registrationService services.RegistrationService
+	productIndex        services.ProductIndex
+	watchdog            watchdog.SettlementWatchdog
+	logger              logrus.FieldLogger
+	running             bool
+	quit                chan bool
+	ctx                 context.Context
+	cancel              context.CancelFunc
 }
 
 func New(opt ...Option) (*broker, error) {
@@ -42,7 +52,16 @@ func New(opt ...Option) (*broker, error) {
 		}
 	}
 
+	defaultQueueConfig, tradeQueueConfigs, err := initQueueConfigs(opts)
+	if err != nil {
+		return nil, fmt.Errorf("init message queue configs: %w", err)
+	}
+
 	logger := initLogger(opts)
+	db, err := initDb(opts)
+	if err != nil {
+		return nil, fmt.Errorf("init db: %w", err)
+	}
 
 	ethClient, err := ethclient.Dial(opts.EthConfig.ClientURL)
 	if err != nil {
@@ -67,6 +86,30 @@ func New(opt ...Option) (*broker, error) {
 		)
 	}
 
+	deviceContract, err := contracts.NewDeviceContractImpl(
+		common.HexToAddress(opts.ContractsConfig.DeviceContractAddress),
+		ethClient,
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"new device contract with address %s: %w",
+			opts.ContractsConfig.DeviceContractAddress,
+			err,
+		)
+	}
+
+	brokerContract, err := contracts.NewBrokerContractImpl(
+		common.HexToAddress(opts.ContractsConfig.BrokerContractAddress),
+		ethClient,
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"new broker contract with address %s: %w",
+			opts.ContractsConfig.BrokerContractAddress,
+			err,
+		)
+	}
+
 	tradingContract, err := contracts.NewTradingContractImpl(
 		common.HexToAddress(opts.ContractsConfig.TradingContractAddress),
 		ethClient,
@@ -79,33 +122,78 @@ func New(opt ...Option) (*broker, error) {
 		)
 	}
 
-	messageService := services.NewMessageServiceImpl(logger)
-	messageServiceServer := api.NewMessageServiceServer(messageService)
+	messageService := services.NewMessageServiceImpl(
+		logger,
+		db,
+		tradingContract,
+		common.HexToAddress(opts.EthConfig.Account),
+		defaultQueueConfig,
+		tradeQueueConfigs,
+	)
+
+	logService := services.NewLogServiceImpl(logger, db, tradingContract, common.HexToAddress(opts.EthConfig.Account))
+	logServiceServer := api.NewLogServiceServer(logService)
 
-	discoveryService := services.NewDiscoveryServiceImpl(logger, productContract, ks)
+	var productIndex services.ProductIndex
+	if opts.ProductIndexConfig.Enabled {
+		productIndex, err = initProductIndex(opts, logger, db, productContract)
+		if err != nil {
+			return nil, fmt.Errorf("init product index: %w", err)
+		}
+	}
+
+	discoveryService := services.NewDiscoveryServiceImpl(logger, productContract, deviceContract, productIndex, ks)
 	discoveryServiceServer := api.NewDiscoveryServiceServer(discoveryService)
 
+	var settlementWatchdog watchdog.SettlementWatchdog
+	if opts.WatchdogConfig.Enabled {
+		settlementWatchdog = initWatchdog(opts, logger, ks, ethClient)
+	}
+
 	disputeService := services.NewDisputeServiceImpl(
 		logger,
 		ks,
 		ethClient,
+		brokerContract,
 		tradingContract,
 		messageService,
+		logService,
+		settlementWatchdog,
 		opts.EthConfig.Account,
 		opts.EthConfig.Passphrase,
 	)
 
-	grpcServer := initGrpcServer(logger)
+	var registrationService services.RegistrationService
+	if opts.RegistrationConfig.Enabled {
+		registrationService = initRegistrationService(opts, logger, ks, ethClient, brokerContract)
+	}
+
+	authService := services.NewAuthServiceImpl(logger, int64(opts.AuthConfig.ChallengeExpirationTime))
+	authServiceServer := api.NewAuthServiceServer(authService)
+
+	// The run context ends the message subscriptions, which would block a graceful stop otherwise.
+	ctx, cancel := context.WithCancel(context.Background())
+	messageServiceServer := api.NewMessageServiceServer(ctx, messageService)
+
+	grpcServer := initGrpcServer(authService, logger)
+	api.RegisterAuthServiceServer(grpcServer, authServiceServer)
 	api.RegisterMessageServiceServer(grpcServer, messageServiceServer)
+	api.RegisterLogServiceServer(grpcServer, logServiceServer)
 	api.RegisterDiscoveryServiceServer(grpcServer, discoveryServiceServer)
 
 	b := &broker{
-		opts:           opts,
-		grpcServer:     grpcServer,
-		ethClient:      ethClient,
-		disputeService: disputeService,
-		logger:         logger,
-		quit:           make(chan bool, 1),
+		opts:                opts,
+		db:                  db,
+		grpcServer:          grpcServer,
+		ethClient:           ethClient,
+		disputeService:      disputeService,
+		registrationService: registrationService,
+		productIndex:        productIndex,
+		watchdog:            settlementWatchdog,
+		logger:              logger,
+		quit:                make(chan bool, 1),
+		ctx:                 ctx,
+		cancel:              cancel,
 	}
 
 	return b, nil
@@ -123,7 +211,91 @@ func initLogger(opts options) logrus.FieldLogger {
 	return logger
 }
 
-func initGrpcServer(logger logrus.FieldLogger) *grpc.Server {
+func initDb(opts options) (*leveldb.DB, error) {
+	db, err := leveldb.OpenFile(opts.DatabaseConfig.Source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open database %s: %w", opts.DatabaseConfig.Source, err)
+	}
+	return db, err
+}
+
+func initQueueConfigs(opts options) (services.QueueConfig, map[uint64]services.QueueConfig, error) {
+	config := opts.MessageQueueConfig
+	policy, err := services.ParseOverflowPolicy(config.OverflowPolicy)
+	if err != nil {
+		return services.QueueConfig{}, nil, err
+	}
+	defaultConfig := services.QueueConfig{
+		Capacity:       config.Capacity,
+		OverflowPolicy: policy,
+	}
+	tradeConfigs := make(map[uint64]services.QueueConfig)
+	for _, tradeConfig := range config.Trades {
+		policy, err := services.ParseOverflowPolicy(tradeConfig.OverflowPolicy)
+		if err != nil {
+			return services.QueueConfig{}, nil, fmt.Errorf("trade %d: %w", tradeConfig.TradeId, err)
+		}
+		tradeConfigs[tradeConfig.TradeId] = services.QueueConfig{
+			Capacity:       tradeConfig.Capacity,
+			OverflowPolicy: policy,
+		}
+	}
+	return defaultConfig, tradeConfigs, nil
+}
+
+func initWatchdog(
+	opts options,
+	logger logrus.FieldLogger,
+	ks *keystore.KeyStore,
+	ethClient *ethclient.Client,
+) watchdog.SettlementWatchdog {
+	config := opts.WatchdogConfig
+	backend := watchdog.NewContractBackend(logger, ks, ethClient, opts.EthConfig.Account, opts.EthConfig.Passphrase)
+	return watchdog.NewSettlementWatchdogImpl(
+		logger,
+		backend,
+		time.Duration(config.Interval)*time.Second,
+		time.Duration(config.SettledTimeout)*time.Second,
+	)
+}
+
+func initProductIndex(
+	opts options,
+	logger logrus.FieldLogger,
+	db *leveldb.DB,
+	productContract contracts.ProductContract,
+) (services.ProductIndex, error) {
+	if !opts.ProductIndexConfig.Persist {
+		db = nil
+	}
+	return services.NewProductIndexImpl(logger, productContract, db)
+}
+
+func initRegistrationService(
+	opts options,
+	logger logrus.FieldLogger,
+	ks *keystore.KeyStore,
+	ethClient *ethclient.Client,
+	brokerContract contracts.BrokerContract,
+) services.RegistrationService {
+	config := opts.RegistrationConfig
+	return services.NewRegistrationServiceImpl(
+		logger,
+		ks,
+		ethClient,
+		brokerContract,
+		&contracts.Broker{
+			Addr:     common.HexToAddress(opts.EthConfig.Account),
+			Name:     config.Name,
+			HostAddr: config.HostAddr,
+			Location: contracts.Location(config.Location),
+		},
+		config.UserAccount,
+		config.UserPassphrase,
+	)
+}
+
+func initGrpcServer(authService services.AuthService, logger logrus.FieldLogger) *grpc.Server {
 	entry := logrus.NewEntry(logger.(*logrus.Logger))
 	server := grpc.NewServer(
 		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
@@ -131,12 +303,14 @@ func initGrpcServer(logger logrus.FieldLogger) *grpc.Server {
 				entry,
 				grpc_logrus.WithLevels(grpc_logrus.DefaultCodeToLevel),
 			),
+			grpc_middleware_auth.StreamServerInterceptor(authService.AuthFunction()),
 		)),
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
 			grpc_logrus.UnaryServerInterceptor(
 				entry,
 				grpc_logrus.WithLevels(grpc_logrus.DefaultCodeToLevel),
 			),
+			grpc_middleware_auth.UnaryServerInterceptor(authService.AuthFunction()),
 		)),
 
 	)
@@ -167,16 +341,44 @@ func (b *broker) Run() error {
 	b.receiveSignals()
 
 	go func() {
-		err := b.disputeService.ResolveDisputes(context.TODO())
-		if err != nil {
+		err := b.disputeService.ResolveDisputes(b.ctx)
+		if err != nil && b.ctx.Err() == nil {
 			b.logger.Errorf("resolve disputes: %v", err)
 		}
 	}()
 
+	if b.productIndex != nil {
+		go b.productIndex.Sync(b.ctx)
+	}
+
+	if b.watchdog != nil {
+		go b.reportTimeouts(b.ctx)
+	}
+
+	if b.registrationService != nil {
+		go func() {
+			interval := time.Duration(b.opts.RegistrationConfig.HeartbeatInterval) * time.Second
+			err := b.registrationService.Heartbeat(b.ctx, interval)
+			if err != nil && b.ctx.Err() == nil {
+				b.logger.Errorf("broker registration: %v", err)
+			}
+		}()
+	}
+
 	b.running = true
 	return b.grpcServer.Serve(lis)
 }
 
+func (b *broker) reportTimeouts(ctx context.Context) {
+	sink, errc := b.watchdog.Run(ctx)
+	for report := range sink {
+		b.logger.Infof("Settlement watchdog %s", report)
+	}
+	if err := <-errc; err != nil && ctx.Err() == nil {
+		b.logger.Errorf("run settlement watchdog: %v", err)
+	}
+}
+
 func (b *broker) receiveSignals() {
 	if b.opts.NoSig {
 		return
@@ -204,8 +406,20 @@ func (b *broker) GraceFulStop() {
 		return
 	}
 	close(b.quit)
+	b.cancel()
 	b.grpcServer.GracefulStop()
+	if b.registrationService != nil && b.opts.RegistrationConfig.RemoveOnShutdown {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := b.registrationService.Deregister(ctx); err != nil {
+			b.logger.Errorf("deregister broker: %v", err)
+		}
+		cancel()
+	}
 	b.ethClient.Close()
+	err := b.db.Close()
+	if err != nil {
+		b.logger.Errorf("%+v", err)
+	}
 	b.running = false
 	b.logger.Infof("Gracefully stopped broker")
 }
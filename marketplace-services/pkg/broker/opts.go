@@ -6,13 +6,19 @@ import (
 )
 
 type options struct {
-	ConfigFile      string
-	Host            string          `json:"host"`
-	Port            int             `json:"port"`
-	NoSig           bool            `json:"noSig"`
-	LoggingConfig   LoggingConfig   `json:"loggingConfig"`
-	EthConfig       EthConfig       `json:"ethConfig"`
-	ContractsConfig ContractsConfig `json:"contractsConfig"`
+	ConfigFile         string
+	Host               string             `json:"host"`
+	Port               int                `json:"port"`
+	NoSig              bool               `json:"noSig"`
+	LoggingConfig      LoggingConfig      `json:"loggingConfig"`
+	DatabaseConfig     DatabaseConfig     `json:"databaseConfig"`
+	AuthConfig         AuthConfig         `json:"authConfig"`
+	MessageQueueConfig MessageQueueConfig `json:"messageQueueConfig"`
+	EthConfig          EthConfig          `json:"ethConfig"`
+	ContractsConfig    ContractsConfig    `json:"contractsConfig"`
+	WatchdogConfig     WatchdogConfig     `json:"watchdogConfig"`
+	RegistrationConfig RegistrationConfig `json:"registrationConfig"`
+	ProductIndexConfig ProductIndexConfig `json:"productIndexConfig"`
 }
 
 type EthConfig struct {
@@ -26,11 +32,55 @@ type LoggingConfig struct {
 	Verbosity int `json:"verbosity"`
 }
 
+type DatabaseConfig struct {
+	Source string `json:"source"`
+}
+
+type AuthConfig struct {
+	ChallengeExpirationTime int `json:"challengeExpirationTime"`
+}
+
+type MessageQueueConfig struct {
+	Capacity       uint64             `json:"capacity"`
+	OverflowPolicy string             `json:"overflowPolicy"`
+	Trades         []TradeQueueConfig `json:"trades"`
+}
+
+type TradeQueueConfig struct {
+	TradeId        uint64 `json:"tradeId"`
+	Capacity       uint64 `json:"capacity"`
+	OverflowPolicy string `json:"overflowPolicy"`
+}
+
 type ContractsConfig struct {
+	BrokerContractAddress  string `json:"brokerContractAddress"`
+	DeviceContractAddress  string `json:"deviceContractAddress"`
 	ProductContractAddress string `json:"productContractAddress"`
 	TradingContractAddress string `json:"tradingContractAddress"`
 }
 
+type WatchdogConfig struct {
+	Enabled        bool `json:"enabled"`
+	Interval       int  `json:"interval"`
+	SettledTimeout int  `json:"settledTimeout"`
+}
+
+type RegistrationConfig struct {
+	Enabled           bool   `json:"enabled"`
+	Name              string `json:"name"`
+	HostAddr          string `json:"hostAddr"`
+	Location          int    `json:"location"`
+	UserAccount       string `json:"userAccount"`
+	UserPassphrase    string `json:"userPassphrase"`
+	HeartbeatInterval int    `json:"heartbeatInterval"`
+	RemoveOnShutdown  bool   `json:"removeOnShutdown"`
+}
+
+type ProductIndexConfig struct {
+	Enabled bool `json:"enabled"`
+	Persist bool `json:"persist"`
+}
+
 type Option interface {
 	apply(*options)
 }
@@ -58,6 +108,16 @@ func DefaultOptions() options {
 		LoggingConfig: LoggingConfig{
 			Verbosity: 4,
 		},
+		DatabaseConfig: DatabaseConfig{
+			Source: "./tmp/broker",
+		},
+		AuthConfig: AuthConfig{
+			ChallengeExpirationTime: 60,
+		},
+		MessageQueueConfig: MessageQueueConfig{
+			Capacity:       100,
+			OverflowPolicy: "block",
+		},
 		EthConfig: EthConfig{
 			ClientURL:  "ws://127.0.0.1:7545",
 			KeyDir:     "./tmp/keystores",
@@ -65,9 +125,25 @@ func DefaultOptions() options {
 			Passphrase: "12345678",
 		},
 		ContractsConfig: ContractsConfig{
+			BrokerContractAddress:  "0x4c950DF5a2d15f05EA9AD272767739bE07Cf923c",
+			DeviceContractAddress:  "0x81AE11e56227656b234061809b9B898512f217f8",
 			ProductContractAddress: "0x1DE2c47702a7C815A1c11D827AED45664C886E72",
 			TradingContractAddress: "0xf4669783a1a75C24BC9E442762514f45fA7FFD8e",
 		},
+		WatchdogConfig: WatchdogConfig{
+			Enabled:        true,
+			Interval:       60,
+			SettledTimeout: 300,
+		},
+		RegistrationConfig: RegistrationConfig{
+			Enabled:           false,
+			HeartbeatInterval: 300,
+			RemoveOnShutdown:  false,
+		},
+		ProductIndexConfig: ProductIndexConfig{
+			Enabled: true,
+			Persist: false,
+		},
 	}
 }
 
@@ -111,6 +187,24 @@ func WithLoggingConfig(loggingConfig LoggingConfig) Option {
 	})
 }
 
+func WithDatabaseConfig(databaseConfig DatabaseConfig) Option {
+	return newFuncOption(func(o *options) {
+		o.DatabaseConfig = databaseConfig
+	})
+}
+
+func WithAuthConfig(authConfig AuthConfig) Option {
+	return newFuncOption(func(o *options) {
+		o.AuthConfig = authConfig
+	})
+}
+
+func WithMessageQueueConfig(messageQueueConfig MessageQueueConfig) Option {
+	return newFuncOption(func(o *options) {
+		o.MessageQueueConfig = messageQueueConfig
+	})
+}
+
 func WithEthConfig(ethConfig EthConfig) Option {
 	return newFuncOption(func(o *options) {
 		o.EthConfig = ethConfig
@@ -122,3 +216,21 @@ func WithContractsConfig(contractsConfig ContractsConfig) Option {
 		o.ContractsConfig = contractsConfig
 	})
 }
+
+func WithWatchdogConfig(watchdogConfig WatchdogConfig) Option {
+	return newFuncOption(func(o *options) {
+		o.WatchdogConfig = watchdogConfig
+	})
+}
+
+func WithRegistrationConfig(registrationConfig RegistrationConfig) Option {
+	return newFuncOption(func(o *options) {
+		o.RegistrationConfig = registrationConfig
+	})
+}
+
+func WithProductIndexConfig(productIndexConfig ProductIndexConfig) Option {
+	return newFuncOption(func(o *options) {
+		o.ProductIndexConfig = productIndexConfig
+	})
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+const (
+	ChallengeHeader = "challenge"
+	SignatureHeader = "signature"
+	challengeLength = 32
+
+	// maxChallenges limits the open challenges, as anyone can request them without authentication.
+	maxChallenges = 10000
+	// maxChallengesPerAddress limits the open challenges of a single address.
+	maxChallengesPerAddress = 16
+)
+
+type AuthService interface {
+	CreateChallenge(address common.Address) ([]byte, error)
+	VerifyChallenge(challenge []byte, signature []byte) (common.Address, error)
+	AuthFunction() func(ctx context.Context) (context.Context, error)
+}
+
+// challenge is an open challenge that only the address it was created for can answer.
+type challenge struct {
+	address   common.Address
+	expiresAt time.Time
+}
+
+type authServiceImpl struct {
+	logger         logrus.FieldLogger
+	expirationTime int64
+	challenges     map[string]*challenge
+	counts         map[common.Address]int
+	sync.Mutex
+}
+
+func NewAuthServiceImpl(logger logrus.FieldLogger, expirationTime int64) *authServiceImpl {
+	return &authServiceImpl{
+		logger:         logger,
+		expirationTime: expirationTime,
+		challenges:     make(map[string]*challenge),
+		counts:         make(map[common.Address]int),
+	}
+}
+
+// CreateChallenge creates a challenge for the address. Requests are rejected while too many
+// challenges are open in total or for the address.
+func (s *authServiceImpl) CreateChallenge(address common.Address) ([]byte, error) {
+	data := make([]byte, challengeLength)
+	if _, err := rand.Read(data); err != nil {
+		return nil, fmt.Errorf("create challenge: %w", err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	for key, c := range s.challenges {
+		if now.After(c.expiresAt) {
+			s.remove(key, c)
+		}
+	}
+	if len(s.challenges) >= maxChallenges {
+		return nil, status.Error(codes.ResourceExhausted, "too many open challenges")
+	}
+	if s.counts[address] >= maxChallengesPerAddress {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many open challenges for %s", address.Hex())
+	}
+
+	s.challenges[hex.EncodeToString(data)] = &challenge{
+		address:   address,
+		expiresAt: now.Add(time.Duration(s.expirationTime) * time.Second),
+	}
+	s.counts[address]++
+	return data, nil
+}
+
+func (s *authServiceImpl) VerifyChallenge(data []byte, signature []byte) (common.Address, error) {
+	s.Lock()
+	key := hex.EncodeToString(data)
+	c, ok := s.challenges[key]
+	if ok {
+		s.remove(key, c)
+	}
+	s.Unlock()
+
+	if !ok {
+		return common.Address{}, fmt.Errorf("unknown challenge")
+	}
+	if time.Now().After(c.expiresAt) {
+		return common.Address{}, fmt.Errorf("challenge expired")
+	}
+
+	pubKey, err := crypto.SigToPub(accounts.TextHash(data), signature)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("recover public key: %w", err)
+	}
+	if address := crypto.PubkeyToAddress(*pubKey); address != c.address {
+		return common.Address{}, fmt.Errorf("challenge signed by %s instead of %s", address.Hex(), c.address.Hex())
+	}
+	return c.address, nil
+}
+
+// remove removes an open challenge, the caller must hold the lock.
+func (s *authServiceImpl) remove(key string, c *challenge) {
+	delete(s.challenges, key)
+	if s.counts[c.address]--; s.counts[c.address] <= 0 {
+		delete(s.counts, c.address)
+	}
+}
+
+func (s *authServiceImpl) AuthFunction() func(ctx context.Context) (context.Context, error) {
+	return func(ctx context.Context) (context.Context, error) {
+		md := metautils.ExtractIncoming(ctx)
+		challenge, err := hexutil.Decode(md.Get(ChallengeHeader))
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "decode challenge: %s", err)
+		}
+		signature, err := hexutil.Decode(md.Get(SignatureHeader))
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "decode signature: %s", err)
+		}
+
+		address, err := s.VerifyChallenge(challenge, signature)
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "authentication failure: %s", err)
+		}
+		s.logger.Debugf("Authenticated %s", address.Hex())
+		return context.WithValue(ctx, "principal", address), nil
+	}
+}
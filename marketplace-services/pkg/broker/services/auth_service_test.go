@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"math/big"
+	"testing"
+)
+
+func newTestAuthService(expirationTime int64) *authServiceImpl {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	return NewAuthServiceImpl(logger, expirationTime)
+}
+
+func signTestChallenge(t *testing.T, key *ecdsa.PrivateKey, challenge []byte) []byte {
+	signature, err := crypto.Sign(accounts.TextHash(challenge), key)
+	if err != nil {
+		t.Fatalf("sign challenge: %s", err)
+	}
+	return signature
+}
+
+func newTestKey(t *testing.T) (*ecdsa.PrivateKey, common.Address) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+	return key, crypto.PubkeyToAddress(key.PublicKey)
+}
+
+func TestVerifyChallenge(t *testing.T) {
+	s := newTestAuthService(60)
+	key, address := newTestKey(t)
+
+	challenge, err := s.CreateChallenge(address)
+	if err != nil {
+		t.Fatalf("create challenge: %s", err)
+	}
+	signature := signTestChallenge(t, key, challenge)
+
+	verified, err := s.VerifyChallenge(challenge, signature)
+	if err != nil {
+		t.Fatalf("verify challenge: %s", err)
+	}
+	if verified != address {
+		t.Fatalf("verified %s, want %s", verified.Hex(), address.Hex())
+	}
+
+	if _, err := s.VerifyChallenge(challenge, signature); err == nil {
+		t.Fatal("challenge was accepted twice")
+	}
+}
+
+func TestVerifyChallengeRejectsOtherSigner(t *testing.T) {
+	s := newTestAuthService(60)
+	_, address := newTestKey(t)
+	other, _ := newTestKey(t)
+
+	challenge, err := s.CreateChallenge(address)
+	if err != nil {
+		t.Fatalf("create challenge: %s", err)
+	}
+	if _, err := s.VerifyChallenge(challenge, signTestChallenge(t, other, challenge)); err == nil {
+		t.Fatal("challenge signed by another key was accepted")
+	}
+	if len(s.challenges) != 0 || len(s.counts) != 0 {
+		t.Fatalf("challenge not removed after failed verification: %d open", len(s.challenges))
+	}
+}
+
+func TestVerifyChallengeRejectsUnknownAndExpired(t *testing.T) {
+	key, address := newTestKey(t)
+
+	s := newTestAuthService(60)
+	unknown := make([]byte, challengeLength)
+	if _, err := s.VerifyChallenge(unknown, signTestChallenge(t, key, unknown)); err == nil {
+		t.Fatal("unknown challenge was accepted")
+	}
+
+	s = newTestAuthService(-1)
+	challenge, err := s.CreateChallenge(address)
+	if err != nil {
+		t.Fatalf("create challenge: %s", err)
+	}
+	if _, err := s.VerifyChallenge(challenge, signTestChallenge(t, key, challenge)); err == nil {
+		t.Fatal("expired challenge was accepted")
+	}
+}
+
+func TestCreateChallengeLimits(t *testing.T) {
+	s := newTestAuthService(60)
+	_, address := newTestKey(t)
+
+	for i := 0; i < maxChallengesPerAddress; i++ {
+		if _, err := s.CreateChallenge(address); err != nil {
+			t.Fatalf("create challenge %d: %s", i, err)
+		}
+	}
+	if _, err := s.CreateChallenge(address); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want ResourceExhausted for the address limit", err)
+	}
+
+	for i := len(s.challenges); i < maxChallenges; i++ {
+		if _, err := s.CreateChallenge(common.BigToAddress(big.NewInt(int64(i)))); err != nil {
+			t.Fatalf("create challenge %d: %s", i, err)
+		}
+	}
+	_, other := newTestKey(t)
+	if _, err := s.CreateChallenge(other); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want ResourceExhausted for the total limit", err)
+	}
+}
+
+func TestCreateChallengePrunesExpired(t *testing.T) {
+	s := newTestAuthService(-1)
+	_, address := newTestKey(t)
+
+	for i := 0; i < maxChallengesPerAddress+1; i++ {
+		if _, err := s.CreateChallenge(address); err != nil {
+			t.Fatalf("create challenge %d: %s", i, err)
+		}
+	}
+	if len(s.challenges) != 1 || s.counts[address] != 1 {
+		t.Fatalf("got %d open challenges, want expired ones pruned", len(s.challenges))
+	}
+}
+
+func TestAuthFunction(t *testing.T) {
+	s := newTestAuthService(60)
+	key, address := newTestKey(t)
+
+	challenge, err := s.CreateChallenge(address)
+	if err != nil {
+		t.Fatalf("create challenge: %s", err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		ChallengeHeader, hexutil.Encode(challenge),
+		SignatureHeader, hexutil.Encode(signTestChallenge(t, key, challenge)),
+	))
+
+	ctx, err = s.AuthFunction()(ctx)
+	if err != nil {
+		t.Fatalf("authenticate: %s", err)
+	}
+	if principal := ctx.Value("principal").(common.Address); principal != address {
+		t.Fatalf("principal %s, want %s", principal.Hex(), address.Hex())
+	}
+
+	if _, err := s.AuthFunction()(metadata.NewIncomingContext(context.Background(), metadata.MD{})); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("got %v, want Unauthenticated without headers", err)
+	}
+}
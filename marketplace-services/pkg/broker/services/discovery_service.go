@@ -7,92 +7,177 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/marketplace"
 	"math/big"
 )
 
-type ProductSearchQuery struct {
-	DataType     string
-	MinCost      uint64
-	MaxCost      uint64
-	MinFrequency uint64
-	MaxFrequency uint64
+// ProductSearchResult is a product matching a search. Rating is the rating of the device of the
+// product, it is only set if the query filters or sorts by rating.
+type ProductSearchResult struct {
+	Product       *contracts.Product
+	Rating        uint64
+	NextPageToken string
 }
 
 type DiscoveryService interface {
-	SearchProduct(ctx context.Context, query *ProductSearchQuery) (<-chan *contracts.Product, <-chan error)
+	SearchProduct(ctx context.Context, query *marketplace.ProductSearchQuery) (<-chan *ProductSearchResult, <-chan error)
 }
 
 type discoveryServiceImpl struct {
 	logger          logrus.FieldLogger
 	productContract contracts.ProductContract
+	deviceContract  contracts.DeviceContract
+	productIndex    ProductIndex
 	keyStore        *keystore.KeyStore
 }
 
 func NewDiscoveryServiceImpl(
 	logger logrus.FieldLogger,
 	productContract contracts.ProductContract,
+	deviceContract contracts.DeviceContract,
+	productIndex ProductIndex,
 	keyStore *keystore.KeyStore,
 ) *discoveryServiceImpl {
-	return &discoveryServiceImpl{logger: logger, productContract: productContract, keyStore: keyStore}
+	return &discoveryServiceImpl{
+		logger:          logger,
+		productContract: productContract,
+		deviceContract:  deviceContract,
+		productIndex:    productIndex,
+		keyStore:        keyStore,
+	}
 }
 
 func (s discoveryServiceImpl) SearchProduct(
 	ctx context.Context,
-	query *ProductSearchQuery,
-) (<-chan *contracts.Product, <-chan error) {
-	sink := make(chan *contracts.Product)
+	query *marketplace.ProductSearchQuery,
+) (<-chan *ProductSearchResult, <-chan error) {
+	sink := make(chan *ProductSearchResult)
 	errc := make(chan error, 1)
-	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(nil)}
 	go func() {
 		defer close(sink)
 		defer close(errc)
 
-		count, err := s.productContract.CountProducts(callOpts)
+		offset, err := marketplace.DecodePageToken(query.PageToken)
+		if err != nil {
+			errc <- status.Errorf(codes.InvalidArgument, "invalid page token: %s", err)
+			return
+		}
+
+		products, err := s.findProducts(ctx, query)
 		if err != nil {
-			errc <- fmt.Errorf("count products: %w", err)
+			errc <- err
 			return
 		}
-		for i := uint64(0); i < count.Uint64(); i++ {
+
+		var ratings map[uint64]uint64
+		if query.MinRating > 0 || query.User != "" || query.SortBy == marketplace.SortByRating {
+			products, ratings, err = s.filterByDevice(ctx, query, products)
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+
+		marketplace.SortProducts(products, ratings, query.SortBy, query.Descending)
+
+		if offset > uint64(len(products)) {
+			offset = uint64(len(products))
+		}
+		end := uint64(len(products))
+		if query.PageSize > 0 && offset+query.PageSize < end {
+			end = offset + query.PageSize
+		}
+
+		for i := offset; i < end; i++ {
+			result := &ProductSearchResult{Product: products[i], Rating: ratings[products[i].Id.Uint64()]}
+			if i == end-1 && end < uint64(len(products)) {
+				result.NextPageToken = marketplace.EncodePageToken(end)
+			}
 			select {
+			case sink <- result:
 			case <-ctx.Done():
 				errc <- ctx.Err()
 				return
-			default:
-				product, err := s.productContract.FindProductByIndex(callOpts, big.NewInt(int64(i)))
-				if err != nil {
-					errc <- fmt.Errorf("find product by index %d: %w", i, err)
-					return
-				}
-				if s.MatchSearchQuery(query, product) {
-					sink <- product
-				}
 			}
 		}
 	}()
 	return sink, errc
 }
 
-func (s discoveryServiceImpl) MatchSearchQuery(
-	query *ProductSearchQuery,
-	product *contracts.Product,
-) bool {
-	matched := true
-	if product.DataType != query.DataType && query.DataType != "" {
-		matched = false
-	}
-	if product.Cost.Uint64() < query.MinCost {
-		matched = false
-	}
-	if product.Cost.Uint64() > query.MaxCost && query.MaxCost != 0 {
-		matched = false
+func (s discoveryServiceImpl) findProducts(
+	ctx context.Context,
+	query *marketplace.ProductSearchQuery,
+) ([]*contracts.Product, error) {
+	if s.productIndex != nil {
+		if products, ok := s.productIndex.Search(query); ok {
+			return products, nil
+		}
+		s.logger.Debugf("Product index not ready, scanning product contract")
 	}
-	if product.Frequency.Uint64() < query.MinFrequency {
-		matched = false
+	return s.scanProducts(ctx, query)
+}
+
+func (s discoveryServiceImpl) scanProducts(
+	ctx context.Context,
+	query *marketplace.ProductSearchQuery,
+) ([]*contracts.Product, error) {
+	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(nil)}
+
+	count, err := s.productContract.CountProducts(callOpts)
+	if err != nil {
+		return nil, fmt.Errorf("count products: %w", err)
 	}
-	if product.Frequency.Uint64() > query.MaxFrequency && query.MaxFrequency != 0 {
-		matched = false
+
+	products := make([]*contracts.Product, 0)
+	for i := uint64(0); i < count.Uint64(); i++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+			product, err := s.productContract.FindProductByIndex(callOpts, big.NewInt(int64(i)))
+			if err != nil {
+				return nil, fmt.Errorf("find product by index %d: %w", i, err)
+			}
+			if !product.Deleted && marketplace.MatchSearchQuery(query, product) {
+				products = append(products, product)
+			}
+		}
 	}
+	return products, nil
+}
 
-	return matched
+func (s discoveryServiceImpl) filterByDevice(
+	ctx context.Context,
+	query *marketplace.ProductSearchQuery,
+	products []*contracts.Product,
+) ([]*contracts.Product, map[uint64]uint64, error) {
+	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(nil)}
+
+	devices := make(map[common.Address]*contracts.Device)
+	ratings := make(map[uint64]uint64)
+	filtered := make([]*contracts.Product, 0, len(products))
+	for _, product := range products {
+		device, ok := devices[product.Device]
+		if !ok {
+			var err error
+			device, err = s.deviceContract.FindDeviceByAddress(callOpts, product.Device)
+			if err != nil {
+				return nil, nil, fmt.Errorf("find device by address %s: %w", product.Device.Hex(), err)
+			}
+			devices[product.Device] = device
+		}
+
+		if device.Rating.Uint64() < query.MinRating {
+			continue
+		}
+		if query.User != "" && common.HexToAddress(device.User) != common.HexToAddress(query.User) {
+			continue
+		}
+		ratings[product.Id.Uint64()] = device.Rating.Uint64()
+		filtered = append(filtered, product)
+	}
+	return filtered, ratings, nil
 }
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/marketplace"
+	"math/big"
+	"testing"
+)
+
+type fakeDeviceContract struct {
+	contracts.DeviceContract
+	devices map[common.Address]*contracts.Device
+}
+
+func (c *fakeDeviceContract) FindDeviceByAddress(opts *bind.CallOpts, address common.Address) (*contracts.Device, error) {
+	return c.devices[address], nil
+}
+
+func newTestDiscoveryService() *discoveryServiceImpl {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	user := common.HexToAddress("0xa1")
+	devices := make(map[common.Address]*contracts.Device)
+	products := make([]*contracts.Product, 0)
+	for i, rating := range []int64{4, 2, 5, 3} {
+		product := newTestProduct(int64(i+1), "temperature")
+		product.Device = common.BigToAddress(big.NewInt(int64(i + 1)))
+		product.Cost = big.NewInt(int64(10 - i))
+		products = append(products, product)
+
+		device := &contracts.Device{Addr: product.Device, User: user.Hex(), Rating: big.NewInt(rating)}
+		if i == 3 {
+			device.User = common.HexToAddress("0xa2").Hex()
+		}
+		devices[device.Addr] = device
+	}
+	deleted := newTestProduct(5, "temperature")
+	deleted.Deleted = true
+	products = append(products, deleted)
+
+	return NewDiscoveryServiceImpl(
+		logger,
+		&fakeProductContract{products: products},
+		&fakeDeviceContract{devices: devices},
+		nil,
+		nil,
+	)
+}
+
+func searchTestProducts(t *testing.T, s *discoveryServiceImpl, query *marketplace.ProductSearchQuery) ([]uint64, string) {
+	sink, errc := s.SearchProduct(context.Background(), query)
+	ids := make([]uint64, 0)
+	nextPageToken := ""
+	for result := range sink {
+		ids = append(ids, result.Product.Id.Uint64())
+		nextPageToken = result.NextPageToken
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("search products: %v", err)
+	}
+	return ids, nextPageToken
+}
+
+func equalIds(a []uint64, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSearchProduct(t *testing.T) {
+	s := newTestDiscoveryService()
+
+	tests := []struct {
+		name  string
+		query *marketplace.ProductSearchQuery
+		want  []uint64
+	}{
+		{"all", &marketplace.ProductSearchQuery{}, []uint64{1, 2, 3, 4}},
+		{"min rating", &marketplace.ProductSearchQuery{MinRating: 4}, []uint64{1, 3}},
+		{"user", &marketplace.ProductSearchQuery{User: "0x00000000000000000000000000000000000000a2"}, []uint64{4}},
+		{"device", &marketplace.ProductSearchQuery{Device: common.BigToAddress(big.NewInt(2)).Hex()}, []uint64{2}},
+		{"cost", &marketplace.ProductSearchQuery{SortBy: marketplace.SortByCost}, []uint64{4, 3, 2, 1}},
+		{"rating descending", &marketplace.ProductSearchQuery{SortBy: marketplace.SortByRating, Descending: true}, []uint64{3, 1, 4, 2}},
+	}
+	for _, test := range tests {
+		if ids, _ := searchTestProducts(t, s, test.query); !equalIds(ids, test.want) {
+			t.Errorf("%s: found %v, want %v", test.name, ids, test.want)
+		}
+	}
+}
+
+func TestSearchProductPages(t *testing.T) {
+	s := newTestDiscoveryService()
+	query := &marketplace.ProductSearchQuery{SortBy: marketplace.SortByRating, PageSize: 3}
+
+	ids, token := searchTestProducts(t, s, query)
+	if !equalIds(ids, []uint64{2, 4, 1}) || token == "" {
+		t.Fatalf("first page %v with token %q, want [2 4 1] and a next page", ids, token)
+	}
+	query.PageToken = token
+	ids, token = searchTestProducts(t, s, query)
+	if !equalIds(ids, []uint64{3}) || token != "" {
+		t.Fatalf("last page %v with token %q, want [3] without a next page", ids, token)
+	}
+
+	query.PageToken = "!"
+	sink, errc := s.SearchProduct(context.Background(), query)
+	for range sink {
+	}
+	if err := <-errc; status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("search with invalid page token: got %v, want InvalidArgument", err)
+	}
+}
@@ -11,7 +11,10 @@ import (
 	"github.com/sirupsen/logrus"
 	"marketplace-services/pkg/contracts"
 	"marketplace-services/pkg/contracts/bindings"
+	"marketplace-services/pkg/watchdog"
 	"math/big"
+	"sync"
+	"time"
 )
 
 type DisputeService interface {
@@ -19,33 +22,51 @@ type DisputeService interface {
 }
 
 type disputeServiceImpl struct {
-	logger          logrus.FieldLogger
-	keyStore        *keystore.KeyStore
-	ethClient       *ethclient.Client
-	tradingContract contracts.TradingContract
-	messageService  MessageService
-	account         string
-	passphrase      string
+	logger             logrus.FieldLogger
+	keyStore           *keystore.KeyStore
+	ethClient          *ethclient.Client
+	brokerContract     contracts.BrokerContract
+	tradingContract    contracts.TradingContract
+	messageService     MessageService
+	logService         LogService
+	settlementWatchdog watchdog.SettlementWatchdog
+	account            string
+	passphrase         string
+	watched            map[uint64]bool
+	sync.Mutex
+
+	newSettlementContract func(address common.Address) (contracts.SettlementContract, error)
 }
 
 func NewDisputeServiceImpl(
 	logger logrus.FieldLogger,
 	keystore *keystore.KeyStore,
 	ethClient *ethclient.Client,
+	brokerContract contracts.BrokerContract,
 	tradingContract contracts.TradingContract,
 	messageService MessageService,
+	logService LogService,
+	settlementWatchdog watchdog.SettlementWatchdog,
 	account string,
 	passphrase string,
 ) *disputeServiceImpl {
-	return &disputeServiceImpl{
-		logger:          logger,
-		keyStore:        keystore,
-		ethClient:       ethClient,
-		tradingContract: tradingContract,
-		messageService:  messageService,
-		account:         account,
-		passphrase:      passphrase,
+	d := &disputeServiceImpl{
+		logger:             logger,
+		keyStore:           keystore,
+		ethClient:          ethClient,
+		brokerContract:     brokerContract,
+		tradingContract:    tradingContract,
+		messageService:     messageService,
+		logService:         logService,
+		settlementWatchdog: settlementWatchdog,
+		account:            account,
+		passphrase:         passphrase,
+		watched:            make(map[uint64]bool),
+	}
+	d.newSettlementContract = func(address common.Address) (contracts.SettlementContract, error) {
+		return contracts.NewSettlementContractImpl(address, d.ethClient)
 	}
+	return d
 }
 
 func (d *disputeServiceImpl) ResolveDisputes(ctx context.Context) error {
@@ -64,6 +85,10 @@ func (d *disputeServiceImpl) ResolveDisputes(ctx context.Context) error {
 	}
 	defer sub.Unsubscribe()
 
+	if err := d.recoverDisputes(ctx); err != nil {
+		d.logger.Errorf("recover disputes: %v", err)
+	}
+
 	for {
 		select {
 		case event := <-sink:
@@ -72,12 +97,7 @@ func (d *disputeServiceImpl) ResolveDisputes(ctx context.Context) error {
 			if err != nil {
 				return fmt.Errorf("find trade by id %d: %w", event.TradeId, err)
 			}
-			go func() {
-				err := d.resolveDispute(ctx, event.TradeId.Uint64(), trade.SettlementContract)
-				if err != nil {
-					d.logger.Errorf("resolve dispute with contract %s: %v", trade.SettlementContract.Hex(), err)
-				}
-			}()
+			d.watchDispute(ctx, trade)
 		case err = <-sub.Err():
 			return err
 		case <-ctx.Done():
@@ -86,8 +106,119 @@ func (d *disputeServiceImpl) ResolveDisputes(ctx context.Context) error {
 	}
 }
 
-func (d *disputeServiceImpl) resolveDispute(ctx context.Context, tradeId uint64, address common.Address) error {
-	settlementContract, err := contracts.NewSettlementContractImpl(address, d.ethClient)
+func (d *disputeServiceImpl) recoverDisputes(ctx context.Context) error {
+	callOpts := &bind.CallOpts{Context: ctx, From: common.HexToAddress(d.account)}
+	broker, err := d.brokerContract.FindBrokerByAddress(callOpts, common.HexToAddress(d.account))
+	if err != nil {
+		return fmt.Errorf("find broker by address %s: %w", d.account, err)
+	}
+	d.logger.Infof("Recovering %d trades of broker %s", len(broker.Trades), d.account)
+
+	for _, tradeId := range broker.Trades {
+		trade, err := d.tradingContract.FindTradeById(callOpts, tradeId)
+		if err != nil {
+			d.logger.Errorf("find trade by id %d: %v", tradeId, err)
+			continue
+		}
+		if err := d.recoverDispute(ctx, trade); err != nil {
+			d.logger.Errorf("recover dispute of trade %d: %v", tradeId, err)
+		}
+	}
+	return nil
+}
+
+// recoverDispute resolves a pending dispute of a trade and watches trades that can still be
+// disputed. Trades that have been paid out or can be resolved by timeout are left to the
+// settlement watchdog.
+func (d *disputeServiceImpl) recoverDispute(ctx context.Context, trade *contracts.Trade) error {
+	settlementContract, err := d.newSettlementContract(trade.SettlementContract)
+	if err != nil {
+		return fmt.Errorf("new settlement contract with address %s: %w", trade.SettlementContract.Hex(), err)
+	}
+	callOpts := &bind.CallOpts{Context: ctx, From: common.HexToAddress(d.account)}
+
+	brokerCounter, err := settlementContract.GetBrokerCounter(callOpts)
+	if err != nil {
+		return fmt.Errorf("get broker counter: %w", err)
+	}
+	if brokerCounter.Set {
+		d.logger.Debugf("Dispute of trade %d already resolved with counter %d", trade.Id, brokerCounter.Value)
+		return nil
+	}
+
+	settlement, err := settlementContract.GetSettlement(callOpts)
+	if err != nil {
+		return fmt.Errorf("get settlement: %w", err)
+	}
+	if !settlement.IsZero() {
+		d.logger.Debugf("Trade %d already paid out", trade.Id)
+		return nil
+	}
+
+	settled, err := settlementContract.IsSettled(callOpts)
+	if err != nil {
+		return fmt.Errorf("check settled: %w", err)
+	}
+	if settled {
+		d.logger.Debugf("Trade %d already settled", trade.Id)
+		return nil
+	}
+
+	if time.Now().Before(contracts.SettlementValidTime(trade.EndTime)) {
+		d.watchDispute(ctx, trade)
+		return nil
+	}
+
+	// A dispute keeps the deposit locked even after the timeout, so it is still resolved.
+	dispute, err := settlementContract.IsDispute(callOpts)
+	if err != nil {
+		return fmt.Errorf("check dispute: %w", err)
+	}
+	if dispute {
+		d.logger.Infof("Resolving pending dispute of trade %d", trade.Id)
+		return d.submitResolution(ctx, trade.Id.Uint64(), trade.SettlementContract, settlementContract)
+	}
+	d.logger.Debugf("Trade %d timed out without dispute", trade.Id)
+	return nil
+}
+
+func (d *disputeServiceImpl) watchDispute(ctx context.Context, trade *contracts.Trade) {
+	tradeId := trade.Id.Uint64()
+	d.Lock()
+	if d.watched[tradeId] {
+		d.Unlock()
+		return
+	}
+	d.watched[tradeId] = true
+	d.Unlock()
+
+	if d.settlementWatchdog != nil {
+		d.settlementWatchdog.Watch(&watchdog.Trade{
+			Id:                 tradeId,
+			SettlementContract: trade.SettlementContract.Hex(),
+			EndTime:            trade.EndTime.Uint64(),
+		})
+	}
+
+	go func() {
+		defer func() {
+			d.Lock()
+			delete(d.watched, tradeId)
+			d.Unlock()
+		}()
+		err := d.resolveDispute(ctx, trade)
+		if err != nil {
+			d.logger.Errorf("resolve dispute with contract %s: %v", trade.SettlementContract.Hex(), err)
+		}
+	}()
+}
+
+// resolveDispute resolves the dispute of a trade once it is raised. It subscribes to disputes
+// before checking for a pending one, so no dispute is missed in between, and stops watching once
+// the trade can be resolved by timeout.
+func (d *disputeServiceImpl) resolveDispute(ctx context.Context, trade *contracts.Trade) error {
+	tradeId, address := trade.Id.Uint64(), trade.SettlementContract
+	settlementContract, err := d.newSettlementContract(address)
 	if err != nil {
 		return fmt.Errorf("new settlement contract with address %s: %w", address.Hex(), err)
 	}
@@ -105,6 +236,19 @@ func (d *disputeServiceImpl) resolveDispute(ctx context.Context, tradeId uint64,
 	}
 	defer sub.Unsubscribe()
 
+	callOpts := &bind.CallOpts{Context: ctx, From: common.HexToAddress(d.account)}
+	dispute, err := settlementContract.IsDispute(callOpts)
+	if err != nil {
+		return fmt.Errorf("check dispute: %w", err)
+	}
+	if dispute {
+		d.logger.Infof("Resolving pending dispute of trade %d", tradeId)
+		return d.submitResolution(ctx, tradeId, address, settlementContract)
+	}
+
+	timeout := time.NewTimer(time.Until(contracts.SettlementValidTime(trade.EndTime)))
+	defer timeout.Stop()
+
 	select {
 	case event := <-sink:
 		d.logger.Infof(
@@ -113,32 +257,53 @@ func (d *disputeServiceImpl) resolveDispute(ctx context.Context, tradeId uint64,
 			event.ProviderCounter,
 			event.ConsumerCounter,
 		)
-		account := accounts.Account{Address: common.HexToAddress(d.account)}
-		transactOpts, err := bind.NewKeyStoreTransactor(
-			d.keyStore,
-			account,
-		)
-		if err != nil {
-			return fmt.Errorf("new keystore transactor: %w", err)
-		}
-		counter := d.messageService.FindCounter(tradeId)
-
-		if err := d.keyStore.Unlock(account, d.passphrase); err != nil {
-			return fmt.Errorf("unlock account %s: %w", account.Address.Hex(), err)
-		}
-		defer func() {
-			if err := d.keyStore.Lock(account.Address); err != nil {
-				d.logger.Warnf("lock account %s: %w", account.Address.Hex(), err)
-			}
-		}()
-		_, err = settlementContract.ResolveDispute(transactOpts, big.NewInt(int64(counter)))
-		if err != nil {
-			return fmt.Errorf("settle trade with contract %s and counter %d: %w", address.Hex(), 0, err)
-		}
+		return d.submitResolution(ctx, tradeId, address, settlementContract)
 	case err = <-sub.Err():
 		return err
+	case <-timeout.C:
+		d.logger.Debugf("Stopped watching trade %d for disputes after timeout", tradeId)
+		return nil
 	case <-ctx.Done():
 		return ctx.Err()
 	}
+}
+
+func (d *disputeServiceImpl) submitResolution(
+	ctx context.Context,
+	tradeId uint64,
+	address common.Address,
+	settlementContract contracts.SettlementContract,
+) error {
+	account := accounts.Account{Address: common.HexToAddress(d.account)}
+	transactOpts, err := bind.NewKeyStoreTransactor(
+		d.keyStore,
+		account,
+	)
+	if err != nil {
+		return fmt.Errorf("new keystore transactor: %w", err)
+	}
+	transactOpts.Context = ctx
+	counter := d.messageService.FindCounter(tradeId)
+	// Missing evidence must not keep the dispute open, it would lock the deposit until the timeout.
+	resolution, err := d.logService.SaveResolution(tradeId, counter)
+	if err != nil {
+		d.logger.Errorf("save resolution of trade %d: %v", tradeId, err)
+		d.logger.Infof("Resolving dispute of trade %d with counter %d without evidence", tradeId, counter)
+	} else {
+		d.logger.Infof("Resolving dispute of trade %d with counter %d and root %x", tradeId, counter, resolution.Root)
+	}
+
+	if err := d.keyStore.Unlock(account, d.passphrase); err != nil {
+		return fmt.Errorf("unlock account %s: %w", account.Address.Hex(), err)
+	}
+	defer func() {
+		if err := d.keyStore.Lock(account.Address); err != nil {
+			d.logger.Warnf("lock account %s: %v", account.Address.Hex(), err)
+		}
+	}()
+	_, err = settlementContract.ResolveDispute(transactOpts, big.NewInt(int64(counter)))
+	if err != nil {
+		return fmt.Errorf("settle trade with contract %s and counter %d: %w", address.Hex(), counter, err)
+	}
 	return nil
 }
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/contracts/bindings"
+	"math/big"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSettlementContract struct {
+	contracts.SettlementContract
+	settlement   contracts.Settlement
+	dispute      bool
+	disputeOnSub bool
+	sync.Mutex
+	subscribed bool
+	resolved   chan *big.Int
+}
+
+func (c *fakeSettlementContract) GetBrokerCounter(opts *bind.CallOpts) (*contracts.Counter, error) {
+	return &contracts.Counter{Value: big.NewInt(0)}, nil
+}
+
+func (c *fakeSettlementContract) GetSettlement(opts *bind.CallOpts) (*contracts.Settlement, error) {
+	return &c.settlement, nil
+}
+
+func (c *fakeSettlementContract) IsSettled(opts *bind.CallOpts) (bool, error) {
+	return false, nil
+}
+
+func (c *fakeSettlementContract) IsDispute(opts *bind.CallOpts) (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.dispute || (c.disputeOnSub && c.subscribed), nil
+}
+
+func (c *fakeSettlementContract) ResolveDispute(opts *bind.TransactOpts, counter *big.Int) (*types.Transaction, error) {
+	c.resolved <- counter
+	return nil, nil
+}
+
+// WatchDisputeEvent never delivers events, a dispute raised after subscribing is only visible to
+// IsDispute.
+func (c *fakeSettlementContract) WatchDisputeEvent(
+	opts *bind.WatchOpts,
+	sink chan<- *bindings.SettlementContractDispute,
+) (event.Subscription, error) {
+	c.Lock()
+	c.subscribed = true
+	c.Unlock()
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		return nil
+	}), nil
+}
+
+type fakeMessageService struct {
+	MessageService
+}
+
+func (s *fakeMessageService) FindCounter(tradeId uint64) uint64 {
+	return 42
+}
+
+type fakeLogService struct {
+	LogService
+}
+
+func (s *fakeLogService) SaveResolution(tradeId uint64, counter uint64) (*Resolution, error) {
+	return &Resolution{TradeId: tradeId, Counter: counter}, nil
+}
+
+func newTestDisputeService(t *testing.T, settlementContract *fakeSettlementContract) (*disputeServiceImpl, func()) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatalf("create keystore dir: %v", err)
+	}
+	ks := keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.NewAccount("passphrase")
+	if err != nil {
+		t.Fatalf("new account: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	d := NewDisputeServiceImpl(
+		logger, ks, nil, nil, nil, &fakeMessageService{}, &fakeLogService{}, nil, account.Address.Hex(), "passphrase",
+	)
+	d.newSettlementContract = func(address common.Address) (contracts.SettlementContract, error) {
+		return settlementContract, nil
+	}
+	return d, func() { os.RemoveAll(dir) }
+}
+
+func newTestTrade(endTime time.Time) *contracts.Trade {
+	return &contracts.Trade{
+		Id:                 big.NewInt(1),
+		EndTime:            big.NewInt(endTime.Unix()),
+		SettlementContract: common.HexToAddress("0x01"),
+	}
+}
+
+func awaitResolution(t *testing.T, settlementContract *fakeSettlementContract) {
+	t.Helper()
+	select {
+	case counter := <-settlementContract.resolved:
+		if counter.Uint64() != 42 {
+			t.Fatalf("resolved dispute with counter %d, want 42", counter)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispute not resolved")
+	}
+}
+
+func TestRecoverDisputeSkipsFinishedTrades(t *testing.T) {
+	trades := map[string]struct {
+		settlementContract *fakeSettlementContract
+		trade              *contracts.Trade
+	}{
+		"paid out": {
+			&fakeSettlementContract{settlement: contracts.Settlement{Provider: big.NewInt(1)}},
+			newTestTrade(time.Now()),
+		},
+		"timed out": {
+			&fakeSettlementContract{},
+			newTestTrade(time.Now().Add(-contracts.SettlementTimeout - time.Minute)),
+		},
+	}
+	for name, test := range trades {
+		d, cleanup := newTestDisputeService(t, test.settlementContract)
+		if err := d.recoverDispute(context.Background(), test.trade); err != nil {
+			t.Fatalf("%s: recover dispute: %v", name, err)
+		}
+		if test.settlementContract.subscribed || len(d.watched) != 0 {
+			t.Errorf("%s: trade watched for disputes", name)
+		}
+		cleanup()
+	}
+}
+
+func TestRecoverDisputeResolvesPendingDispute(t *testing.T) {
+	settlementContract := &fakeSettlementContract{dispute: true, resolved: make(chan *big.Int, 1)}
+	d, cleanup := newTestDisputeService(t, settlementContract)
+	defer cleanup()
+
+	trade := newTestTrade(time.Now().Add(-contracts.SettlementTimeout - time.Minute))
+	if err := d.recoverDispute(context.Background(), trade); err != nil {
+		t.Fatalf("recover dispute: %v", err)
+	}
+	awaitResolution(t, settlementContract)
+}
+
+func TestWatchDisputeSubscribesBeforeCheck(t *testing.T) {
+	settlementContract := &fakeSettlementContract{disputeOnSub: true, resolved: make(chan *big.Int, 1)}
+	d, cleanup := newTestDisputeService(t, settlementContract)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := d.recoverDispute(ctx, newTestTrade(time.Now())); err != nil {
+		t.Fatalf("recover dispute: %v", err)
+	}
+	awaitResolution(t, settlementContract)
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/marketplace"
+	"time"
+)
+
+type LogEntry struct {
+	Index uint64
+	Seq   uint64
+	Leaf  []byte
+	Chain []byte
+}
+
+type InclusionProof struct {
+	TradeId uint64
+	Seq     uint64
+	Index   uint64
+	Count   uint64
+	Leaf    []byte
+	Chain   []byte
+	Root    []byte
+	Proof   [][]byte
+}
+
+type Resolution struct {
+	TradeId    uint64
+	Counter    uint64
+	Root       []byte
+	ResolvedAt int64
+}
+
+type LogService interface {
+	FindInclusionProof(ctx context.Context, tradeId uint64, seq uint64, count uint64) (*InclusionProof, error)
+	FindResolution(ctx context.Context, tradeId uint64) (*Resolution, error)
+	SaveResolution(tradeId uint64, counter uint64) (*Resolution, error)
+}
+
+type logServiceImpl struct {
+	logger     logrus.FieldLogger
+	db         *leveldb.DB
+	authorizer *tradeAuthorizer
+}
+
+func NewLogServiceImpl(
+	logger logrus.FieldLogger,
+	db *leveldb.DB,
+	tradingContract contracts.TradingContract,
+	account common.Address,
+) *logServiceImpl {
+	return &logServiceImpl{
+		logger:     logger,
+		db:         db,
+		authorizer: newTradeAuthorizer(tradingContract, account),
+	}
+}
+
+func (s *logServiceImpl) FindInclusionProof(
+	ctx context.Context,
+	tradeId uint64,
+	seq uint64,
+	count uint64,
+) (*InclusionProof, error) {
+	if err := s.authorizer.authorizeParticipant(ctx, tradeId); err != nil {
+		return nil, err
+	}
+
+	snapshot, err := s.db.GetSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("get snapshot: %w", err)
+	}
+	defer snapshot.Release()
+
+	value, err := snapshot.Get(logSeqKey(tradeId, seq), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "message %d of trade %d not found", seq, tradeId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find index of message %d of trade %d: %w", seq, tradeId, err)
+	}
+	index := bytesToUint64(value)
+
+	if count == 0 {
+		count = ^uint64(0)
+	}
+	if index >= count {
+		return nil, status.Errorf(codes.OutOfRange, "message %d of trade %d is not within the first %d entries", seq, tradeId, count)
+	}
+
+	leaves, entry, err := findLeaves(snapshot, tradeId, count, index)
+	if err != nil {
+		return nil, fmt.Errorf("find log of trade %d: %w", tradeId, err)
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("log entry %d of trade %d not found", index, tradeId)
+	}
+
+	return &InclusionProof{
+		TradeId: tradeId,
+		Seq:     seq,
+		Index:   index,
+		Count:   uint64(len(leaves)),
+		Leaf:    entry.Leaf,
+		Chain:   entry.Chain,
+		Root:    marketplace.MerkleRoot(leaves),
+		Proof:   marketplace.MerkleProof(leaves, index),
+	}, nil
+}
+
+func (s *logServiceImpl) FindResolution(ctx context.Context, tradeId uint64) (*Resolution, error) {
+	if err := s.authorizer.authorizeParticipant(ctx, tradeId); err != nil {
+		return nil, err
+	}
+
+	value, err := s.db.Get(resolutionKey(tradeId), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "resolution of trade %d not found", tradeId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find resolution of trade %d: %w", tradeId, err)
+	}
+
+	var resolution Resolution
+	if err := json.Unmarshal(value, &resolution); err != nil {
+		return nil, fmt.Errorf("unmarshal resolution of trade %d: %w", tradeId, err)
+	}
+	return &resolution, nil
+}
+
+func (s *logServiceImpl) SaveResolution(tradeId uint64, counter uint64) (*Resolution, error) {
+	snapshot, err := s.db.GetSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("get snapshot: %w", err)
+	}
+	defer snapshot.Release()
+
+	leaves, _, err := findLeaves(snapshot, tradeId, counter, 0)
+	if err != nil {
+		return nil, fmt.Errorf("find log of trade %d: %w", tradeId, err)
+	}
+	if uint64(len(leaves)) != counter {
+		return nil, fmt.Errorf("log of trade %d has %d entries, expected %d", tradeId, len(leaves), counter)
+	}
+
+	resolution := &Resolution{
+		TradeId:    tradeId,
+		Counter:    counter,
+		Root:       marketplace.MerkleRoot(leaves),
+		ResolvedAt: time.Now().Unix(),
+	}
+	value, err := json.Marshal(resolution)
+	if err != nil {
+		return nil, fmt.Errorf("marshal resolution of trade %d: %w", tradeId, err)
+	}
+	if err := s.db.Put(resolutionKey(tradeId), value, nil); err != nil {
+		return nil, fmt.Errorf("write resolution of trade %d: %w", tradeId, err)
+	}
+
+	s.logger.Infof("Saved resolution of trade %d with counter %d and root %x", tradeId, counter, resolution.Root)
+	return resolution, nil
+}
+
+func appendLogEntry(batch *leveldb.Batch, message *Message, index uint64, prevChain []byte) ([]byte, error) {
+	leaf := marketplace.LeafHash(message.TradeId, message.Seq, message.Payload, message.Signature)
+	entry := &LogEntry{
+		Index: index,
+		Seq:   message.Seq,
+		Leaf:  leaf,
+		Chain: crypto.Keccak256(prevChain, leaf),
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("marshal log entry %d: %w", index, err)
+	}
+	batch.Put(logEntryKey(message.TradeId, index), value)
+	batch.Put(logSeqKey(message.TradeId, message.Seq), uint64ToBytes(index))
+	batch.Put(logHeadKey(message.TradeId), entry.Chain)
+	return entry.Chain, nil
+}
+
+func findLeaves(snapshot *leveldb.Snapshot, tradeId uint64, limit uint64, index uint64) ([][]byte, *LogEntry, error) {
+	iter := snapshot.NewIterator(util.BytesPrefix(tradeKey("log-entry-", tradeId)), nil)
+	defer iter.Release()
+
+	var leaves [][]byte
+	var found *LogEntry
+	for iter.Next() && uint64(len(leaves)) < limit {
+		var entry LogEntry
+		if err := json.Unmarshal(iter.Value(), &entry); err != nil {
+			return nil, nil, fmt.Errorf("unmarshal log entry %d: %w", len(leaves), err)
+		}
+		if entry.Index == index {
+			found = &entry
+		}
+		leaves = append(leaves, entry.Leaf)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, nil, err
+	}
+	return leaves, found, nil
+}
+
+func logEntryKey(tradeId uint64, index uint64) []byte {
+	return append(tradeKey("log-entry-", tradeId), uint64ToBytes(index)...)
+}
+
+func logSeqKey(tradeId uint64, seq uint64) []byte {
+	return append(tradeKey("log-seq-", tradeId), uint64ToBytes(seq)...)
+}
+
+func logHeadKey(tradeId uint64) []byte {
+	return tradeKey("log-head-", tradeId)
+}
+
+func resolutionKey(tradeId uint64) []byte {
+	return tradeKey("log-resolution-", tradeId)
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"bytes"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"io/ioutil"
+	"marketplace-services/pkg/marketplace"
+	"testing"
+)
+
+func TestAppendLogEntries(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	logService := &logServiceImpl{logger: logger, db: db}
+
+	var chain []byte
+	batch := new(leveldb.Batch)
+	for seq := uint64(0); seq < 5; seq++ {
+		message := &Message{TradeId: 7, Seq: seq + 10, Payload: []byte{byte(seq)}}
+		next, err := appendLogEntry(batch, message, seq, chain)
+		if err != nil {
+			t.Fatalf("append log entry %d: %v", seq, err)
+		}
+		if !bytes.Equal(next, crypto.Keccak256(chain, marketplace.LeafHash(message.TradeId, message.Seq, message.Payload, message.Signature))) {
+			t.Fatalf("log entry %d isn't chained to the previous one", seq)
+		}
+		chain = next
+	}
+	if err := db.Write(batch, nil); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+
+	snapshot, err := db.GetSnapshot()
+	if err != nil {
+		t.Fatalf("get snapshot: %v", err)
+	}
+	defer snapshot.Release()
+	leaves, entry, err := findLeaves(snapshot, 7, 3, 2)
+	if err != nil {
+		t.Fatalf("find leaves: %v", err)
+	}
+	if len(leaves) != 3 || entry == nil || entry.Seq != 12 {
+		t.Fatalf("found %d leaves and entry %+v, want 3 leaves and entry of message 12", len(leaves), entry)
+	}
+	if !marketplace.VerifyInclusionProof(marketplace.MerkleRoot(leaves), entry.Leaf, entry.Index, 3, marketplace.MerkleProof(leaves, entry.Index)) {
+		t.Fatal("proof of log entry not verified")
+	}
+
+	resolution, err := logService.SaveResolution(7, 5)
+	if err != nil {
+		t.Fatalf("save resolution: %v", err)
+	}
+	all, _, err := findLeaves(snapshot, 7, 5, 0)
+	if err != nil {
+		t.Fatalf("find leaves: %v", err)
+	}
+	if !bytes.Equal(resolution.Root, marketplace.MerkleRoot(all)) {
+		t.Fatal("resolution root differs from root of log")
+	}
+	if _, err := logService.SaveResolution(7, 6); err == nil {
+		t.Fatal("saved resolution with counter beyond the log")
+	}
+}
@@ -2,69 +2,461 @@ package services
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/marketplace"
+	"sync"
 )
 
+var ErrQueueFull = errors.New("queue full")
+
+type OverflowPolicy string
+
+const (
+	OverflowPolicyBlock      OverflowPolicy = "block"
+	OverflowPolicyReject     OverflowPolicy = "reject"
+	OverflowPolicyDropOldest OverflowPolicy = "dropOldest"
+)
+
+// ParseOverflowPolicy returns the overflow policy with the given name, an empty name is the block
+// policy.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(name); policy {
+	case "":
+		return OverflowPolicyBlock, nil
+	case OverflowPolicyBlock, OverflowPolicyReject, OverflowPolicyDropOldest:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q", name)
+	}
+}
+
+type QueueConfig struct {
+	Capacity       uint64
+	OverflowPolicy OverflowPolicy
+}
+
 type Message struct {
-	TradeId uint64
-	Payload []byte
+	TradeId   uint64
+	Payload   []byte
+	Seq       uint64
+	Signature []byte
+}
+
+// Delivery is a message handed to a subscriber. The message stays in the queue until it is
+// acknowledged, so it is delivered again if the subscriber fails to forward it.
+type Delivery struct {
+	*Message
+	ack     func() error
+	ackErr  error
+	acked   chan struct{}
+	ackOnce sync.Once
+}
+
+// NewDelivery creates a delivery of a message, ack removes the message from its queue.
+func NewDelivery(message *Message, ack func() error) *Delivery {
+	return &Delivery{Message: message, ack: ack, acked: make(chan struct{})}
+}
+
+// Ack removes the delivered message from the queue and lets the subscription continue with the
+// next message. Repeated calls return the result of the first one.
+func (d *Delivery) Ack() error {
+	d.ackOnce.Do(func() {
+		defer close(d.acked)
+		d.ackErr = d.ack()
+	})
+	return d.ackErr
 }
 
 type MessageService interface {
 	PushMessage(ctx context.Context, message *Message) error
 	PullMessage(ctx context.Context, tradeId uint64) (*Message, error)
+	SubscribeMessages(ctx context.Context, tradeId uint64) (<-chan *Delivery, <-chan error)
+	FindSequence(ctx context.Context, tradeId uint64) (uint64, error)
 	FindCounter(tradeId uint64) uint64
 }
 
+type queue struct {
+	head    uint64
+	tail    uint64
+	counter uint64
+	seq     uint64
+	chain   []byte
+	changed chan struct{}
+	sync.Mutex
+}
+
+func (q *queue) size() uint64 {
+	return q.tail - q.head
+}
+
+func (q *queue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
 type messageServiceImpl struct {
-	logger   logrus.FieldLogger
-	queues   map[uint64]chan *Message
-	counters map[uint64]uint64
+	logger        logrus.FieldLogger
+	db            *leveldb.DB
+	authorizer    *tradeAuthorizer
+	defaultConfig QueueConfig
+	tradeConfigs  map[uint64]QueueConfig
+	queues        map[uint64]*queue
+	sync.Mutex
 }
 
 func NewMessageServiceImpl(
 	logger logrus.FieldLogger,
+	db *leveldb.DB,
+	tradingContract contracts.TradingContract,
+	account common.Address,
+	defaultConfig QueueConfig,
+	tradeConfigs map[uint64]QueueConfig,
 ) *messageServiceImpl {
 	return &messageServiceImpl{
-		logger:   logger,
-		queues:   make(map[uint64]chan *Message),
-		counters: make(map[uint64]uint64),
+		logger:        logger,
+		db:            db,
+		authorizer:    newTradeAuthorizer(tradingContract, account),
+		defaultConfig: defaultConfig,
+		tradeConfigs:  tradeConfigs,
+		queues:        make(map[uint64]*queue),
 	}
 }
 
-func (s messageServiceImpl) PushMessage(ctx context.Context, message *Message) error {
-	queue, ok := s.queues[message.TradeId]
-	if !ok {
-		queue = make(chan *Message, 100)
-		s.queues[message.TradeId] = queue
+func (s *messageServiceImpl) PushMessage(ctx context.Context, message *Message) error {
+	if err := s.authorizer.authorizeProvider(ctx, message.TradeId); err != nil {
+		return err
+	}
+	if err := verifyMessage(message, principal(ctx)); err != nil {
+		s.logger.Warnf("Rejected message %d of trade %d: %v", message.Seq, message.TradeId, err)
+		return status.Errorf(codes.InvalidArgument, "verify message %d of trade %d: %s", message.Seq, message.TradeId, err)
+	}
+
+	q, err := s.findQueue(message.TradeId)
+	if err != nil {
+		return fmt.Errorf("find queue of trade %d: %w", message.TradeId, err)
 	}
+	config := s.queueConfig(message.TradeId)
+
+	value, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("marshal message of trade %d: %w", message.TradeId, err)
+	}
+
+	for {
+		q.Lock()
+		if message.Seq <= q.seq {
+			q.Unlock()
+			s.logger.Warnf("Rejected message %d of trade %d: sequence number not increasing", message.Seq, message.TradeId)
+			return status.Errorf(
+				codes.InvalidArgument,
+				"message %d of trade %d: sequence number must be greater than %d",
+				message.Seq,
+				message.TradeId,
+				q.seq,
+			)
+		}
+
+		batch := new(leveldb.Batch)
+		dropped := false
+		if config.Capacity != 0 && q.size() >= config.Capacity {
+			switch config.OverflowPolicy {
+			case OverflowPolicyReject:
+				q.Unlock()
+				return fmt.Errorf("push message to trade %d: %w", message.TradeId, ErrQueueFull)
+			case OverflowPolicyDropOldest:
+				batch.Delete(messageKey(message.TradeId, q.head))
+				batch.Put(headKey(message.TradeId), uint64ToBytes(q.head+1))
+				dropped = true
+			default:
+				changed := q.changed
+				q.Unlock()
+				select {
+				case <-changed:
+					continue
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+
+		batch.Put(messageKey(message.TradeId, q.tail), value)
+		batch.Put(tailKey(message.TradeId), uint64ToBytes(q.tail+1))
+		batch.Put(counterKey(message.TradeId), uint64ToBytes(q.counter+1))
+		batch.Put(seqKey(message.TradeId), uint64ToBytes(message.Seq))
+		chain, err := appendLogEntry(batch, message, q.counter, q.chain)
+		if err != nil {
+			q.Unlock()
+			return fmt.Errorf("append message of trade %d to log: %w", message.TradeId, err)
+		}
+		if err := s.db.Write(batch, nil); err != nil {
+			q.Unlock()
+			return fmt.Errorf("write message of trade %d: %w", message.TradeId, err)
+		}
+		if dropped {
+			s.logger.Warnf("Dropped oldest message of trade %d", message.TradeId)
+			q.head++
+		}
+		q.tail++
+		q.counter++
+		q.seq = message.Seq
+		q.chain = chain
+		q.notify()
+		q.Unlock()
 
-	select {
-	case queue <- message:
 		s.logger.Infof("Enqueued message for trade %d", message.TradeId)
-		s.counters[message.TradeId] = s.counters[message.TradeId] + 1
 		return nil
-	case <-ctx.Done():
-		return ctx.Err()
 	}
 }
 
-func (s messageServiceImpl) PullMessage(ctx context.Context, tradeId uint64) (*Message, error) {
-	queue, ok := s.queues[tradeId]
-	if !ok {
-		queue = make(chan *Message, 100)
-		s.queues[tradeId] = queue
+func (s *messageServiceImpl) PullMessage(ctx context.Context, tradeId uint64) (*Message, error) {
+	if err := s.authorizer.authorizeConsumer(ctx, tradeId); err != nil {
+		return nil, err
+	}
+	message, index, err := s.peekMessage(ctx, tradeId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.removeMessage(tradeId, index); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// peekMessage waits for the oldest message of the trade and returns it with its index without
+// removing it from the queue.
+func (s *messageServiceImpl) peekMessage(ctx context.Context, tradeId uint64) (*Message, uint64, error) {
+	q, err := s.findQueue(tradeId)
+	if err != nil {
+		return nil, 0, fmt.Errorf("find queue of trade %d: %w", tradeId, err)
+	}
+
+	for {
+		q.Lock()
+		if q.size() == 0 {
+			changed := q.changed
+			q.Unlock()
+			select {
+			case <-changed:
+				continue
+			case <-ctx.Done():
+				return nil, 0, ctx.Err()
+			}
+		}
+
+		index := q.head
+		value, err := s.db.Get(messageKey(tradeId, index), nil)
+		q.Unlock()
+		if err != nil {
+			return nil, 0, fmt.Errorf("get message %d of trade %d: %w", index, tradeId, err)
+		}
+
+		var message Message
+		if err := json.Unmarshal(value, &message); err != nil {
+			return nil, 0, fmt.Errorf("unmarshal message of trade %d: %w", tradeId, err)
+		}
+		return &message, index, nil
+	}
+}
+
+// removeMessage removes the message with the given index from the queue. Messages that were
+// already removed, e.g. dropped because the queue overflowed, are ignored.
+func (s *messageServiceImpl) removeMessage(tradeId uint64, index uint64) error {
+	q, err := s.findQueue(tradeId)
+	if err != nil {
+		return fmt.Errorf("find queue of trade %d: %w", tradeId, err)
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	if q.size() == 0 || q.head != index {
+		return nil
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Delete(messageKey(tradeId, index))
+	batch.Put(headKey(tradeId), uint64ToBytes(index+1))
+	if err := s.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("remove message %d of trade %d: %w", index, tradeId, err)
+	}
+	q.head++
+	q.notify()
+
+	s.logger.Infof("Dequeued message for trade %d", tradeId)
+	return nil
+}
+
+// SubscribeMessages delivers the messages of the trade one at a time. The next message is only
+// delivered after the previous one was acknowledged.
+func (s *messageServiceImpl) SubscribeMessages(ctx context.Context, tradeId uint64) (<-chan *Delivery, <-chan error) {
+	sink := make(chan *Delivery)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(sink)
+		defer close(errc)
+
+		if err := s.authorizer.authorizeConsumer(ctx, tradeId); err != nil {
+			errc <- err
+			return
+		}
+
+		for {
+			message, index, err := s.peekMessage(ctx, tradeId)
+			if err != nil {
+				errc <- err
+				return
+			}
+			delivery := NewDelivery(message, func() error {
+				return s.removeMessage(tradeId, index)
+			})
+			select {
+			case sink <- delivery:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+			select {
+			case <-delivery.acked:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return sink, errc
+}
+
+// FindSequence returns the sequence number of the last message accepted for the trade, so the
+// provider can continue with the next one.
+func (s *messageServiceImpl) FindSequence(ctx context.Context, tradeId uint64) (uint64, error) {
+	if err := s.authorizer.authorizeProvider(ctx, tradeId); err != nil {
+		return 0, err
+	}
+	q, err := s.findQueue(tradeId)
+	if err != nil {
+		return 0, fmt.Errorf("find queue of trade %d: %w", tradeId, err)
+	}
+	q.Lock()
+	defer q.Unlock()
+	return q.seq, nil
+}
+
+func (s *messageServiceImpl) FindCounter(tradeId uint64) uint64 {
+	q, err := s.findQueue(tradeId)
+	if err != nil {
+		s.logger.Errorf("find queue of trade %d: %v", tradeId, err)
+		return 0
 	}
+	q.Lock()
+	defer q.Unlock()
+	return q.counter
+}
 
-	select {
-	case message := <-queue:
-		s.logger.Infof("Dequeued message for trade %d", message.TradeId)
-		return message, nil
-	case <-ctx.Done():
-		return nil, ctx.Err()
+func (s *messageServiceImpl) queueConfig(tradeId uint64) QueueConfig {
+	if config, ok := s.tradeConfigs[tradeId]; ok {
+		return config
 	}
+	return s.defaultConfig
 }
 
-func (s messageServiceImpl) FindCounter(tradeId uint64) uint64 {
-	return s.counters[tradeId]
+func (s *messageServiceImpl) findQueue(tradeId uint64) (*queue, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	q, ok := s.queues[tradeId]
+	if ok {
+		return q, nil
+	}
+
+	head, err := s.findUint64(headKey(tradeId))
+	if err != nil {
+		return nil, fmt.Errorf("find head: %w", err)
+	}
+	tail, err := s.findUint64(tailKey(tradeId))
+	if err != nil {
+		return nil, fmt.Errorf("find tail: %w", err)
+	}
+	counter, err := s.findUint64(counterKey(tradeId))
+	if err != nil {
+		return nil, fmt.Errorf("find counter: %w", err)
+	}
+
+	seq, err := s.findUint64(seqKey(tradeId))
+	if err != nil {
+		return nil, fmt.Errorf("find seq: %w", err)
+	}
+
+	chain, err := s.db.Get(logHeadKey(tradeId), nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return nil, fmt.Errorf("find log head: %w", err)
+	}
+
+	q = &queue{head: head, tail: tail, counter: counter, seq: seq, chain: chain, changed: make(chan struct{})}
+	s.queues[tradeId] = q
+	return q, nil
+}
+
+func (s *messageServiceImpl) findUint64(key []byte) (uint64, error) {
+	value, err := s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return bytesToUint64(value), nil
+}
+
+func messageKey(tradeId uint64, seq uint64) []byte {
+	return append(tradeKey("queue-message-", tradeId), uint64ToBytes(seq)...)
+}
+
+func headKey(tradeId uint64) []byte {
+	return tradeKey("queue-head-", tradeId)
+}
+
+func tailKey(tradeId uint64) []byte {
+	return tradeKey("queue-tail-", tradeId)
+}
+
+func counterKey(tradeId uint64) []byte {
+	return tradeKey("queue-counter-", tradeId)
+}
+
+func seqKey(tradeId uint64) []byte {
+	return tradeKey("queue-seq-", tradeId)
+}
+
+func tradeKey(prefix string, tradeId uint64) []byte {
+	return append([]byte(prefix), uint64ToBytes(tradeId)...)
+}
+
+func uint64ToBytes(value uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, value)
+	return data
+}
+
+func bytesToUint64(data []byte) uint64 {
+	return binary.BigEndian.Uint64(data)
+}
+
+func verifyMessage(message *Message, provider common.Address) error {
+	pubKey, err := crypto.SigToPub(marketplace.MessageHash(message.TradeId, message.Seq, message.Payload), message.Signature)
+	if err != nil {
+		return fmt.Errorf("recover public key: %w", err)
+	}
+	if signer := crypto.PubkeyToAddress(*pubKey); signer != provider {
+		return fmt.Errorf("signed by %s instead of provider %s", signer.Hex(), provider.Hex())
+	}
+	return nil
 }
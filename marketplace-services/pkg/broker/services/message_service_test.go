@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/marketplace"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const testTradeId = 1
+
+type fakeTradingContract struct {
+	contracts.TradingContract
+	trade *contracts.Trade
+}
+
+func (c *fakeTradingContract) FindTradeById(opts *bind.CallOpts, id *big.Int) (*contracts.Trade, error) {
+	return c.trade, nil
+}
+
+type testTrade struct {
+	service     *messageServiceImpl
+	providerKey *ecdsa.PrivateKey
+	provider    context.Context
+	consumer    context.Context
+}
+
+func newTestMessageService(t *testing.T, defaultConfig QueueConfig, tradeConfigs map[uint64]QueueConfig) *testTrade {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	providerKey, provider := newTestKey(t)
+	_, consumer := newTestKey(t)
+	broker := common.HexToAddress("0xb0")
+	tradingContract := &fakeTradingContract{trade: &contracts.Trade{
+		Id:       big.NewInt(testTradeId),
+		Provider: provider,
+		Consumer: consumer,
+		Broker:   broker,
+	}}
+
+	return &testTrade{
+		service:     NewMessageServiceImpl(logger, db, tradingContract, broker, defaultConfig, tradeConfigs),
+		providerKey: providerKey,
+		provider:    context.WithValue(context.Background(), "principal", provider),
+		consumer:    context.WithValue(context.Background(), "principal", consumer),
+	}
+}
+
+func (tt *testTrade) message(t *testing.T, seq uint64) *Message {
+	payload := []byte{byte(seq)}
+	signature, err := crypto.Sign(marketplace.MessageHash(testTradeId, seq, payload), tt.providerKey)
+	if err != nil {
+		t.Fatalf("sign message %d: %v", seq, err)
+	}
+	return &Message{TradeId: testTradeId, Payload: payload, Seq: seq, Signature: signature}
+}
+
+func (tt *testTrade) push(t *testing.T, seqs ...uint64) {
+	for _, seq := range seqs {
+		if err := tt.service.PushMessage(tt.provider, tt.message(t, seq)); err != nil {
+			t.Fatalf("push message %d: %v", seq, err)
+		}
+	}
+}
+
+func (tt *testTrade) pull(t *testing.T) uint64 {
+	message, err := tt.service.PullMessage(tt.consumer, testTradeId)
+	if err != nil {
+		t.Fatalf("pull message: %v", err)
+	}
+	return message.Seq
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for name, want := range map[string]OverflowPolicy{
+		"":           OverflowPolicyBlock,
+		"block":      OverflowPolicyBlock,
+		"reject":     OverflowPolicyReject,
+		"dropOldest": OverflowPolicyDropOldest,
+	} {
+		if policy, err := ParseOverflowPolicy(name); err != nil || policy != want {
+			t.Errorf("parse %q: got %q, %v, want %q", name, policy, err, want)
+		}
+	}
+	for _, name := range []string{"drop", "Block", "dropoldest"} {
+		if _, err := ParseOverflowPolicy(name); err == nil {
+			t.Errorf("parsed unknown overflow policy %q", name)
+		}
+	}
+}
+
+func TestPushMessageRejectPolicy(t *testing.T) {
+	tt := newTestMessageService(t, QueueConfig{Capacity: 2, OverflowPolicy: OverflowPolicyReject}, nil)
+	tt.push(t, 1, 2)
+
+	if err := tt.service.PushMessage(tt.provider, tt.message(t, 3)); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("push to full queue: got %v, want %v", err, ErrQueueFull)
+	}
+	if seq := tt.pull(t); seq != 1 {
+		t.Fatalf("pulled message %d, want 1", seq)
+	}
+	tt.push(t, 3)
+	if counter := tt.service.FindCounter(testTradeId); counter != 3 {
+		t.Fatalf("counter %d, want 3 without the rejected message", counter)
+	}
+}
+
+func TestPushMessageDropOldestPolicy(t *testing.T) {
+	tt := newTestMessageService(t, QueueConfig{Capacity: 2, OverflowPolicy: OverflowPolicyDropOldest}, nil)
+	tt.push(t, 1, 2, 3)
+
+	if seq := tt.pull(t); seq != 2 {
+		t.Fatalf("pulled message %d, want 2 after dropping the oldest", seq)
+	}
+	if seq := tt.pull(t); seq != 3 {
+		t.Fatalf("pulled message %d, want 3", seq)
+	}
+	if counter := tt.service.FindCounter(testTradeId); counter != 3 {
+		t.Fatalf("counter %d, want 3 including the dropped message", counter)
+	}
+}
+
+func TestPushMessageBlockPolicy(t *testing.T) {
+	tt := newTestMessageService(t, QueueConfig{Capacity: 1, OverflowPolicy: OverflowPolicyBlock}, nil)
+	tt.push(t, 1)
+
+	ctx, cancel := context.WithTimeout(tt.provider, 50*time.Millisecond)
+	defer cancel()
+	if err := tt.service.PushMessage(ctx, tt.message(t, 2)); err != context.DeadlineExceeded {
+		t.Fatalf("push to full queue: got %v, want to block until the deadline", err)
+	}
+
+	pushed := make(chan error, 1)
+	go func() {
+		pushed <- tt.service.PushMessage(tt.provider, tt.message(t, 2))
+	}()
+	if seq := tt.pull(t); seq != 1 {
+		t.Fatalf("pulled message %d, want 1", seq)
+	}
+	select {
+	case err := <-pushed:
+		if err != nil {
+			t.Fatalf("push after pull: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("push still blocked after pull")
+	}
+}
+
+func TestPushMessageTradeConfig(t *testing.T) {
+	tt := newTestMessageService(
+		t,
+		QueueConfig{Capacity: 1, OverflowPolicy: OverflowPolicyBlock},
+		map[uint64]QueueConfig{testTradeId: {Capacity: 1, OverflowPolicy: OverflowPolicyReject}},
+	)
+	tt.push(t, 1)
+	if err := tt.service.PushMessage(tt.provider, tt.message(t, 2)); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("push to full queue: got %v, want the reject policy of the trade", err)
+	}
+}
+
+func TestPushMessageRejectsInvalidMessages(t *testing.T) {
+	tt := newTestMessageService(t, QueueConfig{}, nil)
+	tt.push(t, 2)
+
+	if err := tt.service.PushMessage(tt.provider, tt.message(t, 2)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("push repeated sequence number: got %v, want InvalidArgument", err)
+	}
+	tampered := tt.message(t, 3)
+	tampered.Payload = []byte("tampered")
+	if err := tt.service.PushMessage(tt.provider, tampered); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("push tampered message: got %v, want InvalidArgument", err)
+	}
+	if err := tt.service.PushMessage(tt.consumer, tt.message(t, 3)); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("push as consumer: got %v, want PermissionDenied", err)
+	}
+	if seq, err := tt.service.FindSequence(tt.provider, testTradeId); err != nil || seq != 2 {
+		t.Errorf("find sequence: got %d, %v, want 2", seq, err)
+	}
+}
+
+func TestSubscribeMessagesWaitsForAck(t *testing.T) {
+	tt := newTestMessageService(t, QueueConfig{}, nil)
+	tt.push(t, 1, 2)
+
+	ctx, cancel := context.WithCancel(tt.consumer)
+	defer cancel()
+	sink, errc := tt.service.SubscribeMessages(ctx, testTradeId)
+
+	delivery := <-sink
+	if delivery.Seq != 1 {
+		t.Fatalf("delivered message %d, want 1", delivery.Seq)
+	}
+	select {
+	case next := <-sink:
+		t.Fatalf("delivered message %d before ack", next.Seq)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := delivery.Ack(); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if err := delivery.Ack(); err != nil {
+		t.Fatalf("repeated ack: %v", err)
+	}
+	if next := <-sink; next.Seq != 2 {
+		t.Fatalf("delivered message %d, want 2", next.Seq)
+	}
+
+	cancel()
+	for range sink {
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("subscription ended with %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestMessagesSurviveRestart(t *testing.T) {
+	tt := newTestMessageService(t, QueueConfig{}, nil)
+	tt.push(t, 1, 2)
+	tt.pull(t)
+
+	restarted := NewMessageServiceImpl(
+		tt.service.logger,
+		tt.service.db,
+		tt.service.authorizer.tradingContract,
+		tt.service.authorizer.account,
+		QueueConfig{},
+		nil,
+	)
+	if counter := restarted.FindCounter(testTradeId); counter != 2 {
+		t.Fatalf("counter %d after restart, want 2", counter)
+	}
+	message, err := restarted.PullMessage(tt.consumer, testTradeId)
+	if err != nil || message.Seq != 2 {
+		t.Fatalf("pulled %+v, %v after restart, want message 2", message, err)
+	}
+}
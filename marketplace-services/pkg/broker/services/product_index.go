@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/contracts/bindings"
+	"marketplace-services/pkg/marketplace"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+const productPrefix = "product-"
+
+type ProductIndex interface {
+	Sync(ctx context.Context)
+	Search(query *marketplace.ProductSearchQuery) ([]*contracts.Product, bool)
+}
+
+type productIndexImpl struct {
+	logger          logrus.FieldLogger
+	productContract contracts.ProductContract
+	db              *leveldb.DB
+	products        map[uint64]*contracts.Product
+	ready           bool
+	sync.RWMutex
+}
+
+// NewProductIndexImpl creates an index of the products in the product contract. If db is not nil,
+// the index is persisted and loaded on startup. A loaded index may be stale, so queries are only
+// answered from it once the first sync has rebuilt it from the contract.
+func NewProductIndexImpl(
+	logger logrus.FieldLogger,
+	productContract contracts.ProductContract,
+	db *leveldb.DB,
+) (*productIndexImpl, error) {
+	index := &productIndexImpl{
+		logger:          logger,
+		productContract: productContract,
+		db:              db,
+		products:        make(map[uint64]*contracts.Product),
+	}
+	if db != nil {
+		if err := index.load(); err != nil {
+			return nil, fmt.Errorf("load product index: %w", err)
+		}
+	}
+	return index, nil
+}
+
+// Sync builds the index from the product contract and keeps it current with the product events
+// until the context is cancelled. The index is rebuilt if the subscriptions fail.
+func (i *productIndexImpl) Sync(ctx context.Context) {
+	eventSync := &contracts.EventSync{
+		Name:   "product index",
+		Logger: i.logger,
+		Watches: []contracts.EventWatch{
+			func(opts *bind.WatchOpts) (interface{}, event.Subscription, error) {
+				sink := make(chan *bindings.ProductContractCreatedProduct)
+				sub, err := i.productContract.WatchCreatedProductEvent(opts, sink, nil)
+				return sink, sub, err
+			},
+			func(opts *bind.WatchOpts) (interface{}, event.Subscription, error) {
+				sink := make(chan *bindings.ProductContractUpdatedProduct)
+				sub, err := i.productContract.WatchUpdatedProductEvent(opts, sink, nil, nil)
+				return sink, sub, err
+			},
+			func(opts *bind.WatchOpts) (interface{}, event.Subscription, error) {
+				sink := make(chan *bindings.ProductContractRemovedProduct)
+				sub, err := i.productContract.WatchRemovedProductEvent(opts, sink, nil, nil)
+				return sink, sub, err
+			},
+		},
+		Load:       i.rebuild,
+		Apply:      i.apply,
+		Invalidate: i.invalidate,
+	}
+	eventSync.Run(ctx)
+}
+
+// Search returns the indexed products matching the query ordered by id. The second return value
+// is false if the index has not been built by a sync yet.
+func (i *productIndexImpl) Search(query *marketplace.ProductSearchQuery) ([]*contracts.Product, bool) {
+	i.RLock()
+	defer i.RUnlock()
+	if !i.ready {
+		return nil, false
+	}
+
+	products := make([]*contracts.Product, 0)
+	for _, product := range i.products {
+		if marketplace.MatchSearchQuery(query, product) {
+			products = append(products, product)
+		}
+	}
+	sort.Slice(products, func(a, b int) bool {
+		return products[a].Id.Cmp(products[b].Id) < 0
+	})
+	return products, true
+}
+
+func (i *productIndexImpl) rebuild(ctx context.Context) error {
+	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(nil)}
+	count, err := i.productContract.CountProducts(callOpts)
+	if err != nil {
+		return fmt.Errorf("count products: %w", err)
+	}
+
+	products := make(map[uint64]*contracts.Product)
+	for j := uint64(0); j < count.Uint64(); j++ {
+		product, err := i.productContract.FindProductByIndex(callOpts, new(big.Int).SetUint64(j))
+		if err != nil {
+			return fmt.Errorf("find product by index %d: %w", j, err)
+		}
+		if product.Deleted {
+			continue
+		}
+		products[product.Id.Uint64()] = product
+	}
+
+	if i.db != nil {
+		if err := i.persist(products); err != nil {
+			return fmt.Errorf("persist product index: %w", err)
+		}
+	}
+
+	i.Lock()
+	i.products = products
+	i.ready = true
+	i.Unlock()
+
+	i.logger.Infof("Indexed %d of %d products", len(products), count.Uint64())
+	return nil
+}
+
+func (i *productIndexImpl) apply(ctx context.Context, e interface{}) error {
+	switch e := e.(type) {
+	case *bindings.ProductContractCreatedProduct:
+		return i.refresh(ctx, e.Id)
+	case *bindings.ProductContractUpdatedProduct:
+		return i.refresh(ctx, e.Id)
+	case *bindings.ProductContractRemovedProduct:
+		i.logger.Debugf("Removing product %d from index", e.Id)
+		return i.remove(e.Id.Uint64())
+	}
+	return nil
+}
+
+// invalidate stops answering queries from the index until it was rebuilt, because events may have
+// been missed while the subscriptions were down.
+func (i *productIndexImpl) invalidate() {
+	i.Lock()
+	i.ready = false
+	i.Unlock()
+}
+
+func (i *productIndexImpl) refresh(ctx context.Context, id *big.Int) error {
+	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(nil)}
+	product, err := i.productContract.FindProductById(callOpts, id)
+	if err != nil {
+		return fmt.Errorf("find product by id %d: %w", id, err)
+	}
+	if product.Deleted {
+		return i.remove(id.Uint64())
+	}
+
+	if i.db != nil {
+		value, err := json.Marshal(product)
+		if err != nil {
+			return fmt.Errorf("marshal product %d: %w", id, err)
+		}
+		if err := i.db.Put(productKey(id.Uint64()), value, nil); err != nil {
+			return fmt.Errorf("write product %d: %w", id, err)
+		}
+	}
+
+	i.logger.Debugf("Indexing product %d", id)
+	i.Lock()
+	i.products[id.Uint64()] = product
+	i.Unlock()
+	return nil
+}
+
+func (i *productIndexImpl) remove(id uint64) error {
+	if i.db != nil {
+		if err := i.db.Delete(productKey(id), nil); err != nil {
+			return fmt.Errorf("delete product %d: %w", id, err)
+		}
+	}
+
+	i.Lock()
+	delete(i.products, id)
+	i.Unlock()
+	return nil
+}
+
+func (i *productIndexImpl) load() error {
+	iter := i.db.NewIterator(util.BytesPrefix([]byte(productPrefix)), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var product contracts.Product
+		if err := json.Unmarshal(iter.Value(), &product); err != nil {
+			return fmt.Errorf("unmarshal product: %w", err)
+		}
+		i.products[product.Id.Uint64()] = &product
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	i.logger.Infof("Loaded %d products from persisted index", len(i.products))
+	return nil
+}
+
+func (i *productIndexImpl) persist(products map[uint64]*contracts.Product) error {
+	batch := new(leveldb.Batch)
+
+	iter := i.db.NewIterator(util.BytesPrefix([]byte(productPrefix)), nil)
+	for iter.Next() {
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	for id, product := range products {
+		value, err := json.Marshal(product)
+		if err != nil {
+			return fmt.Errorf("marshal product %d: %w", id, err)
+		}
+		batch.Put(productKey(id), value)
+	}
+	return i.db.Write(batch, nil)
+}
+
+func productKey(id uint64) []byte {
+	return append([]byte(productPrefix), uint64ToBytes(id)...)
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"io/ioutil"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/contracts/bindings"
+	"marketplace-services/pkg/marketplace"
+	"math/big"
+	"testing"
+)
+
+type fakeProductContract struct {
+	contracts.ProductContract
+	products []*contracts.Product
+}
+
+func (c *fakeProductContract) CountProducts(*bind.CallOpts) (*big.Int, error) {
+	return big.NewInt(int64(len(c.products))), nil
+}
+
+func (c *fakeProductContract) FindProductByIndex(opts *bind.CallOpts, index *big.Int) (*contracts.Product, error) {
+	return c.products[index.Uint64()], nil
+}
+
+func (c *fakeProductContract) FindProductById(opts *bind.CallOpts, id *big.Int) (*contracts.Product, error) {
+	for _, product := range c.products {
+		if product.Id.Cmp(id) == 0 {
+			return product, nil
+		}
+	}
+	return nil, fmt.Errorf("product %d not found", id)
+}
+
+func newTestProduct(id int64, dataType string) *contracts.Product {
+	return &contracts.Product{
+		Id:        big.NewInt(id),
+		Name:      fmt.Sprintf("product %d", id),
+		DataType:  dataType,
+		Frequency: big.NewInt(1),
+		Cost:      big.NewInt(10),
+	}
+}
+
+func newTestProductIndex(t *testing.T, db *leveldb.DB, products ...*contracts.Product) (*productIndexImpl, *fakeProductContract) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	productContract := &fakeProductContract{products: products}
+	index, err := NewProductIndexImpl(logger, productContract, db)
+	if err != nil {
+		t.Fatalf("new product index: %v", err)
+	}
+	return index, productContract
+}
+
+func searchTestProductIndex(t *testing.T, index *productIndexImpl, query *marketplace.ProductSearchQuery) []uint64 {
+	products, ok := index.Search(query)
+	if !ok {
+		t.Fatal("index not ready")
+	}
+	ids := make([]uint64, len(products))
+	for j, product := range products {
+		ids[j] = product.Id.Uint64()
+	}
+	return ids
+}
+
+func TestProductIndexNotReadyBeforeSync(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+
+	index, _ := newTestProductIndex(t, db, newTestProduct(1, "temperature"), newTestProduct(2, "humidity"))
+	if err := index.rebuild(context.Background()); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+
+	deleted := newTestProduct(2, "humidity")
+	deleted.Deleted = true
+	loaded, _ := newTestProductIndex(t, db, newTestProduct(1, "temperature"), deleted)
+	if len(loaded.products) != 2 {
+		t.Fatalf("loaded %d products, want 2", len(loaded.products))
+	}
+	if _, ok := loaded.Search(&marketplace.ProductSearchQuery{}); ok {
+		t.Fatal("stale persisted index answered a query before the first sync")
+	}
+
+	if err := loaded.rebuild(context.Background()); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	if ids := searchTestProductIndex(t, loaded, &marketplace.ProductSearchQuery{}); len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("found products %v after sync, want [1]", ids)
+	}
+}
+
+func TestProductIndexApply(t *testing.T) {
+	index, productContract := newTestProductIndex(t, nil, newTestProduct(1, "temperature"))
+	if _, ok := index.Search(&marketplace.ProductSearchQuery{}); ok {
+		t.Fatal("index answered a query before the first sync")
+	}
+	if err := index.rebuild(context.Background()); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+
+	productContract.products = append(productContract.products, newTestProduct(2, "temperature"))
+	if err := index.apply(context.Background(), &bindings.ProductContractCreatedProduct{Id: big.NewInt(2)}); err != nil {
+		t.Fatalf("apply created product: %v", err)
+	}
+	productContract.products[0] = newTestProduct(1, "humidity")
+	if err := index.apply(context.Background(), &bindings.ProductContractUpdatedProduct{Id: big.NewInt(1)}); err != nil {
+		t.Fatalf("apply updated product: %v", err)
+	}
+	if ids := searchTestProductIndex(t, index, &marketplace.ProductSearchQuery{DataType: "temperature"}); len(ids) != 1 || ids[0] != 2 {
+		t.Fatalf("found products %v, want [2]", ids)
+	}
+
+	if err := index.apply(context.Background(), &bindings.ProductContractRemovedProduct{Id: big.NewInt(2)}); err != nil {
+		t.Fatalf("apply removed product: %v", err)
+	}
+	if ids := searchTestProductIndex(t, index, &marketplace.ProductSearchQuery{}); len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("found products %v, want [1]", ids)
+	}
+
+	index.invalidate()
+	if _, ok := index.Search(&marketplace.ProductSearchQuery{}); ok {
+		t.Fatal("invalidated index answered a query")
+	}
+}
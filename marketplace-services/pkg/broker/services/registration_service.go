@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/sirupsen/logrus"
+	"marketplace-services/pkg/contracts"
+	"time"
+)
+
+type RegistrationService interface {
+	Register(ctx context.Context) error
+	Reconcile(ctx context.Context) error
+	Heartbeat(ctx context.Context, interval time.Duration) error
+	Deregister(ctx context.Context) error
+}
+
+type registrationServiceImpl struct {
+	logger         logrus.FieldLogger
+	keyStore       *keystore.KeyStore
+	ethClient      *ethclient.Client
+	brokerContract contracts.BrokerContract
+	broker         *contracts.Broker
+	user           string
+	passphrase     string
+}
+
+func NewRegistrationServiceImpl(
+	logger logrus.FieldLogger,
+	keyStore *keystore.KeyStore,
+	ethClient *ethclient.Client,
+	brokerContract contracts.BrokerContract,
+	broker *contracts.Broker,
+	user string,
+	passphrase string,
+) *registrationServiceImpl {
+	return &registrationServiceImpl{
+		logger:         logger,
+		keyStore:       keyStore,
+		ethClient:      ethClient,
+		brokerContract: brokerContract,
+		broker:         broker,
+		user:           user,
+		passphrase:     passphrase,
+	}
+}
+
+func (s *registrationServiceImpl) Register(ctx context.Context) error {
+	exists, err := s.brokerContract.ExistsBrokerByAddress(s.callOpts(ctx), s.broker.Addr)
+	if err != nil {
+		return fmt.Errorf("exists broker by address %s: %w", s.broker.Addr.Hex(), err)
+	}
+	if exists {
+		return s.Reconcile(ctx)
+	}
+
+	s.logger.Infof("Registering broker %s as %s at %s", s.broker.Addr.Hex(), s.broker.Name, s.broker.HostAddr)
+	err = s.transact(ctx, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return s.brokerContract.CreateBroker(opts, s.broker)
+	})
+	if err != nil {
+		return fmt.Errorf("create broker %s: %w", s.broker.Addr.Hex(), err)
+	}
+	return nil
+}
+
+func (s *registrationServiceImpl) Reconcile(ctx context.Context) error {
+	registered, err := s.brokerContract.FindBrokerByAddress(s.callOpts(ctx), s.broker.Addr)
+	if err != nil {
+		return fmt.Errorf("find broker by address %s: %w", s.broker.Addr.Hex(), err)
+	}
+	if registered.Deleted {
+		return fmt.Errorf("broker %s has been removed and cannot be registered again", s.broker.Addr.Hex())
+	}
+	if registered.User != common.HexToAddress(s.user) {
+		return fmt.Errorf("broker %s is owned by %s instead of %s", s.broker.Addr.Hex(), registered.User.Hex(), s.user)
+	}
+
+	drift := false
+	if registered.Name != s.broker.Name {
+		s.logger.Warnf("Registered name %q of broker differs from %q", registered.Name, s.broker.Name)
+		drift = true
+	}
+	if registered.HostAddr != s.broker.HostAddr {
+		s.logger.Warnf("Registered host address %s of broker differs from %s", registered.HostAddr, s.broker.HostAddr)
+		drift = true
+	}
+	if registered.Location != s.broker.Location {
+		s.logger.Warnf("Registered location %d of broker differs from %d", registered.Location, s.broker.Location)
+		drift = true
+	}
+	if !drift {
+		s.logger.Debugf("Registration of broker %s is up to date", s.broker.Addr.Hex())
+		return nil
+	}
+
+	s.logger.Infof("Updating registration of broker %s", s.broker.Addr.Hex())
+	err = s.transact(ctx, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return s.brokerContract.UpdateBroker(opts, s.broker)
+	})
+	if err != nil {
+		return fmt.Errorf("update broker %s: %w", s.broker.Addr.Hex(), err)
+	}
+	return nil
+}
+
+// Heartbeat registers the broker and then reconciles its registration every interval until ctx is
+// done. Failed registrations are retried every interval as well.
+func (s *registrationServiceImpl) Heartbeat(ctx context.Context, interval time.Duration) error {
+	registered := s.heartbeat(ctx, false)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			registered = s.heartbeat(ctx, registered)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *registrationServiceImpl) heartbeat(ctx context.Context, registered bool) bool {
+	if registered {
+		if err := s.Reconcile(ctx); err != nil {
+			s.logger.Errorf("reconcile broker registration: %v", err)
+		}
+		return true
+	}
+	if err := s.Register(ctx); err != nil {
+		s.logger.Errorf("register broker: %v", err)
+		return false
+	}
+	return true
+}
+
+func (s *registrationServiceImpl) Deregister(ctx context.Context) error {
+	exists, err := s.brokerContract.ExistsBrokerByAddressAndDeleted(s.callOpts(ctx), s.broker.Addr, false)
+	if err != nil {
+		return fmt.Errorf("exists broker by address %s: %w", s.broker.Addr.Hex(), err)
+	}
+	if !exists {
+		return nil
+	}
+
+	s.logger.Infof("Removing broker %s", s.broker.Addr.Hex())
+	err = s.transact(ctx, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return s.brokerContract.RemoveBroker(opts, s.broker.Addr)
+	})
+	if err != nil {
+		return fmt.Errorf("remove broker %s: %w", s.broker.Addr.Hex(), err)
+	}
+	return nil
+}
+
+func (s *registrationServiceImpl) transact(
+	ctx context.Context,
+	send func(opts *bind.TransactOpts) (*types.Transaction, error),
+) error {
+	account := accounts.Account{Address: common.HexToAddress(s.user)}
+	transactOpts, err := bind.NewKeyStoreTransactor(
+		s.keyStore,
+		account,
+	)
+	if err != nil {
+		return fmt.Errorf("new keystore transactor: %w", err)
+	}
+	transactOpts.Context = ctx
+
+	if err := s.keyStore.Unlock(account, s.passphrase); err != nil {
+		return fmt.Errorf("unlock account %s: %w", account.Address.Hex(), err)
+	}
+	tx, err := send(transactOpts)
+	if err := s.keyStore.Lock(account.Address); err != nil {
+		s.logger.Warnf("lock account %s: %v", account.Address.Hex(), err)
+	}
+	if err != nil {
+		return err
+	}
+
+	receipt, err := bind.WaitMined(ctx, s.ethClient, tx)
+	if err != nil {
+		return fmt.Errorf("wait for transaction %s: %w", tx.Hash().Hex(), err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return fmt.Errorf("transaction %s failed", tx.Hash().Hex())
+	}
+	return nil
+}
+
+func (s *registrationServiceImpl) callOpts(ctx context.Context) *bind.CallOpts {
+	return &bind.CallOpts{Context: ctx, From: common.HexToAddress(s.user)}
+}
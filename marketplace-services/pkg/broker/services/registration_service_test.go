@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"marketplace-services/pkg/contracts"
+	"os"
+	"strings"
+	"testing"
+)
+
+// errTestSent is returned by the fake broker contract instead of a transaction, so tests can check
+// which transaction would have been sent without waiting for it to be mined.
+var errTestSent = errors.New("sent")
+
+type fakeBrokerContract struct {
+	contracts.BrokerContract
+	registered *contracts.Broker
+	sent       []string
+}
+
+func (c *fakeBrokerContract) CreateBroker(opts *bind.TransactOpts, broker *contracts.Broker) (*types.Transaction, error) {
+	c.sent = append(c.sent, "create")
+	return nil, errTestSent
+}
+
+func (c *fakeBrokerContract) UpdateBroker(opts *bind.TransactOpts, broker *contracts.Broker) (*types.Transaction, error) {
+	c.sent = append(c.sent, "update")
+	return nil, errTestSent
+}
+
+func (c *fakeBrokerContract) RemoveBroker(opts *bind.TransactOpts, addr common.Address) (*types.Transaction, error) {
+	c.sent = append(c.sent, "remove")
+	return nil, errTestSent
+}
+
+func (c *fakeBrokerContract) FindBrokerByAddress(opts *bind.CallOpts, addr common.Address) (*contracts.Broker, error) {
+	return c.registered, nil
+}
+
+func (c *fakeBrokerContract) ExistsBrokerByAddress(opts *bind.CallOpts, addr common.Address) (bool, error) {
+	return c.registered != nil, nil
+}
+
+func (c *fakeBrokerContract) ExistsBrokerByAddressAndDeleted(opts *bind.CallOpts, addr common.Address, deleted bool) (bool, error) {
+	return c.registered != nil && c.registered.Deleted == deleted, nil
+}
+
+func newTestRegistrationService(t *testing.T, registered *contracts.Broker) (*registrationServiceImpl, *fakeBrokerContract, func()) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	keyStore := keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP)
+	account, err := keyStore.NewAccount("password")
+	if err != nil {
+		t.Fatalf("new account: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	broker := &contracts.Broker{
+		Addr:     common.HexToAddress("0xb0"),
+		User:     account.Address,
+		Name:     "broker",
+		HostAddr: "broker:25565",
+		Location: contracts.LocationEUW,
+	}
+	if registered != nil {
+		registered.Addr = broker.Addr
+		if registered.User == (common.Address{}) {
+			registered.User = account.Address
+		}
+	}
+	brokerContract := &fakeBrokerContract{registered: registered}
+	s := NewRegistrationServiceImpl(logger, keyStore, nil, brokerContract, broker, account.Address.Hex(), "password")
+	return s, brokerContract, func() { os.RemoveAll(dir) }
+}
+
+func TestRegister(t *testing.T) {
+	s, brokerContract, cleanup := newTestRegistrationService(t, nil)
+	defer cleanup()
+
+	if err := s.Register(context.Background()); !errors.Is(err, errTestSent) {
+		t.Fatalf("register: got %v, want create transaction", err)
+	}
+	if len(brokerContract.sent) != 1 || brokerContract.sent[0] != "create" {
+		t.Fatalf("sent %v, want [create]", brokerContract.sent)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name       string
+		registered *contracts.Broker
+		sent       []string
+		err        string
+	}{
+		{"up to date", &contracts.Broker{Name: "broker", HostAddr: "broker:25565", Location: contracts.LocationEUW}, nil, ""},
+		{"name drift", &contracts.Broker{Name: "old", HostAddr: "broker:25565", Location: contracts.LocationEUW}, []string{"update"}, "sent"},
+		{"host drift", &contracts.Broker{Name: "broker", HostAddr: "old:25565", Location: contracts.LocationEUW}, []string{"update"}, "sent"},
+		{"location drift", &contracts.Broker{Name: "broker", HostAddr: "broker:25565", Location: contracts.LocationNA}, []string{"update"}, "sent"},
+		{"removed", &contracts.Broker{Name: "broker", HostAddr: "broker:25565", Location: contracts.LocationEUW, Deleted: true}, nil, "removed"},
+		{"other owner", &contracts.Broker{User: common.HexToAddress("0xa1"), Name: "broker", HostAddr: "broker:25565", Location: contracts.LocationEUW}, nil, "owned by"},
+	}
+	for _, test := range tests {
+		s, brokerContract, cleanup := newTestRegistrationService(t, test.registered)
+		err := s.Register(context.Background())
+		cleanup()
+
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got %v, want error containing %q", test.name, err, test.err)
+		}
+		if strings.Join(brokerContract.sent, ",") != strings.Join(test.sent, ",") {
+			t.Errorf("%s: sent %v, want %v", test.name, brokerContract.sent, test.sent)
+		}
+	}
+}
+
+func TestHeartbeatRetriesRegistration(t *testing.T) {
+	s, brokerContract, cleanup := newTestRegistrationService(t, nil)
+	defer cleanup()
+
+	if s.heartbeat(context.Background(), false) {
+		t.Fatal("failed registration reported as registered")
+	}
+	registered := *s.broker
+	brokerContract.registered = &registered
+	if !s.heartbeat(context.Background(), false) {
+		t.Fatal("registration not retried")
+	}
+
+	registered.HostAddr = "old:25565"
+	if !s.heartbeat(context.Background(), true) {
+		t.Fatal("failed reconciliation reported as unregistered")
+	}
+	if strings.Join(brokerContract.sent, ",") != "create,update" {
+		t.Fatalf("sent %v, want [create update]", brokerContract.sent)
+	}
+}
+
+func TestDeregister(t *testing.T) {
+	s, brokerContract, cleanup := newTestRegistrationService(t, &contracts.Broker{Deleted: true})
+	defer cleanup()
+
+	if err := s.Deregister(context.Background()); err != nil {
+		t.Fatalf("deregister removed broker: %v", err)
+	}
+	brokerContract.registered.Deleted = false
+	if err := s.Deregister(context.Background()); !errors.Is(err, errTestSent) {
+		t.Fatalf("deregister: got %v, want remove transaction", err)
+	}
+	if len(brokerContract.sent) != 1 || brokerContract.sent[0] != "remove" {
+		t.Fatalf("sent %v, want [remove]", brokerContract.sent)
+	}
+}
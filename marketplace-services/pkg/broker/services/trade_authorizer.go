@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/contracts"
+	"math/big"
+	"sync"
+)
+
+type tradeAuthorizer struct {
+	tradingContract contracts.TradingContract
+	account         common.Address
+	trades          map[uint64]*contracts.Trade
+	sync.Mutex
+}
+
+func newTradeAuthorizer(tradingContract contracts.TradingContract, account common.Address) *tradeAuthorizer {
+	return &tradeAuthorizer{
+		tradingContract: tradingContract,
+		account:         account,
+		trades:          make(map[uint64]*contracts.Trade),
+	}
+}
+
+func (a *tradeAuthorizer) authorizeProvider(ctx context.Context, tradeId uint64) error {
+	trade, err := a.authorize(ctx, tradeId)
+	if err != nil {
+		return err
+	}
+	if principal(ctx) != trade.Provider {
+		return status.Errorf(codes.PermissionDenied, "only the provider may push messages to trade %d", tradeId)
+	}
+	return nil
+}
+
+func (a *tradeAuthorizer) authorizeConsumer(ctx context.Context, tradeId uint64) error {
+	trade, err := a.authorize(ctx, tradeId)
+	if err != nil {
+		return err
+	}
+	if principal(ctx) != trade.Consumer {
+		return status.Errorf(codes.PermissionDenied, "only the consumer may pull messages of trade %d", tradeId)
+	}
+	return nil
+}
+
+func (a *tradeAuthorizer) authorizeParticipant(ctx context.Context, tradeId uint64) error {
+	trade, err := a.authorize(ctx, tradeId)
+	if err != nil {
+		return err
+	}
+	if p := principal(ctx); p != trade.Provider && p != trade.Consumer {
+		return status.Errorf(codes.PermissionDenied, "only the provider or consumer may access trade %d", tradeId)
+	}
+	return nil
+}
+
+func (a *tradeAuthorizer) authorize(ctx context.Context, tradeId uint64) (*contracts.Trade, error) {
+	if _, ok := ctx.Value("principal").(common.Address); !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "no authenticated principal")
+	}
+	trade, err := a.findTrade(ctx, tradeId)
+	if err != nil {
+		return nil, fmt.Errorf("find trade %d: %w", tradeId, err)
+	}
+	if trade.Broker != a.account {
+		return nil, status.Errorf(codes.PermissionDenied, "trade %d is not assigned to this broker", tradeId)
+	}
+	return trade, nil
+}
+
+func (a *tradeAuthorizer) findTrade(ctx context.Context, tradeId uint64) (*contracts.Trade, error) {
+	a.Lock()
+	trade, ok := a.trades[tradeId]
+	a.Unlock()
+	if ok {
+		return trade, nil
+	}
+
+	trade, err := a.tradingContract.FindTradeById(
+		&bind.CallOpts{Context: ctx, From: a.account},
+		new(big.Int).SetUint64(tradeId),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Lock()
+	a.trades[tradeId] = trade
+	a.Unlock()
+	return trade, nil
+}
+
+func principal(ctx context.Context) common.Address {
+	address, _ := ctx.Value("principal").(common.Address)
+	return address
+}
@@ -6,9 +6,10 @@ import (
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
 	"marketplace-services/pkg/domain"
 	"marketplace-services/pkg/proxy/api"
+	"marketplace-services/pkg/watchdog"
+	"marketplace-services/pkg/watchdog/remote"
 	"os"
 	"strconv"
 	"time"
@@ -20,11 +21,13 @@ type consumer struct {
 	logger                          logrus.FieldLogger
 	proxy                           *grpc.ClientConn
 	authServiceClient               api.AuthServiceClient
+	tokenSource                     *api.TokenSource
 	walletServiceClient             api.WalletServiceClient
 	discoveryServiceClient          api.DiscoveryServiceClient
 	tradingContractServiceClient    api.TradingContractServiceClient
 	settlementContractServiceClient api.SettlementContractServiceClient
 	cryptoMessageServiceClient      api.CryptoMessageServiceClient
+	watchdog                        watchdog.SettlementWatchdog
 }
 
 func New(opt ...Option) (*consumer, error) {
@@ -39,27 +42,51 @@ func New(opt ...Option) (*consumer, error) {
 		}
 	}
 
-	proxy, err := grpc.Dial(opts.ProxyConfig.Address+":"+strconv.Itoa(opts.ProxyConfig.Port), grpc.WithInsecure())
+	tokenSource := api.NewTokenSource(opts.ProxyConfig.Username, []byte(opts.ProxyConfig.Password))
+	if opts.ProxyConfig.ApiKey != "" {
+		tokenSource = api.NewApiKeyTokenSource(opts.ProxyConfig.ApiKey)
+	}
+	proxy, err := grpc.Dial(
+		opts.ProxyConfig.Address+":"+strconv.Itoa(opts.ProxyConfig.Port),
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(tokenSource.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(tokenSource.StreamClientInterceptor()),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("dial proxy: %w", err)
 	}
 	authService := api.NewAuthServiceClient(proxy)
+	tokenSource.SetClient(authService)
 	walletService := api.NewWalletServiceClient(proxy)
 	discoveryService := api.NewDiscoveryServiceClient(proxy)
 	tradingService := api.NewTradingContractServiceClient(proxy)
 	settlementService := api.NewSettlementContractServiceClient(proxy)
 	messageService := api.NewCryptoMessageServiceClient(proxy)
+	logger := initLogger(opts)
+
+	var settlementWatchdog watchdog.SettlementWatchdog
+	if opts.WatchdogConfig.Enabled {
+		settlementWatchdog = watchdog.NewSettlementWatchdogImpl(
+			logger,
+			remote.NewSettlementBackend(settlementService),
+			time.Duration(opts.WatchdogConfig.Interval)*time.Second,
+			time.Duration(opts.WatchdogConfig.SettledTimeout)*time.Second,
+		)
+	}
+
 	return &consumer{
 		opts:                            opts,
 		ctx:                             context.Background(),
-		logger:                          initLogger(opts),
+		logger:                          logger,
 		proxy:                           proxy,
 		authServiceClient:               authService,
+		tokenSource:                     tokenSource,
 		walletServiceClient:             walletService,
 		discoveryServiceClient:          discoveryService,
 		tradingContractServiceClient:    tradingService,
 		settlementContractServiceClient: settlementService,
 		cryptoMessageServiceClient:      messageService,
+		watchdog:                        settlementWatchdog,
 	}, nil
 }
 
@@ -92,35 +119,14 @@ func (c *consumer) Run() error {
 
 	c.logger.Infof("Get access token for proxy %s", c.proxy.Target())
 
-	getTokenRequest := &api.GetTokenRequest{
-		Username: proxyConfig.Username,
-		Password: []byte(proxyConfig.Password),
-	}
-	getTokenResponse, err := c.authServiceClient.GetToken(c.ctx, getTokenRequest)
-	if err != nil {
-		return err
-	}
-
-	md := metadata.Pairs("authorization", "bearer "+getTokenResponse.Token)
-	ctx := metadata.NewOutgoingContext(c.ctx, md)
-
-	c.logger.Infof("Searching for a broker in location %s", domain.Location(searchConfig.BrokerLocation))
-
-	brokerStream, err := c.discoveryServiceClient.SearchBroker(ctx, &api.SearchBrokerRequest{
-		Query: &domain.BrokerSearchQuery{
-			Locations: []domain.Location{domain.Location(searchConfig.BrokerLocation)},
-		},
-	})
-	if err != nil {
-		return err
-	}
-	searchBrokerResponse, err := brokerStream.Recv()
-	if err != nil {
+	if _, err := c.tokenSource.Token(c.ctx); err != nil {
 		return err
 	}
+	ctx := c.ctx
 
 	c.logger.Infof(
-		"Search for a product with type %s in price range [%d-%d] and frequency range [%d-%d]",
+		"Search brokers in location %s for a product with type %s in price range [%d-%d] and frequency range [%d-%d]",
+		domain.Location(searchConfig.BrokerLocation),
 		searchConfig.DataType,
 		searchConfig.MinCost,
 		searchConfig.MaxCost,
@@ -128,9 +134,11 @@ func (c *consumer) Run() error {
 		searchConfig.MaxFrequency,
 	)
 
-	productStream, err := c.discoveryServiceClient.SearchProductWithBroker(ctx, &api.SearchProductWithBrokerRequest{
-		BrokerAddr: searchBrokerResponse.Broker.HostAddr,
-		Query: &domain.ProductSearchQuery{
+	productStream, err := c.discoveryServiceClient.SearchProducts(ctx, &api.SearchProductsRequest{
+		BrokerQuery: &domain.BrokerSearchQuery{
+			Locations: []domain.Location{domain.Location(searchConfig.BrokerLocation)},
+		},
+		ProductQuery: &domain.ProductSearchQuery{
 			DataType:     searchConfig.DataType,
 			MinCost:      searchConfig.MinCost,
 			MaxCost:      searchConfig.MaxCost,
@@ -142,9 +150,17 @@ func (c *consumer) Run() error {
 		return err
 	}
 
-	searchProductResponse, err := productStream.Recv()
-	if err != nil {
-		return err
+	var searchProductResponse *api.SearchProductsResponse
+	for searchProductResponse == nil {
+		response, err := productStream.Recv()
+		if err != nil {
+			return err
+		}
+		if response.Product == nil {
+			c.logger.Warnf("Broker %s failed to search products: %s", response.Broker.HostAddr, response.Error)
+			continue
+		}
+		searchProductResponse = response
 	}
 
 	c.logger.Infof("Request to buy product %d", searchProductResponse.Product.Id)
@@ -161,7 +177,7 @@ func (c *consumer) Run() error {
 	endTime := startTime.Add(60 * time.Second)
 	_, err = c.tradingContractServiceClient.RequestTrading(ctx, &api.RequestTradingRequest{
 		Product:   uint64(searchProductResponse.Product.Id),
-		Broker:    searchBrokerResponse.Broker.Address,
+		Broker:    searchProductResponse.Broker.Address,
 		StartTime: uint64(startTime.Unix()),
 		EndTime:   uint64(endTime.Unix()),
 	})
@@ -212,37 +228,82 @@ func (c *consumer) Run() error {
 	waitTime := time.Until(startTime)
 	c.logger.Infof("Waiting %s with transmission until trade %d starts", waitTime, findTradeResponse.Trade.Id)
 
-	stop := time.After(time.Until(endTime))
-	deadlineContext, _ := context.WithDeadline(ctx, endTime)
+	deadlineContext, cancel := context.WithDeadline(ctx, endTime)
+	defer cancel()
 
 	counter := 0
-	for {
-		select {
-		case <-stop:
-			c.logger.Infof("Settle trade with counter %d", counter)
-			_, err = c.settlementContractServiceClient.SettleTrade(ctx, &api.SettleTradeRequest{
-				ContractAddress: findTradeResponse.Trade.SettlementContract,
-				Counter:         uint64(counter),
-			})
-			c.logger.Infof("Finished trade %d", findTradeResponse.Trade.Id)
-			c.logger.Infof("Shutdown consumer")
-			return err
-		default:
-			pullMessageResponse, err := c.cryptoMessageServiceClient.DecryptAndPullMessage(deadlineContext, &api.DecryptAndPullMessageRequest{
-				BrokerAddr: searchBrokerResponse.Broker.HostAddr,
-				TradeId:    findTradeResponse.Trade.Id,
-			})
-			if err != nil {
-				break
+	for deadlineContext.Err() == nil {
+		received, err := c.subscribeMessages(
+			deadlineContext,
+			searchProductResponse.Broker.HostAddr,
+			findTradeResponse.Trade.Id,
+		)
+		counter += received
+		if err != nil && deadlineContext.Err() == nil {
+			c.logger.Errorf("subscribe messages of trade %d: %v", findTradeResponse.Trade.Id, err)
+			select {
+			case <-time.After(time.Second):
+			case <-deadlineContext.Done():
 			}
+		}
+	}
 
-			counter++
-			payload := pullMessageResponse.Message.Payload
-			value := int64(binary.LittleEndian.Uint64(payload))
-			if err != nil {
-				break
-			}
-			c.logger.Printf("Received value %d", value)
+	c.logger.Infof("Settle trade with counter %d", counter)
+	_, err = c.settlementContractServiceClient.SettleTrade(ctx, &api.SettleTradeRequest{
+		ContractAddress: findTradeResponse.Trade.SettlementContract,
+		Counter:         uint64(counter),
+	})
+	if err != nil {
+		c.logger.Errorf("settle trade %d: %v", findTradeResponse.Trade.Id, err)
+	}
+
+	if c.watchdog != nil {
+		c.logger.Infof("Waiting for settlement of trade %d", findTradeResponse.Trade.Id)
+		report, err := c.watchdog.Await(ctx, &watchdog.Trade{
+			Id:                 findTradeResponse.Trade.Id,
+			SettlementContract: findTradeResponse.Trade.SettlementContract,
+			EndTime:            findTradeResponse.Trade.EndTime,
+		})
+		if err != nil {
+			return err
+		}
+		if report != nil {
+			c.logger.Infof("Settlement watchdog %s", report)
+		}
+	}
+
+	c.logger.Infof("Finished trade %d", findTradeResponse.Trade.Id)
+	c.logger.Infof("Shutdown consumer")
+	return err
+}
+
+func (c *consumer) subscribeMessages(ctx context.Context, brokerAddr string, tradeId uint64) (int, error) {
+	stream, err := c.cryptoMessageServiceClient.DecryptAndSubscribeMessages(ctx)
+	if err != nil {
+		return 0, err
+	}
+	err = stream.Send(&api.DecryptAndSubscribeMessagesRequest{
+		BrokerAddr: brokerAddr,
+		TradeId:    tradeId,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	counter := 0
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			return counter, err
+		}
+
+		counter++
+		payload := response.Message.Payload
+		value := int64(binary.LittleEndian.Uint64(payload))
+		c.logger.Printf("Received value %d", value)
+
+		if err := stream.Send(&api.DecryptAndSubscribeMessagesRequest{AckSeq: response.Message.Seq}); err != nil {
+			return counter, err
 		}
 	}
 }
@@ -6,10 +6,11 @@ import (
 )
 
 type options struct {
-	ConfigFile    string
-	ProxyConfig   ProxyConfig   `json:"proxyConfig"`
-	LoggingConfig LoggingConfig `json:"loggingConfig"`
-	SearchConfig  SearchConfig  `json:"searchConfig"`
+	ConfigFile     string
+	ProxyConfig    ProxyConfig    `json:"proxyConfig"`
+	LoggingConfig  LoggingConfig  `json:"loggingConfig"`
+	SearchConfig   SearchConfig   `json:"searchConfig"`
+	WatchdogConfig WatchdogConfig `json:"watchdogConfig"`
 }
 
 type ProxyConfig struct {
@@ -17,6 +18,7 @@ type ProxyConfig struct {
 	Port     int    `json:"port"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	ApiKey   string `json:"apiKey"`
 	Account  string `json:"account"`
 }
 
@@ -33,6 +35,12 @@ type SearchConfig struct {
 	MaxFrequency   uint64 `json:"maxFrequency"`
 }
 
+type WatchdogConfig struct {
+	Enabled        bool `json:"enabled"`
+	Interval       int  `json:"interval"`
+	SettledTimeout int  `json:"settledTimeout"`
+}
+
 type Option interface {
 	apply(*options)
 }
@@ -72,6 +80,11 @@ func defaultOptions() options {
 			MinFrequency:   1,
 			MaxFrequency:   10,
 		},
+		WatchdogConfig: WatchdogConfig{
+			Enabled:        true,
+			Interval:       60,
+			SettledTimeout: 300,
+		},
 	}
 }
 
@@ -108,3 +121,9 @@ func WithSearchConfig(searchConfig SearchConfig) Option {
 		o.SearchConfig = searchConfig
 	})
 }
+
+func WithWatchdogConfig(watchdogConfig WatchdogConfig) Option {
+	return newFuncOption(func(o *options) {
+		o.WatchdogConfig = watchdogConfig
+	})
+}
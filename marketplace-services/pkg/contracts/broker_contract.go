@@ -40,6 +40,9 @@ type Broker struct {
 	Location Location
 	Trades   []*big.Int
 	Deleted  bool
+
+	// GeoLocation is nil unless the proxy knows the geographic location of the broker.
+	GeoLocation *GeoLocation
 }
 
 func BrokerStructToBroker(result struct {
@@ -115,11 +118,11 @@ func (b *brokerContractImpl) CountBrokers(opts *bind.CallOpts) (*big.Int, error)
 }
 
 func (b *brokerContractImpl) ExistsBrokerByAddress(opts *bind.CallOpts, addr common.Address) (bool, error) {
-	return b.ExistsBrokerByAddress(opts, addr)
+	return b.binding.ExistsByAddress(opts, addr)
 }
 
 func (b *brokerContractImpl) ExistsBrokerByAddressAndDeleted(opts *bind.CallOpts, addr common.Address, deleted bool) (bool, error) {
-	return b.ExistsBrokerByAddressAndDeleted(opts, addr, deleted)
+	return b.binding.ExistsByAddressAndDeleted(opts, addr, deleted)
 }
 
 func (b *brokerContractImpl) WatchCreatedBrokerEvent(opts *bind.WatchOpts, sink chan<- *bindings.BrokerContractCreatedBroker, addr []common.Address) (event.Subscription, error) {
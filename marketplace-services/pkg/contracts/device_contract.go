@@ -18,6 +18,8 @@ type Device struct {
 	PublicKey   []byte
 	Rating      *big.Int
 	Deleted     bool
+
+	GeoLocation *GeoLocation
 }
 
 func DeviceStructToDevice(result struct {
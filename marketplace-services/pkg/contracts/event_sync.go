@@ -0,0 +1,106 @@
+package contracts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/sirupsen/logrus"
+	"reflect"
+	"time"
+)
+
+const (
+	minSyncBackoff = time.Second
+	maxSyncBackoff = time.Minute
+)
+
+// EventWatch subscribes to one kind of contract event. It returns the channel the events are sent
+// to, which must be a channel of the event binding type.
+type EventWatch func(opts *bind.WatchOpts) (sink interface{}, sub event.Subscription, err error)
+
+// EventSync keeps local state current with contract events. It subscribes to the events, loads the
+// state and applies the events until a subscription fails, then starts over with backoff.
+type EventSync struct {
+	Name    string
+	Logger  logrus.FieldLogger
+	Watches []EventWatch
+	// Load replaces the local state with the state of the contract, it may be nil.
+	Load func(ctx context.Context) error
+	// Apply updates the local state with an event received from one of the watches.
+	Apply func(ctx context.Context, event interface{}) error
+	// Invalidate marks the local state as stale until it is loaded again, it may be nil.
+	Invalidate func()
+}
+
+// Run syncs until the context is cancelled.
+func (s *EventSync) Run(ctx context.Context) {
+	backoff := minSyncBackoff
+	for {
+		started := time.Now()
+		err := s.sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if s.Invalidate != nil {
+			s.Invalidate()
+		}
+		if time.Since(started) > maxSyncBackoff {
+			backoff = minSyncBackoff
+		}
+		s.Logger.Errorf("sync %s, retrying in %s: %v", s.Name, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > maxSyncBackoff {
+			backoff = maxSyncBackoff
+		}
+	}
+}
+
+func (s *EventSync) sync(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The first case is the context, followed by the event and error channel of each watch.
+	cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}}
+	for _, watch := range s.Watches {
+		sink, sub, err := watch(&bind.WatchOpts{Context: ctx})
+		if err != nil {
+			return fmt.Errorf("watch events: %w", err)
+		}
+		defer sub.Unsubscribe()
+		cases = append(
+			cases,
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(sink)},
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(sub.Err())},
+		)
+	}
+
+	if s.Load != nil {
+		if err := s.Load(ctx); err != nil {
+			return fmt.Errorf("load: %w", err)
+		}
+	}
+
+	for {
+		chosen, value, ok := reflect.Select(cases)
+		switch {
+		case chosen == 0:
+			return ctx.Err()
+		case chosen%2 == 0:
+			if err, _ := value.Interface().(error); ok && err != nil {
+				return err
+			}
+			return errors.New("subscription closed")
+		default:
+			if err := s.Apply(ctx, value.Interface()); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package contracts
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const earthRadius = 6371.0
+
+// GeoLocation describes where a broker or device is with an ISO 3166-1 alpha-2 country code and
+// optional coordinates in decimal degrees. It is not stored in the contracts, which only know the
+// Location region of a broker, but in the database of the proxy that created or updated the broker
+// or device. Other proxies only know the region of such a broker.
+type GeoLocation struct {
+	Country        string
+	Latitude       float64
+	Longitude      float64
+	HasCoordinates bool
+}
+
+type region struct {
+	latitude  float64
+	longitude float64
+	countries string
+}
+
+var regions = map[Location]region{
+	LocationBR:   {-14.235, -51.925, "BR"},
+	LocationEUNE: {51.919, 19.145, "AL AX BA BG BY CY CZ DK EE FI FO GR HR HU IS LT LV MD ME MK NO PL RO RS SE SI SJ SK UA"},
+	LocationEUW:  {46.228, 2.214, "AD AT BE CH DE ES FR GB GG GI IE IM IT JE LI LU MC MT NL PT SM VA"},
+	LocationLAN: {23.635, -102.553, "AG AI AW BB BL BQ BS BZ CO CR CU CW DM DO EC GD GP GT HN HT JM KN KY LC MF MQ MS MX NI " +
+		"PA PE PR SV SX TC TT VC VE VG VI"},
+	LocationLAS: {-38.416, -63.617, "AR BO CL FK PY UY"},
+	LocationNA:  {37.090, -95.713, "BM CA GL PM UM US"},
+	LocationOCE: {-25.274, 133.775, "AS AU CK FJ FM GU KI MH MP NC NF NR NU NZ PF PG PW SB TK TO TV VU WF WS"},
+	LocationRU:  {61.524, 105.319, "RU"},
+	LocationTR:  {38.964, 35.243, "TR"},
+	LocationJP:  {36.205, 138.253, "JP"},
+	LocationPH:  {12.880, 121.774, "PH"},
+	LocationSG:  {1.352, 103.820, "BN ID MY SG"},
+	LocationTW:  {23.698, 120.961, "HK MO TW"},
+	LocationVN:  {14.058, 108.277, "VN"},
+	LocationTH:  {15.870, 100.993, "TH"},
+	LocationKR:  {35.908, 127.767, "KR"},
+	LocationCN:  {35.862, 104.195, "CN"},
+}
+
+// fallbackRegions assigns the countries outside of all regions to the region their brokers are
+// stored in, unless their coordinates are known.
+var fallbackRegions = map[Location]string{
+	LocationBR:  "GF GY SR",
+	LocationEUW: "AO BF BJ BW CD CF CG CI CM CV DZ EH GA GH GM GN GQ GW LR LS LY MA ML MR MW MZ NA NE NG SH SL SN ST SZ TD TG TN ZA ZM ZW",
+	LocationKR:  "KP",
+	LocationLAS: "BV GS",
+	LocationOCE: "AQ HM PN TF",
+	LocationRU:  "KG KZ MN TJ TM UZ",
+	LocationSG:  "AF BD BT CC CX IN IO LK MV NP PK TL",
+	LocationTH:  "KH LA MM",
+	LocationTR:  "AE AM AZ BH BI DJ EG ER ET GE IL IQ IR JO KE KM KW LB MG MU OM PS QA RE RW SA SC SD SO SS SY TZ UG YE YT",
+}
+
+var countryCodes = strings.Fields(`
+	AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ
+	CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM DO DZ EC EE EG EH ER ES ET FI FJ FK FM FO FR
+	GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM HN HR HT HU ID IE IL IM IN IO IQ IR IS IT JE JM JO
+	JP KE KG KH KI KM KN KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR
+	MS MT MU MV MW MX MY MZ NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM PN PR PS PT PW PY QA RE RO
+	RS RU RW SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ TC TD TF TG TH TJ TK TL TM TN TO TR TT TV
+	TW TZ UA UG UM US UY UZ VA VC VE VG VI VN VU WF WS YE YT ZA ZM ZW
+`)
+
+var (
+	countries                 = make(map[string]bool)
+	locationByCountry         = make(map[string]Location)
+	fallbackLocationByCountry = make(map[string]Location)
+)
+
+func init() {
+	for _, code := range countryCodes {
+		countries[code] = true
+	}
+	for location, r := range regions {
+		for _, code := range strings.Fields(r.countries) {
+			locationByCountry[code] = location
+		}
+	}
+	for location, codes := range fallbackRegions {
+		for _, code := range strings.Fields(codes) {
+			fallbackLocationByCountry[code] = location
+		}
+	}
+}
+
+// IsCountryCode reports whether code is an ISO 3166-1 alpha-2 country code, ignoring case.
+func IsCountryCode(code string) bool {
+	return countries[strings.ToUpper(code)]
+}
+
+// Centre returns the coordinates of the centre of a region, so entries that only carry a region
+// can take part in distance searches. It has no country, as a region spans several.
+func (l Location) Centre() *GeoLocation {
+	r, ok := regions[l]
+	if !ok {
+		return nil
+	}
+	return &GeoLocation{
+		Latitude:       r.latitude,
+		Longitude:      r.longitude,
+		HasCoordinates: true,
+	}
+}
+
+// ContainsCountry reports whether a country, given by its ISO 3166-1 alpha-2 code in any case, is
+// part of a region.
+func (l Location) ContainsCountry(code string) bool {
+	location, ok := locationByCountry[strings.ToUpper(code)]
+	return ok && location == l
+}
+
+func (g *GeoLocation) Validate() error {
+	if !IsCountryCode(g.Country) {
+		return fmt.Errorf("unknown country code %q", g.Country)
+	}
+	if !g.HasCoordinates {
+		return nil
+	}
+	if g.Latitude < -90 || g.Latitude > 90 {
+		return fmt.Errorf("latitude %f out of range", g.Latitude)
+	}
+	if g.Longitude < -180 || g.Longitude > 180 {
+		return fmt.Errorf("longitude %f out of range", g.Longitude)
+	}
+	return nil
+}
+
+// Location returns the region stored in the broker contract for a geographic location. Countries
+// outside of all regions are assigned to the region with the nearest centre, or to the region of
+// fallbackRegions if the location has no coordinates.
+func (g *GeoLocation) Location() (Location, error) {
+	if location, ok := locationByCountry[strings.ToUpper(g.Country)]; ok {
+		return location, nil
+	}
+	if !g.HasCoordinates {
+		if location, ok := fallbackLocationByCountry[strings.ToUpper(g.Country)]; ok {
+			return location, nil
+		}
+		return 0, fmt.Errorf("country %s is not part of a region and has no coordinates", g.Country)
+	}
+
+	nearest, distance := Location(0), math.Inf(1)
+	for location := range regions {
+		if d := Distance(g, location.Centre()); d < distance {
+			nearest, distance = location, d
+		}
+	}
+	return nearest, nil
+}
+
+// Distance returns the great-circle distance in kilometres between two locations with coordinates.
+func Distance(a *GeoLocation, b *GeoLocation) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
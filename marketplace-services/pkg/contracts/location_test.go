@@ -0,0 +1,48 @@
+package contracts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocationOfEveryCountry(t *testing.T) {
+	for _, code := range countryCodes {
+		_, inRegion := locationByCountry[code]
+		_, inFallback := fallbackLocationByCountry[code]
+		if inRegion == inFallback {
+			t.Errorf("country %s is in a region: %t, in a fallback region: %t", code, inRegion, inFallback)
+		}
+		if _, err := (&GeoLocation{Country: strings.ToLower(code)}).Location(); err != nil {
+			t.Errorf("location of %s without coordinates: %v", code, err)
+		}
+	}
+	for code := range fallbackLocationByCountry {
+		if !IsCountryCode(code) {
+			t.Errorf("fallback region of unknown country %s", code)
+		}
+	}
+}
+
+func TestLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		location *GeoLocation
+		want     Location
+	}{
+		{"region", &GeoLocation{Country: "DE"}, LocationEUW},
+		{"region with coordinates", &GeoLocation{Country: "US", Latitude: 1.3, Longitude: 103.8, HasCoordinates: true}, LocationNA},
+		{"fallback", &GeoLocation{Country: "IN"}, LocationSG},
+		{"fallback", &GeoLocation{Country: "ZA"}, LocationEUW},
+		{"fallback", &GeoLocation{Country: "AE"}, LocationTR},
+		{"nearest centre", &GeoLocation{Country: "IN", Latitude: 35.7, Longitude: 51.4, HasCoordinates: true}, LocationTR},
+	}
+	for _, test := range tests {
+		location, err := test.location.Location()
+		if err != nil {
+			t.Fatalf("%s: location of %s: %v", test.name, test.location.Country, err)
+		}
+		if location != test.want {
+			t.Errorf("%s: location of %s is %d, want %d", test.name, test.location.Country, location, test.want)
+		}
+	}
+}
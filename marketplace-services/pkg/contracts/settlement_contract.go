@@ -8,8 +8,19 @@ import (
 	"github.com/ethereum/go-ethereum/event"
 	"marketplace-services/pkg/contracts/bindings"
 	"math/big"
+	"time"
 )
 
+// SettlementTimeout is the time after the end of a trade until its settlement can be resolved
+// by timeout. It mirrors the timeout constant of the settlement contract.
+const SettlementTimeout = 3600 * time.Second
+
+// SettlementValidTime returns the time after which the settlement of a trade can be resolved by
+// timeout.
+func SettlementValidTime(endTime *big.Int) time.Time {
+	return time.Unix(endTime.Int64(), 0).Add(SettlementTimeout)
+}
+
 type Counter struct {
 	Value *big.Int
 	Set   bool
@@ -55,12 +66,24 @@ type SettlementContract interface {
 	GetConsumerCounter(opts *bind.CallOpts) (*Counter, error)
 	GetBrokerCounter(opts *bind.CallOpts) (*Counter, error)
 	GetSettlement(opts *bind.CallOpts) (*Settlement, error)
+	IsSettled(opts *bind.CallOpts) (bool, error)
+	IsDispute(opts *bind.CallOpts) (bool, error)
 	WatchDepositedEvent(opts *bind.WatchOpts, sink chan<- *bindings.SettlementContractDeposited, payee []common.Address) (event.Subscription, error)
 	WatchSettledEvent(opts *bind.WatchOpts, sink chan<- *bindings.SettlementContractSettled) (event.Subscription, error)
 	WatchCounterSetEvent(opts *bind.WatchOpts, sink chan<- *bindings.SettlementContractCounterSet, setter []common.Address) (event.Subscription, error)
 	WatchDisputeEvent(opts *bind.WatchOpts, sink chan<- *bindings.SettlementContractDispute) (event.Subscription, error)
 }
 
+// IsZero reports whether no funds have been transferred by the settlement.
+func (s *Settlement) IsZero() bool {
+	for _, value := range []*big.Int{s.ActualCost, s.Provider, s.Consumer, s.Broker} {
+		if value != nil && value.Sign() != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 type settlementContractImpl struct {
 	binding *bindings.SettlementContract
 }
@@ -109,6 +132,14 @@ func (s *settlementContractImpl) GetSettlement(opts *bind.CallOpts) (*Settlement
 	return SettlementStructToSettlement(result), err
 }
 
+func (s *settlementContractImpl) IsSettled(opts *bind.CallOpts) (bool, error) {
+	return s.binding.Settled(opts)
+}
+
+func (s *settlementContractImpl) IsDispute(opts *bind.CallOpts) (bool, error) {
+	return s.binding.Dispute(opts)
+}
+
 func (s settlementContractImpl) WatchDepositedEvent(opts *bind.WatchOpts, sink chan<- *bindings.SettlementContractDeposited, payee []common.Address) (event.Subscription, error) {
 	return s.binding.WatchDeposited(opts, sink, payee)
 }
@@ -0,0 +1,89 @@
+package marketplace
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Leaves and inner nodes of the message log are hashed with different prefixes, so an inner node
+// can't be passed off as a leaf.
+var (
+	leafPrefix = []byte{0x00}
+	nodePrefix = []byte{0x01}
+)
+
+func MerkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return crypto.Keccak256()
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+func MerkleProof(leaves [][]byte, index uint64) [][]byte {
+	var proof [][]byte
+	level := leaves
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < uint64(len(level)) {
+			proof = append(proof, level[sibling])
+		}
+		level = nextLevel(level)
+		index /= 2
+	}
+	return proof
+}
+
+func VerifyInclusionProof(root []byte, leaf []byte, index uint64, count uint64, proof [][]byte) bool {
+	if index >= count {
+		return false
+	}
+	hash := leaf
+	for count > 1 {
+		sibling := index ^ 1
+		if sibling < count {
+			if len(proof) == 0 {
+				return false
+			}
+			if index%2 == 0 {
+				hash = nodeHash(hash, proof[0])
+			} else {
+				hash = nodeHash(proof[0], hash)
+			}
+			proof = proof[1:]
+		}
+		index /= 2
+		count = (count + 1) / 2
+	}
+	return len(proof) == 0 && common.BytesToHash(hash) == common.BytesToHash(root)
+}
+
+// LeafHash returns the hash of a message as a leaf of the log of its trade.
+func LeafHash(tradeId uint64, seq uint64, payload []byte, signature []byte) []byte {
+	return crypto.Keccak256(
+		leafPrefix,
+		uint64ToBytes(tradeId),
+		uint64ToBytes(seq),
+		crypto.Keccak256(payload),
+		signature,
+	)
+}
+
+func nextLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, nodeHash(level[i], level[i+1]))
+	}
+	return next
+}
+
+func nodeHash(left []byte, right []byte) []byte {
+	return crypto.Keccak256(nodePrefix, left, right)
+}
@@ -0,0 +1,48 @@
+package marketplace
+
+import (
+	"bytes"
+	"github.com/ethereum/go-ethereum/crypto"
+	"testing"
+)
+
+func newTestLeaves(count int) [][]byte {
+	leaves := make([][]byte, count)
+	for i := range leaves {
+		leaves[i] = LeafHash(1, uint64(i), []byte{byte(i)}, nil)
+	}
+	return leaves
+}
+
+func TestMerkleProofs(t *testing.T) {
+	for count := 1; count <= 9; count++ {
+		leaves := newTestLeaves(count)
+		root := MerkleRoot(leaves)
+		for index := range leaves {
+			proof := MerkleProof(leaves, uint64(index))
+			if !VerifyInclusionProof(root, leaves[index], uint64(index), uint64(count), proof) {
+				t.Fatalf("proof of leaf %d of %d not verified", index, count)
+			}
+			if VerifyInclusionProof(root, leaves[(index+1)%count], uint64(index), uint64(count), proof) && count > 1 {
+				t.Fatalf("proof of leaf %d of %d verified other leaf", index, count)
+			}
+			if VerifyInclusionProof(root, leaves[index], uint64(index^1), uint64(count), proof) && count > 1 {
+				t.Fatalf("proof of leaf %d of %d verified at wrong index", index, count)
+			}
+		}
+		if VerifyInclusionProof(root, leaves[0], uint64(count), uint64(count), nil) {
+			t.Fatalf("proof of leaf out of range verified")
+		}
+	}
+}
+
+func TestMerkleRootIsDomainSeparated(t *testing.T) {
+	leaves := newTestLeaves(2)
+	// An inner node must not be accepted as a leaf of a shorter log.
+	if bytes.Equal(MerkleRoot(leaves), crypto.Keccak256(leaves[0], leaves[1])) {
+		t.Fatal("inner nodes are hashed like leaves")
+	}
+	if !bytes.Equal(MerkleRoot(leaves[:1]), leaves[0]) {
+		t.Fatal("root of single leaf differs from leaf")
+	}
+}
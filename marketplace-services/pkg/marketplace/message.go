@@ -0,0 +1,19 @@
+package marketplace
+
+import (
+	"encoding/binary"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// MessageHash returns the hash providers sign their messages with, it commits to the trade and
+// sequence number of the message.
+func MessageHash(tradeId uint64, seq uint64, payload []byte) []byte {
+	return accounts.TextHash(crypto.Keccak256(uint64ToBytes(tradeId), uint64ToBytes(seq), crypto.Keccak256(payload)))
+}
+
+func uint64ToBytes(value uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, value)
+	return data
+}
@@ -0,0 +1,124 @@
+package marketplace
+
+import (
+	"encoding/base64"
+	"github.com/ethereum/go-ethereum/common"
+	"marketplace-services/pkg/contracts"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ProductSortField int
+
+const (
+	SortById ProductSortField = iota
+	SortByCost
+	SortByFrequency
+	SortByRating
+)
+
+type ProductSearchQuery struct {
+	DataType     string
+	MinCost      uint64
+	MaxCost      uint64
+	MinFrequency uint64
+	MaxFrequency uint64
+	Text         string
+	MinRating    uint64
+	Device       string
+	User         string
+	SortBy       ProductSortField
+	Descending   bool
+	PageSize     uint64
+	PageToken    string
+}
+
+func MatchSearchQuery(
+	query *ProductSearchQuery,
+	product *contracts.Product,
+) bool {
+	matched := true
+	if product.DataType != query.DataType && query.DataType != "" {
+		matched = false
+	}
+	if product.Cost.Uint64() < query.MinCost {
+		matched = false
+	}
+	if product.Cost.Uint64() > query.MaxCost && query.MaxCost != 0 {
+		matched = false
+	}
+	if product.Frequency.Uint64() < query.MinFrequency {
+		matched = false
+	}
+	if product.Frequency.Uint64() > query.MaxFrequency && query.MaxFrequency != 0 {
+		matched = false
+	}
+	if query.Device != "" && product.Device != common.HexToAddress(query.Device) {
+		matched = false
+	}
+	if !matchText(query.Text, product) {
+		matched = false
+	}
+
+	return matched
+}
+
+func matchText(text string, product *contracts.Product) bool {
+	content := strings.ToLower(product.Name + " " + product.Description)
+	for _, term := range strings.Fields(strings.ToLower(text)) {
+		if !strings.Contains(content, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// SortProducts orders products by the given field and their id. The ratings of the products are
+// only needed to sort by rating.
+func SortProducts(
+	products []*contracts.Product,
+	ratings map[uint64]uint64,
+	sortBy ProductSortField,
+	descending bool,
+) {
+	key := func(product *contracts.Product) uint64 {
+		switch sortBy {
+		case SortByCost:
+			return product.Cost.Uint64()
+		case SortByFrequency:
+			return product.Frequency.Uint64()
+		case SortByRating:
+			return ratings[product.Id.Uint64()]
+		default:
+			return product.Id.Uint64()
+		}
+	}
+	sort.SliceStable(products, func(i, j int) bool {
+		a, b := key(products[i]), key(products[j])
+		if a == b {
+			return products[i].Id.Cmp(products[j].Id) < 0
+		}
+		if descending {
+			return a > b
+		}
+		return a < b
+	})
+}
+
+// EncodePageToken returns the token of the page starting at the given offset of a search.
+func EncodePageToken(offset uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(offset, 10)))
+}
+
+// DecodePageToken returns the offset of the page of a token, an empty token is the first page.
+func DecodePageToken(token string) (uint64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(data), 10, 64)
+}
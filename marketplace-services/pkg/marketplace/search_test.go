@@ -0,0 +1,95 @@
+package marketplace
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"marketplace-services/pkg/contracts"
+	"math/big"
+	"testing"
+)
+
+func newTestProduct(id int64, cost int64, frequency int64) *contracts.Product {
+	return &contracts.Product{
+		Id:          big.NewInt(id),
+		Device:      common.BigToAddress(big.NewInt(id)),
+		Name:        "Outdoor Temperature",
+		Description: "Temperature of the roof sensor",
+		DataType:    "temperature",
+		Cost:        big.NewInt(cost),
+		Frequency:   big.NewInt(frequency),
+	}
+}
+
+func TestMatchSearchQuery(t *testing.T) {
+	product := newTestProduct(1, 10, 60)
+
+	tests := []struct {
+		name  string
+		query *ProductSearchQuery
+		want  bool
+	}{
+		{"empty", &ProductSearchQuery{}, true},
+		{"data type", &ProductSearchQuery{DataType: "temperature"}, true},
+		{"other data type", &ProductSearchQuery{DataType: "humidity"}, false},
+		{"cost range", &ProductSearchQuery{MinCost: 10, MaxCost: 10}, true},
+		{"below min cost", &ProductSearchQuery{MinCost: 11}, false},
+		{"above max cost", &ProductSearchQuery{MaxCost: 9}, false},
+		{"frequency range", &ProductSearchQuery{MinFrequency: 30, MaxFrequency: 60}, true},
+		{"below min frequency", &ProductSearchQuery{MinFrequency: 61}, false},
+		{"above max frequency", &ProductSearchQuery{MaxFrequency: 59}, false},
+		{"device", &ProductSearchQuery{Device: product.Device.Hex()}, true},
+		{"other device", &ProductSearchQuery{Device: common.BigToAddress(big.NewInt(2)).Hex()}, false},
+		{"text in name", &ProductSearchQuery{Text: "outdoor"}, true},
+		{"text in description", &ProductSearchQuery{Text: "ROOF sensor"}, true},
+		{"text across fields", &ProductSearchQuery{Text: "outdoor roof"}, true},
+		{"missing term", &ProductSearchQuery{Text: "outdoor humidity"}, false},
+	}
+	for _, test := range tests {
+		if got := MatchSearchQuery(test.query, product); got != test.want {
+			t.Errorf("%s: matched %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSortProducts(t *testing.T) {
+	ratings := map[uint64]uint64{1: 3, 2: 5, 3: 3}
+
+	tests := []struct {
+		name       string
+		sortBy     ProductSortField
+		descending bool
+		want       []uint64
+	}{
+		{"id", SortById, false, []uint64{1, 2, 3}},
+		{"cost", SortByCost, false, []uint64{3, 1, 2}},
+		{"cost descending", SortByCost, true, []uint64{2, 1, 3}},
+		{"frequency", SortByFrequency, false, []uint64{2, 3, 1}},
+		{"rating with ties by id", SortByRating, false, []uint64{1, 3, 2}},
+		{"rating descending with ties by id", SortByRating, true, []uint64{2, 1, 3}},
+	}
+	for _, test := range tests {
+		products := []*contracts.Product{newTestProduct(3, 5, 20), newTestProduct(2, 20, 10), newTestProduct(1, 10, 30)}
+		SortProducts(products, ratings, test.sortBy, test.descending)
+		for i, product := range products {
+			if product.Id.Uint64() != test.want[i] {
+				t.Errorf("%s: product %d at position %d, want %d", test.name, product.Id, i, test.want[i])
+			}
+		}
+	}
+}
+
+func TestPageToken(t *testing.T) {
+	for _, offset := range []uint64{0, 1, 25, 1 << 40} {
+		decoded, err := DecodePageToken(EncodePageToken(offset))
+		if err != nil || decoded != offset {
+			t.Errorf("decode token of offset %d: got %d, %v", offset, decoded, err)
+		}
+	}
+	if offset, err := DecodePageToken(""); err != nil || offset != 0 {
+		t.Errorf("decode empty token: got %d, %v, want the first page", offset, err)
+	}
+	for _, token := range []string{"!", EncodePageToken(1) + "=", "LTE"} {
+		if _, err := DecodePageToken(token); err == nil {
+			t.Errorf("decoded invalid token %q", token)
+		}
+	}
+}
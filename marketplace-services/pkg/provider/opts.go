@@ -10,6 +10,7 @@ type options struct {
 	ProxyConfig      ProxyConfig      `json:"proxyConfig"`
 	LoggingConfig    LoggingConfig    `json:"loggingConfig"`
 	SimulationConfig SimulationConfig `json:"simulationConfig"`
+	WatchdogConfig   WatchdogConfig   `json:"watchdogConfig"`
 }
 
 type ProxyConfig struct {
@@ -17,6 +18,7 @@ type ProxyConfig struct {
 	Port     int    `json:"port"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	ApiKey   string `json:"apiKey"`
 }
 
 type LoggingConfig struct {
@@ -35,6 +37,12 @@ type SimulatorConfig struct {
 	Timeout   int `json:"timeout"`
 }
 
+type WatchdogConfig struct {
+	Enabled        bool `json:"enabled"`
+	Interval       int  `json:"interval"`
+	SettledTimeout int  `json:"settledTimeout"`
+}
+
 type Option interface {
 	apply(*options)
 }
@@ -76,6 +84,11 @@ func DefaultOptions() options {
 				},
 			},
 		},
+		WatchdogConfig: WatchdogConfig{
+			Enabled:        true,
+			Interval:       60,
+			SettledTimeout: 300,
+		},
 	}
 }
 
@@ -112,3 +125,9 @@ func WithSimulationConfig(simulationConfig SimulationConfig) Option {
 		o.SimulationConfig = simulationConfig
 	})
 }
+
+func WithWatchdogConfig(watchdogConfig WatchdogConfig) Option {
+	return newFuncOption(func(o *options) {
+		o.WatchdogConfig = watchdogConfig
+	})
+}
@@ -7,9 +7,10 @@ import (
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
 	"marketplace-services/pkg/domain"
 	"marketplace-services/pkg/proxy/api"
+	"marketplace-services/pkg/watchdog"
+	"marketplace-services/pkg/watchdog/remote"
 	"os"
 	"os/signal"
 	"strconv"
@@ -23,11 +24,13 @@ type provider struct {
 	logger                          logrus.FieldLogger
 	proxy                           *grpc.ClientConn
 	authServiceClient               api.AuthServiceClient
+	tokenSource                     *api.TokenSource
 	tradingContractServiceClient    api.TradingContractServiceClient
 	deviceContractServiceClient     api.DeviceContractServiceClient
 	settlementContractServiceClient api.SettlementContractServiceClient
 	cryptoMessageServiceClient      api.CryptoMessageServiceClient
 	brokerContractServiceClient     api.BrokerContractServiceClient
+	watchdog                        watchdog.SettlementWatchdog
 	simulators                      map[int]*sensorSimulator
 }
 
@@ -43,12 +46,22 @@ func New(opt ...Option) (*provider, error) {
 		}
 	}
 
-	proxy, err := grpc.Dial(opts.ProxyConfig.Address+":"+strconv.Itoa(opts.ProxyConfig.Port), grpc.WithInsecure())
+	tokenSource := api.NewTokenSource(opts.ProxyConfig.Username, []byte(opts.ProxyConfig.Password))
+	if opts.ProxyConfig.ApiKey != "" {
+		tokenSource = api.NewApiKeyTokenSource(opts.ProxyConfig.ApiKey)
+	}
+	proxy, err := grpc.Dial(
+		opts.ProxyConfig.Address+":"+strconv.Itoa(opts.ProxyConfig.Port),
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(tokenSource.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(tokenSource.StreamClientInterceptor()),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("dial proxy: %w", err)
 	}
 
 	authServiceClient := api.NewAuthServiceClient(proxy)
+	tokenSource.SetClient(authServiceClient)
 	tradingServiceClient := api.NewTradingContractServiceClient(proxy)
 	deviceServiceClient := api.NewDeviceContractServiceClient(proxy)
 	settlementServiceClient := api.NewSettlementContractServiceClient(proxy)
@@ -65,17 +78,31 @@ func New(opt ...Option) (*provider, error) {
 		)
 	}
 
+	logger := initLogger(opts)
+
+	var settlementWatchdog watchdog.SettlementWatchdog
+	if opts.WatchdogConfig.Enabled {
+		settlementWatchdog = watchdog.NewSettlementWatchdogImpl(
+			logger,
+			remote.NewSettlementBackend(settlementServiceClient),
+			time.Duration(opts.WatchdogConfig.Interval)*time.Second,
+			time.Duration(opts.WatchdogConfig.SettledTimeout)*time.Second,
+		)
+	}
+
 	return &provider{
 		opts:                            opts,
-		logger:                          initLogger(opts),
+		logger:                          logger,
 		proxy:                           proxy,
 		ctx:                             context.Background(),
 		authServiceClient:               authServiceClient,
+		tokenSource:                     tokenSource,
 		tradingContractServiceClient:    tradingServiceClient,
 		deviceContractServiceClient:     deviceServiceClient,
 		settlementContractServiceClient: settlementServiceClient,
 		cryptoMessageServiceClient:      messageServiceClient,
 		brokerContractServiceClient:     brokerServiceClient,
+		watchdog:                        settlementWatchdog,
 		simulators:                      simulators,
 	}, nil
 }
@@ -110,24 +137,31 @@ func (p *provider) Run() error {
 
 	p.logger.Infof("Get access token for proxy %s", p.proxy.Target())
 
-	getTokenRequest := &api.GetTokenRequest{
-		Username: p.opts.ProxyConfig.Username,
-		Password: []byte(p.opts.ProxyConfig.Password),
-	}
-	getTokenResponse, err := p.authServiceClient.GetToken(p.ctx, getTokenRequest)
-	if err != nil {
+	if _, err := p.tokenSource.Token(p.ctx); err != nil {
 		return err
 	}
-
-	md := metadata.Pairs("authorization", "bearer "+getTokenResponse.Token)
-	ctx := metadata.NewOutgoingContext(p.ctx, md)
+	ctx := p.ctx
 
 	p.receiveSignals()
 
+	if p.watchdog != nil {
+		go p.reportTimeouts(ctx)
+	}
+
 	p.logger.Infof("Listen and serve trading requests")
 	return p.listenAndServe(ctx)
 }
 
+func (p *provider) reportTimeouts(ctx context.Context) {
+	sink, errc := p.watchdog.Run(ctx)
+	for report := range sink {
+		p.logger.Infof("Settlement watchdog %s", report)
+	}
+	if err := <-errc; err != nil {
+		p.logger.Errorf("run settlement watchdog: %v", err)
+	}
+}
+
 func (p *provider) receiveSignals() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -193,6 +227,14 @@ func (p *provider) handleRequestedTradingEvent(ctx context.Context, event *domai
 	}
 	trade := findTradeResponse.Trade
 
+	if p.watchdog != nil {
+		p.watchdog.Watch(&watchdog.Trade{
+			Id:                 trade.Id,
+			SettlementContract: trade.SettlementContract,
+			EndTime:            trade.EndTime,
+		})
+	}
+
 	err = p.waitForDeposit(ctx, trade.SettlementContract)
 	if err != nil {
 		return err
@@ -275,6 +317,17 @@ func (p *provider) waitForDeposit(ctx context.Context, contract string) error {
 	return err
 }
 
+func (p *provider) findSequence(ctx context.Context, broker string, tradeId uint64) (uint64, error) {
+	response, err := p.cryptoMessageServiceClient.FindMessageSequence(ctx, &api.FindMessageSequenceRequest{
+		BrokerAddr: broker,
+		TradeId:    tradeId,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("find message sequence of trade %d: %w", tradeId, err)
+	}
+	return response.Seq, nil
+}
+
 func (p *provider) pushMessages(ctx context.Context, trade *domain.Trade, broker string, pubKey []byte) (int, error) {
 	p.logger.Infof("Push messages for trade %d", trade.Id)
 	sink := make(chan int)
@@ -292,33 +345,70 @@ func (p *provider) pushMessages(ctx context.Context, trade *domain.Trade, broker
 	endTime := time.Unix(int64(trade.EndTime), 0)
 	stop := time.After(time.Until(endTime))
 
+	// The broker rejects sequence numbers that are not increasing, so the provider continues after
+	// the last message the broker accepted for the trade, e.g. after a restart.
+	seq, err := p.findSequence(ctx, broker, trade.Id)
+	if err != nil {
+		return 0, err
+	}
+
+	var stream api.CryptoMessageService_EncryptAndPublishMessagesClient
 	counter := 0
+	closeStream := func() {
+		response, err := stream.CloseAndRecv()
+		stream = nil
+		if err != nil {
+			p.logger.Errorf("close message stream for trade %d: %v", trade.Id, err)
+			return
+		}
+		counter += int(response.Counter)
+	}
+
 	for {
 		select {
 		case m := <-sink:
 			buf := new(bytes.Buffer)
 			if err := binary.Write(buf, binary.LittleEndian, int64(m)); err != nil {
-				p.logger.Errorf("measurement %d to binary: %w", m, err)
+				p.logger.Errorf("measurement %d to binary: %v", m, err)
 				break
 			}
-			_, err := p.cryptoMessageServiceClient.EncryptAndPushMessage(ctx, &api.EncryptAndPushMessageRequest{
+			if stream == nil {
+				var err error
+				stream, err = p.cryptoMessageServiceClient.EncryptAndPublishMessages(ctx)
+				if err != nil {
+					p.logger.Errorf("open message stream for trade %d: %v", trade.Id, err)
+					break
+				}
+			}
+			seq++
+			err := stream.Send(&api.EncryptAndPublishMessagesRequest{
 				BrokerAddr: broker,
 				PublicKey:  pubKey,
 				Message: &domain.Message{
 					TradeId: trade.Id,
 					Payload: buf.Bytes(),
+					Seq:     seq,
 				},
 			})
 			if err != nil {
-				p.logger.Errorf("push message for trade %d: %w", trade.Id, err)
+				p.logger.Errorf("push message for trade %d: %v", trade.Id, err)
+				closeStream()
+				// Messages sent before the failure may have been rejected, so resume from the broker.
+				if resumed, err := p.findSequence(ctx, broker, trade.Id); err == nil {
+					seq = resumed
+				} else {
+					p.logger.Errorf("%v", err)
+				}
 				break
 			}
-			counter++
 			p.logger.Infof("Pushed message with payload %d for trade %d", m, trade.Id)
 		case <-stop:
 			err := simulator.Detach(sink)
 			if err != nil {
-				p.logger.Errorf("detach sink: %w", trade.Id, err)
+				p.logger.Errorf("detach sink of trade %d: %v", trade.Id, err)
+			}
+			if stream != nil {
+				closeStream()
 			}
 			return counter, nil
 		}
@@ -60,3 +60,93 @@ func (s *accountServiceServer) FindAccounts(req *FindAccountsRequest, stream Acc
 	}
 	return nil
 }
+
+func (s *accountServiceServer) UpdateAccount(
+	ctx context.Context,
+	req *UpdateAccountRequest,
+) (*UpdateAccountResponse, error) {
+	account, err := s.accountService.UpdateAccount(ctx, AccountFromGrpcAccount(req.Account))
+	if err != nil {
+		return nil, err
+	}
+	return &UpdateAccountResponse{Account: AccountToGrpcAccount(account)}, err
+}
+
+func (s *accountServiceServer) DisableAccount(
+	ctx context.Context,
+	req *DisableAccountRequest,
+) (*DisableAccountResponse, error) {
+	if err := s.accountService.DisableAccount(ctx, uint(req.Id)); err != nil {
+		return nil, err
+	}
+	return &DisableAccountResponse{}, nil
+}
+
+func (s *accountServiceServer) EnableAccount(
+	ctx context.Context,
+	req *EnableAccountRequest,
+) (*EnableAccountResponse, error) {
+	if err := s.accountService.EnableAccount(ctx, uint(req.Id)); err != nil {
+		return nil, err
+	}
+	return &EnableAccountResponse{}, nil
+}
+
+func (s *accountServiceServer) DeleteAccount(
+	ctx context.Context,
+	req *DeleteAccountRequest,
+) (*DeleteAccountResponse, error) {
+	if err := s.accountService.DeleteAccount(ctx, uint(req.Id)); err != nil {
+		return nil, err
+	}
+	return &DeleteAccountResponse{}, nil
+}
+
+func (s *accountServiceServer) ChangePassword(
+	ctx context.Context,
+	req *ChangePasswordRequest,
+) (*ChangePasswordResponse, error) {
+	if err := s.accountService.ChangePassword(ctx, req.OldPassword, req.NewPassword); err != nil {
+		return nil, err
+	}
+	return &ChangePasswordResponse{}, nil
+}
+
+func (s *accountServiceServer) ResetPassword(
+	ctx context.Context,
+	req *ResetPasswordRequest,
+) (*ResetPasswordResponse, error) {
+	if err := s.accountService.ResetPassword(ctx, uint(req.Id), req.Password); err != nil {
+		return nil, err
+	}
+	return &ResetPasswordResponse{}, nil
+}
+
+func (s *accountServiceServer) EnrollTotp(ctx context.Context, req *EnrollTotpRequest) (*EnrollTotpResponse, error) {
+	enrollment, err := s.accountService.EnrollTotp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &EnrollTotpResponse{Secret: enrollment.Secret, Uri: enrollment.URI}, nil
+}
+
+func (s *accountServiceServer) ConfirmTotp(ctx context.Context, req *ConfirmTotpRequest) (*ConfirmTotpResponse, error) {
+	if err := s.accountService.ConfirmTotp(ctx, req.Code); err != nil {
+		return nil, err
+	}
+	return &ConfirmTotpResponse{}, nil
+}
+
+func (s *accountServiceServer) DisableTotp(ctx context.Context, req *DisableTotpRequest) (*DisableTotpResponse, error) {
+	if err := s.accountService.DisableTotp(ctx, req.Code); err != nil {
+		return nil, err
+	}
+	return &DisableTotpResponse{}, nil
+}
+
+func (s *accountServiceServer) ResetTotp(ctx context.Context, req *ResetTotpRequest) (*ResetTotpResponse, error) {
+	if err := s.accountService.ResetTotp(ctx, uint(req.Id)); err != nil {
+		return nil, err
+	}
+	return &ResetTotpResponse{}, nil
+}
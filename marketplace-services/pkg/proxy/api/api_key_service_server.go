@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"marketplace-services/pkg/proxy/services"
+)
+
+type apiKeyServiceServer struct {
+	UnimplementedApiKeyServiceServer
+	apiKeyService services.ApiKeyService
+}
+
+func NewApiKeyServiceServer(service services.ApiKeyService) *apiKeyServiceServer {
+	return &apiKeyServiceServer{apiKeyService: service}
+}
+
+func (s *apiKeyServiceServer) CreateApiKey(ctx context.Context, req *CreateApiKeyRequest) (*CreateApiKeyResponse, error) {
+	key, plaintext, err := s.apiKeyService.CreateApiKey(ctx, ApiKeyFromGrpcApiKey(req.ApiKey))
+	if err != nil {
+		return nil, err
+	}
+	return &CreateApiKeyResponse{ApiKey: ApiKeyToGrpcApiKey(key), Key: plaintext}, nil
+}
+
+func (s *apiKeyServiceServer) FindApiKeys(req *FindApiKeysRequest, stream ApiKeyService_FindApiKeysServer) error {
+	keys, err := s.apiKeyService.FindApiKeys(stream.Context(), uint(req.AccountId))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := stream.Send(&FindApiKeysResponse{ApiKey: ApiKeyToGrpcApiKey(key)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *apiKeyServiceServer) RevokeApiKey(ctx context.Context, req *RevokeApiKeyRequest) (*RevokeApiKeyResponse, error) {
+	if err := s.apiKeyService.RevokeApiKey(ctx, uint(req.Id)); err != nil {
+		return nil, err
+	}
+	return &RevokeApiKeyResponse{}, nil
+}
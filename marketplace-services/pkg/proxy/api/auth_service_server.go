@@ -2,26 +2,159 @@ package api
 
 import (
 	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/domain"
 	"marketplace-services/pkg/proxy/services"
 )
 
+var errOidcDisabled = status.Error(codes.FailedPrecondition, "login through an identity provider is disabled")
+
 type authServiceServer struct {
 	UnimplementedAuthServiceServer
 	authService services.AuthService
+	oidcService services.OidcService
 }
 
-func NewAuthServiceServer(authService services.AuthService) *authServiceServer {
-	return &authServiceServer{authService: authService}
+// NewAuthServiceServer creates the auth service server. The oidc service is nil if login through an
+// OpenID Connect provider is disabled.
+func NewAuthServiceServer(authService services.AuthService, oidcService services.OidcService) *authServiceServer {
+	return &authServiceServer{authService: authService, oidcService: oidcService}
 }
 
 func (s *authServiceServer) GetToken(ctx context.Context, req *GetTokenRequest) (*GetTokenResponse, error) {
-	token, err := s.authService.GetToken(ctx, req.Username, req.Password)
-	return &GetTokenResponse{Token: token}, err
+	tokens, err := s.authService.GetToken(ctx, req.Username, req.Password, req.TotpCode)
+	if err != nil {
+		return nil, err
+	}
+	return &GetTokenResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+	}, nil
+}
+
+func (s *authServiceServer) RefreshToken(ctx context.Context, req *RefreshTokenRequest) (*RefreshTokenResponse, error) {
+	tokens, err := s.authService.RefreshToken(ctx, req.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return &RefreshTokenResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+	}, nil
+}
+
+func (s *authServiceServer) Logout(ctx context.Context, req *LogoutRequest) (*LogoutResponse, error) {
+	if err := s.authService.Logout(ctx, req.RefreshToken); err != nil {
+		return nil, err
+	}
+	return &LogoutResponse{}, nil
+}
+
+func (s *authServiceServer) RevokeAccountTokens(
+	ctx context.Context,
+	req *RevokeAccountTokensRequest,
+) (*RevokeAccountTokensResponse, error) {
+	if err := s.authService.RevokeAccountTokens(ctx, uint(req.AccountId)); err != nil {
+		return nil, err
+	}
+	return &RevokeAccountTokensResponse{}, nil
+}
+
+func (s *authServiceServer) GetJwks(ctx context.Context, req *GetJwksRequest) (*GetJwksResponse, error) {
+	jwks, err := s.authService.Jwks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*domain.Jwk, len(jwks))
+	for i, jwk := range jwks {
+		keys[i] = JwkToGrpcJwk(jwk)
+	}
+	return &GetJwksResponse{Keys: keys}, nil
+}
+
+func (s *authServiceServer) RotateSigningKey(
+	ctx context.Context,
+	req *RotateSigningKeyRequest,
+) (*RotateSigningKeyResponse, error) {
+	kid, err := s.authService.RotateSigningKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &RotateSigningKeyResponse{Kid: kid}, nil
 }
 
-func (s *authServiceServer) AuthFuncOverride(ctx context.Context, fullMethodName string) (context.Context, error) {
-	if fullMethodName == "/proxy.AuthService/GetToken" {
-		return ctx, nil
+func (s *authServiceServer) BeginOidcLogin(ctx context.Context, req *BeginOidcLoginRequest) (*BeginOidcLoginResponse, error) {
+	if s.oidcService == nil {
+		return nil, errOidcDisabled
+	}
+	authorizationURL, state, err := s.oidcService.BeginLogin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &BeginOidcLoginResponse{AuthorizationUrl: authorizationURL, State: state}, nil
+}
+
+func (s *authServiceServer) CompleteOidcLogin(
+	ctx context.Context,
+	req *CompleteOidcLoginRequest,
+) (*CompleteOidcLoginResponse, error) {
+	if s.oidcService == nil {
+		return nil, errOidcDisabled
+	}
+	tokens, err := s.oidcService.CompleteLogin(ctx, req.State, req.Code)
+	if err != nil {
+		return nil, err
+	}
+	return &CompleteOidcLoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+	}, nil
+}
+
+func (s *authServiceServer) ExchangeOidcToken(
+	ctx context.Context,
+	req *ExchangeOidcTokenRequest,
+) (*ExchangeOidcTokenResponse, error) {
+	if s.oidcService == nil {
+		return nil, errOidcDisabled
+	}
+	tokens, err := s.oidcService.ExchangeIDToken(ctx, req.IdToken)
+	if err != nil {
+		return nil, err
+	}
+	return &ExchangeOidcTokenResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+	}, nil
+}
+
+func (s *authServiceServer) LinkExternalIdentity(
+	ctx context.Context,
+	req *LinkExternalIdentityRequest,
+) (*LinkExternalIdentityResponse, error) {
+	if s.oidcService == nil {
+		return nil, errOidcDisabled
+	}
+	if err := s.oidcService.LinkExternalIdentity(ctx, uint(req.AccountId), req.Subject); err != nil {
+		return nil, err
+	}
+	return &LinkExternalIdentityResponse{}, nil
+}
+
+func (s *authServiceServer) UnlinkExternalIdentity(
+	ctx context.Context,
+	req *UnlinkExternalIdentityRequest,
+) (*UnlinkExternalIdentityResponse, error) {
+	if s.oidcService == nil {
+		return nil, errOidcDisabled
+	}
+	if err := s.oidcService.UnlinkExternalIdentity(ctx, uint(req.AccountId)); err != nil {
+		return nil, err
 	}
-	return s.authService.AuthFunction()(ctx)
+	return &UnlinkExternalIdentityResponse{}, nil
 }
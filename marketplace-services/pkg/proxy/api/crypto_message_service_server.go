@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"io"
 	"marketplace-services/pkg/proxy/services"
 )
 
@@ -33,3 +34,134 @@ func (s *cryptoMessageServiceServer) DecryptAndPullMessage(
 	msg, err := s.cryptoMessageService.DecryptAndPullMessage(ctx, req.BrokerAddr, req.TradeId)
 	return &DecryptAndPullMessageResponse{Message: MessageToGrpcMessage(msg)}, err
 }
+
+func (s *cryptoMessageServiceServer) EncryptAndPublishMessages(
+	stream CryptoMessageService_EncryptAndPublishMessagesServer,
+) error {
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return stream.SendAndClose(&EncryptAndPublishMessagesResponse{})
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	messages := make(chan *services.Message)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(messages)
+		defer close(errc)
+
+		req := first
+		for {
+			select {
+			case messages <- MessageFromGrpcMessage(req.Message):
+			case <-ctx.Done():
+				return
+			}
+			next, err := stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			req = next
+		}
+	}()
+
+	counter, err := s.cryptoMessageService.EncryptAndPublishMessages(ctx, first.BrokerAddr, first.PublicKey, messages)
+	if err != nil {
+		return err
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	return stream.SendAndClose(&EncryptAndPublishMessagesResponse{Counter: counter})
+}
+
+// DecryptAndSubscribeMessages relays the messages of the broker and trade selected by the first
+// request. Every following request acknowledges a relayed message.
+func (s *cryptoMessageServiceServer) DecryptAndSubscribeMessages(
+	stream CryptoMessageService_DecryptAndSubscribeMessagesServer,
+) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	acks := make(chan uint64)
+	recvErrc := make(chan error, 1)
+	go func() {
+		defer close(acks)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErrc <- err
+				return
+			}
+			select {
+			case acks <- req.AckSeq:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	sink, errc := s.cryptoMessageService.DecryptAndSubscribeMessages(ctx, req.BrokerAddr, req.TradeId, acks)
+	for msg := range sink {
+		if err := stream.Send(&DecryptAndSubscribeMessagesResponse{Message: MessageToGrpcMessage(msg)}); err != nil {
+			return err
+		}
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	select {
+	case err := <-recvErrc:
+		if err != io.EOF {
+			return err
+		}
+	default:
+	}
+	return nil
+}
+
+func (s *cryptoMessageServiceServer) FindMessageSequence(
+	ctx context.Context,
+	req *FindMessageSequenceRequest,
+) (*FindMessageSequenceResponse, error) {
+	seq, err := s.cryptoMessageService.FindMessageSequence(ctx, req.BrokerAddr, req.TradeId)
+	if err != nil {
+		return &FindMessageSequenceResponse{}, err
+	}
+	return &FindMessageSequenceResponse{Seq: seq}, nil
+}
+
+func (s *cryptoMessageServiceServer) FindInclusionProof(
+	ctx context.Context,
+	req *FindInclusionProofRequest,
+) (*FindInclusionProofResponse, error) {
+	proof, err := s.cryptoMessageService.FindInclusionProof(ctx, req.BrokerAddr, req.TradeId, req.Seq, req.Count)
+	if err != nil {
+		return &FindInclusionProofResponse{}, err
+	}
+	return &FindInclusionProofResponse{Proof: InclusionProofToGrpcInclusionProof(proof)}, nil
+}
+
+func (s *cryptoMessageServiceServer) FindResolution(
+	ctx context.Context,
+	req *FindResolutionRequest,
+) (*FindResolutionResponse, error) {
+	resolution, err := s.cryptoMessageService.FindResolution(ctx, req.BrokerAddr, req.TradeId)
+	if err != nil {
+		return &FindResolutionResponse{}, err
+	}
+	return &FindResolutionResponse{Resolution: ResolutionToGrpcResolution(resolution)}, nil
+}
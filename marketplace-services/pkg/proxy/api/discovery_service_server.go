@@ -5,7 +5,6 @@ import (
 	"google.golang.org/grpc"
 	"io"
 	"marketplace-services/pkg/broker/api"
-	"marketplace-services/pkg/domain"
 	"marketplace-services/pkg/proxy/services"
 )
 
@@ -38,6 +37,27 @@ func (s *discoveryServiceServer) SearchBroker(
 	return nil
 }
 
+func (s *discoveryServiceServer) SearchProducts(
+	req *SearchProductsRequest,
+	stream DiscoveryService_SearchProductsServer,
+) error {
+	ctx := stream.Context()
+	sink, errc := s.discoveryService.SearchProducts(
+		ctx,
+		BrokerSearchQueryFromGrpcBrokerSearchQuery(req.BrokerQuery),
+		api.ProductSearchQueryFromGrpcProductSearchQuery(req.ProductQuery),
+	)
+	for result := range sink {
+		if err := stream.Send(ProductSearchResultToGrpcSearchProductsResponse(result)); err != nil {
+			return err
+		}
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	return nil
+}
+
 func (s *discoveryServiceServer) SearchProductWithBroker(
 	req *SearchProductWithBrokerRequest,
 	stream DiscoveryService_SearchProductWithBrokerServer,
@@ -54,11 +74,7 @@ func (s *discoveryServiceServer) SearchProductWithBroker(
 
 	discoveryServiceClient := api.NewDiscoveryServiceClient(conn)
 	productStream, err := discoveryServiceClient.SearchProduct(stream.Context(), &api.SearchProductRequest{
-		Query: &domain.ProductSearchQuery{
-			DataType: req.Query.DataType,
-			MinCost:  req.Query.MinCost,
-			MaxCost:  req.Query.MaxCost,
-		},
+		Query: req.Query,
 	})
 	if err != nil {
 		return err
@@ -72,7 +88,8 @@ func (s *discoveryServiceServer) SearchProductWithBroker(
 			return err
 		}
 		err = stream.Send(&SearchProductWithBrokerResponse{
-			Product: response.Product,
+			Product:       response.Product,
+			NextPageToken: response.NextPageToken,
 		})
 		if err != nil {
 			return err
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"marketplace-services/pkg/proxy/services"
+	"net/http"
+)
+
+const JwksPath = "/.well-known/jwks.json"
+
+type jwksHandler struct {
+	keyManager services.KeyManager
+	logger     logrus.FieldLogger
+}
+
+// NewJwksHandler creates an HTTP handler publishing the public signing keys of the proxy as a JSON
+// Web Key Set, so other services can verify proxy tokens without calling the proxy for each token.
+func NewJwksHandler(keyManager services.KeyManager, logger logrus.FieldLogger) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(JwksPath, &jwksHandler{keyManager: keyManager, logger: logger})
+	return mux
+}
+
+func (h *jwksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	jwks, err := h.keyManager.Jwks(r.Context())
+	if err != nil {
+		h.logger.Errorf("get jwks: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if jwks == nil {
+		jwks = []*services.Jwk{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(struct {
+		Keys []*services.Jwk `json:"keys"`
+	}{Keys: jwks}); err != nil {
+		h.logger.Warnf("write jwks: %v", err)
+	}
+}
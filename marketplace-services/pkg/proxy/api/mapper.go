@@ -9,6 +9,7 @@ import (
 	"marketplace-services/pkg/proxy/model"
 	"marketplace-services/pkg/proxy/services"
 	"math/big"
+	"strings"
 )
 
 func AccountFromGrpcAccount(account *domain.Account) *model.Account {
@@ -22,6 +23,7 @@ func AccountFromGrpcAccount(account *domain.Account) *model.Account {
 		Name:     account.Name,
 		Password: account.Password,
 		Role:     model.Role(account.Role),
+		Disabled: account.Disabled,
 	}
 }
 
@@ -29,13 +31,19 @@ func AccountToGrpcAccount(account *model.Account) *domain.Account {
 	if account == nil {
 		return nil
 	}
-	return &domain.Account{
-		Id:       uint64(account.ID),
-		Name:     account.Name,
-		Password: nil,
-		Role:     domain.Role(account.Role),
-		Wallet:   WalletToGrpcWallet(&account.Wallet),
+	grpcAccount := &domain.Account{
+		Id:          uint64(account.ID),
+		Name:        account.Name,
+		Password:    nil,
+		Role:        domain.Role(account.Role),
+		Wallet:      WalletToGrpcWallet(&account.Wallet),
+		Disabled:    account.Disabled,
+		TotpEnabled: account.TotpEnabled,
+	}
+	if account.LockedUntil != nil {
+		grpcAccount.LockedUntil = account.LockedUntil.Unix()
 	}
+	return grpcAccount
 }
 
 func WalletFromGrpcWallet(wallet *domain.Wallet) *model.Wallet {
@@ -46,11 +54,9 @@ func WalletFromGrpcWallet(wallet *domain.Wallet) *model.Wallet {
 		Model: gorm.Model{
 			ID: uint(wallet.Id),
 		},
-		AccountID:  uint(wallet.UserId),
-		Passphrase: wallet.Passphrase,
-		Address:    wallet.Address,
-		FilePath:   wallet.FilePath,
-		PublicKey:  wallet.PublicKey,
+		AccountID: uint(wallet.UserId),
+		Address:   wallet.Address,
+		PublicKey: wallet.PublicKey,
 	}
 }
 
@@ -59,12 +65,11 @@ func WalletToGrpcWallet(wallet *model.Wallet) *domain.Wallet {
 		return nil
 	}
 	return &domain.Wallet{
-		Id:         uint64(wallet.ID),
-		UserId:     uint64(wallet.AccountID),
-		Passphrase: "",
-		Address:    wallet.Address,
-		FilePath:   wallet.FilePath,
-		PublicKey:  wallet.PublicKey,
+		Id:        uint64(wallet.ID),
+		UserId:    uint64(wallet.AccountID),
+		Address:   wallet.Address,
+		PublicKey: wallet.PublicKey,
+		Hd:        wallet.HD,
 	}
 }
 
@@ -109,6 +114,8 @@ func BrokerFromGrpcBroker(broker *domain.Broker) *contracts.Broker {
 		Name:     broker.Name,
 		HostAddr: broker.HostAddr,
 		Location: contracts.Location(broker.Location),
+
+		GeoLocation: GeoLocationFromGrpcGeoLocation(broker.GeoLocation),
 	}
 }
 
@@ -121,6 +128,32 @@ func BrokerToGrpcBroker(broker *contracts.Broker) *domain.Broker {
 		Location: domain.Location(broker.Location),
 		Deleted:  broker.Deleted,
 		Trades:   contracts.BigIntToInt64(broker.Trades),
+
+		GeoLocation: GeoLocationToGrpcGeoLocation(broker.GeoLocation),
+	}
+}
+
+func GeoLocationFromGrpcGeoLocation(location *domain.GeoLocation) *contracts.GeoLocation {
+	if location == nil {
+		return nil
+	}
+	return &contracts.GeoLocation{
+		Country:        location.Country,
+		Latitude:       location.Latitude,
+		Longitude:      location.Longitude,
+		HasCoordinates: location.HasCoordinates,
+	}
+}
+
+func GeoLocationToGrpcGeoLocation(location *contracts.GeoLocation) *domain.GeoLocation {
+	if location == nil {
+		return nil
+	}
+	return &domain.GeoLocation{
+		Country:        location.Country,
+		Latitude:       location.Latitude,
+		Longitude:      location.Longitude,
+		HasCoordinates: location.HasCoordinates,
 	}
 }
 
@@ -132,6 +165,7 @@ func DeviceFromGrpcDevice(device *domain.Device) *contracts.Device {
 		Description: device.Description,
 		PublicKey:   device.PublicKey[:],
 		Deleted:     device.Deleted,
+		GeoLocation: GeoLocationFromGrpcGeoLocation(device.GeoLocation),
 	}
 }
 
@@ -143,6 +177,7 @@ func DeviceToGrpcDevice(device *contracts.Device) *domain.Device {
 		Description: device.Description,
 		PublicKey:   device.PublicKey,
 		Deleted:     device.Deleted,
+		GeoLocation: GeoLocationToGrpcGeoLocation(device.GeoLocation),
 	}
 }
 
@@ -167,15 +202,34 @@ func ProductToGrpcProduct(product *contracts.Product) *domain.Product {
 		Description: product.Description,
 		DataType:    product.DataType,
 		Frequency:   product.Frequency.Int64(),
-		Cost:        product.Frequency.Int64(),
+		Cost:        product.Cost.Int64(),
 		Deleted:     product.Deleted,
 	}
 }
 
+func ProductSearchResultToGrpcSearchProductsResponse(result *services.ProductSearchResult) *SearchProductsResponse {
+	response := &SearchProductsResponse{
+		Broker:        BrokerToGrpcBroker(result.Broker),
+		TimedOut:      result.TimedOut,
+		NextPageToken: result.NextPageToken,
+	}
+	if result.Product != nil {
+		response.Product = ProductToGrpcProduct(result.Product)
+	}
+	if result.Err != nil {
+		response.Error = result.Err.Error()
+	}
+	return response
+}
+
 func BrokerSearchQueryFromGrpcBrokerSearchQuery(query *domain.BrokerSearchQuery) *services.BrokerSearchQuery {
 	return &services.BrokerSearchQuery{
 		Name:      query.Name,
 		Locations: LocationsFromGrpcLocations(query.Locations),
+		Countries: query.Countries,
+		Near:      GeoLocationFromGrpcGeoLocation(query.Near),
+		Radius:    query.Radius,
+		Limit:     int(query.Limit),
 	}
 }
 
@@ -293,6 +347,7 @@ func MessageFromGrpcMessage(message *domain.Message) *services.Message {
 	return &services.Message{
 		TradeId: message.TradeId,
 		Payload: message.Payload,
+		Seq:     message.Seq,
 	}
 }
 
@@ -300,6 +355,29 @@ func MessageToGrpcMessage(message *services.Message) *domain.Message {
 	return &domain.Message{
 		TradeId: message.TradeId,
 		Payload: message.Payload,
+		Seq:     message.Seq,
+	}
+}
+
+func InclusionProofToGrpcInclusionProof(proof *services.InclusionProof) *domain.InclusionProof {
+	return &domain.InclusionProof{
+		TradeId: proof.TradeId,
+		Seq:     proof.Seq,
+		Index:   proof.Index,
+		Count:   proof.Count,
+		Leaf:    proof.Leaf,
+		Chain:   proof.Chain,
+		Root:    proof.Root,
+		Proof:   proof.Proof,
+	}
+}
+
+func ResolutionToGrpcResolution(resolution *services.Resolution) *domain.Resolution {
+	return &domain.Resolution{
+		TradeId:    resolution.TradeId,
+		Counter:    resolution.Counter,
+		Root:       resolution.Root,
+		ResolvedAt: resolution.ResolvedAt,
 	}
 }
 
@@ -318,3 +396,108 @@ func SettlementToGrpcSettlement(settlement *contracts.Settlement) *domain.Settle
 		Broke:      settlement.Broker.Uint64(),
 	}
 }
+
+func SavedSearchFromGrpcSavedSearch(search *domain.SavedSearch) *model.SavedSearch {
+	if search == nil {
+		return nil
+	}
+	savedSearch := &model.SavedSearch{
+		Model: gorm.Model{
+			ID: uint(search.Id),
+		},
+		Name:       search.Name,
+		WebhookURL: search.WebhookUrl,
+	}
+	if query := search.Query; query != nil {
+		savedSearch.DataType = query.DataType
+		savedSearch.MinCost = query.MinCost
+		savedSearch.MaxCost = query.MaxCost
+		savedSearch.MinFrequency = query.MinFrequency
+		savedSearch.MaxFrequency = query.MaxFrequency
+		savedSearch.Text = query.Text
+		savedSearch.MinRating = query.MinRating
+		savedSearch.Device = query.Device
+		savedSearch.User = query.User
+	}
+	return savedSearch
+}
+
+func SavedSearchToGrpcSavedSearch(search *model.SavedSearch) *domain.SavedSearch {
+	if search == nil {
+		return nil
+	}
+	return &domain.SavedSearch{
+		Id:   uint64(search.ID),
+		Name: search.Name,
+		Query: &domain.ProductSearchQuery{
+			DataType:     search.DataType,
+			MinCost:      search.MinCost,
+			MaxCost:      search.MaxCost,
+			MinFrequency: search.MinFrequency,
+			MaxFrequency: search.MaxFrequency,
+			Text:         search.Text,
+			MinRating:    search.MinRating,
+			Device:       search.Device,
+			User:         search.User,
+		},
+		WebhookUrl: search.WebhookURL,
+	}
+}
+
+func ProductNotificationToGrpcWatchSavedSearchResponse(
+	notification *services.ProductNotification,
+) *WatchSavedSearchResponse {
+	return &WatchSavedSearchResponse{
+		SavedSearch: uint64(notification.SavedSearch),
+		Event:       notification.Event,
+		Product:     ProductToGrpcProduct(notification.Product),
+	}
+}
+
+func ApiKeyFromGrpcApiKey(key *domain.ApiKey) *model.ApiKey {
+	if key == nil {
+		return &model.ApiKey{}
+	}
+	return &model.ApiKey{
+		Model: gorm.Model{
+			ID: uint(key.Id),
+		},
+		AccountID: uint(key.AccountId),
+		Name:      key.Name,
+		Device:    key.Device,
+		Methods:   strings.Join(key.Methods, " "),
+	}
+}
+
+func ApiKeyToGrpcApiKey(key *model.ApiKey) *domain.ApiKey {
+	if key == nil {
+		return nil
+	}
+	grpcKey := &domain.ApiKey{
+		Id:        uint64(key.ID),
+		AccountId: uint64(key.AccountID),
+		Name:      key.Name,
+		Device:    key.Device,
+		Methods:   key.MethodList(),
+		Revoked:   key.Revoked,
+		CreatedAt: key.CreatedAt.Unix(),
+	}
+	if key.LastUsedAt != nil {
+		grpcKey.LastUsedAt = key.LastUsedAt.Unix()
+	}
+	return grpcKey
+}
+
+func JwkToGrpcJwk(jwk *services.Jwk) *domain.Jwk {
+	return &domain.Jwk{
+		Kty: jwk.Kty,
+		Kid: jwk.Kid,
+		Use: jwk.Use,
+		Alg: jwk.Alg,
+		N:   jwk.N,
+		E:   jwk.E,
+		Crv: jwk.Crv,
+		X:   jwk.X,
+		Y:   jwk.Y,
+	}
+}
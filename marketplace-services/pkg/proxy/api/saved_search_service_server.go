@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"marketplace-services/pkg/proxy/services"
+)
+
+type savedSearchServiceServer struct {
+	UnimplementedSavedSearchServiceServer
+	savedSearchService services.SavedSearchService
+}
+
+func NewSavedSearchServiceServer(service services.SavedSearchService) *savedSearchServiceServer {
+	return &savedSearchServiceServer{savedSearchService: service}
+}
+
+func (s *savedSearchServiceServer) CreateSavedSearch(
+	ctx context.Context,
+	req *CreateSavedSearchRequest,
+) (*CreateSavedSearchResponse, error) {
+	search, secret, err := s.savedSearchService.CreateSavedSearch(ctx, SavedSearchFromGrpcSavedSearch(req.SavedSearch))
+	if err != nil {
+		return nil, err
+	}
+	return &CreateSavedSearchResponse{SavedSearch: SavedSearchToGrpcSavedSearch(search), WebhookSecret: secret}, nil
+}
+
+func (s *savedSearchServiceServer) FindSavedSearches(
+	req *FindSavedSearchesRequest,
+	stream SavedSearchService_FindSavedSearchesServer,
+) error {
+	searches, err := s.savedSearchService.FindSavedSearches(stream.Context())
+	if err != nil {
+		return err
+	}
+	for _, search := range searches {
+		err = stream.Send(&FindSavedSearchesResponse{SavedSearch: SavedSearchToGrpcSavedSearch(search)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *savedSearchServiceServer) DeleteSavedSearch(
+	ctx context.Context,
+	req *DeleteSavedSearchRequest,
+) (*DeleteSavedSearchResponse, error) {
+	if err := s.savedSearchService.DeleteSavedSearch(ctx, uint(req.Id)); err != nil {
+		return nil, err
+	}
+	return &DeleteSavedSearchResponse{}, nil
+}
+
+func (s *savedSearchServiceServer) WatchSavedSearch(
+	req *WatchSavedSearchRequest,
+	stream SavedSearchService_WatchSavedSearchServer,
+) error {
+	sink, errc := s.savedSearchService.WatchSavedSearch(stream.Context(), uint(req.Id))
+	for notification := range sink {
+		if err := stream.Send(ProductNotificationToGrpcWatchSavedSearchResponse(notification)); err != nil {
+			return err
+		}
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	return nil
+}
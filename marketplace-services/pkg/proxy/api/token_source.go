@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"sync"
+	"time"
+)
+
+const tokenRenewalMargin = 30 * time.Second
+
+// TokenSource logs a proxy account in and attaches its access token to outgoing calls. The token is
+// renewed with the refresh token shortly before it expires, so long-running clients stay
+// authenticated. Machine clients can attach an API key instead.
+type TokenSource struct {
+	client       AuthServiceClient
+	apiKey       string
+	username     string
+	password     []byte
+	token        string
+	refreshToken string
+	expiresAt    time.Time
+	sync.Mutex
+}
+
+func NewTokenSource(username string, password []byte) *TokenSource {
+	return &TokenSource{username: username, password: password}
+}
+
+func NewApiKeyTokenSource(apiKey string) *TokenSource {
+	return &TokenSource{apiKey: apiKey}
+}
+
+// SetClient sets the auth service client used to get and refresh tokens. It must be set before the
+// first call through the interceptors.
+func (t *TokenSource) SetClient(client AuthServiceClient) {
+	t.Lock()
+	defer t.Unlock()
+	t.client = client
+}
+
+func (t *TokenSource) Token(ctx context.Context) (string, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.apiKey != "" {
+		return t.apiKey, nil
+	}
+
+	if t.token != "" && time.Now().Add(tokenRenewalMargin).Before(t.expiresAt) {
+		return t.token, nil
+	}
+
+	if t.refreshToken != "" {
+		resp, err := t.client.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: t.refreshToken})
+		if err == nil {
+			t.set(resp.Token, resp.RefreshToken, resp.ExpiresAt)
+			return t.token, nil
+		}
+	}
+
+	resp, err := t.client.GetToken(ctx, &GetTokenRequest{Username: t.username, Password: t.password})
+	if err != nil {
+		return "", fmt.Errorf("get token for %s: %w", t.username, err)
+	}
+	t.set(resp.Token, resp.RefreshToken, resp.ExpiresAt)
+	return t.token, nil
+}
+
+func (t *TokenSource) set(token string, refreshToken string, expiresAt int64) {
+	t.token = token
+	t.refreshToken = refreshToken
+	t.expiresAt = time.Unix(expiresAt, 0)
+}
+
+func (t *TokenSource) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, err := t.authorize(ctx, method)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func (t *TokenSource) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, err := t.authorize(ctx, method)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func (t *TokenSource) authorize(ctx context.Context, method string) (context.Context, error) {
+	if method == "/proxy.AuthService/GetToken" || method == "/proxy.AuthService/RefreshToken" {
+		return ctx, nil
+	}
+	token, err := t.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t.apiKey != "" {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "apikey "+token), nil
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "bearer "+token), nil
+}
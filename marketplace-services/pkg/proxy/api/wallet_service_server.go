@@ -2,6 +2,9 @@ package api
 
 import (
 	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"marketplace-services/pkg/proxy/services"
 )
 
@@ -18,11 +21,17 @@ func (s *walletServiceServer) CreateWallet(
 	ctx context.Context,
 	req *CreateWalletRequest,
 ) (*CreateWalletResponse, error) {
-	w, err := s.walletService.CreateWallet(ctx, WalletFromGrpcWallet(req.Wallet))
+	wallet := WalletFromGrpcWallet(req.Wallet)
+	if wallet == nil {
+		return nil, status.Error(codes.InvalidArgument, "wallet missing")
+	}
+	wallet.Passphrase = req.Passphrase
+
+	w, err := s.walletService.CreateWallet(ctx, wallet)
 	if err != nil {
 		return nil, err
 	}
-	return &CreateWalletResponse{Wallet: WalletToGrpcWallet(w)}, err
+	return &CreateWalletResponse{Wallet: WalletToGrpcWallet(w), Mnemonic: w.Mnemonic}, err
 }
 
 func (s *walletServiceServer) FindWalletById(
@@ -46,3 +55,73 @@ func (s *walletServiceServer) FindWalletByAccountId(
 	}
 	return &FindWalletByAccountIdResponse{Wallet: WalletToGrpcWallet(w)}, err
 }
+
+func (s *walletServiceServer) ImportKeyStore(
+	ctx context.Context,
+	req *ImportKeyStoreRequest,
+) (*ImportKeyStoreResponse, error) {
+	w, err := s.walletService.ImportKeyStore(ctx, uint(req.AccountId), req.KeyJson, req.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &ImportKeyStoreResponse{Wallet: WalletToGrpcWallet(w)}, err
+}
+
+func (s *walletServiceServer) ImportPrivateKey(
+	ctx context.Context,
+	req *ImportPrivateKeyRequest,
+) (*ImportPrivateKeyResponse, error) {
+	w, err := s.walletService.ImportPrivateKey(ctx, uint(req.AccountId), req.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ImportPrivateKeyResponse{Wallet: WalletToGrpcWallet(w)}, err
+}
+
+func (s *walletServiceServer) ExportKeyStore(
+	ctx context.Context,
+	req *ExportKeyStoreRequest,
+) (*ExportKeyStoreResponse, error) {
+	keyJSON, err := s.walletService.ExportKeyStore(ctx, uint(req.AccountId), req.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &ExportKeyStoreResponse{KeyJson: keyJSON}, err
+}
+
+func (s *walletServiceServer) RecoverWallet(
+	ctx context.Context,
+	req *RecoverWalletRequest,
+) (*RecoverWalletResponse, error) {
+	w, err := s.walletService.RecoverWallet(ctx, uint(req.AccountId), req.Mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	return &RecoverWalletResponse{Wallet: WalletToGrpcWallet(w)}, err
+}
+
+func (s *walletServiceServer) DeriveDeviceKey(
+	ctx context.Context,
+	req *DeriveDeviceKeyRequest,
+) (*DeriveDeviceKeyResponse, error) {
+	key, err := s.walletService.DeriveDeviceKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &DeriveDeviceKeyResponse{
+		Address: common.BytesToAddress(key.Address).Hex(),
+		Path:    key.Path,
+		Index:   key.Index,
+	}, err
+}
+
+func (s *walletServiceServer) ExportDerivedKey(
+	ctx context.Context,
+	req *ExportDerivedKeyRequest,
+) (*ExportDerivedKeyResponse, error) {
+	keyJSON, err := s.walletService.ExportDerivedKey(ctx, req.Index, req.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &ExportDerivedKeyResponse{KeyJson: keyJSON}, err
+}
@@ -0,0 +1,177 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// initialSchema creates the tables of the proxy as they were before versioned migrations. It only
+// adds missing tables and columns, so databases created by the former auto migration are adopted.
+// The structs are snapshots of the models and must not follow later changes of the models. It can't
+// be rolled back, that would drop all data of the proxy.
+var initialSchema = &Migration{
+	Version: 1,
+	Name:    "initial_schema",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(initialSchemaTables()...).Error
+	},
+}
+
+func initialSchemaTables() []interface{} {
+	return []interface{}{
+		&account0001{},
+		&wallet0001{},
+		&geoLocation0001{},
+		&savedSearch0001{},
+		&token0001{},
+		&derivedKey0001{},
+		&apiKey0001{},
+		&signingKey0001{},
+		&externalIdentity0001{},
+		&oidcLogin0001{},
+	}
+}
+
+type account0001 struct {
+	gorm.Model
+	Name            string `gorm:"unique;not null"`
+	Password        []byte `gorm:"not null"`
+	Role            int32  `gorm:"not null"`
+	Disabled        bool   `gorm:"not null;default:false"`
+	FailedLogins    int    `gorm:"not null;default:0"`
+	LockedUntil     *time.Time
+	TotpSecret      string
+	TotpEnabled     bool  `gorm:"not null;default:false"`
+	TotpLastCounter int64 `gorm:"not null;default:0"`
+}
+
+func (account0001) TableName() string {
+	return "accounts"
+}
+
+type wallet0001 struct {
+	gorm.Model
+	AccountID      uint   `gorm:"unique" sql:"type:integer REFERENCES accounts(id)"`
+	Passphrase     string `gorm:"not null"`
+	Address        []byte `gorm:"unique;not null"`
+	FilePath       string `gorm:"not null"`
+	PublicKey      []byte `gorm:"unique;not null"`
+	HD             bool   `gorm:"not null;default:false"`
+	SealedMnemonic string
+	NextIndex      uint32 `gorm:"not null;default:1"`
+}
+
+func (wallet0001) TableName() string {
+	return "wallets"
+}
+
+type geoLocation0001 struct {
+	gorm.Model
+	Address        []byte `gorm:"unique;not null"`
+	Country        string `gorm:"not null"`
+	Latitude       float64
+	Longitude      float64
+	HasCoordinates bool
+}
+
+func (geoLocation0001) TableName() string {
+	return "geo_locations"
+}
+
+type savedSearch0001 struct {
+	gorm.Model
+	AccountID    uint   `gorm:"not null" sql:"type:integer REFERENCES accounts(id)"`
+	Name         string `gorm:"not null"`
+	DataType     string
+	MinCost      uint64
+	MaxCost      uint64
+	MinFrequency uint64
+	MaxFrequency uint64
+	Text         string
+	MinRating    uint64
+	Device       string
+	User         string
+	WebhookURL   string
+}
+
+func (savedSearch0001) TableName() string {
+	return "saved_searches"
+}
+
+type token0001 struct {
+	gorm.Model
+	TokenID   string `gorm:"unique;not null"`
+	AccountID uint   `gorm:"not null;index" sql:"type:integer REFERENCES accounts(id)"`
+	Type      int32  `gorm:"not null"`
+	Hash      []byte
+	ExpiresAt time.Time `gorm:"not null;index"`
+	Revoked   bool      `gorm:"not null"`
+}
+
+func (token0001) TableName() string {
+	return "tokens"
+}
+
+type derivedKey0001 struct {
+	gorm.Model
+	WalletID uint   `gorm:"not null" sql:"type:integer REFERENCES wallets(id)"`
+	Index    uint32 `gorm:"not null"`
+	Path     string `gorm:"not null"`
+	Address  []byte `gorm:"unique;not null"`
+}
+
+func (derivedKey0001) TableName() string {
+	return "derived_keys"
+}
+
+type apiKey0001 struct {
+	gorm.Model
+	KeyID      string `gorm:"unique;not null"`
+	AccountID  uint   `gorm:"not null;index" sql:"type:integer REFERENCES accounts(id)"`
+	Name       string `gorm:"not null"`
+	Hash       []byte `gorm:"not null"`
+	Device     string
+	Methods    string
+	Revoked    bool `gorm:"not null"`
+	LastUsedAt *time.Time
+}
+
+func (apiKey0001) TableName() string {
+	return "api_keys"
+}
+
+type signingKey0001 struct {
+	gorm.Model
+	KeyID      string `gorm:"unique;not null"`
+	Algorithm  string `gorm:"not null"`
+	PrivateKey string `gorm:"not null"`
+	Active     bool   `gorm:"not null"`
+	RetiredAt  *time.Time
+}
+
+func (signingKey0001) TableName() string {
+	return "signing_keys"
+}
+
+type externalIdentity0001 struct {
+	gorm.Model
+	Issuer    string `gorm:"not null;unique_index:idx_issuer_subject"`
+	Subject   string `gorm:"not null;unique_index:idx_issuer_subject"`
+	AccountID uint   `gorm:"not null;index" sql:"type:integer REFERENCES accounts(id)"`
+}
+
+func (externalIdentity0001) TableName() string {
+	return "external_identities"
+}
+
+type oidcLogin0001 struct {
+	gorm.Model
+	State     string    `gorm:"unique;not null"`
+	Nonce     string    `gorm:"not null"`
+	Verifier  string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+func (oidcLogin0001) TableName() string {
+	return "oidc_logins"
+}
@@ -0,0 +1,26 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// savedSearchWebhookSecret adds the sealed secret signing the webhook requests of saved searches.
+// Rolling back keeps the column, older versions ignore it and SQLite can't drop columns.
+var savedSearchWebhookSecret = &Migration{
+	Version: 2,
+	Name:    "saved_search_webhook_secret",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&savedSearch0002{}).Error
+	},
+	Down: func(tx *gorm.DB) error {
+		return nil
+	},
+}
+
+type savedSearch0002 struct {
+	WebhookSecret string
+}
+
+func (savedSearch0002) TableName() string {
+	return "saved_searches"
+}
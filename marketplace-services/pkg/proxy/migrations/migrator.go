@@ -0,0 +1,275 @@
+package migrations
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// advisoryLockID identifies the PostgreSQL advisory lock serializing migrations of proxy replicas.
+const advisoryLockID = 25566
+
+// Migration changes the schema of the proxy database from the previous version to its version.
+// Migrations without Down can't be rolled back.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+type schemaMigration struct {
+	Version   int       `gorm:"primary_key;auto_increment:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// all lists the migrations of the proxy database ordered by version. Applied migrations must never
+// be changed, schema changes are added as new migrations.
+var all = []*Migration{
+	initialSchema,
+	savedSearchWebhookSecret,
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	logger     logrus.FieldLogger
+	migrations []*Migration
+}
+
+func NewMigrator(db *gorm.DB, logger logrus.FieldLogger) *Migrator {
+	return &Migrator{db: db, logger: logger, migrations: all}
+}
+
+// Latest returns the version of the newest migration.
+func (m *Migrator) Latest() int {
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status returns all migrations with the time they were applied, which is nil for pending ones.
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = &MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if a, ok := applied[migration.Version]; ok {
+			appliedAt := a.AppliedAt
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// Pending returns the number of migrations that are not applied yet.
+func (m *Migrator) Pending() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// Up applies all pending migrations up to the target version, or all of them if target is 0, and
+// returns the number of applied migrations. Targets below the applied version are rejected, those
+// migrations are rolled back with Down.
+func (m *Migrator) Up(target int) (int, error) {
+	if target != 0 && m.find(target) == nil {
+		return 0, fmt.Errorf("unknown migration version %d", target)
+	}
+	if target != 0 {
+		applied, err := m.applied()
+		if err != nil {
+			return 0, err
+		}
+		for version := range applied {
+			if version > target {
+				return 0, fmt.Errorf("migration %d is already applied, roll back to reach version %d", version, target)
+			}
+		}
+	}
+
+	count := 0
+	for _, migration := range m.migrations {
+		if target != 0 && migration.Version > target {
+			break
+		}
+		applied, err := m.apply(migration)
+		if err != nil {
+			return count, err
+		}
+		if applied {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Down rolls back the given number of the most recently applied migrations and returns the number
+// of rolled back migrations.
+func (m *Migrator) Down(steps int) (int, error) {
+	if steps < 1 {
+		return 0, fmt.Errorf("invalid number of steps %d, must be positive", steps)
+	}
+
+	for count := 0; count < steps; count++ {
+		rolledBack, err := m.rollback()
+		if err != nil {
+			return count, err
+		}
+		if !rolledBack {
+			return count, nil
+		}
+	}
+	return steps, nil
+}
+
+func (m *Migrator) apply(migration *Migration) (bool, error) {
+	tx, err := m.begin()
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	if err := tx.Model(&schemaMigration{}).Where("version = ?", migration.Version).Count(&count).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("check migration %d: %w", migration.Version, err)
+	}
+	if count > 0 {
+		tx.Rollback()
+		return false, nil
+	}
+
+	m.logger.Infof("Applying migration %d %s", migration.Version, migration.Name)
+	if err := migration.Up(tx); err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("apply migration %d %s: %w", migration.Version, migration.Name, err)
+	}
+	record := &schemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}
+	if err := tx.Create(record).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("record migration %d: %w", migration.Version, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return false, fmt.Errorf("commit migration %d: %w", migration.Version, err)
+	}
+	return true, nil
+}
+
+func (m *Migrator) rollback() (bool, error) {
+	tx, err := m.begin()
+	if err != nil {
+		return false, err
+	}
+
+	var record schemaMigration
+	err = tx.Order("version desc").First(&record).Error
+	if gorm.IsRecordNotFoundError(err) {
+		tx.Rollback()
+		return false, nil
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("get latest migration: %w", err)
+	}
+
+	migration := m.find(record.Version)
+	if migration == nil {
+		tx.Rollback()
+		return false, fmt.Errorf("unknown migration %d", record.Version)
+	}
+	if migration.Down == nil {
+		tx.Rollback()
+		return false, fmt.Errorf("migration %d %s can't be rolled back", migration.Version, migration.Name)
+	}
+
+	m.logger.Infof("Rolling back migration %d %s", migration.Version, migration.Name)
+	if err := migration.Down(tx); err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("roll back migration %d %s: %w", migration.Version, migration.Name, err)
+	}
+	if err := tx.Delete(&record).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("delete record of migration %d: %w", migration.Version, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return false, fmt.Errorf("commit rollback of migration %d: %w", migration.Version, err)
+	}
+	return true, nil
+}
+
+// begin starts a transaction that holds the migration lock on PostgreSQL, so replicas starting at
+// the same time apply each migration once. SQLite serializes writing transactions itself. The
+// schema migrations table is created after taking the lock.
+func (m *Migrator) begin() (*gorm.DB, error) {
+	tx := m.db.Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("begin transaction: %w", tx.Error)
+	}
+	if m.db.Dialect().GetName() == "postgres" {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", advisoryLockID).Error; err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("acquire migration lock: %w", err)
+		}
+	}
+	err := tx.Exec(
+		"CREATE TABLE IF NOT EXISTS schema_migrations (" +
+			"version integer PRIMARY KEY, " +
+			"name varchar(255) NOT NULL, " +
+			"applied_at timestamp NOT NULL)",
+	).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("create schema migrations table: %w", err)
+	}
+	return tx, nil
+}
+
+func (m *Migrator) applied() (map[int]*schemaMigration, error) {
+	tx, err := m.begin()
+	if err != nil {
+		return nil, err
+	}
+	var records []*schemaMigration
+	if err := tx.Find(&records).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("get applied migrations: %w", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	applied := make(map[int]*schemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+func (m *Migrator) find(version int) *Migration {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration
+		}
+	}
+	return nil
+}
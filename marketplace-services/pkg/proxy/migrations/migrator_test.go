@@ -0,0 +1,136 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"marketplace-services/pkg/proxy/model"
+	"testing"
+)
+
+// models are the models stored in the proxy database, which the migrations must create.
+var models = []interface{}{
+	&model.Account{},
+	&model.Wallet{},
+	&model.GeoLocation{},
+	&model.SavedSearch{},
+	&model.Token{},
+	&model.DerivedKey{},
+	&model.ApiKey{},
+	&model.SigningKey{},
+	&model.ExternalIdentity{},
+	&model.OidcLogin{},
+}
+
+func newTestMigrator(t *testing.T) (*Migrator, *gorm.DB) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	db.DB().SetMaxOpenConns(1)
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	return NewMigrator(db, logger), db
+}
+
+func migrateUp(t *testing.T, migrator *Migrator, target int) int {
+	count, err := migrator.Up(target)
+	if err != nil {
+		t.Fatalf("migrate up to %d: %v", target, err)
+	}
+	return count
+}
+
+func TestUpCreatesSchemaOfModels(t *testing.T) {
+	migrator, db := newTestMigrator(t)
+	if count := migrateUp(t, migrator, 0); count != len(all) {
+		t.Fatalf("applied %d migrations, want %d", count, len(all))
+	}
+
+	for _, m := range models {
+		scope := db.NewScope(m)
+		table := scope.TableName()
+		if !db.Dialect().HasTable(table) {
+			t.Errorf("table %s missing", table)
+			continue
+		}
+		for _, field := range scope.GetModelStruct().StructFields {
+			if field.IsNormal && !db.Dialect().HasColumn(table, field.DBName) {
+				t.Errorf("column %s.%s missing", table, field.DBName)
+			}
+		}
+	}
+
+	if count := migrateUp(t, migrator, 0); count != 0 {
+		t.Fatalf("applied %d migrations again", count)
+	}
+	if pending, err := migrator.Pending(); err != nil || pending != 0 {
+		t.Fatalf("%d migrations pending, err %v", pending, err)
+	}
+}
+
+func TestUpAdoptsAutoMigratedDatabase(t *testing.T) {
+	migrator, db := newTestMigrator(t)
+	if err := db.AutoMigrate(models...).Error; err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	if count := migrateUp(t, migrator, 0); count != len(all) {
+		t.Fatalf("applied %d migrations, want %d", count, len(all))
+	}
+}
+
+func TestUpToTarget(t *testing.T) {
+	migrator, _ := newTestMigrator(t)
+	if count := migrateUp(t, migrator, 1); count != 1 {
+		t.Fatalf("applied %d migrations, want 1", count)
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("get migration status: %v", err)
+	}
+	if statuses[0].AppliedAt == nil || statuses[1].AppliedAt != nil {
+		t.Fatal("status doesn't match the applied migrations")
+	}
+
+	migrateUp(t, migrator, 0)
+	if _, err := migrator.Up(1); err == nil {
+		t.Fatal("migrated up to version below the applied version")
+	}
+	if _, err := migrator.Up(len(all) + 1); err == nil {
+		t.Fatal("migrated up to unknown version")
+	}
+}
+
+func TestDown(t *testing.T) {
+	migrator, db := newTestMigrator(t)
+	migrateUp(t, migrator, 0)
+	account := &model.Account{Name: "alice", Password: []byte("hash")}
+	if err := db.Create(account).Error; err != nil {
+		t.Fatalf("create account: %v", err)
+	}
+
+	for _, steps := range []int{0, -1} {
+		if _, err := migrator.Down(steps); err == nil {
+			t.Fatalf("rolled back %d steps", steps)
+		}
+	}
+
+	count, err := migrator.Down(len(all))
+	if err == nil {
+		t.Fatal("rolled back the initial schema")
+	}
+	if count != len(all)-1 {
+		t.Fatalf("rolled back %d migrations, want %d", count, len(all)-1)
+	}
+	if pending, err := migrator.Pending(); err != nil || pending != len(all)-1 {
+		t.Fatalf("%d migrations pending, err %v", pending, err)
+	}
+	if err := db.First(&model.Account{}, account.ID).Error; err != nil {
+		t.Fatalf("account lost by rollback: %v", err)
+	}
+
+	if count := migrateUp(t, migrator, 0); count != len(all)-1 {
+		t.Fatalf("applied %d migrations, want %d", count, len(all)-1)
+	}
+}
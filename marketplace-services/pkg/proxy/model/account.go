@@ -1,26 +1,108 @@
 package model
 
 import (
+	"errors"
+	"fmt"
 	validation "github.com/go-ozzo/ozzo-validation/v3"
 	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
+	"unicode"
 )
 
+// maxPasswordLength is the number of bytes bcrypt takes into account.
+const maxPasswordLength = 72
+
 type Account struct {
 	gorm.Model
-	Name     string `gorm:"unique;not null"`
-	Password []byte `gorm:"not null"`
-	Role     Role   `gorm:"not null"`
-	Wallet   Wallet `gorm:"association_autoupdate:false;association_autocreate:false"`
+	Name            string `gorm:"unique;not null"`
+	Password        []byte `gorm:"not null"`
+	Role            Role   `gorm:"not null"`
+	Disabled        bool   `gorm:"not null;default:false"`
+	FailedLogins    int    `gorm:"not null;default:0"`
+	LockedUntil     *time.Time
+	TotpSecret      string
+	TotpEnabled     bool   `gorm:"not null;default:false"`
+	TotpLastCounter int64  `gorm:"not null;default:0"`
+	Wallet          Wallet `gorm:"association_autoupdate:false;association_autocreate:false"`
+}
+
+type PasswordPolicy struct {
+	MinLength           int
+	MinCharacterClasses int
+}
+
+var passwordPolicy = PasswordPolicy{MinLength: 10, MinCharacterClasses: 3}
+
+// SetPasswordPolicy sets the policy new passwords of all accounts are validated against.
+func SetPasswordPolicy(policy PasswordPolicy) {
+	passwordPolicy = policy
+}
+
+// Validate checks that a password has the minimum length and contains characters of the minimum
+// number of classes, which are lower case letters, upper case letters, digits and other characters.
+func (p PasswordPolicy) Validate(value interface{}) error {
+	password, _ := value.([]byte)
+	if len(password) == 0 {
+		return nil
+	}
+	if len(password) < p.MinLength || len(password) > maxPasswordLength {
+		return fmt.Errorf("the length must be between %d and %d", p.MinLength, maxPasswordLength)
+	}
+
+	var lower, upper, digit, other int
+	for _, r := range string(password) {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if lower+upper+digit+other < p.MinCharacterClasses {
+		return fmt.Errorf(
+			"must contain at least %d of lower case letters, upper case letters, digits and other characters",
+			p.MinCharacterClasses,
+		)
+	}
+	return nil
 }
 
 func (u Account) HasRole(role Role) bool {
 	return u.Role == role
 }
 
+func (u Account) IsLocked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
+}
+
 func (u Account) Validate() error {
 	return validation.ValidateStruct(&u,
 		validation.Field(&u.Name, validation.Required, validation.Length(2, 32)),
-		validation.Field(&u.Password, validation.Required, validation.Length(8, 32)),
+		validation.Field(&u.Password, validation.Required, passwordPolicy, validation.By(u.notContainingName)),
 		validation.Field(&u.Role, validation.Required, validation.Min(1), validation.Max(2)),
 	)
 }
+
+func (u Account) ValidateUpdate() error {
+	return validation.ValidateStruct(&u,
+		validation.Field(&u.Name, validation.Required, validation.Length(2, 32)),
+		validation.Field(&u.Role, validation.Required, validation.Min(1), validation.Max(2)),
+	)
+}
+
+func (u Account) notContainingName(value interface{}) error {
+	password, _ := value.([]byte)
+	if u.Name != "" && strings.Contains(strings.ToLower(string(password)), strings.ToLower(u.Name)) {
+		return errors.New("must not contain the account name")
+	}
+	return nil
+}
+
+func ValidatePassword(password []byte) error {
+	return validation.Validate(password, validation.Required, passwordPolicy)
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	validation "github.com/go-ozzo/ozzo-validation/v3"
+	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
+)
+
+// deviceScopedMethods are the method prefixes API keys scoped to a device may call. Their methods
+// check that the device they act for is the one of the key. The contracts treat the sender of a
+// transaction as the device, so the wallet of the account has to be the device of the key to trade.
+// Brokers can only be looked up.
+var deviceScopedMethods = []string{
+	"/proxy.DeviceContractService/",
+	"/proxy.ProductContractService/",
+	"/proxy.TradingContractService/",
+	"/proxy.SettlementContractService/",
+	"/proxy.CryptoMessageService/",
+	"/proxy.BrokerContractService/Find",
+	"/proxy.BrokerContractService/Count",
+	"/proxy.BrokerContractService/Exists",
+	"/proxy.BrokerContractService/Watch",
+}
+
+type ApiKey struct {
+	gorm.Model
+	KeyID      string `gorm:"unique;not null"`
+	AccountID  uint   `gorm:"not null;index" sql:"type:integer REFERENCES accounts(id)"`
+	Name       string `gorm:"not null"`
+	Hash       []byte `gorm:"not null"`
+	Device     string
+	Methods    string
+	Revoked    bool `gorm:"not null"`
+	LastUsedAt *time.Time
+}
+
+func (k ApiKey) Validate() error {
+	return validation.ValidateStruct(&k,
+		validation.Field(&k.AccountID, validation.Required),
+		validation.Field(&k.Name, validation.Required, validation.Length(1, 64)),
+		validation.Field(&k.Device, validation.By(validateAddress)),
+		validation.Field(&k.Methods, validation.By(validateMethods), validation.By(k.validateDeviceMethods)),
+	)
+}
+
+// MethodList returns the full method names the key is restricted to. An empty list allows all
+// methods permitted by the role of the account, or all device scoped methods if the key is scoped
+// to a device.
+func (k ApiKey) MethodList() []string {
+	return strings.Fields(k.Methods)
+}
+
+func (k ApiKey) AllowsMethod(fullMethod string) bool {
+	if k.Device != "" && !isDeviceScopedMethod(fullMethod) {
+		return false
+	}
+	methods := k.MethodList()
+	if len(methods) == 0 {
+		return true
+	}
+	for _, method := range methods {
+		if method == fullMethod {
+			return true
+		}
+	}
+	return false
+}
+
+func (k ApiKey) AllowsDevice(device common.Address) bool {
+	return k.Device == "" || common.HexToAddress(k.Device) == device
+}
+
+func validateAddress(value interface{}) error {
+	address, _ := value.(string)
+	if address != "" && !common.IsHexAddress(address) {
+		return errors.New("must be a hex address")
+	}
+	return nil
+}
+
+func (k ApiKey) validateDeviceMethods(value interface{}) error {
+	methods, _ := value.(string)
+	if k.Device == "" {
+		return nil
+	}
+	for _, method := range strings.Fields(methods) {
+		if !isDeviceScopedMethod(method) {
+			return fmt.Errorf("%s can't be called with a device scoped key", method)
+		}
+	}
+	return nil
+}
+
+func isDeviceScopedMethod(fullMethod string) bool {
+	for _, prefix := range deviceScopedMethods {
+		if strings.HasPrefix(fullMethod, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateMethods(value interface{}) error {
+	methods, _ := value.(string)
+	for _, method := range strings.Fields(methods) {
+		if !strings.HasPrefix(method, "/") || strings.Count(method, "/") != 2 {
+			return errors.New("must be full method names like /proxy.Service/Method")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type DerivedKey struct {
+	gorm.Model
+	WalletID uint   `gorm:"not null" sql:"type:integer REFERENCES wallets(id)"`
+	Index    uint32 `gorm:"not null"`
+	Path     string `gorm:"not null"`
+	Address  []byte `gorm:"unique;not null"`
+}
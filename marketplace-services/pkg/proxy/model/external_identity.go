@@ -0,0 +1,23 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// ExternalIdentity links a subject of an OpenID Connect provider to a proxy account.
+type ExternalIdentity struct {
+	gorm.Model
+	Issuer    string `gorm:"not null;unique_index:idx_issuer_subject"`
+	Subject   string `gorm:"not null;unique_index:idx_issuer_subject"`
+	AccountID uint   `gorm:"not null;index" sql:"type:integer REFERENCES accounts(id)"`
+}
+
+// OidcLogin is a pending authorization code login started by the proxy.
+type OidcLogin struct {
+	gorm.Model
+	State     string    `gorm:"unique;not null"`
+	Nonce     string    `gorm:"not null"`
+	Verifier  string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
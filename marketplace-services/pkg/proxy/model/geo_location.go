@@ -0,0 +1,14 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type GeoLocation struct {
+	gorm.Model
+	Address        []byte `gorm:"unique;not null"`
+	Country        string `gorm:"not null"`
+	Latitude       float64
+	Longitude      float64
+	HasCoordinates bool
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"errors"
+	validation "github.com/go-ozzo/ozzo-validation/v3"
+	"github.com/jinzhu/gorm"
+	"net/url"
+)
+
+type SavedSearch struct {
+	gorm.Model
+	AccountID    uint   `gorm:"not null" sql:"type:integer REFERENCES accounts(id)"`
+	Name         string `gorm:"not null"`
+	DataType     string
+	MinCost      uint64
+	MaxCost      uint64
+	MinFrequency uint64
+	MaxFrequency uint64
+	Text         string
+	MinRating    uint64
+	Device       string
+	User         string
+	WebhookURL   string
+	// WebhookSecret is the sealed secret signing the webhook requests.
+	WebhookSecret string
+}
+
+func (s SavedSearch) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.AccountID, validation.Required),
+		validation.Field(&s.Name, validation.Required, validation.Length(1, 64)),
+		validation.Field(&s.WebhookURL, validation.By(validateWebhookURL)),
+	)
+}
+
+func validateWebhookURL(value interface{}) error {
+	rawURL, _ := value.(string)
+	if rawURL == "" {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("must be an http or https URL")
+	}
+	return nil
+}
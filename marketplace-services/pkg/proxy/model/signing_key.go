@@ -0,0 +1,15 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+type SigningKey struct {
+	gorm.Model
+	KeyID      string `gorm:"unique;not null"`
+	Algorithm  string `gorm:"not null"`
+	PrivateKey string `gorm:"not null"`
+	Active     bool   `gorm:"not null"`
+	RetiredAt  *time.Time
+}
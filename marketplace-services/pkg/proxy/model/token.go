@@ -0,0 +1,24 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+type TokenType int32
+
+const (
+	TokenTypeUnspecified TokenType = iota
+	TokenTypeAccess
+	TokenTypeRefresh
+)
+
+type Token struct {
+	gorm.Model
+	TokenID   string    `gorm:"unique;not null"`
+	AccountID uint      `gorm:"not null;index" sql:"type:integer REFERENCES accounts(id)"`
+	Type      TokenType `gorm:"not null"`
+	Hash      []byte
+	ExpiresAt time.Time `gorm:"not null;index"`
+	Revoked   bool      `gorm:"not null"`
+}
@@ -7,11 +7,15 @@ import (
 
 type Wallet struct {
 	gorm.Model
-	AccountID  uint   `gorm:"unique" sql:"type:integer REFERENCES accounts(id)"`
-	Passphrase string `gorm:"not null"`
-	Address    []byte `gorm:"unique;not null"`
-	FilePath   string `gorm:"not null"`
-	PublicKey  []byte `gorm:"unique;not null"`
+	AccountID      uint   `gorm:"unique" sql:"type:integer REFERENCES accounts(id)"`
+	Passphrase     string `gorm:"not null"`
+	Address        []byte `gorm:"unique;not null"`
+	FilePath       string `gorm:"not null"`
+	PublicKey      []byte `gorm:"unique;not null"`
+	HD             bool   `gorm:"not null;default:false"`
+	SealedMnemonic string
+	NextIndex      uint32 `gorm:"not null;default:1"`
+	Mnemonic       string `gorm:"-"`
 }
 
 func (w Wallet) Validate() error {
@@ -5,17 +5,29 @@ import (
 	"os"
 )
 
+const (
+	MasterKeyEnv        = "PROXY_WALLET_MASTER_KEY"
+	OidcClientSecretEnv = "PROXY_OIDC_CLIENT_SECRET"
+	DatabaseSourceEnv   = "PROXY_DATABASE_SOURCE"
+	SigningKeyEnv       = "PROXY_AUTH_SIGNING_KEY"
+)
+
 type options struct {
-	ConfigFile      string
-	AppName         string          `json:"appName"`
-	Host            string          `json:"host"`
-	Port            int             `json:"port"`
-	NoSig           bool            `json:"noSig"`
-	LoggingConfig   LoggingConfig   `json:"loggingConfig"`
-	DatabaseConfig  DatabaseConfig  `json:"databaseConfig"`
-	AuthConfig      AuthConfig      `json:"authConfig"`
-	EthConfig       EthConfig       `json:"ethConfig"`
-	ContractsConfig ContractsConfig `json:"contractsConfig"`
+	ConfigFile        string
+	AppName           string            `json:"appName"`
+	Host              string            `json:"host"`
+	Port              int               `json:"port"`
+	NoSig             bool              `json:"noSig"`
+	LoggingConfig     LoggingConfig     `json:"loggingConfig"`
+	DatabaseConfig    DatabaseConfig    `json:"databaseConfig"`
+	AuthConfig        AuthConfig        `json:"authConfig"`
+	LoginConfig       LoginConfig       `json:"loginConfig"`
+	OidcConfig        OidcConfig        `json:"oidcConfig"`
+	EthConfig         EthConfig         `json:"ethConfig"`
+	ContractsConfig   ContractsConfig   `json:"contractsConfig"`
+	DiscoveryConfig   DiscoveryConfig   `json:"discoveryConfig"`
+	SavedSearchConfig SavedSearchConfig `json:"savedSearchConfig"`
+	WalletConfig      WalletConfig      `json:"walletConfig"`
 }
 
 type LoggingConfig struct {
@@ -23,12 +35,43 @@ type LoggingConfig struct {
 }
 
 type DatabaseConfig struct {
-	Source string `json:"source"`
+	Dialect        string `json:"dialect"`
+	Source         string `json:"source"`
+	MigrateOnStart bool   `json:"migrateOnStart"`
 }
 
 type AuthConfig struct {
-	SigningKey          string `json:"signingKey"`
-	TokenExpirationTime int    `json:"TokenExpirationTime"`
+	SigningAlgorithm           string `json:"signingAlgorithm"`
+	SigningKey                 string `json:"signingKey"`
+	KeyRotationInterval        int    `json:"keyRotationInterval"`
+	JwksAddress                string `json:"jwksAddress"`
+	TokenExpirationTime        int    `json:"TokenExpirationTime"`
+	RefreshTokenExpirationTime int    `json:"refreshTokenExpirationTime"`
+}
+
+type LoginConfig struct {
+	BcryptCost                  int `json:"bcryptCost"`
+	PasswordMinLength           int `json:"passwordMinLength"`
+	PasswordMinCharacterClasses int `json:"passwordMinCharacterClasses"`
+	MaxFailedLogins             int `json:"maxFailedLogins"`
+	LockoutDuration             int `json:"lockoutDuration"`
+	MaxLockoutDuration          int `json:"maxLockoutDuration"`
+}
+
+type OidcConfig struct {
+	Enabled       bool     `json:"enabled"`
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"clientId"`
+	ClientSecret  string   `json:"clientSecret"`
+	RedirectURL   string   `json:"redirectUrl"`
+	Scopes        []string `json:"scopes"`
+	UsernameClaim string   `json:"usernameClaim"`
+	RoleClaim     string   `json:"roleClaim"`
+	AdminValues   []string `json:"adminValues"`
+	UserValues    []string `json:"userValues"`
+	AutoProvision bool     `json:"autoProvision"`
+	LoginTimeout  int      `json:"loginTimeout"`
+	HTTPTimeout   int      `json:"httpTimeout"`
 }
 
 type EthConfig struct {
@@ -45,6 +88,24 @@ type ContractsConfig struct {
 	TradingContractAddress     string `json:"tradingContractAddress"`
 }
 
+type DiscoveryConfig struct {
+	BrokerTimeout        int `json:"brokerTimeout"`
+	MaxConcurrentBrokers int `json:"maxConcurrentBrokers"`
+}
+
+type SavedSearchConfig struct {
+	WebhookTimeout             int  `json:"webhookTimeout"`
+	WebhookWorkers             int  `json:"webhookWorkers"`
+	AllowPrivateWebhookTargets bool `json:"allowPrivateWebhookTargets"`
+}
+
+// WalletConfig configures the wallets of the proxy. The master key sealing wallet secrets is only
+// read from the environment, so it never ends up in a config file.
+type WalletConfig struct {
+	MasterKey string `json:"-"`
+	HDWallets bool   `json:"hdWallets"`
+}
+
 type Option interface {
 	apply(*options)
 }
@@ -74,11 +135,31 @@ func defaultOptions() options {
 			Verbosity: 4,
 		},
 		DatabaseConfig: DatabaseConfig{
-			Source: "./tmp/proxy.db",
+			Dialect:        "sqlite3",
+			Source:         "./tmp/proxy.db",
+			MigrateOnStart: true,
 		},
 		AuthConfig: AuthConfig{
-			SigningKey:          "qwUyQF0htT",
-			TokenExpirationTime: 86400,
+			SigningAlgorithm:           "ES256",
+			KeyRotationInterval:        604800,
+			TokenExpirationTime:        900,
+			RefreshTokenExpirationTime: 2592000,
+		},
+		LoginConfig: LoginConfig{
+			BcryptCost:                  12,
+			PasswordMinLength:           10,
+			PasswordMinCharacterClasses: 3,
+			MaxFailedLogins:             5,
+			LockoutDuration:             60,
+			MaxLockoutDuration:          3600,
+		},
+		OidcConfig: OidcConfig{
+			Enabled:       false,
+			Scopes:        []string{"openid", "profile", "email"},
+			UsernameClaim: "preferred_username",
+			RoleClaim:     "groups",
+			LoginTimeout:  600,
+			HTTPTimeout:   10,
 		},
 		EthConfig: EthConfig{
 			ClientURL: "ws://127.0.0.1:7545",
@@ -92,6 +173,14 @@ func defaultOptions() options {
 			NegotiationContractAddress: "0xC87EDADd5E42C5cBC3f35C0eBEf64FCE43a5AbAA",
 			TradingContractAddress:     "0xf4669783a1a75C24BC9E442762514f45fA7FFD8e",
 		},
+		DiscoveryConfig: DiscoveryConfig{
+			BrokerTimeout:        10,
+			MaxConcurrentBrokers: 8,
+		},
+		SavedSearchConfig: SavedSearchConfig{
+			WebhookTimeout: 10,
+			WebhookWorkers: 4,
+		},
 	}
 }
 
@@ -105,6 +194,21 @@ func (o *options) loadConfiguration() error {
 	return jsonParser.Decode(&o)
 }
 
+func (o *options) loadEnvironment() {
+	if source := os.Getenv(DatabaseSourceEnv); source != "" {
+		o.DatabaseConfig.Source = source
+	}
+	if masterKey := os.Getenv(MasterKeyEnv); masterKey != "" {
+		o.WalletConfig.MasterKey = masterKey
+	}
+	if clientSecret := os.Getenv(OidcClientSecretEnv); clientSecret != "" {
+		o.OidcConfig.ClientSecret = clientSecret
+	}
+	if signingKey := os.Getenv(SigningKeyEnv); signingKey != "" {
+		o.AuthConfig.SigningKey = signingKey
+	}
+}
+
 func WithAppName(n string) Option {
 	return newFuncOption(func(o *options) {
 		o.AppName = n
@@ -164,3 +268,21 @@ func WithContractsConfig(contractsConfig ContractsConfig) Option {
 		o.ContractsConfig = contractsConfig
 	})
 }
+
+func WithDiscoveryConfig(discoveryConfig DiscoveryConfig) Option {
+	return newFuncOption(func(o *options) {
+		o.DiscoveryConfig = discoveryConfig
+	})
+}
+
+func WithSavedSearchConfig(savedSearchConfig SavedSearchConfig) Option {
+	return newFuncOption(func(o *options) {
+		o.SavedSearchConfig = savedSearchConfig
+	})
+}
+
+func WithWalletConfig(walletConfig WalletConfig) Option {
+	return newFuncOption(func(o *options) {
+		o.WalletConfig = walletConfig
+	})
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"context"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/proxy/model"
+	"strings"
+)
+
+type Access int
+
+const (
+	AccessDenied Access = iota
+	AccessPublic
+	AccessUser
+	AccessAdmin
+)
+
+// policies maps full method names to the access they require. Entries ending in a slash apply to
+// all methods of a service that have no entry of their own. Methods without any entry are denied.
+var policies = map[string]Access{
+	"/proxy.AuthService/GetToken":               AccessPublic,
+	"/proxy.AuthService/RefreshToken":           AccessPublic,
+	"/proxy.AuthService/Logout":                 AccessUser,
+	"/proxy.AuthService/RevokeAccountTokens":    AccessAdmin,
+	"/proxy.AuthService/GetJwks":                AccessPublic,
+	"/proxy.AuthService/RotateSigningKey":       AccessAdmin,
+	"/proxy.AuthService/BeginOidcLogin":         AccessPublic,
+	"/proxy.AuthService/CompleteOidcLogin":      AccessPublic,
+	"/proxy.AuthService/ExchangeOidcToken":      AccessPublic,
+	"/proxy.AuthService/LinkExternalIdentity":   AccessAdmin,
+	"/proxy.AuthService/UnlinkExternalIdentity": AccessAdmin,
+
+	"/proxy.AccountService/ChangePassword":  AccessUser,
+	"/proxy.AccountService/EnrollTotp":      AccessUser,
+	"/proxy.AccountService/ConfirmTotp":     AccessUser,
+	"/proxy.AccountService/DisableTotp":     AccessUser,
+	"/proxy.AccountService/":                AccessAdmin,
+	"/proxy.WalletService/DeriveDeviceKey":  AccessUser,
+	"/proxy.WalletService/ExportDerivedKey": AccessUser,
+	"/proxy.WalletService/":                 AccessAdmin,
+	"/proxy.ApiKeyService/":                 AccessAdmin,
+
+	"/proxy.UserContractService/":        AccessUser,
+	"/proxy.DeviceContractService/":      AccessUser,
+	"/proxy.ProductContractService/":     AccessUser,
+	"/proxy.BrokerContractService/":      AccessUser,
+	"/proxy.NegotiationContractService/": AccessUser,
+	"/proxy.BiddingContractService/":     AccessUser,
+	"/proxy.TradingContractService/":     AccessUser,
+	"/proxy.SettlementContractService/":  AccessUser,
+	"/proxy.DiscoveryService/":           AccessUser,
+	"/proxy.CryptoMessageService/":       AccessUser,
+	"/proxy.SavedSearchService/":         AccessUser,
+}
+
+func policy(fullMethod string) Access {
+	if access, ok := policies[fullMethod]; ok {
+		return access
+	}
+	return policies[fullMethod[:strings.LastIndex(fullMethod, "/")+1]]
+}
+
+func authorize(ctx context.Context, authFunc grpc_auth.AuthFunc, fullMethod string) (context.Context, error) {
+	access := policy(fullMethod)
+	switch access {
+	case AccessDenied:
+		return nil, status.Errorf(codes.PermissionDenied, "no access policy for %s", fullMethod)
+	case AccessPublic:
+		return ctx, nil
+	}
+
+	ctx, err := authFunc(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	account, ok := ctx.Value("principal").(model.Account)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "no principal")
+	}
+	if key, ok := ctx.Value("apiKey").(*model.ApiKey); ok && !key.AllowsMethod(fullMethod) {
+		return nil, status.Errorf(codes.PermissionDenied, "api key is not scoped to %s", fullMethod)
+	}
+	if access == AccessAdmin && !account.HasRole(model.RoleAdmin) {
+		return nil, status.Errorf(codes.PermissionDenied, "role %d needed for %s", model.RoleAdmin, fullMethod)
+	}
+	if access == AccessUser && !account.HasRole(model.RoleAdmin) && !account.HasRole(model.RoleUser) {
+		return nil, status.Errorf(codes.PermissionDenied, "role %d needed for %s", model.RoleUser, fullMethod)
+	}
+	return ctx, nil
+}
+
+func unaryAuthorizationInterceptor(authFunc grpc_auth.AuthFunc) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, err := authorize(ctx, authFunc, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func streamAuthorizationInterceptor(authFunc grpc_auth.AuthFunc) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, err := authorize(stream.Context(), authFunc, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		wrapped := grpc_middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
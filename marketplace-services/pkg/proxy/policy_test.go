@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"marketplace-services/pkg/proxy/model"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	tests := map[string]Access{
+		"/proxy.AuthService/GetToken":                 AccessPublic,
+		"/proxy.AuthService/RotateSigningKey":         AccessAdmin,
+		"/proxy.AccountService/ChangePassword":        AccessUser,
+		"/proxy.AccountService/CreateAccount":         AccessAdmin,
+		"/proxy.WalletService/DeriveDeviceKey":        AccessUser,
+		"/proxy.WalletService/CreateWallet":           AccessAdmin,
+		"/proxy.WalletService/ImportPrivateKey":       AccessAdmin,
+		"/proxy.WalletService/ExportKeyStore":         AccessAdmin,
+		"/proxy.DeviceContractService/CreateDevice":   AccessUser,
+		"/proxy.AuthService/Unknown":                  AccessDenied,
+		"/proxy.UnknownService/Method":                AccessDenied,
+		"/grpc.reflection.v1alpha.ServerReflection/X": AccessDenied,
+	}
+	for method, want := range tests {
+		if access := policy(method); access != want {
+			t.Errorf("policy of %s is %d, want %d", method, access, want)
+		}
+	}
+}
+
+// TestPolicyCoversProtos checks that no RPC of the proxy is denied for lack of a policy.
+func TestPolicyCoversProtos(t *testing.T) {
+	files, err := filepath.Glob("../../api/proto/proxy/*.proto")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("find proto files: %v", err)
+	}
+	serviceRegexp := regexp.MustCompile(`service\s+(\w+)\s*\{([^}]*(?:\{[^}]*\}[^}]*)*)\}`)
+	rpcRegexp := regexp.MustCompile(`rpc\s+(\w+)\s*\(`)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		for _, service := range serviceRegexp.FindAllStringSubmatch(string(data), -1) {
+			for _, rpc := range rpcRegexp.FindAllStringSubmatch(service[2], -1) {
+				method := "/proxy." + service[1] + "/" + rpc[1]
+				if policy(method) == AccessDenied {
+					t.Errorf("no policy for %s", method)
+				}
+			}
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	authenticate := func(account model.Account, key *model.ApiKey) func(ctx context.Context) (context.Context, error) {
+		return func(ctx context.Context) (context.Context, error) {
+			ctx = context.WithValue(ctx, "principal", account)
+			if key != nil {
+				ctx = context.WithValue(ctx, "apiKey", key)
+			}
+			return ctx, nil
+		}
+	}
+	admin := model.Account{Role: model.RoleAdmin}
+	user := model.Account{Role: model.RoleUser}
+	deviceKey := &model.ApiKey{Device: "0x01"}
+
+	tests := []struct {
+		name    string
+		account model.Account
+		key     *model.ApiKey
+		method  string
+		code    codes.Code
+	}{
+		{"public", model.Account{}, nil, "/proxy.AuthService/GetToken", codes.OK},
+		{"unknown method", admin, nil, "/proxy.AuthService/Unknown", codes.PermissionDenied},
+		{"admin method as admin", admin, nil, "/proxy.AccountService/CreateAccount", codes.OK},
+		{"admin method as user", user, nil, "/proxy.AccountService/CreateAccount", codes.PermissionDenied},
+		{"user method as user", user, nil, "/proxy.TradingContractService/FindTrades", codes.OK},
+		{"user method as admin", admin, nil, "/proxy.TradingContractService/FindTrades", codes.OK},
+		{"device key in scope", user, deviceKey, "/proxy.DeviceContractService/UpdateDevice", codes.OK},
+		{"device key trading", user, deviceKey, "/proxy.TradingContractService/AcceptTradingRequest", codes.OK},
+		{"device key finding broker", user, deviceKey, "/proxy.BrokerContractService/FindBrokerByAddress", codes.OK},
+		{"device key changing broker", user, deviceKey, "/proxy.BrokerContractService/UpdateBroker", codes.PermissionDenied},
+		{"device key out of scope", user, deviceKey, "/proxy.WalletService/DeriveDeviceKey", codes.PermissionDenied},
+		{"method key", user, &model.ApiKey{Methods: "/proxy.DiscoveryService/SearchBroker"},
+			"/proxy.DiscoveryService/SearchProducts", codes.PermissionDenied},
+	}
+	for _, test := range tests {
+		_, err := authorize(context.Background(), authenticate(test.account, test.key), test.method)
+		if code := status.Code(err); code != test.code {
+			t.Errorf("%s: authorized with %v, want %s", test.name, err, test.code)
+		}
+	}
+}
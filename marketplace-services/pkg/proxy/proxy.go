@@ -1,26 +1,31 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
-	grpc_middleware_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
 	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"marketplace-services/pkg/contracts"
 	"marketplace-services/pkg/proxy/api"
+	"marketplace-services/pkg/proxy/migrations"
 	"marketplace-services/pkg/proxy/model"
 	"marketplace-services/pkg/proxy/services"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 )
 
 type proxy struct {
@@ -28,11 +33,17 @@ type proxy struct {
 	db     *gorm.DB
 	logger logrus.FieldLogger
 
-	grpcServer *grpc.Server
-	ethClient  *ethclient.Client
+	grpcServer         *grpc.Server
+	ethClient          *ethclient.Client
+	brokerRegistry     services.BrokerRegistry
+	savedSearchService services.SavedSearchService
+	keyManager         services.KeyManager
+	jwksServer         *http.Server
 
 	running bool
 	quit    chan bool
+	ctx     context.Context
+	cancel  context.CancelFunc
 }
 
 func New(opt ...Option) (*proxy, error) {
@@ -46,6 +57,7 @@ func New(opt ...Option) (*proxy, error) {
 			return nil, fmt.Errorf("load configuration: %w", err)
 		}
 	}
+	opts.loadEnvironment()
 
 	logger := initLogger(opts)
 	db, err := initDb(logger, opts)
@@ -136,25 +148,104 @@ func New(opt ...Option) (*proxy, error) {
 		)
 	}
 
-	accountService := services.NewAccountServiceImpl(db, logger)
+	if opts.WalletConfig.MasterKey == "" {
+		return nil, fmt.Errorf("wallet master key missing, set %s", MasterKeyEnv)
+	}
+	sealer, err := services.NewAESPassphraseSealer(opts.WalletConfig.MasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("new passphrase sealer: %w", err)
+	}
+
+	model.SetPasswordPolicy(model.PasswordPolicy{
+		MinLength:           opts.LoginConfig.PasswordMinLength,
+		MinCharacterClasses: opts.LoginConfig.PasswordMinCharacterClasses,
+	})
+	accountService, err := services.NewAccountServiceImpl(db, logger, sealer, services.LoginPolicy{
+		BcryptCost:         opts.LoginConfig.BcryptCost,
+		MaxFailedLogins:    opts.LoginConfig.MaxFailedLogins,
+		LockoutDuration:    time.Duration(opts.LoginConfig.LockoutDuration) * time.Second,
+		MaxLockoutDuration: time.Duration(opts.LoginConfig.MaxLockoutDuration) * time.Second,
+		TotpIssuer:         opts.AppName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("new account service: %w", err)
+	}
 	accountServer := api.NewAccountServiceServer(accountService)
 
-	walletService := services.NewWalletServiceImpl(db, logger, ks)
+	walletService := services.NewWalletServiceImpl(db, logger, ks, sealer, opts.WalletConfig.HDWallets)
+	if err := walletService.SealPassphrases(context.TODO()); err != nil {
+		return nil, fmt.Errorf("seal wallet passphrases: %w", err)
+	}
 	walletServer := api.NewWalletServiceServer(walletService)
 
+	keyManager, err := initKeyManager(db, logger, sealer, opts)
+	if err != nil {
+		return nil, fmt.Errorf("init key manager: %w", err)
+	}
+
+	apiKeyService := services.NewApiKeyServiceImpl(db, logger)
+	apiKeyServer := api.NewApiKeyServiceServer(apiKeyService)
+
 	authService := services.NewAuthServiceImpl(
 		logger,
+		db,
 		accountService,
+		apiKeyService,
+		keyManager,
 		opts.AppName,
-		[]byte(opts.AuthConfig.SigningKey),
 		int64(opts.AuthConfig.TokenExpirationTime),
+		int64(opts.AuthConfig.RefreshTokenExpirationTime),
 	)
-	authServer := api.NewAuthServiceServer(authService)
+	var oidcService services.OidcService
+	if opts.OidcConfig.Enabled {
+		oidcClient := services.NewOidcClient(
+			opts.OidcConfig.Issuer,
+			opts.OidcConfig.ClientID,
+			opts.OidcConfig.ClientSecret,
+			opts.OidcConfig.RedirectURL,
+			opts.OidcConfig.Scopes,
+			time.Duration(opts.OidcConfig.HTTPTimeout)*time.Second,
+		)
+		oidcService = services.NewOidcServiceImpl(db, logger, oidcClient, authService, services.OidcMapping{
+			UsernameClaim: opts.OidcConfig.UsernameClaim,
+			RoleClaim:     opts.OidcConfig.RoleClaim,
+			AdminValues:   opts.OidcConfig.AdminValues,
+			UserValues:    opts.OidcConfig.UserValues,
+			AutoProvision: opts.OidcConfig.AutoProvision,
+			LoginTimeout:  time.Duration(opts.OidcConfig.LoginTimeout) * time.Second,
+		}, opts.LoginConfig.BcryptCost)
+	}
+	authServer := api.NewAuthServiceServer(authService, oidcService)
+
+	locationService := services.NewLocationServiceImpl(db, logger, ethClient)
 
-	discoveryService := services.NewDiscoveryServiceImpl(logger, walletService, ks, brokerContract)
+	brokerRegistry := services.NewBrokerRegistryImpl(logger, brokerContract)
+	discoveryService := services.NewDiscoveryServiceImpl(
+		logger,
+		ks,
+		brokerContract,
+		brokerRegistry,
+		locationService,
+		time.Duration(opts.DiscoveryConfig.BrokerTimeout)*time.Second,
+		opts.DiscoveryConfig.MaxConcurrentBrokers,
+	)
 	discoveryServiceServer := api.NewDiscoveryServiceServer(discoveryService)
 
-	cryptoMessageService := services.NewCryptoMessageServiceImpl(logger, walletService)
+	savedSearchService := services.NewSavedSearchServiceImpl(
+		db,
+		logger,
+		productContract,
+		deviceContract,
+		sealer,
+		services.WebhookPolicy{
+			Timeout:             time.Duration(opts.SavedSearchConfig.WebhookTimeout) * time.Second,
+			Workers:             opts.SavedSearchConfig.WebhookWorkers,
+			AllowPrivateTargets: opts.SavedSearchConfig.AllowPrivateWebhookTargets,
+		},
+	)
+	savedSearchServer := api.NewSavedSearchServiceServer(savedSearchService)
+
+	cryptoMessageService := services.NewCryptoMessageServiceImpl(logger, walletService, tradingContract, ethClient)
 	cryptoMessageServiceServer := api.NewCryptoMessageServiceServer(cryptoMessageService)
 
 	userContractService := services.NewUserContractServiceImpl(logger, walletService, ks, userContract)
@@ -163,7 +254,13 @@ func New(opt ...Option) (*proxy, error) {
 		userContract,
 	)
 
-	deviceContractService := services.NewDeviceContractServiceImpl(logger, walletService, ks, deviceContract)
+	deviceContractService := services.NewDeviceContractServiceImpl(
+		logger,
+		walletService,
+		ks,
+		deviceContract,
+		locationService,
+	)
 	deviceContractProxyServer := api.NewDeviceContractServiceServer(
 		deviceContractService,
 		deviceContract,
@@ -175,7 +272,13 @@ func New(opt ...Option) (*proxy, error) {
 		productContract,
 	)
 
-	brokerContractService := services.NewBrokerContractServiceImpl(logger, walletService, ks, brokerContract)
+	brokerContractService := services.NewBrokerContractServiceImpl(
+		logger,
+		walletService,
+		ks,
+		brokerContract,
+		locationService,
+	)
 	brokerContractServer := api.NewBrokerContractServiceServer(
 		brokerContractService,
 		brokerContract,
@@ -215,20 +318,119 @@ func New(opt ...Option) (*proxy, error) {
 	api.RegisterSettlementContractServiceServer(grpcServer, settlementContractServer)
 	api.RegisterDiscoveryServiceServer(grpcServer, discoveryServiceServer)
 	api.RegisterCryptoMessageServiceServer(grpcServer, cryptoMessageServiceServer)
+	api.RegisterSavedSearchServiceServer(grpcServer, savedSearchServer)
+	api.RegisterApiKeyServiceServer(grpcServer, apiKeyServer)
 
+	ctx, cancel := context.WithCancel(context.Background())
 	p := &proxy{
-		opts:       opts,
-		db:         db,
-		logger:     logger,
-		grpcServer: grpcServer,
-		ethClient:  ethClient,
-		running:    true,
-		quit:       make(chan bool, 1),
+		opts:               opts,
+		db:                 db,
+		logger:             logger,
+		grpcServer:         grpcServer,
+		ethClient:          ethClient,
+		brokerRegistry:     brokerRegistry,
+		savedSearchService: savedSearchService,
+		keyManager:         keyManager,
+		running:            true,
+		quit:               make(chan bool, 1),
+		ctx:                ctx,
+		cancel:             cancel,
 	}
 
 	return p, nil
 }
 
+// RotateWalletMasterKey re-seals the passphrases of all wallets in the proxy database with a new
+// master key. The current master key is read from PROXY_WALLET_MASTER_KEY and the proxy must not be
+// running.
+func RotateWalletMasterKey(newMasterKey string, opt ...Option) error {
+	opts := defaultOptions()
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	if opts.ConfigFile != "" {
+		err := opts.loadConfiguration()
+		if err != nil {
+			return fmt.Errorf("load configuration: %w", err)
+		}
+	}
+	opts.loadEnvironment()
+
+	logger := initLogger(opts)
+	db, err := initDb(logger, opts)
+	if err != nil {
+		return fmt.Errorf("init db: %w", err)
+	}
+	defer db.Close()
+
+	if opts.WalletConfig.MasterKey == "" {
+		return fmt.Errorf("wallet master key missing, set %s", MasterKeyEnv)
+	}
+	sealer, err := services.NewAESPassphraseSealer(opts.WalletConfig.MasterKey)
+	if err != nil {
+		return fmt.Errorf("new passphrase sealer: %w", err)
+	}
+	newSealer, err := services.NewAESPassphraseSealer(newMasterKey)
+	if err != nil {
+		return fmt.Errorf("new passphrase sealer: %w", err)
+	}
+
+	walletService := services.NewWalletServiceImpl(db, logger, nil, sealer, false)
+	return walletService.RotateMasterKey(context.TODO(), newSealer)
+}
+
+// MigrationStatus returns the versioned migrations of the proxy database and when they were applied.
+func MigrationStatus(opt ...Option) ([]*migrations.MigrationStatus, error) {
+	migrator, db, err := newMigrator(opt...)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return migrator.Status()
+}
+
+// MigrateUp applies the pending migrations of the proxy database up to the target version, or all
+// of them if target is 0.
+func MigrateUp(target int, opt ...Option) (int, error) {
+	migrator, db, err := newMigrator(opt...)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	return migrator.Up(target)
+}
+
+// MigrateDown rolls back the given number of the most recently applied migrations.
+func MigrateDown(steps int, opt ...Option) (int, error) {
+	migrator, db, err := newMigrator(opt...)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	return migrator.Down(steps)
+}
+
+func newMigrator(opt ...Option) (*migrations.Migrator, *gorm.DB, error) {
+	opts := defaultOptions()
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	if opts.ConfigFile != "" {
+		err := opts.loadConfiguration()
+		if err != nil {
+			return nil, nil, fmt.Errorf("load configuration: %w", err)
+		}
+	}
+	opts.loadEnvironment()
+
+	logger := initLogger(opts)
+	db, err := openDb(logger, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open db: %w", err)
+	}
+	return migrations.NewMigrator(db, logger), db, nil
+}
+
 func initLogger(opts options) logrus.FieldLogger {
 	logger := &logrus.Logger{
 		Out: os.Stderr,
@@ -241,15 +443,87 @@ func initLogger(opts options) logrus.FieldLogger {
 	return logger
 }
 
+// initDb opens the database and brings its schema to the latest version. If migrations on start
+// are disabled, it only checks that the schema is up to date.
 func initDb(logger logrus.FieldLogger, opts options) (*gorm.DB, error) {
-	db, err := gorm.Open("sqlite3", opts.DatabaseConfig.Source)
+	db, err := openDb(logger, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator := migrations.NewMigrator(db, logger)
+	if opts.DatabaseConfig.MigrateOnStart {
+		if _, err := migrator.Up(0); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("migrate database: %w", err)
+		}
+		return db, nil
+	}
+
+	pending, err := migrator.Pending()
 	if err != nil {
-		return nil, fmt.Errorf("open database %s: %w", opts.DatabaseConfig.Source, err)
+		db.Close()
+		return nil, fmt.Errorf("get pending migrations: %w", err)
+	}
+	if pending > 0 {
+		db.Close()
+		return nil, fmt.Errorf("database schema is %d migrations behind, run migrate up", pending)
+	}
+	return db, nil
+}
+
+func openDb(logger logrus.FieldLogger, opts options) (*gorm.DB, error) {
+	dialect := opts.DatabaseConfig.Dialect
+	switch dialect {
+	case "sqlite3", "postgres":
+	default:
+		return nil, fmt.Errorf("unsupported database dialect %s", dialect)
+	}
+
+	source := opts.DatabaseConfig.Source
+	if dialect == "sqlite3" {
+		source = sqliteSource(source)
+	}
+	db, err := gorm.Open(dialect, source)
+	if err != nil {
+		return nil, fmt.Errorf("open %s database: %w", dialect, err)
 	}
 	db.SetLogger(logger)
-	db.Exec("PRAGMA foreign_keys = ON")
-	db.AutoMigrate(&model.Account{}, &model.Wallet{})
-	return db, err
+	return db, nil
+}
+
+// sqliteSource enables foreign keys in the data source name, so that every pooled connection
+// enforces them and not only the one that happens to run a pragma.
+func sqliteSource(source string) string {
+	if strings.Contains(source, "_foreign_keys=") || strings.Contains(source, "_fk=") {
+		return source
+	}
+	if strings.Contains(source, "?") {
+		return source + "&_foreign_keys=1"
+	}
+	return source + "?_foreign_keys=1"
+}
+
+func initKeyManager(
+	db *gorm.DB,
+	logger logrus.FieldLogger,
+	sealer services.PassphraseSealer,
+	opts options,
+) (services.KeyManager, error) {
+	if opts.AuthConfig.SigningAlgorithm == services.AlgorithmHS256 {
+		if opts.AuthConfig.SigningKey == "" {
+			return nil, fmt.Errorf("signing key of %s missing, set %s", services.AlgorithmHS256, SigningKeyEnv)
+		}
+		logger.Warnf("Signing tokens with a shared secret, proxy tokens can't be verified by other services")
+		return services.NewHMACKeyManager([]byte(opts.AuthConfig.SigningKey)), nil
+	}
+	return services.NewAsymmetricKeyManager(
+		db,
+		logger,
+		sealer,
+		opts.AuthConfig.SigningAlgorithm,
+		time.Duration(opts.AuthConfig.TokenExpirationTime)*time.Second,
+	)
 }
 
 func initGrpcServer(authService services.AuthService, logger logrus.FieldLogger) *grpc.Server {
@@ -260,14 +534,14 @@ func initGrpcServer(authService services.AuthService, logger logrus.FieldLogger)
 				entry,
 				grpc_logrus.WithLevels(grpc_logrus.DefaultCodeToLevel),
 			),
-			grpc_middleware_auth.StreamServerInterceptor(authService.AuthFunction()),
+			streamAuthorizationInterceptor(authService.AuthFunction()),
 		)),
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
 			grpc_logrus.UnaryServerInterceptor(
 				entry,
 				grpc_logrus.WithLevels(grpc_logrus.DefaultCodeToLevel),
 			),
-			grpc_middleware_auth.UnaryServerInterceptor(authService.AuthFunction()),
+			unaryAuthorizationInterceptor(authService.AuthFunction()),
 		)),
 
 	)
@@ -297,6 +571,30 @@ func (p *proxy) Run() error {
 
 	p.receiveSignals()
 
+	go p.brokerRegistry.Sync(p.ctx)
+
+	go p.savedSearchService.NotifySavedSearches(p.ctx)
+
+	go func() {
+		interval := time.Duration(p.opts.AuthConfig.KeyRotationInterval) * time.Second
+		if err := p.keyManager.RotateSigningKeys(p.ctx, interval); err != nil && err != context.Canceled {
+			p.logger.Errorf("rotate signing keys: %v", err)
+		}
+	}()
+
+	if p.opts.AuthConfig.JwksAddress != "" {
+		p.jwksServer = &http.Server{
+			Addr:    p.opts.AuthConfig.JwksAddress,
+			Handler: api.NewJwksHandler(p.keyManager, p.logger),
+		}
+		go func() {
+			p.logger.Infof("Publishing signing keys on %s", p.jwksServer.Addr)
+			if err := p.jwksServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				p.logger.Errorf("serve jwks: %v", err)
+			}
+		}()
+	}
+
 	p.running = true
 	return p.grpcServer.Serve(lis)
 }
@@ -328,7 +626,13 @@ func (p *proxy) GraceFulStop() {
 		return
 	}
 	close(p.quit)
+	p.cancel()
 	p.grpcServer.GracefulStop()
+	if p.jwksServer != nil {
+		if err := p.jwksServer.Shutdown(context.TODO()); err != nil {
+			p.logger.Errorf("shutdown jwks server: %v", err)
+		}
+	}
 	p.ethClient.Close()
 	err := p.db.Close()
 	if err != nil {
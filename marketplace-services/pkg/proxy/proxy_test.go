@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"marketplace-services/pkg/proxy/services"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	return logger
+}
+
+func TestInitKeyManagerRequiresSigningKey(t *testing.T) {
+	opts := defaultOptions()
+	opts.AuthConfig.SigningAlgorithm = services.AlgorithmHS256
+	if _, err := initKeyManager(nil, newTestLogger(), nil, opts); err == nil {
+		t.Fatal("initialized HS256 key manager without a signing key")
+	}
+
+	opts.AuthConfig.SigningKey = "secret"
+	if _, err := initKeyManager(nil, newTestLogger(), nil, opts); err != nil {
+		t.Fatalf("init key manager: %v", err)
+	}
+}
+
+func TestSqliteSource(t *testing.T) {
+	for source, want := range map[string]string{
+		"./tmp/proxy.db":                      "./tmp/proxy.db?_foreign_keys=1",
+		"file:proxy.db?cache=shared":          "file:proxy.db?cache=shared&_foreign_keys=1",
+		"file:proxy.db?_foreign_keys=0":       "file:proxy.db?_foreign_keys=0",
+		"file:proxy.db?mode=memory&_fk=false": "file:proxy.db?mode=memory&_fk=false",
+	} {
+		if got := sqliteSource(source); got != want {
+			t.Errorf("source of %s: got %s, want %s", source, got, want)
+		}
+	}
+}
+
+func TestOpenDbEnablesForeignKeysOnEveryConnection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxy")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := defaultOptions()
+	opts.DatabaseConfig.Source = filepath.Join(dir, "proxy.db")
+	db, err := openDb(newTestLogger(), opts)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		conn, err := db.DB().Conn(context.Background())
+		if err != nil {
+			t.Fatalf("open connection %d: %v", i, err)
+		}
+		defer conn.Close()
+		var enabled int
+		if err := conn.QueryRowContext(context.Background(), "PRAGMA foreign_keys").Scan(&enabled); err != nil {
+			t.Fatalf("query foreign keys of connection %d: %v", i, err)
+		}
+		if enabled != 1 {
+			t.Fatalf("foreign keys disabled on connection %d", i)
+		}
+	}
+}
@@ -2,53 +2,92 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"marketplace-services/pkg/proxy/model"
+	"time"
 )
 
 type AccountService interface {
 	CreateAccount(ctx context.Context, acc *model.Account) (*model.Account, error)
 	FindAccountByName(ctx context.Context, name string) (*model.Account, error)
-	FindAccountByNameAndPassword(ctx context.Context, name string, password []byte) (*model.Account, error)
+	Login(ctx context.Context, name string, password []byte, totpCode string) (*model.Account, error)
 	FindAccountById(ctx context.Context, id uint) (*model.Account, error)
 	FindAccounts(ctx context.Context) ([]*model.Account, error)
+	UpdateAccount(ctx context.Context, acc *model.Account) (*model.Account, error)
+	DisableAccount(ctx context.Context, id uint) error
+	EnableAccount(ctx context.Context, id uint) error
+	DeleteAccount(ctx context.Context, id uint) error
+	ChangePassword(ctx context.Context, oldPassword []byte, newPassword []byte) error
+	ResetPassword(ctx context.Context, id uint, password []byte) error
+	EnrollTotp(ctx context.Context) (*TotpEnrollment, error)
+	ConfirmTotp(ctx context.Context, code string) error
+	DisableTotp(ctx context.Context, code string) error
+	ResetTotp(ctx context.Context, id uint) error
 }
 
-type accountServiceImpl struct {
-	db     *gorm.DB
-	logger logrus.FieldLogger
+// LoginPolicy configures how passwords are hashed and how accounts are locked after repeated
+// failed logins. The lockout duration doubles with each failure beyond MaxFailedLogins.
+type LoginPolicy struct {
+	BcryptCost         int
+	MaxFailedLogins    int
+	LockoutDuration    time.Duration
+	MaxLockoutDuration time.Duration
+	TotpIssuer         string
 }
 
-func NewAccountServiceImpl(db *gorm.DB, logger logrus.FieldLogger) *accountServiceImpl {
-	return &accountServiceImpl{
-		db:     db,
-		logger: logger,
-	}
+type TotpEnrollment struct {
+	Secret string
+	URI    string
 }
 
-func (s *accountServiceImpl) CreateAccount(ctx context.Context, acc *model.Account) (*model.Account, error) {
-	account, ok := ctx.Value("principal").(model.Account)
-	if !ok {
-		return nil, fmt.Errorf("extract account from context")
-	}
+type accountServiceImpl struct {
+	db        *gorm.DB
+	logger    logrus.FieldLogger
+	sealer    PassphraseSealer
+	policy    LoginPolicy
+	dummyHash []byte
+}
 
-	if !account.HasRole(model.RoleAdmin) {
-		return nil, fmt.Errorf("role %d needed", model.RoleAdmin)
+func NewAccountServiceImpl(
+	db *gorm.DB,
+	logger logrus.FieldLogger,
+	sealer PassphraseSealer,
+	policy LoginPolicy,
+) (*accountServiceImpl, error) {
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), policy.BcryptCost)
+	if err != nil {
+		return nil, fmt.Errorf("generate dummy hash: %w", err)
 	}
+	return &accountServiceImpl{
+		db:        db,
+		logger:    logger,
+		sealer:    sealer,
+		policy:    policy,
+		dummyHash: dummyHash,
+	}, nil
+}
 
+func (s *accountServiceImpl) CreateAccount(_ context.Context, acc *model.Account) (*model.Account, error) {
 	err := acc.Validate()
 	if err != nil {
 		return nil, fmt.Errorf("validate account: %w", err)
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword(acc.Password, bcrypt.MinCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword(acc.Password, s.policy.BcryptCost)
 	if err != nil {
 		return nil, fmt.Errorf("generate hash from password: %w", err)
 	}
 	acc.Password = hashedPassword
+	acc.FailedLogins = 0
+	acc.LockedUntil = nil
+	acc.TotpSecret = ""
+	acc.TotpEnabled = false
 
 	return acc, s.db.Create(acc).Error
 }
@@ -62,17 +101,91 @@ func (s *accountServiceImpl) FindAccountByName(_ context.Context, name string) (
 	return &user, err
 }
 
-func (s *accountServiceImpl) FindAccountByNameAndPassword(ctx context.Context, name string, password []byte) (*model.Account, error) {
+// Login checks the password and, if enrolled, the TOTP code of an account. Failed logins lock the
+// account according to the login policy. Password hashes of an outdated cost are renewed.
+func (s *accountServiceImpl) Login(
+	ctx context.Context,
+	name string,
+	password []byte,
+	totpCode string,
+) (*model.Account, error) {
 	account, err := s.FindAccountByName(ctx, name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Compare anyway, so unknown names can't be told apart by the response time.
+		_ = bcrypt.CompareHashAndPassword(s.dummyHash, password)
+		return nil, status.Error(codes.Unauthenticated, "invalid username or password")
+	}
 	if err != nil {
 		return nil, fmt.Errorf("find account by name %s: %w", name, err)
 	}
 
-	err = bcrypt.CompareHashAndPassword(account.Password, password)
-	if err != nil {
-		return nil, fmt.Errorf("compare hash and password of account %s: %w", name, err)
+	now := time.Now()
+	if account.IsLocked(now) {
+		// Locked accounts fail like wrong passwords, so the lock doesn't reveal that the account exists.
+		_ = bcrypt.CompareHashAndPassword(s.dummyHash, password)
+		s.logger.Warnf("Rejecting login of account %s locked until %s", name, account.LockedUntil.Format(time.RFC3339))
+		return nil, status.Error(codes.Unauthenticated, "invalid username or password")
+	}
+
+	if err := bcrypt.CompareHashAndPassword(account.Password, password); err != nil {
+		return nil, s.failLogin(account, now, "invalid username or password")
+	}
+
+	if account.TotpEnabled {
+		if totpCode == "" {
+			return nil, status.Error(codes.Unauthenticated, "totp code required")
+		}
+		if err := s.checkTotp(account, totpCode, now); err != nil {
+			if _, ok := status.FromError(err); ok {
+				return nil, s.failLogin(account, now, "invalid totp code")
+			}
+			return nil, err
+		}
+	}
+
+	updates := map[string]interface{}{"failed_logins": 0, "locked_until": nil}
+	if cost, err := bcrypt.Cost(account.Password); err == nil && cost != s.policy.BcryptCost {
+		hashedPassword, err := bcrypt.GenerateFromPassword(password, s.policy.BcryptCost)
+		if err != nil {
+			return nil, fmt.Errorf("generate hash from password: %w", err)
+		}
+		s.logger.Infof("Rehashing password of account %s with cost %d", name, s.policy.BcryptCost)
+		updates["password"] = hashedPassword
 	}
-	return account, err
+	if err := s.db.Model(account).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("update login state of account %s: %w", name, err)
+	}
+	return account, nil
+}
+
+func (s *accountServiceImpl) failLogin(account *model.Account, now time.Time, msg string) error {
+	failedLogins := account.FailedLogins + 1
+	updates := map[string]interface{}{"failed_logins": gorm.Expr("failed_logins + 1")}
+	if s.policy.MaxFailedLogins > 0 && failedLogins >= s.policy.MaxFailedLogins {
+		lockedUntil := now.Add(s.lockoutDuration(failedLogins))
+		s.logger.Warnf(
+			"Locking account %s until %s after %d failed logins",
+			account.Name,
+			lockedUntil.Format(time.RFC3339),
+			failedLogins,
+		)
+		updates["locked_until"] = lockedUntil
+	}
+	if err := s.db.Model(&model.Account{}).Where("id = ?", account.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("register failed login of account %s: %w", account.Name, err)
+	}
+	return status.Error(codes.Unauthenticated, msg)
+}
+
+func (s *accountServiceImpl) lockoutDuration(failedLogins int) time.Duration {
+	duration := s.policy.LockoutDuration
+	for i := s.policy.MaxFailedLogins; i < failedLogins && duration < s.policy.MaxLockoutDuration; i++ {
+		duration *= 2
+	}
+	if duration > s.policy.MaxLockoutDuration {
+		duration = s.policy.MaxLockoutDuration
+	}
+	return duration
 }
 
 func (s *accountServiceImpl) FindAccountById(_ context.Context, id uint) (*model.Account, error) {
@@ -92,3 +205,257 @@ func (s *accountServiceImpl) FindAccounts(_ context.Context) ([]*model.Account,
 	}
 	return accounts, err
 }
+
+func (s *accountServiceImpl) UpdateAccount(ctx context.Context, acc *model.Account) (*model.Account, error) {
+	err := acc.ValidateUpdate()
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validate account: %s", err)
+	}
+
+	account, err := s.FindAccountById(ctx, acc.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Model(account).Updates(map[string]interface{}{"name": acc.Name, "role": acc.Role}).Error
+	if err != nil {
+		return nil, fmt.Errorf("update account %d: %w", acc.ID, err)
+	}
+
+	if err := s.revokeTokens(acc.ID); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *accountServiceImpl) DisableAccount(ctx context.Context, id uint) error {
+	if err := s.checkNotPrincipal(ctx, id); err != nil {
+		return err
+	}
+
+	account, err := s.FindAccountById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Infof("Disabling account %s", account.Name)
+	if err := s.db.Model(account).Update("disabled", true).Error; err != nil {
+		return fmt.Errorf("disable account %d: %w", id, err)
+	}
+	return s.revokeTokens(id)
+}
+
+func (s *accountServiceImpl) EnableAccount(ctx context.Context, id uint) error {
+	account, err := s.FindAccountById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Infof("Enabling account %s", account.Name)
+	updates := map[string]interface{}{"disabled": false, "failed_logins": 0, "locked_until": nil}
+	if err := s.db.Model(account).Updates(updates).Error; err != nil {
+		return fmt.Errorf("enable account %d: %w", id, err)
+	}
+	return nil
+}
+
+func (s *accountServiceImpl) DeleteAccount(ctx context.Context, id uint) error {
+	if err := s.checkNotPrincipal(ctx, id); err != nil {
+		return err
+	}
+
+	account, err := s.FindAccountById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Infof("Deleting account %s", account.Name)
+	if err := s.db.Delete(account).Error; err != nil {
+		return fmt.Errorf("delete account %d: %w", id, err)
+	}
+	return s.revokeTokens(id)
+}
+
+func (s *accountServiceImpl) ChangePassword(ctx context.Context, oldPassword []byte, newPassword []byte) error {
+	principal, ok := ctx.Value("principal").(model.Account)
+	if !ok {
+		return fmt.Errorf("extract account from context")
+	}
+
+	account, err := s.FindAccountById(ctx, principal.ID)
+	if err != nil {
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword(account.Password, oldPassword)
+	if err != nil {
+		return status.Error(codes.PermissionDenied, "old password does not match")
+	}
+
+	return s.setPassword(account, newPassword)
+}
+
+func (s *accountServiceImpl) ResetPassword(ctx context.Context, id uint, password []byte) error {
+	account, err := s.FindAccountById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.setPassword(account, password); err != nil {
+		return err
+	}
+	return s.revokeTokens(id)
+}
+
+// EnrollTotp generates a TOTP secret for the principal. It is required at login once the
+// enrollment is confirmed with a code of the authenticator.
+func (s *accountServiceImpl) EnrollTotp(ctx context.Context) (*TotpEnrollment, error) {
+	account, err := s.findPrincipalAccount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if account.TotpEnabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "totp already enabled for account %s", account.Name)
+	}
+
+	secret, err := newTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.sealer.Seal(secret)
+	if err != nil {
+		return nil, fmt.Errorf("seal totp secret: %w", err)
+	}
+
+	updates := map[string]interface{}{"totp_secret": sealed, "totp_last_counter": 0}
+	if err := s.db.Model(account).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("update totp secret of account %s: %w", account.Name, err)
+	}
+	return &TotpEnrollment{Secret: secret, URI: totpURI(s.policy.TotpIssuer, account.Name, secret)}, nil
+}
+
+func (s *accountServiceImpl) ConfirmTotp(ctx context.Context, code string) error {
+	account, err := s.findPrincipalAccount(ctx)
+	if err != nil {
+		return err
+	}
+	if account.TotpEnabled {
+		return status.Errorf(codes.FailedPrecondition, "totp already enabled for account %s", account.Name)
+	}
+	if account.TotpSecret == "" {
+		return status.Errorf(codes.FailedPrecondition, "no totp enrollment for account %s", account.Name)
+	}
+
+	if err := s.checkTotp(account, code, time.Now()); err != nil {
+		return err
+	}
+
+	s.logger.Infof("Enabling totp for account %s", account.Name)
+	if err := s.db.Model(account).Update("totp_enabled", true).Error; err != nil {
+		return fmt.Errorf("enable totp of account %s: %w", account.Name, err)
+	}
+	return nil
+}
+
+func (s *accountServiceImpl) DisableTotp(ctx context.Context, code string) error {
+	account, err := s.findPrincipalAccount(ctx)
+	if err != nil {
+		return err
+	}
+	if !account.TotpEnabled {
+		return status.Errorf(codes.FailedPrecondition, "totp not enabled for account %s", account.Name)
+	}
+
+	if err := s.checkTotp(account, code, time.Now()); err != nil {
+		return err
+	}
+	return s.clearTotp(account)
+}
+
+// ResetTotp removes the TOTP enrollment of an account that lost its authenticator.
+func (s *accountServiceImpl) ResetTotp(ctx context.Context, id uint) error {
+	account, err := s.FindAccountById(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.clearTotp(account)
+}
+
+func (s *accountServiceImpl) clearTotp(account *model.Account) error {
+	s.logger.Infof("Disabling totp for account %s", account.Name)
+	updates := map[string]interface{}{"totp_enabled": false, "totp_secret": "", "totp_last_counter": 0}
+	if err := s.db.Model(account).Updates(updates).Error; err != nil {
+		return fmt.Errorf("disable totp of account %s: %w", account.Name, err)
+	}
+	return nil
+}
+
+// checkTotp validates a TOTP code of the account and marks it as used.
+func (s *accountServiceImpl) checkTotp(account *model.Account, code string, now time.Time) error {
+	secret, err := s.sealer.Open(account.TotpSecret)
+	if err != nil {
+		return fmt.Errorf("open totp secret of account %s: %w", account.Name, err)
+	}
+
+	counter, ok := validateTotp(secret, code, now, account.TotpLastCounter)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "invalid totp code")
+	}
+
+	update := s.db.Model(&model.Account{}).
+		Where("id = ? AND totp_last_counter < ?", account.ID, counter).
+		Update("totp_last_counter", counter)
+	if update.Error != nil {
+		return fmt.Errorf("update totp counter of account %s: %w", account.Name, update.Error)
+	}
+	if update.RowsAffected != 1 {
+		return status.Error(codes.Unauthenticated, "totp code already used")
+	}
+	return nil
+}
+
+func (s *accountServiceImpl) findPrincipalAccount(ctx context.Context) (*model.Account, error) {
+	principal, ok := ctx.Value("principal").(model.Account)
+	if !ok {
+		return nil, fmt.Errorf("extract account from context")
+	}
+	return s.FindAccountById(ctx, principal.ID)
+}
+
+func (s *accountServiceImpl) setPassword(account *model.Account, password []byte) error {
+	if err := model.ValidatePassword(password); err != nil {
+		return status.Errorf(codes.InvalidArgument, "validate password: %s", err)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword(password, s.policy.BcryptCost)
+	if err != nil {
+		return fmt.Errorf("generate hash from password: %w", err)
+	}
+
+	if err := s.db.Model(account).Update("password", hashedPassword).Error; err != nil {
+		return fmt.Errorf("update password of account %d: %w", account.ID, err)
+	}
+	return nil
+}
+
+func (s *accountServiceImpl) checkNotPrincipal(ctx context.Context, id uint) error {
+	principal, ok := ctx.Value("principal").(model.Account)
+	if !ok {
+		return fmt.Errorf("extract account from context")
+	}
+	if principal.ID == id {
+		return status.Error(codes.FailedPrecondition, "cannot disable or delete own account")
+	}
+	return nil
+}
+
+func (s *accountServiceImpl) revokeTokens(id uint) error {
+	if id == 0 {
+		return status.Error(codes.InvalidArgument, "account id missing")
+	}
+	err := s.db.Model(&model.Token{}).Where("account_id = ?", id).Update("revoked", true).Error
+	if err != nil {
+		return fmt.Errorf("revoke tokens of account %d: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/proxy/model"
+	"os"
+	"testing"
+	"time"
+)
+
+func assertLoginRejected(t *testing.T, err error) {
+	t.Helper()
+	if status.Code(err) != codes.Unauthenticated || status.Convert(err).Message() != "invalid username or password" {
+		t.Fatalf("login failed with %v, want invalid username or password", err)
+	}
+}
+
+func findTestAccount(t *testing.T, accountService *accountServiceImpl, id uint) *model.Account {
+	account, err := accountService.FindAccountById(context.Background(), id)
+	if err != nil {
+		t.Fatalf("find account %d: %v", id, err)
+	}
+	return account
+}
+
+func TestLoginLocksAccount(t *testing.T) {
+	db := newTestDb(t)
+	accountService := newTestAccountService(t, db)
+	account := createTestAccount(t, db, "alice", "correct password")
+
+	for i := 0; i < 3; i++ {
+		_, err := accountService.Login(context.Background(), "alice", []byte("wrong password"), "")
+		assertLoginRejected(t, err)
+	}
+	locked := findTestAccount(t, accountService, account.ID)
+	if !locked.IsLocked(time.Now()) {
+		t.Fatal("account not locked after max failed logins")
+	}
+
+	// Locked accounts can't be told apart from wrong passwords or unknown accounts.
+	_, err := accountService.Login(context.Background(), "alice", []byte("correct password"), "")
+	assertLoginRejected(t, err)
+	_, err = accountService.Login(context.Background(), "bob", []byte("correct password"), "")
+	assertLoginRejected(t, err)
+
+	if err := db.Model(locked).Update("locked_until", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatalf("expire lock: %v", err)
+	}
+	if _, err := accountService.Login(context.Background(), "alice", []byte("correct password"), ""); err != nil {
+		t.Fatalf("login after lock expired: %v", err)
+	}
+	if unlocked := findTestAccount(t, accountService, account.ID); unlocked.FailedLogins != 0 || unlocked.LockedUntil != nil {
+		t.Fatalf("login state not reset, %d failed logins", unlocked.FailedLogins)
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	accountService := newTestAccountService(t, newTestDb(t))
+	durations := map[int]time.Duration{
+		3:  time.Minute,
+		4:  2 * time.Minute,
+		5:  4 * time.Minute,
+		9:  time.Hour,
+		30: time.Hour,
+	}
+	for failedLogins, want := range durations {
+		if duration := accountService.lockoutDuration(failedLogins); duration != want {
+			t.Errorf("lockout after %d failed logins is %s, want %s", failedLogins, duration, want)
+		}
+	}
+}
+
+func TestLoginWithTotp(t *testing.T) {
+	db := newTestDb(t)
+	accountService := newTestAccountService(t, db)
+	account := createTestAccount(t, db, "alice", "correct password")
+	ctx := context.WithValue(context.Background(), "principal", *account)
+
+	enrollment, err := accountService.EnrollTotp(ctx)
+	if err != nil {
+		t.Fatalf("enroll totp: %v", err)
+	}
+	key, err := totpEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("decode totp secret: %v", err)
+	}
+	counter := time.Now().Unix() / totpPeriod
+	if err := accountService.ConfirmTotp(ctx, totpCode(key, counter-1)); err != nil {
+		t.Fatalf("confirm totp: %v", err)
+	}
+
+	_, err = accountService.Login(context.Background(), "alice", []byte("correct password"), "")
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("login without totp code failed with %v, want %s", err, codes.Unauthenticated)
+	}
+	_, err = accountService.Login(context.Background(), "alice", []byte("correct password"), totpCode(key, counter-1))
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("login with used totp code failed with %v, want %s", err, codes.Unauthenticated)
+	}
+	if _, err := accountService.Login(context.Background(), "alice", []byte("correct password"), totpCode(key, counter)); err != nil {
+		t.Fatalf("login with totp code: %v", err)
+	}
+	_, err = accountService.Login(context.Background(), "alice", []byte("wrong password"), totpCode(key, counter+1))
+	assertLoginRejected(t, err)
+}
+
+func TestUpdateAccount(t *testing.T) {
+	authService, accountService := newTestAuthService(t)
+	account := createTestAccount(t, authService.db, "alice", "Correct-Horse-1")
+	tokens, err := authService.IssueTokens(context.Background(), account)
+	if err != nil {
+		t.Fatalf("issue tokens: %v", err)
+	}
+
+	updated := &model.Account{Name: "alice-admin", Role: model.RoleAdmin}
+	updated.ID = account.ID
+	if _, err := accountService.UpdateAccount(context.Background(), updated); err != nil {
+		t.Fatalf("update account: %v", err)
+	}
+	if found := findTestAccount(t, accountService, account.ID); found.Name != "alice-admin" || found.Role != model.RoleAdmin {
+		t.Fatalf("found account %s with role %d, want alice-admin with role %d", found.Name, found.Role, model.RoleAdmin)
+	}
+	if _, err := authService.RefreshToken(context.Background(), tokens.RefreshToken); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("refresh token after update: got %v, want Unauthenticated", err)
+	}
+
+	invalid := &model.Account{Name: "a", Role: model.RoleUser}
+	invalid.ID = account.ID
+	if _, err := accountService.UpdateAccount(context.Background(), invalid); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("update with invalid name: got %v, want InvalidArgument", err)
+	}
+}
+
+func TestDisableAccount(t *testing.T) {
+	authService, accountService := newTestAuthService(t)
+	admin := createTestAccount(t, authService.db, "admin", "Correct-Horse-1")
+	account := createTestAccount(t, authService.db, "alice", "Correct-Horse-1")
+	ctx := context.WithValue(context.Background(), "principal", *admin)
+
+	if err := accountService.DisableAccount(ctx, admin.ID); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("disable own account: got %v, want FailedPrecondition", err)
+	}
+	if err := accountService.DisableAccount(ctx, account.ID); err != nil {
+		t.Fatalf("disable account: %v", err)
+	}
+	if _, err := authService.GetToken(context.Background(), "alice", []byte("Correct-Horse-1"), ""); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("get token of disabled account: got %v, want PermissionDenied", err)
+	}
+
+	if err := accountService.EnableAccount(ctx, account.ID); err != nil {
+		t.Fatalf("enable account: %v", err)
+	}
+	if _, err := authService.GetToken(context.Background(), "alice", []byte("Correct-Horse-1"), ""); err != nil {
+		t.Fatalf("get token of enabled account: %v", err)
+	}
+}
+
+func TestDisabledAccountCannotSign(t *testing.T) {
+	db := newTestDb(t)
+	dir := newTestKeyStoreDir(t)
+	defer os.RemoveAll(dir)
+	walletService := newTestWalletService(t, db, dir)
+	accountService := newTestAccountService(t, db)
+	admin := createTestAccount(t, db, "admin", "Correct-Horse-1")
+	account := createTestAccount(t, db, "alice", "Correct-Horse-1")
+	ctx := context.WithValue(context.Background(), "principal", *account)
+
+	if _, err := walletService.CreateWallet(ctx, &model.Wallet{AccountID: account.ID}); err != nil {
+		t.Fatalf("create wallet: %v", err)
+	}
+	if _, err := walletService.FindKeyByAuthenticatedAccount(ctx); err != nil {
+		t.Fatalf("find key: %v", err)
+	}
+	if err := accountService.DisableAccount(context.WithValue(context.Background(), "principal", *admin), account.ID); err != nil {
+		t.Fatalf("disable account: %v", err)
+	}
+	if _, err := walletService.FindKeyByAuthenticatedAccount(ctx); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("find key of disabled account: got %v, want PermissionDenied", err)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	db := newTestDb(t)
+	accountService := newTestAccountService(t, db)
+	admin := createTestAccount(t, db, "admin", "Correct-Horse-1")
+	account := createTestAccount(t, db, "alice", "Correct-Horse-1")
+	ctx := context.WithValue(context.Background(), "principal", *admin)
+
+	if err := accountService.DeleteAccount(ctx, admin.ID); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("delete own account: got %v, want FailedPrecondition", err)
+	}
+	if err := accountService.DeleteAccount(ctx, account.ID); err != nil {
+		t.Fatalf("delete account: %v", err)
+	}
+	if _, err := accountService.FindAccountById(context.Background(), account.ID); err == nil {
+		t.Fatal("found deleted account")
+	}
+	_, err := accountService.Login(context.Background(), "alice", []byte("Correct-Horse-1"), "")
+	assertLoginRejected(t, err)
+
+	var deleted model.Account
+	if err := db.Unscoped().First(&deleted, account.ID).Error; err != nil || deleted.DeletedAt == nil {
+		t.Fatalf("account not soft deleted: %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	db := newTestDb(t)
+	accountService := newTestAccountService(t, db)
+	account := createTestAccount(t, db, "alice", "Correct-Horse-1")
+	ctx := context.WithValue(context.Background(), "principal", *account)
+
+	if err := accountService.ChangePassword(ctx, []byte("Wrong-Horse-1"), []byte("Battery-Staple-2")); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("change password with wrong old password: got %v, want PermissionDenied", err)
+	}
+	if err := accountService.ChangePassword(ctx, []byte("Correct-Horse-1"), []byte("short")); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("change to weak password: got %v, want InvalidArgument", err)
+	}
+	if err := accountService.ChangePassword(ctx, []byte("Correct-Horse-1"), []byte("Battery-Staple-2")); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	_, err := accountService.Login(context.Background(), "alice", []byte("Correct-Horse-1"), "")
+	assertLoginRejected(t, err)
+	if _, err := accountService.Login(context.Background(), "alice", []byte("Battery-Staple-2"), ""); err != nil {
+		t.Fatalf("login with changed password: %v", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	authService, accountService := newTestAuthService(t)
+	account := createTestAccount(t, authService.db, "alice", "Correct-Horse-1")
+	tokens, err := authService.IssueTokens(context.Background(), account)
+	if err != nil {
+		t.Fatalf("issue tokens: %v", err)
+	}
+
+	if err := accountService.ResetPassword(context.Background(), account.ID, []byte("Battery-Staple-2")); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+	if _, err := accountService.Login(context.Background(), "alice", []byte("Battery-Staple-2"), ""); err != nil {
+		t.Fatalf("login with reset password: %v", err)
+	}
+	if _, err := authService.RefreshToken(context.Background(), tokens.RefreshToken); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("refresh token after reset: got %v, want Unauthenticated", err)
+	}
+	if err := accountService.ResetPassword(context.Background(), account.ID+1, []byte("Battery-Staple-2")); err == nil {
+		t.Fatal("reset password of unknown account")
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/proxy/model"
+	"strings"
+	"time"
+)
+
+// lastUsedResolution limits how often the last use of an API key is written to the database.
+const lastUsedResolution = time.Minute
+
+type ApiKeyService interface {
+	CreateApiKey(ctx context.Context, key *model.ApiKey) (*model.ApiKey, string, error)
+	FindApiKeys(ctx context.Context, accountId uint) ([]*model.ApiKey, error)
+	RevokeApiKey(ctx context.Context, id uint) error
+	Authenticate(ctx context.Context, key string) (*model.ApiKey, error)
+}
+
+type apiKeyServiceImpl struct {
+	db     *gorm.DB
+	logger logrus.FieldLogger
+}
+
+func NewApiKeyServiceImpl(db *gorm.DB, logger logrus.FieldLogger) *apiKeyServiceImpl {
+	return &apiKeyServiceImpl{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// CreateApiKey mints an API key for the account of the given key. The returned plaintext key of the
+// form <id>.<secret> is not stored and can't be retrieved later.
+func (s *apiKeyServiceImpl) CreateApiKey(_ context.Context, key *model.ApiKey) (*model.ApiKey, string, error) {
+	key.ID = 0
+	key.Revoked = false
+	key.LastUsedAt = nil
+	key.Methods = strings.Join(strings.Fields(key.Methods), " ")
+	if err := key.Validate(); err != nil {
+		return nil, "", status.Errorf(codes.InvalidArgument, "validate api key: %s", err)
+	}
+	if key.Device != "" {
+		key.Device = common.HexToAddress(key.Device).Hex()
+	}
+
+	var account model.Account
+	if err := s.db.First(&account, key.AccountID).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, "", status.Errorf(codes.NotFound, "account %d not found", key.AccountID)
+		}
+		return nil, "", fmt.Errorf("get account %d: %w", key.AccountID, err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("generate api key secret: %w", err)
+	}
+	hash := sha256.Sum256(secret)
+	key.KeyID = uuid.New()
+	key.Hash = hash[:]
+
+	if err := s.db.Create(key).Error; err != nil {
+		return nil, "", fmt.Errorf("create api key for account %d: %w", key.AccountID, err)
+	}
+	s.logger.Infof("Created api key %s for account %s", key.KeyID, account.Name)
+	return key, key.KeyID + "." + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// FindApiKeys returns the API keys of the given account or of all accounts if accountId is 0.
+func (s *apiKeyServiceImpl) FindApiKeys(_ context.Context, accountId uint) ([]*model.ApiKey, error) {
+	var keys []*model.ApiKey
+	err := s.db.Where(&model.ApiKey{AccountID: accountId}).Find(&keys).Error
+	if err != nil {
+		return nil, fmt.Errorf("get api keys of account %d: %w", accountId, err)
+	}
+	return keys, nil
+}
+
+func (s *apiKeyServiceImpl) RevokeApiKey(_ context.Context, id uint) error {
+	var key model.ApiKey
+	err := s.db.First(&key, id).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return status.Errorf(codes.NotFound, "api key %d not found", id)
+	}
+	if err != nil {
+		return fmt.Errorf("get api key %d: %w", id, err)
+	}
+
+	s.logger.Infof("Revoking api key %s of account %d", key.KeyID, key.AccountID)
+	if err := s.db.Model(&key).Update("revoked", true).Error; err != nil {
+		return fmt.Errorf("revoke api key %d: %w", id, err)
+	}
+	return nil
+}
+
+// Authenticate resolves a plaintext API key to its stored key and records its use.
+func (s *apiKeyServiceImpl) Authenticate(_ context.Context, plaintext string) (*model.ApiKey, error) {
+	parts := strings.SplitN(plaintext, ".", 2)
+	if len(parts) != 2 {
+		return nil, status.Error(codes.Unauthenticated, "malformed api key")
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "malformed api key")
+	}
+
+	var key model.ApiKey
+	err = s.db.Where(&model.ApiKey{KeyID: parts[0]}).First(&key).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, status.Error(codes.Unauthenticated, "unknown api key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get api key %s: %w", parts[0], err)
+	}
+
+	hash := sha256.Sum256(secret)
+	if subtle.ConstantTimeCompare(hash[:], key.Hash) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "unknown api key")
+	}
+	if key.Revoked {
+		s.logger.Warnf("Revoked api key %s of account %d presented", key.KeyID, key.AccountID)
+		return nil, status.Error(codes.Unauthenticated, "api key revoked")
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedResolution {
+		if err := s.db.Model(&key).Update("last_used_at", now).Error; err != nil {
+			s.logger.Warnf("update last use of api key %s: %v", key.KeyID, err)
+		}
+	}
+	return &key, nil
+}
+
+// checkDeviceScope rejects calls authenticated with an API key that is scoped to another device.
+func checkDeviceScope(ctx context.Context, device common.Address) error {
+	key, ok := ctx.Value("apiKey").(*model.ApiKey)
+	if !ok || key.AllowsDevice(device) {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "api key is not scoped to device %s", device.Hex())
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/proxy/model"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+const testDevice = "0x00000000000000000000000000000000000000d1"
+
+type fakeWalletService struct {
+	WalletService
+	address common.Address
+}
+
+func (s fakeWalletService) FindWalletByAuthenticatedAccount(context.Context) (*model.Wallet, error) {
+	return &model.Wallet{Address: s.address.Bytes()}, nil
+}
+
+type fakeTradingContract struct {
+	contracts.TradingContract
+	trade *contracts.Trade
+}
+
+func (c fakeTradingContract) FindTradeById(*bind.CallOpts, *big.Int) (*contracts.Trade, error) {
+	return c.trade, nil
+}
+
+func TestApiKeyAuthenticate(t *testing.T) {
+	db := newTestDb(t)
+	account := createTestAccount(t, db, "device", "password")
+	s := NewApiKeyServiceImpl(db, newTestLogger())
+
+	key, plaintext, err := s.CreateApiKey(context.Background(), &model.ApiKey{AccountID: account.ID, Name: "sensor"})
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	if strings.Contains(string(key.Hash), plaintext) {
+		t.Fatal("api key stored in plaintext")
+	}
+
+	authenticated, err := s.Authenticate(context.Background(), plaintext)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if authenticated.ID != key.ID || authenticated.LastUsedAt == nil {
+		t.Fatalf("authenticated %+v, want key %d with last use", authenticated, key.ID)
+	}
+
+	for _, invalid := range []string{"", "malformed", key.KeyID + ".", key.KeyID + ".c2VjcmV0", "unknown." + strings.SplitN(plaintext, ".", 2)[1]} {
+		if _, err := s.Authenticate(context.Background(), invalid); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("authenticate %q: got %v, want Unauthenticated", invalid, err)
+		}
+	}
+}
+
+func TestRevokeApiKey(t *testing.T) {
+	db := newTestDb(t)
+	account := createTestAccount(t, db, "device", "password")
+	s := NewApiKeyServiceImpl(db, newTestLogger())
+
+	key, plaintext, err := s.CreateApiKey(context.Background(), &model.ApiKey{AccountID: account.ID, Name: "sensor"})
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	if err := s.RevokeApiKey(context.Background(), key.ID); err != nil {
+		t.Fatalf("revoke api key: %v", err)
+	}
+	if _, err := s.Authenticate(context.Background(), plaintext); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("authenticate revoked key: got %v, want Unauthenticated", err)
+	}
+	if err := s.RevokeApiKey(context.Background(), key.ID+1); status.Code(err) != codes.NotFound {
+		t.Fatalf("revoke unknown key: got %v, want NotFound", err)
+	}
+
+	keys, err := s.FindApiKeys(context.Background(), account.ID)
+	if err != nil {
+		t.Fatalf("find api keys: %v", err)
+	}
+	if len(keys) != 1 || !keys[0].Revoked {
+		t.Fatalf("got keys %+v, want one revoked key", keys)
+	}
+}
+
+func TestCreateApiKeyValidatesScope(t *testing.T) {
+	db := newTestDb(t)
+	account := createTestAccount(t, db, "device", "password")
+	s := NewApiKeyServiceImpl(db, newTestLogger())
+
+	tests := []struct {
+		name string
+		key  *model.ApiKey
+		code codes.Code
+	}{
+		{"device key", &model.ApiKey{Device: testDevice, Methods: "/proxy.TradingContractService/AcceptTradingRequest"}, codes.OK},
+		{"device key with account method", &model.ApiKey{Device: testDevice, Methods: "/proxy.WalletService/DeriveDeviceKey"}, codes.InvalidArgument},
+		{"device key changing brokers", &model.ApiKey{Device: testDevice, Methods: "/proxy.BrokerContractService/CreateBroker"}, codes.InvalidArgument},
+		{"invalid device", &model.ApiKey{Device: "device"}, codes.InvalidArgument},
+		{"invalid method", &model.ApiKey{Methods: "SearchProducts"}, codes.InvalidArgument},
+		{"unknown account", &model.ApiKey{AccountID: account.ID + 1}, codes.NotFound},
+	}
+	for _, test := range tests {
+		test.key.Name = test.name
+		if test.key.AccountID == 0 {
+			test.key.AccountID = account.ID
+		}
+		if _, _, err := s.CreateApiKey(context.Background(), test.key); status.Code(err) != test.code {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.code)
+		}
+	}
+}
+
+func TestApiKeyAllowsMethod(t *testing.T) {
+	deviceKey := model.ApiKey{Device: testDevice}
+	for method, allowed := range map[string]bool{
+		"/proxy.ProductContractService/UpdateProduct":           true,
+		"/proxy.TradingContractService/AcceptTradingRequest":    true,
+		"/proxy.SettlementContractService/SettleTrade":          true,
+		"/proxy.CryptoMessageService/EncryptAndPublishMessages": true,
+		"/proxy.BrokerContractService/FindBrokerByAddress":      true,
+		"/proxy.BrokerContractService/RemoveBroker":             false,
+		"/proxy.ApiKeyService/CreateApiKey":                     false,
+	} {
+		if deviceKey.AllowsMethod(method) != allowed {
+			t.Errorf("device key allows %s: %t, want %t", method, !allowed, allowed)
+		}
+	}
+
+	methodKey := model.ApiKey{Methods: "/proxy.DiscoveryService/SearchProducts"}
+	if !methodKey.AllowsMethod("/proxy.DiscoveryService/SearchProducts") || methodKey.AllowsMethod("/proxy.DiscoveryService/SearchBroker") {
+		t.Error("method key allows other methods than its own")
+	}
+}
+
+func TestDeviceScopeOfTransactions(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "apiKey", &model.ApiKey{Device: testDevice})
+	other := common.HexToAddress("0x00000000000000000000000000000000000000d2")
+
+	settlementService := NewSettlementContractServiceImpl(newTestLogger(), fakeWalletService{address: other}, nil, nil)
+	if _, err := settlementService.SettleTrade(ctx, big.NewInt(1)); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("settle trade of other device: got %v, want PermissionDenied", err)
+	}
+
+	tradingService := NewTradingContractServiceImpl(newTestLogger(), fakeWalletService{address: other}, nil, nil)
+	if _, err := tradingService.AcceptTradingRequest(ctx, big.NewInt(1)); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("accept trading request of other device: got %v, want PermissionDenied", err)
+	}
+}
+
+func TestCheckTradeScope(t *testing.T) {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	key := &keystore.Key{Address: crypto.PubkeyToAddress(privateKey.PublicKey), PrivateKey: privateKey}
+	other := common.HexToAddress("0x00000000000000000000000000000000000000d2")
+	deviceKey := &model.ApiKey{Device: key.Address.Hex()}
+
+	tests := []struct {
+		name   string
+		apiKey *model.ApiKey
+		trade  *contracts.Trade
+		code   codes.Code
+	}{
+		{"no api key", nil, nil, codes.OK},
+		{"provider", deviceKey, &contracts.Trade{Provider: key.Address, Consumer: other}, codes.OK},
+		{"consumer", deviceKey, &contracts.Trade{Provider: other, Consumer: key.Address}, codes.OK},
+		{"other trade", deviceKey, &contracts.Trade{Provider: other, Consumer: other}, codes.PermissionDenied},
+		{"other device", &model.ApiKey{Device: other.Hex()}, nil, codes.PermissionDenied},
+	}
+	for _, test := range tests {
+		ctx := context.Background()
+		if test.apiKey != nil {
+			ctx = context.WithValue(ctx, "apiKey", test.apiKey)
+		}
+		s := NewCryptoMessageServiceImpl(newTestLogger(), nil, fakeTradingContract{trade: test.trade}, nil)
+		if err := s.checkTradeScope(ctx, key, 1); status.Code(err) != test.code {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.code)
+		}
+	}
+}
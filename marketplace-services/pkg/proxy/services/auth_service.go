@@ -2,6 +2,10 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
@@ -11,11 +15,18 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"marketplace-services/pkg/proxy/model"
+	"strings"
 	"time"
 )
 
 type AuthService interface {
-	GetToken(ctx context.Context, username string, password []byte) (string, error)
+	GetToken(ctx context.Context, username string, password []byte, totpCode string) (*Tokens, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error)
+	IssueTokens(ctx context.Context, account *model.Account) (*Tokens, error)
+	Logout(ctx context.Context, refreshToken string) error
+	RevokeAccountTokens(ctx context.Context, accountId uint) error
+	RotateSigningKey(ctx context.Context) (string, error)
+	Jwks(ctx context.Context) ([]*Jwk, error)
 	GenerateToken(user *model.Account) (string, error)
 	ParseToken(token string) (*CustomClaims, error)
 	AuthFunction() func(ctx context.Context) (context.Context, error)
@@ -27,46 +38,203 @@ type CustomClaims struct {
 	UserRole model.Role `json:"role,omitempty"`
 }
 
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    int64
+}
+
 type authServiceImpl struct {
-	logger         logrus.FieldLogger
-	userService    AccountService
-	appName        string
-	signingKey     []byte
-	expirationTime int64
+	logger                logrus.FieldLogger
+	db                    *gorm.DB
+	userService           AccountService
+	apiKeyService         ApiKeyService
+	keyManager            KeyManager
+	appName               string
+	expirationTime        int64
+	refreshExpirationTime int64
 }
 
 func NewAuthServiceImpl(
 	logger logrus.FieldLogger,
+	db *gorm.DB,
 	userService AccountService,
+	apiKeyService ApiKeyService,
+	keyManager KeyManager,
 	appName string,
-	signingKey []byte,
 	expirationTime int64,
+	refreshExpirationTime int64,
 ) *authServiceImpl {
 	return &authServiceImpl{
-		logger:         logger,
-		userService:    userService,
-		appName:        appName,
-		signingKey:     signingKey,
-		expirationTime: expirationTime,
+		logger:                logger,
+		db:                    db,
+		userService:           userService,
+		apiKeyService:         apiKeyService,
+		keyManager:            keyManager,
+		appName:               appName,
+		expirationTime:        expirationTime,
+		refreshExpirationTime: refreshExpirationTime,
+	}
+}
+
+func (s *authServiceImpl) GetToken(
+	ctx context.Context,
+	username string,
+	password []byte,
+	totpCode string,
+) (*Tokens, error) {
+	u, err := s.userService.Login(ctx, username, password, totpCode)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("get token: %w", err)
+	}
+	if u.Disabled {
+		return nil, status.Errorf(codes.PermissionDenied, "account %s is disabled", username)
+	}
+	tokens, err := s.generateTokens(u)
+	if err != nil {
+		return nil, fmt.Errorf("get token: %w", err)
+	}
+	return tokens, nil
+}
+
+// IssueTokens issues an access and refresh token for an account authenticated by other means than
+// its password.
+func (s *authServiceImpl) IssueTokens(_ context.Context, account *model.Account) (*Tokens, error) {
+	if account.Disabled {
+		return nil, status.Errorf(codes.PermissionDenied, "account %s is disabled", account.Name)
+	}
+	tokens, err := s.generateTokens(account)
+	if err != nil {
+		return nil, fmt.Errorf("issue tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access and refresh token. The presented refresh
+// token is revoked, so each refresh token can only be used once.
+func (s *authServiceImpl) RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error) {
+	token, err := s.findRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if token.Revoked {
+		s.logger.Warnf("Revoked refresh token %s of account %d presented", token.TokenID, token.AccountID)
+		return nil, status.Error(codes.Unauthenticated, "refresh token revoked")
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, status.Error(codes.Unauthenticated, "refresh token expired")
+	}
+
+	account, err := s.userService.FindAccountById(ctx, token.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("find account by id %d: %w", token.AccountID, err)
+	}
+	if account.Disabled {
+		return nil, status.Errorf(codes.PermissionDenied, "account %s is disabled", account.Name)
+	}
+
+	// Only the refresh that revokes the token wins if the same token is presented concurrently.
+	update := s.db.Model(&model.Token{}).
+		Where("id = ? AND revoked = ?", token.ID, false).
+		Update("revoked", true)
+	if update.Error != nil {
+		return nil, fmt.Errorf("revoke refresh token %s: %w", token.TokenID, update.Error)
 	}
+	if update.RowsAffected != 1 {
+		s.logger.Warnf("Revoked refresh token %s of account %d presented", token.TokenID, token.AccountID)
+		return nil, status.Error(codes.Unauthenticated, "refresh token revoked")
+	}
+
+	tokens, err := s.generateTokens(account)
+	if err != nil {
+		return nil, fmt.Errorf("refresh token: %w", err)
+	}
+	return tokens, nil
 }
 
-func (s *authServiceImpl) GetToken(ctx context.Context, username string, password []byte) (string, error) {
-	u, err := s.userService.FindAccountByNameAndPassword(ctx, username, password)
+// Logout revokes the access token of the call and the given refresh token of the principal.
+func (s *authServiceImpl) Logout(ctx context.Context, refreshToken string) error {
+	account, ok := ctx.Value("principal").(model.Account)
+	if !ok {
+		return fmt.Errorf("extract account from context")
+	}
+
+	if tokenId, ok := ctx.Value("tokenId").(string); ok && tokenId != "" {
+		err := s.db.Model(&model.Token{}).Where("token_id = ?", tokenId).Update("revoked", true).Error
+		if err != nil {
+			return fmt.Errorf("revoke access token %s: %w", tokenId, err)
+		}
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+	token, err := s.findRefreshToken(refreshToken)
 	if err != nil {
-		return "", fmt.Errorf("get token: %w", err)
+		return err
+	}
+	if token.AccountID != account.ID {
+		return status.Error(codes.PermissionDenied, "refresh token belongs to another account")
+	}
+	if err := s.db.Model(token).Update("revoked", true).Error; err != nil {
+		return fmt.Errorf("revoke refresh token %s: %w", token.TokenID, err)
+	}
+	return nil
+}
+
+func (s *authServiceImpl) RevokeAccountTokens(_ context.Context, accountId uint) error {
+	if accountId == 0 {
+		return status.Error(codes.InvalidArgument, "account id missing")
 	}
-	token, err := s.GenerateToken(u)
+	s.logger.Infof("Revoking all tokens of account %d", accountId)
+	err := s.db.Model(&model.Token{}).Where("account_id = ?", accountId).Update("revoked", true).Error
 	if err != nil {
-		return "", fmt.Errorf("get token: %w", err)
+		return fmt.Errorf("revoke tokens of account %d: %w", accountId, err)
 	}
-	return token, nil
+	return nil
+}
+
+func (s *authServiceImpl) RotateSigningKey(ctx context.Context) (string, error) {
+	return s.keyManager.RotateSigningKey(ctx)
+}
+
+// Jwks returns the public keys verifying the tokens issued by the proxy.
+func (s *authServiceImpl) Jwks(ctx context.Context) ([]*Jwk, error) {
+	return s.keyManager.Jwks(ctx)
 }
 
 func (s *authServiceImpl) GenerateToken(user *model.Account) (string, error) {
-	s.logger.Infof("Generating token for user %s", user.Name)
+	tokenString, _, err := s.generateAccessToken(user, time.Now())
+	return tokenString, err
+}
 
+func (s *authServiceImpl) generateTokens(user *model.Account) (*Tokens, error) {
 	now := time.Now()
+
+	err := s.db.Unscoped().Where("expires_at < ?", now).Delete(&model.Token{}).Error
+	if err != nil {
+		return nil, fmt.Errorf("delete expired tokens: %w", err)
+	}
+
+	accessToken, expiresAt, err := s.generateAccessToken(user, now)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := s.generateRefreshToken(user, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresAt: expiresAt}, nil
+}
+
+func (s *authServiceImpl) generateAccessToken(user *model.Account, now time.Time) (string, int64, error) {
+	s.logger.Infof("Generating token for user %s", user.Name)
+
 	customClaims := &CustomClaims{
 		StandardClaims: jwt.StandardClaims{
 			Audience:  "",
@@ -81,21 +249,94 @@ func (s *authServiceImpl) GenerateToken(user *model.Account) (string, error) {
 		UserRole: user.Role,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, customClaims)
+	kid, method, key, err := s.keyManager.SigningKey()
+	if err != nil {
+		return "", 0, fmt.Errorf("get signing key: %w", err)
+	}
+	token := jwt.NewWithClaims(method, customClaims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
 
-	tokenString, err := token.SignedString(s.signingKey)
+	tokenString, err := token.SignedString(key)
 	if err != nil {
-		return "", fmt.Errorf("get token for user %s: %w", user.Name, err)
+		return "", 0, fmt.Errorf("get token for user %s: %w", user.Name, err)
+	}
+
+	err = s.db.Create(&model.Token{
+		TokenID:   customClaims.Id,
+		AccountID: user.ID,
+		Type:      model.TokenTypeAccess,
+		ExpiresAt: time.Unix(customClaims.ExpiresAt, 0),
+	}).Error
+	if err != nil {
+		return "", 0, fmt.Errorf("create access token for user %s: %w", user.Name, err)
+	}
+	return tokenString, customClaims.ExpiresAt, nil
+}
+
+// generateRefreshToken creates an opaque refresh token of the form <id>.<secret>. Only a hash of
+// the secret is stored.
+func (s *authServiceImpl) generateRefreshToken(user *model.Account, now time.Time) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("generate refresh token secret: %w", err)
+	}
+	hash := sha256.Sum256(secret)
+
+	tokenId := uuid.New()
+	err := s.db.Create(&model.Token{
+		TokenID:   tokenId,
+		AccountID: user.ID,
+		Type:      model.TokenTypeRefresh,
+		Hash:      hash[:],
+		ExpiresAt: now.Add(time.Duration(s.refreshExpirationTime) * time.Second),
+	}).Error
+	if err != nil {
+		return "", fmt.Errorf("create refresh token for user %s: %w", user.Name, err)
+	}
+	return tokenId + "." + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func (s *authServiceImpl) findRefreshToken(refreshToken string) (*model.Token, error) {
+	parts := strings.SplitN(refreshToken, ".", 2)
+	if len(parts) != 2 {
+		return nil, status.Error(codes.Unauthenticated, "malformed refresh token")
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "malformed refresh token")
+	}
+
+	var token model.Token
+	err = s.db.Where(&model.Token{TokenID: parts[0], Type: model.TokenTypeRefresh}).First(&token).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, status.Error(codes.Unauthenticated, "unknown refresh token")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get refresh token %s: %w", parts[0], err)
 	}
-	return tokenString, err
 
+	hash := sha256.Sum256(secret)
+	if subtle.ConstantTimeCompare(hash[:], token.Hash) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "unknown refresh token")
+	}
+	return &token, nil
+}
+
+func (s *authServiceImpl) isRevoked(tokenId string) (bool, error) {
+	var count int
+	err := s.db.Model(&model.Token{}).Where(&model.Token{TokenID: tokenId, Revoked: true}).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("count revoked tokens with id %s: %w", tokenId, err)
+	}
+	return count > 0, nil
 }
+
 func (s *authServiceImpl) ParseToken(tokenString string) (*CustomClaims, error) {
 	s.logger.Debugf("Parsing token %s", tokenString)
 
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return s.signingKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, s.keyManager.VerificationKey)
 
 	if err != nil {
 		return nil, fmt.Errorf("parse token: %w", err)
@@ -110,6 +351,10 @@ func (s *authServiceImpl) ParseToken(tokenString string) (*CustomClaims, error)
 
 func (s *authServiceImpl) AuthFunction() func(ctx context.Context) (context.Context, error) {
 	return func(ctx context.Context) (context.Context, error) {
+		if key, err := grpc_auth.AuthFromMD(ctx, "apikey"); err == nil {
+			return s.authenticateApiKey(ctx, key)
+		}
+
 		token, err := grpc_auth.AuthFromMD(ctx, "bearer")
 		if err != nil {
 			return nil, err
@@ -117,7 +362,15 @@ func (s *authServiceImpl) AuthFunction() func(ctx context.Context) (context.Cont
 
 		claims, err := s.ParseToken(token)
 		if err != nil {
-			return nil, status.Errorf(codes.Unauthenticated, "authentication failure: %w", err)
+			return nil, status.Errorf(codes.Unauthenticated, "authentication failure: %s", err)
+		}
+
+		revoked, err := s.isRevoked(claims.Id)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "check token revocation: %s", err)
+		}
+		if revoked {
+			return nil, status.Error(codes.Unauthenticated, "authentication failure: token revoked")
 		}
 
 		u := model.Account{Model: gorm.Model{
@@ -126,6 +379,40 @@ func (s *authServiceImpl) AuthFunction() func(ctx context.Context) (context.Cont
 			Name: claims.Subject,
 			Role: claims.UserRole,
 		}
+		ctx = context.WithValue(ctx, "tokenId", claims.Id)
 		return context.WithValue(ctx, "principal", u), nil
 	}
 }
+
+// authenticateApiKey sets the account of an API key as principal of the call. The key itself is
+// added to the context, so its method and device scopes can be enforced.
+func (s *authServiceImpl) authenticateApiKey(ctx context.Context, plaintext string) (context.Context, error) {
+	key, err := s.apiKeyService.Authenticate(ctx, plaintext)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Internal, "authenticate api key: %s", err)
+	}
+
+	var account model.Account
+	err = s.db.First(&account, key.AccountID).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, status.Error(codes.Unauthenticated, "authentication failure: account of api key deleted")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "get account %d: %s", key.AccountID, err)
+	}
+	if account.Disabled {
+		return nil, status.Errorf(codes.PermissionDenied, "account %s is disabled", account.Name)
+	}
+
+	u := model.Account{Model: gorm.Model{
+		ID: account.ID,
+	},
+		Name: account.Name,
+		Role: account.Role,
+	}
+	ctx = context.WithValue(ctx, "apiKey", key)
+	return context.WithValue(ctx, "principal", u), nil
+}
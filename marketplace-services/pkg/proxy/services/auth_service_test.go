@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/proxy/model"
+	"sync"
+	"testing"
+)
+
+// barrierAccountService holds every account lookup until the expected number of lookups arrived,
+// so concurrent refreshes all pass the revocation check before any of them revokes the token.
+type barrierAccountService struct {
+	AccountService
+	arrived sync.WaitGroup
+}
+
+func (s *barrierAccountService) FindAccountById(ctx context.Context, id uint) (*model.Account, error) {
+	s.arrived.Done()
+	s.arrived.Wait()
+	return s.AccountService.FindAccountById(ctx, id)
+}
+
+func newTestAuthService(t *testing.T) (*authServiceImpl, *accountServiceImpl) {
+	db := newTestDb(t)
+	accountService := newTestAccountService(t, db)
+	keyManager := NewHMACKeyManager([]byte("test signing key"))
+	authService := NewAuthServiceImpl(newTestLogger(), db, accountService, nil, keyManager, "test", 60, 3600)
+	return authService, accountService
+}
+
+func TestRefreshTokenIsSingleUse(t *testing.T) {
+	authService, _ := newTestAuthService(t)
+	defer authService.db.Close()
+	account := createTestAccount(t, authService.db, "alice", "Correct-Horse-1")
+
+	tokens, err := authService.IssueTokens(context.Background(), account)
+	if err != nil {
+		t.Fatalf("issue tokens: %v", err)
+	}
+
+	refreshed, err := authService.RefreshToken(context.Background(), tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh token: %v", err)
+	}
+	if refreshed.RefreshToken == tokens.RefreshToken {
+		t.Fatal("refresh returned the presented refresh token")
+	}
+
+	_, err = authService.RefreshToken(context.Background(), tokens.RefreshToken)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("second refresh: got %v, want %s", err, codes.Unauthenticated)
+	}
+	if _, err := authService.RefreshToken(context.Background(), refreshed.RefreshToken); err != nil {
+		t.Fatalf("refresh with new token: %v", err)
+	}
+}
+
+func TestRefreshTokenConcurrently(t *testing.T) {
+	authService, _ := newTestAuthService(t)
+	defer authService.db.Close()
+	account := createTestAccount(t, authService.db, "alice", "Correct-Horse-1")
+
+	tokens, err := authService.IssueTokens(context.Background(), account)
+	if err != nil {
+		t.Fatalf("issue tokens: %v", err)
+	}
+
+	const refreshes = 8
+	barrier := &barrierAccountService{AccountService: authService.userService}
+	barrier.arrived.Add(refreshes)
+	authService.userService = barrier
+
+	var wg sync.WaitGroup
+	errs := make(chan error, refreshes)
+	for i := 0; i < refreshes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := authService.RefreshToken(context.Background(), tokens.RefreshToken)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch status.Code(err) {
+		case codes.OK:
+			succeeded++
+		case codes.Unauthenticated:
+		default:
+			t.Errorf("refresh token: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d concurrent refreshes succeeded, want 1", succeeded)
+	}
+}
+
+func TestRefreshTokenRejectsMalformedAndUnknownTokens(t *testing.T) {
+	authService, _ := newTestAuthService(t)
+	defer authService.db.Close()
+
+	for _, refreshToken := range []string{"", "no-separator", "id.%%%", "unknown.c2VjcmV0"} {
+		_, err := authService.RefreshToken(context.Background(), refreshToken)
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("refresh token %q: got %v, want %s", refreshToken, err, codes.Unauthenticated)
+		}
+	}
+}
+
+func TestRevokeAccountTokens(t *testing.T) {
+	authService, _ := newTestAuthService(t)
+	defer authService.db.Close()
+	alice := createTestAccount(t, authService.db, "alice", "Correct-Horse-1")
+	bob := createTestAccount(t, authService.db, "bob", "Correct-Horse-1")
+	aliceTokens, err := authService.IssueTokens(context.Background(), alice)
+	if err != nil {
+		t.Fatalf("issue tokens: %v", err)
+	}
+	bobTokens, err := authService.IssueTokens(context.Background(), bob)
+	if err != nil {
+		t.Fatalf("issue tokens: %v", err)
+	}
+
+	if err := authService.RevokeAccountTokens(context.Background(), 0); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("revoked tokens of account 0 with %v, want %s", err, codes.InvalidArgument)
+	}
+	if err := authService.RevokeAccountTokens(context.Background(), alice.ID); err != nil {
+		t.Fatalf("revoke tokens: %v", err)
+	}
+
+	if _, err := authService.RefreshToken(context.Background(), aliceTokens.RefreshToken); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("refreshed revoked token with %v, want %s", err, codes.Unauthenticated)
+	}
+	if _, err := authService.RefreshToken(context.Background(), bobTokens.RefreshToken); err != nil {
+		t.Fatalf("token of other account revoked: %v", err)
+	}
+}
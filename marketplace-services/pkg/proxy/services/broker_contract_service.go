@@ -25,10 +25,11 @@ type BrokerContractService interface {
 }
 
 type brokerContractServiceImpl struct {
-	logger         logrus.FieldLogger
-	keyStore       *keystore.KeyStore
-	walletService  WalletService
-	brokerContract contracts.BrokerContract
+	logger          logrus.FieldLogger
+	keyStore        *keystore.KeyStore
+	walletService   WalletService
+	brokerContract  contracts.BrokerContract
+	locationService LocationService
 }
 
 func NewBrokerContractServiceImpl(
@@ -36,12 +37,14 @@ func NewBrokerContractServiceImpl(
 	walletService WalletService,
 	keyStore *keystore.KeyStore,
 	brokerContract contracts.BrokerContract,
+	locationService LocationService,
 ) *brokerContractServiceImpl {
 	return &brokerContractServiceImpl{
-		logger:         logger,
-		walletService:  walletService,
-		keyStore:       keyStore,
-		brokerContract: brokerContract,
+		logger:          logger,
+		walletService:   walletService,
+		keyStore:        keyStore,
+		brokerContract:  brokerContract,
+		locationService: locationService,
 	}
 }
 
@@ -49,6 +52,12 @@ func (s brokerContractServiceImpl) CreateBroker(
 	ctx context.Context,
 	broker *contracts.Broker,
 ) (*types.Transaction, error) {
+	if broker.GeoLocation != nil {
+		if err := applyGeoLocation(broker); err != nil {
+			return nil, err
+		}
+	}
+
 	w, err := s.walletService.FindWalletByAuthenticatedAccount(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("find wallet of authenticated proxy account: %w", err)
@@ -72,13 +81,27 @@ func (s brokerContractServiceImpl) CreateBroker(
 		}
 	}()
 
-	return s.brokerContract.CreateBroker(transactOpts, broker)
+	tx, err := s.brokerContract.CreateBroker(transactOpts, broker)
+	if err != nil {
+		return nil, err
+	}
+
+	if broker.GeoLocation != nil {
+		s.locationService.SaveGeoLocationOnReceipt(tx, broker.Addr, broker.GeoLocation)
+	}
+	return tx, nil
 }
 
 func (s brokerContractServiceImpl) UpdateBroker(
 	ctx context.Context,
 	broker *contracts.Broker,
 ) (*types.Transaction, error) {
+	if broker.GeoLocation != nil {
+		if err := applyGeoLocation(broker); err != nil {
+			return nil, err
+		}
+	}
+
 	w, err := s.walletService.FindWalletByAuthenticatedAccount(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("find wallet of authenticated proxy account: %w", err)
@@ -102,7 +125,15 @@ func (s brokerContractServiceImpl) UpdateBroker(
 		}
 	}()
 
-	return s.brokerContract.UpdateBroker(transactOpts, broker)
+	tx, err := s.brokerContract.UpdateBroker(transactOpts, broker)
+	if err != nil {
+		return nil, err
+	}
+
+	if broker.GeoLocation != nil {
+		s.locationService.SaveGeoLocationOnReceipt(tx, broker.Addr, broker.GeoLocation)
+	}
+	return tx, nil
 }
 
 func (s brokerContractServiceImpl) RemoveBroker(
@@ -141,7 +172,14 @@ func (s brokerContractServiceImpl) FindBrokerByIndex(ctx context.Context, index
 		return nil, fmt.Errorf("find wallet of authenticated proxy account: %w", err)
 	}
 	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(w.Address)}
-	return s.brokerContract.FindBrokerByIndex(callOpts, index)
+	broker, err := s.brokerContract.FindBrokerByIndex(callOpts, index)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.locationService.ResolveBroker(broker); err != nil {
+		return nil, err
+	}
+	return broker, nil
 }
 
 func (s brokerContractServiceImpl) FindBrokerByAddress(
@@ -153,7 +191,14 @@ func (s brokerContractServiceImpl) FindBrokerByAddress(
 		return nil, fmt.Errorf("find wallet of authenticated proxy account: %w", err)
 	}
 	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(w.Address)}
-	return s.brokerContract.FindBrokerByAddress(callOpts, address)
+	broker, err := s.brokerContract.FindBrokerByAddress(callOpts, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.locationService.ResolveBroker(broker); err != nil {
+		return nil, err
+	}
+	return broker, nil
 }
 
 func (s brokerContractServiceImpl) CountBrokers(ctx context.Context) (*big.Int, error) {
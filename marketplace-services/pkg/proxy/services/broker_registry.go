@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/sirupsen/logrus"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/contracts/bindings"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+type BrokerRegistry interface {
+	Sync(ctx context.Context)
+	Brokers() ([]*contracts.Broker, bool)
+}
+
+type brokerRegistryImpl struct {
+	logger         logrus.FieldLogger
+	brokerContract contracts.BrokerContract
+	brokers        map[common.Address]*contracts.Broker
+	ready          bool
+	sync.RWMutex
+}
+
+func NewBrokerRegistryImpl(
+	logger logrus.FieldLogger,
+	brokerContract contracts.BrokerContract,
+) *brokerRegistryImpl {
+	return &brokerRegistryImpl{
+		logger:         logger,
+		brokerContract: brokerContract,
+		brokers:        make(map[common.Address]*contracts.Broker),
+	}
+}
+
+// Sync loads all brokers from the broker contract and keeps the registry current with the broker
+// events until the context is cancelled. The brokers are loaded again if the subscriptions fail.
+func (r *brokerRegistryImpl) Sync(ctx context.Context) {
+	eventSync := &contracts.EventSync{
+		Name:   "broker registry",
+		Logger: r.logger,
+		Watches: []contracts.EventWatch{
+			func(opts *bind.WatchOpts) (interface{}, event.Subscription, error) {
+				sink := make(chan *bindings.BrokerContractCreatedBroker)
+				sub, err := r.brokerContract.WatchCreatedBrokerEvent(opts, sink, nil)
+				return sink, sub, err
+			},
+			func(opts *bind.WatchOpts) (interface{}, event.Subscription, error) {
+				sink := make(chan *bindings.BrokerContractUpdatedBroker)
+				sub, err := r.brokerContract.WatchUpdatedBrokerEvent(opts, sink, nil)
+				return sink, sub, err
+			},
+			func(opts *bind.WatchOpts) (interface{}, event.Subscription, error) {
+				sink := make(chan *bindings.BrokerContractRemovedBroker)
+				sub, err := r.brokerContract.WatchRemovedBrokerEvent(opts, sink, nil)
+				return sink, sub, err
+			},
+		},
+		Load:       r.load,
+		Apply:      r.apply,
+		Invalidate: r.invalidate,
+	}
+	eventSync.Run(ctx)
+}
+
+// Brokers returns copies of the registered brokers ordered by address. The second return value
+// is false if the registry has not been loaded yet.
+func (r *brokerRegistryImpl) Brokers() ([]*contracts.Broker, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	if !r.ready {
+		return nil, false
+	}
+
+	brokers := make([]*contracts.Broker, 0, len(r.brokers))
+	for _, broker := range r.brokers {
+		b := *broker
+		brokers = append(brokers, &b)
+	}
+	sort.Slice(brokers, func(i, j int) bool {
+		return brokers[i].Addr.Hex() < brokers[j].Addr.Hex()
+	})
+	return brokers, true
+}
+
+func (r *brokerRegistryImpl) load(ctx context.Context) error {
+	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(nil)}
+	count, err := r.brokerContract.CountBrokers(callOpts)
+	if err != nil {
+		return fmt.Errorf("count brokers: %w", err)
+	}
+
+	brokers := make(map[common.Address]*contracts.Broker)
+	for i := uint64(0); i < count.Uint64(); i++ {
+		broker, err := r.brokerContract.FindBrokerByIndex(callOpts, new(big.Int).SetUint64(i))
+		if err != nil {
+			return fmt.Errorf("find broker by index %d: %w", i, err)
+		}
+		if broker.Deleted {
+			continue
+		}
+		brokers[broker.Addr] = broker
+	}
+
+	r.Lock()
+	r.brokers = brokers
+	r.ready = true
+	r.Unlock()
+
+	r.logger.Infof("Registered %d of %d brokers", len(brokers), count.Uint64())
+	return nil
+}
+
+func (r *brokerRegistryImpl) apply(ctx context.Context, e interface{}) error {
+	switch e := e.(type) {
+	case *bindings.BrokerContractCreatedBroker:
+		return r.refresh(ctx, e.Addr)
+	case *bindings.BrokerContractUpdatedBroker:
+		return r.refresh(ctx, e.Addr)
+	case *bindings.BrokerContractRemovedBroker:
+		r.logger.Debugf("Removing broker %s from registry", e.Addr.Hex())
+		r.Lock()
+		delete(r.brokers, e.Addr)
+		r.Unlock()
+	}
+	return nil
+}
+
+// invalidate marks the registry as not ready until it was loaded again, because events may have
+// been missed while the subscriptions were down.
+func (r *brokerRegistryImpl) invalidate() {
+	r.Lock()
+	r.ready = false
+	r.Unlock()
+}
+
+func (r *brokerRegistryImpl) refresh(ctx context.Context, addr common.Address) error {
+	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(nil)}
+	broker, err := r.brokerContract.FindBrokerByAddress(callOpts, addr)
+	if err != nil {
+		return fmt.Errorf("find broker by address %s: %w", addr.Hex(), err)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if broker.Deleted {
+		delete(r.brokers, addr)
+		return nil
+	}
+	r.logger.Debugf("Registering broker %s", addr.Hex())
+	r.brokers[addr] = broker
+	return nil
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/contracts/bindings"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type fakeBrokerContract struct {
+	contracts.BrokerContract
+	brokers []*contracts.Broker
+}
+
+func (c *fakeBrokerContract) CountBrokers(*bind.CallOpts) (*big.Int, error) {
+	return big.NewInt(int64(len(c.brokers))), nil
+}
+
+func (c *fakeBrokerContract) FindBrokerByIndex(opts *bind.CallOpts, index *big.Int) (*contracts.Broker, error) {
+	return c.brokers[index.Uint64()], nil
+}
+
+func (c *fakeBrokerContract) FindBrokerByAddress(opts *bind.CallOpts, addr common.Address) (*contracts.Broker, error) {
+	for _, broker := range c.brokers {
+		if broker.Addr == addr {
+			return broker, nil
+		}
+	}
+	return &contracts.Broker{Addr: addr, Deleted: true}, nil
+}
+
+func newTestRegistryBroker(id int64, name string, location contracts.Location) *contracts.Broker {
+	return &contracts.Broker{
+		Addr:     common.BigToAddress(big.NewInt(id)),
+		Name:     name,
+		HostAddr: name + ":25565",
+		Location: location,
+	}
+}
+
+func registeredBrokers(t *testing.T, registry BrokerRegistry) []common.Address {
+	brokers, ok := registry.Brokers()
+	if !ok {
+		t.Fatal("broker registry not ready")
+	}
+	addresses := make([]common.Address, len(brokers))
+	for i, broker := range brokers {
+		addresses[i] = broker.Addr
+	}
+	return addresses
+}
+
+func TestBrokerRegistry(t *testing.T) {
+	removed := newTestRegistryBroker(2, "removed", contracts.LocationEUW)
+	removed.Deleted = true
+	brokerContract := &fakeBrokerContract{brokers: []*contracts.Broker{
+		newTestRegistryBroker(1, "frankfurt", contracts.LocationEUW),
+		removed,
+	}}
+	registry := NewBrokerRegistryImpl(newTestLogger(), brokerContract)
+
+	if _, ok := registry.Brokers(); ok {
+		t.Fatal("broker registry ready before it was loaded")
+	}
+	if err := registry.load(context.Background()); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if addresses := registeredBrokers(t, registry); len(addresses) != 1 || addresses[0] != common.BigToAddress(big.NewInt(1)) {
+		t.Fatalf("registered %v, want broker 1 without the removed one", addresses)
+	}
+
+	brokers, _ := registry.Brokers()
+	brokers[0].Name = "changed"
+	if brokers, _ := registry.Brokers(); brokers[0].Name != "frankfurt" {
+		t.Fatalf("registered broker changed through a returned copy to %q", brokers[0].Name)
+	}
+
+	created := newTestRegistryBroker(3, "virginia", contracts.LocationNA)
+	brokerContract.brokers = append(brokerContract.brokers, created)
+	if err := registry.apply(context.Background(), &bindings.BrokerContractCreatedBroker{Addr: created.Addr}); err != nil {
+		t.Fatalf("apply created broker: %v", err)
+	}
+	brokerContract.brokers[0] = newTestRegistryBroker(1, "paris", contracts.LocationEUW)
+	if err := registry.apply(context.Background(), &bindings.BrokerContractUpdatedBroker{Addr: brokerContract.brokers[0].Addr}); err != nil {
+		t.Fatalf("apply updated broker: %v", err)
+	}
+	if brokers, _ := registry.Brokers(); len(brokers) != 2 || brokers[0].Name != "paris" || brokers[1].Addr != created.Addr {
+		t.Fatalf("registered %+v, want the updated and the created broker", brokers)
+	}
+
+	if err := registry.apply(context.Background(), &bindings.BrokerContractRemovedBroker{Addr: created.Addr}); err != nil {
+		t.Fatalf("apply removed broker: %v", err)
+	}
+	if addresses := registeredBrokers(t, registry); len(addresses) != 1 {
+		t.Fatalf("registered %v after removal, want one broker", addresses)
+	}
+
+	registry.invalidate()
+	if _, ok := registry.Brokers(); ok {
+		t.Fatal("invalidated broker registry ready")
+	}
+}
+
+func TestSearchBrokerWithRegistry(t *testing.T) {
+	removed := newTestRegistryBroker(4, "frankfurt-old", contracts.LocationEUW)
+	removed.Deleted = true
+	registry := NewBrokerRegistryImpl(newTestLogger(), &fakeBrokerContract{brokers: []*contracts.Broker{
+		newTestRegistryBroker(1, "frankfurt", contracts.LocationEUW),
+		newTestRegistryBroker(2, "virginia", contracts.LocationNA),
+		newTestRegistryBroker(3, "oregon", contracts.LocationNA),
+		removed,
+	}})
+	if err := registry.load(context.Background()); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	s := NewDiscoveryServiceImpl(
+		newTestLogger(),
+		nil,
+		nil,
+		registry,
+		NewLocationServiceImpl(newTestDb(t), newTestLogger(), nil),
+		time.Second,
+		1,
+	)
+
+	tests := []struct {
+		name  string
+		query *BrokerSearchQuery
+		want  []int64
+	}{
+		{"all", &BrokerSearchQuery{}, []int64{1, 2, 3}},
+		{"name", &BrokerSearchQuery{Name: "FRANK"}, []int64{1}},
+		{"locations", &BrokerSearchQuery{Locations: []contracts.Location{contracts.LocationNA}}, []int64{2, 3}},
+		{"name and locations", &BrokerSearchQuery{Name: "oregon", Locations: []contracts.Location{contracts.LocationEUW}}, nil},
+		{"limit", &BrokerSearchQuery{Limit: 1}, []int64{1}},
+	}
+	for _, test := range tests {
+		sink, errc := s.SearchBroker(context.Background(), test.query)
+		var found []int64
+		for broker := range sink {
+			found = append(found, new(big.Int).SetBytes(broker.Addr.Bytes()).Int64())
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("%s: search broker: %v", test.name, err)
+		}
+		if len(found) != len(test.want) {
+			t.Errorf("%s: found %v, want %v", test.name, found, test.want)
+			continue
+		}
+		for i := range found {
+			if found[i] != test.want[i] {
+				t.Errorf("%s: found %v, want %v", test.name, found, test.want)
+				break
+			}
+		}
+	}
+}
@@ -3,12 +3,26 @@ package services
 import (
 	"context"
 	"fmt"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
 	"marketplace-services/pkg/broker/api"
+	"marketplace-services/pkg/contracts"
 	"marketplace-services/pkg/domain"
+	"marketplace-services/pkg/marketplace"
+	"marketplace-services/pkg/proxy/model"
+	"math/big"
 	"math/rand"
 	"time"
 )
@@ -16,23 +30,73 @@ import (
 type Message struct {
 	TradeId uint64
 	Payload []byte
+	Seq     uint64
+}
+
+// InclusionProof proves that a message is one of the first Count messages the broker logged for a
+// trade. Root is the Merkle root of these messages.
+type InclusionProof struct {
+	TradeId uint64
+	Seq     uint64
+	Index   uint64
+	Count   uint64
+	Leaf    []byte
+	Chain   []byte
+	Root    []byte
+	Proof   [][]byte
+}
+
+// Resolution is the Merkle root of the messages the broker counted when it resolved the dispute of
+// a trade.
+type Resolution struct {
+	TradeId    uint64
+	Counter    uint64
+	Root       []byte
+	ResolvedAt int64
 }
 
 type CryptoMessageService interface {
 	EncryptAndPushMessage(ctx context.Context, brokerAddress string, publicKey []byte, msg *Message) error
 	DecryptAndPullMessage(ctx context.Context, brokerAddress string, tradeId uint64) (*Message, error)
+	EncryptAndPublishMessages(ctx context.Context, brokerAddress string, publicKey []byte, messages <-chan *Message) (uint64, error)
+	DecryptAndSubscribeMessages(ctx context.Context, brokerAddress string, tradeId uint64, acks <-chan uint64) (<-chan *Message, <-chan error)
+	FindMessageSequence(ctx context.Context, brokerAddress string, tradeId uint64) (uint64, error)
+	FindInclusionProof(ctx context.Context, brokerAddress string, tradeId uint64, seq uint64, count uint64) (*InclusionProof, error)
+	FindResolution(ctx context.Context, brokerAddress string, tradeId uint64) (*Resolution, error)
 }
 
 type cryptoMessageServiceImpl struct {
-	logger        logrus.FieldLogger
-	walletService WalletService
+	logger          logrus.FieldLogger
+	walletService   WalletService
+	tradingContract contracts.TradingContract
+
+	newSettlementContract func(address common.Address) (contracts.SettlementContract, error)
 }
 
-func NewCryptoMessageServiceImpl(logger logrus.FieldLogger, walletService WalletService, ) *cryptoMessageServiceImpl {
-	return &cryptoMessageServiceImpl{logger: logger, walletService: walletService}
+func NewCryptoMessageServiceImpl(
+	logger logrus.FieldLogger,
+	walletService WalletService,
+	tradingContract contracts.TradingContract,
+	ethClient *ethclient.Client,
+) *cryptoMessageServiceImpl {
+	return &cryptoMessageServiceImpl{
+		logger:          logger,
+		walletService:   walletService,
+		tradingContract: tradingContract,
+		newSettlementContract: func(address common.Address) (contracts.SettlementContract, error) {
+			return contracts.NewSettlementContractImpl(address, ethClient)
+		},
+	}
 }
 
 func (c *cryptoMessageServiceImpl) EncryptAndPushMessage(ctx context.Context, brokerAddress string, publicKey []byte, msg *Message) error {
+	key, err := c.walletService.FindKeyByAuthenticatedAccount(ctx)
+	if err != nil {
+		return fmt.Errorf("find key of authenticated proxy account: %w", err)
+	}
+	if err := c.checkTradeScope(ctx, key, msg.TradeId); err != nil {
+		return err
+	}
 
 	conn, err := grpc.Dial(brokerAddress, grpc.WithInsecure())
 	if err != nil {
@@ -44,10 +108,23 @@ func (c *cryptoMessageServiceImpl) EncryptAndPushMessage(ctx context.Context, br
 		}
 	}()
 
+	ctx, err = c.authenticate(ctx, conn, key)
+	if err != nil {
+		return fmt.Errorf("authenticate at broker %s: %w", brokerAddress, err)
+	}
+
 	pubKey, err := crypto.UnmarshalPubkey(publicKey)
 	if err != nil {
 		return fmt.Errorf("unmarshal pubkey: %w", err)
 	}
+	messageService := api.NewMessageServiceClient(conn)
+	if msg.Seq == 0 {
+		seq, err := findSequence(ctx, messageService, msg.TradeId)
+		if err != nil {
+			return err
+		}
+		msg.Seq = seq + 1
+	}
 	c.logger.Infof("%+v", msg)
 	encryptedPayload, err := ecies.Encrypt(
 		rand.New(rand.NewSource(time.Now().UnixNano())),
@@ -59,16 +136,32 @@ func (c *cryptoMessageServiceImpl) EncryptAndPushMessage(ctx context.Context, br
 	if err != nil {
 		return fmt.Errorf("encrypt payload: %w", err)
 	}
+	signature, err := signMessage(key, msg.TradeId, msg.Seq, encryptedPayload)
+	if err != nil {
+		return fmt.Errorf("sign message: %w", err)
+	}
 
-	messageService := api.NewMessageServiceClient(conn)
 	_, err = messageService.PushMessage(ctx, &api.PushMessageRequest{
-		Message: &domain.Message{TradeId: msg.TradeId, Payload: encryptedPayload},
+		Message: &domain.Message{
+			TradeId:   msg.TradeId,
+			Payload:   encryptedPayload,
+			Seq:       msg.Seq,
+			Signature: signature,
+		},
 	})
 
 	return err
 }
 
 func (c *cryptoMessageServiceImpl) DecryptAndPullMessage(ctx context.Context, brokerAddress string, tradeId uint64) (*Message, error) {
+	key, err := c.walletService.FindKeyByAuthenticatedAccount(ctx)
+	if err != nil {
+		return &Message{}, fmt.Errorf("find key of authenticated proxy account: %w", err)
+	}
+	if err := c.checkTradeScope(ctx, key, tradeId); err != nil {
+		return &Message{}, err
+	}
+
 	conn, err := grpc.Dial(brokerAddress, grpc.WithInsecure())
 	if err != nil {
 		return &Message{}, err
@@ -79,6 +172,11 @@ func (c *cryptoMessageServiceImpl) DecryptAndPullMessage(ctx context.Context, br
 		}
 	}()
 
+	ctx, err = c.authenticate(ctx, conn, key)
+	if err != nil {
+		return &Message{}, fmt.Errorf("authenticate at broker %s: %w", brokerAddress, err)
+	}
+
 	messageService := api.NewMessageServiceClient(conn)
 	response, err := messageService.PullMessage(ctx, &api.PullMessageRequest{
 		TradeId: tradeId,
@@ -88,10 +186,6 @@ func (c *cryptoMessageServiceImpl) DecryptAndPullMessage(ctx context.Context, br
 	}
 	msg := response.Message
 
-	key, err := c.walletService.FindKeyByAuthenticatedAccount(ctx)
-	if err != nil {
-		return &Message{}, fmt.Errorf("find key of authenticated proxy account: %w", err)
-	}
 	privateKey := ecies.ImportECDSA(key.PrivateKey)
 
 	decryptedPayload, err := privateKey.Decrypt(msg.Payload, nil, nil)
@@ -99,5 +193,423 @@ func (c *cryptoMessageServiceImpl) DecryptAndPullMessage(ctx context.Context, br
 		return &Message{}, fmt.Errorf("decrypt payload: %w", err)
 	}
 
-	return &Message{TradeId: msg.TradeId, Payload: decryptedPayload}, nil
+	return &Message{TradeId: msg.TradeId, Payload: decryptedPayload, Seq: msg.Seq}, nil
+}
+
+func (c *cryptoMessageServiceImpl) EncryptAndPublishMessages(
+	ctx context.Context,
+	brokerAddress string,
+	publicKey []byte,
+	messages <-chan *Message,
+) (uint64, error) {
+	key, err := c.walletService.FindKeyByAuthenticatedAccount(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("find key of authenticated proxy account: %w", err)
+	}
+
+	conn, err := grpc.Dial(brokerAddress, grpc.WithInsecure())
+	if err != nil {
+		return 0, fmt.Errorf("dial grpc %s: %w", brokerAddress, err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			c.logger.Errorf("%+v", err)
+		}
+	}()
+
+	ctx, err = c.authenticate(ctx, conn, key)
+	if err != nil {
+		return 0, fmt.Errorf("authenticate at broker %s: %w", brokerAddress, err)
+	}
+
+	pubKey, err := crypto.UnmarshalPubkey(publicKey)
+	if err != nil {
+		return 0, fmt.Errorf("unmarshal pubkey: %w", err)
+	}
+	generator := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	messageService := api.NewMessageServiceClient(conn)
+	stream, err := messageService.PublishMessages(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("publish messages to %s: %w", brokerAddress, err)
+	}
+
+	// Messages without sequence number continue after the last sequence number of their trade.
+	seqs := make(map[uint64]uint64)
+	for msg := range messages {
+		if _, ok := seqs[msg.TradeId]; !ok {
+			if err := c.checkTradeScope(ctx, key, msg.TradeId); err != nil {
+				return 0, err
+			}
+		}
+		if msg.Seq == 0 {
+			seq, ok := seqs[msg.TradeId]
+			if !ok {
+				seq, err = findSequence(ctx, messageService, msg.TradeId)
+				if err != nil {
+					return 0, err
+				}
+			}
+			msg.Seq = seq + 1
+		}
+		seqs[msg.TradeId] = msg.Seq
+
+		encryptedPayload, err := ecies.Encrypt(generator, ecies.ImportECDSAPublic(pubKey), msg.Payload, nil, nil)
+		if err != nil {
+			return 0, fmt.Errorf("encrypt payload: %w", err)
+		}
+		signature, err := signMessage(key, msg.TradeId, msg.Seq, encryptedPayload)
+		if err != nil {
+			return 0, fmt.Errorf("sign message: %w", err)
+		}
+		err = stream.Send(&api.PublishMessagesRequest{
+			Message: &domain.Message{
+				TradeId:   msg.TradeId,
+				Payload:   encryptedPayload,
+				Seq:       msg.Seq,
+				Signature: signature,
+			},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("send message of trade %d: %w", msg.TradeId, err)
+		}
+	}
+
+	response, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, fmt.Errorf("close message stream to %s: %w", brokerAddress, err)
+	}
+	return response.Counter, nil
+}
+
+// DecryptAndSubscribeMessages relays the messages of the trade one at a time. The next message is
+// only relayed once the current one is acknowledged with its sequence number on acks, which
+// acknowledges it at the broker. The subscription ends when acks is closed.
+func (c *cryptoMessageServiceImpl) DecryptAndSubscribeMessages(
+	ctx context.Context,
+	brokerAddress string,
+	tradeId uint64,
+	acks <-chan uint64,
+) (<-chan *Message, <-chan error) {
+	sink := make(chan *Message)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(sink)
+		defer close(errc)
+
+		key, err := c.walletService.FindKeyByAuthenticatedAccount(ctx)
+		if err != nil {
+			errc <- fmt.Errorf("find key of authenticated proxy account: %w", err)
+			return
+		}
+		if err := c.checkTradeScope(ctx, key, tradeId); err != nil {
+			errc <- err
+			return
+		}
+		privateKey := ecies.ImportECDSA(key.PrivateKey)
+
+		conn, err := grpc.Dial(brokerAddress, grpc.WithInsecure())
+		if err != nil {
+			errc <- fmt.Errorf("dial grpc %s: %w", brokerAddress, err)
+			return
+		}
+		defer func() {
+			if err := conn.Close(); err != nil {
+				c.logger.Errorf("%+v", err)
+			}
+		}()
+
+		ctx, err := c.authenticate(ctx, conn, key)
+		if err != nil {
+			errc <- fmt.Errorf("authenticate at broker %s: %w", brokerAddress, err)
+			return
+		}
+
+		messageService := api.NewMessageServiceClient(conn)
+		stream, err := messageService.SubscribeMessages(ctx)
+		if err != nil {
+			errc <- fmt.Errorf("subscribe messages of trade %d: %w", tradeId, err)
+			return
+		}
+		defer func() {
+			if err := stream.CloseSend(); err != nil {
+				c.logger.Errorf("close subscription of trade %d: %v", tradeId, err)
+			}
+		}()
+		if err := stream.Send(&api.SubscribeMessagesRequest{TradeId: tradeId}); err != nil {
+			errc <- fmt.Errorf("subscribe messages of trade %d: %w", tradeId, err)
+			return
+		}
+
+		for {
+			response, err := stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			msg := response.Message
+
+			decryptedPayload, err := privateKey.Decrypt(msg.Payload, nil, nil)
+			if err != nil {
+				errc <- fmt.Errorf("decrypt payload: %w", err)
+				return
+			}
+
+			select {
+			case sink <- &Message{TradeId: msg.TradeId, Payload: decryptedPayload, Seq: msg.Seq}:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+
+			select {
+			case seq, ok := <-acks:
+				if !ok {
+					return
+				}
+				if err := stream.Send(&api.SubscribeMessagesRequest{AckSeq: seq}); err != nil {
+					errc <- fmt.Errorf("acknowledge message %d of trade %d: %w", seq, tradeId, err)
+					return
+				}
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return sink, errc
+}
+
+// FindMessageSequence returns the sequence number of the last message the broker accepted for the
+// trade. Only the provider of the trade may ask for it.
+func (c *cryptoMessageServiceImpl) FindMessageSequence(
+	ctx context.Context,
+	brokerAddress string,
+	tradeId uint64,
+) (uint64, error) {
+	key, err := c.walletService.FindKeyByAuthenticatedAccount(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("find key of authenticated proxy account: %w", err)
+	}
+	if err := c.checkTradeScope(ctx, key, tradeId); err != nil {
+		return 0, err
+	}
+
+	conn, err := grpc.Dial(brokerAddress, grpc.WithInsecure())
+	if err != nil {
+		return 0, fmt.Errorf("dial grpc %s: %w", brokerAddress, err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			c.logger.Errorf("%+v", err)
+		}
+	}()
+
+	ctx, err = c.authenticate(ctx, conn, key)
+	if err != nil {
+		return 0, fmt.Errorf("authenticate at broker %s: %w", brokerAddress, err)
+	}
+	return findSequence(ctx, api.NewMessageServiceClient(conn), tradeId)
+}
+
+// FindInclusionProof returns the proof that the message with the sequence number is one of the
+// first count messages the broker logged for the trade, all logged messages if count is 0. Proofs
+// that don't verify are rejected.
+func (c *cryptoMessageServiceImpl) FindInclusionProof(
+	ctx context.Context,
+	brokerAddress string,
+	tradeId uint64,
+	seq uint64,
+	count uint64,
+) (*InclusionProof, error) {
+	key, err := c.walletService.FindKeyByAuthenticatedAccount(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("find key of authenticated proxy account: %w", err)
+	}
+	if err := c.checkTradeScope(ctx, key, tradeId); err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.Dial(brokerAddress, grpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("dial grpc %s: %w", brokerAddress, err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			c.logger.Errorf("%+v", err)
+		}
+	}()
+
+	ctx, err = c.authenticate(ctx, conn, key)
+	if err != nil {
+		return nil, fmt.Errorf("authenticate at broker %s: %w", brokerAddress, err)
+	}
+
+	logService := api.NewLogServiceClient(conn)
+	response, err := logService.GetInclusionProof(ctx, &api.GetInclusionProofRequest{
+		TradeId: tradeId,
+		Seq:     seq,
+		Count:   count,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get inclusion proof of message %d of trade %d: %w", seq, tradeId, err)
+	}
+	proof := &InclusionProof{
+		TradeId: response.Proof.GetTradeId(),
+		Seq:     response.Proof.GetSeq(),
+		Index:   response.Proof.GetIndex(),
+		Count:   response.Proof.GetCount(),
+		Leaf:    response.Proof.GetLeaf(),
+		Chain:   response.Proof.GetChain(),
+		Root:    response.Proof.GetRoot(),
+		Proof:   response.Proof.GetProof(),
+	}
+	if err := verifyInclusionProof(proof, tradeId, seq, count); err != nil {
+		c.logger.Warnf("Broker %s returned invalid inclusion proof: %v", brokerAddress, err)
+		return nil, status.Errorf(codes.DataLoss, "verify inclusion proof: %s", err)
+	}
+	return proof, nil
+}
+
+// FindResolution returns the resolution of the dispute of the trade. It is only returned if its
+// counter is the broker counter submitted to the settlement contract of the trade.
+func (c *cryptoMessageServiceImpl) FindResolution(ctx context.Context, brokerAddress string, tradeId uint64) (*Resolution, error) {
+	key, err := c.walletService.FindKeyByAuthenticatedAccount(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("find key of authenticated proxy account: %w", err)
+	}
+	if err := c.checkTradeScope(ctx, key, tradeId); err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.Dial(brokerAddress, grpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("dial grpc %s: %w", brokerAddress, err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			c.logger.Errorf("%+v", err)
+		}
+	}()
+
+	ctx, err = c.authenticate(ctx, conn, key)
+	if err != nil {
+		return nil, fmt.Errorf("authenticate at broker %s: %w", brokerAddress, err)
+	}
+
+	logService := api.NewLogServiceClient(conn)
+	response, err := logService.GetResolution(ctx, &api.GetResolutionRequest{TradeId: tradeId})
+	if err != nil {
+		return nil, fmt.Errorf("get resolution of trade %d: %w", tradeId, err)
+	}
+	resolution := &Resolution{
+		TradeId:    response.Resolution.GetTradeId(),
+		Counter:    response.Resolution.GetCounter(),
+		Root:       response.Resolution.GetRoot(),
+		ResolvedAt: response.Resolution.GetResolvedAt(),
+	}
+
+	callOpts := &bind.CallOpts{Context: ctx, From: key.Address}
+	trade, err := c.tradingContract.FindTradeById(callOpts, new(big.Int).SetUint64(tradeId))
+	if err != nil {
+		return nil, fmt.Errorf("find trade by id %d: %w", tradeId, err)
+	}
+	settlementContract, err := c.newSettlementContract(trade.SettlementContract)
+	if err != nil {
+		return nil, fmt.Errorf("new settlement contract %s: %w", trade.SettlementContract.Hex(), err)
+	}
+	counter, err := settlementContract.GetBrokerCounter(callOpts)
+	if err != nil {
+		return nil, fmt.Errorf("get broker counter of trade %d: %w", tradeId, err)
+	}
+	if err := verifyResolution(resolution, tradeId, counter); err != nil {
+		c.logger.Warnf("Broker %s returned invalid resolution: %v", brokerAddress, err)
+		return nil, status.Errorf(codes.DataLoss, "verify resolution: %s", err)
+	}
+	return resolution, nil
+}
+
+func verifyInclusionProof(proof *InclusionProof, tradeId uint64, seq uint64, count uint64) error {
+	if proof.TradeId != tradeId || proof.Seq != seq {
+		return fmt.Errorf("proof of message %d of trade %d instead of message %d of trade %d", proof.Seq, proof.TradeId, seq, tradeId)
+	}
+	if count != 0 && proof.Count > count {
+		return fmt.Errorf("proof covers %d messages instead of at most %d", proof.Count, count)
+	}
+	if !marketplace.VerifyInclusionProof(proof.Root, proof.Leaf, proof.Index, proof.Count, proof.Proof) {
+		return fmt.Errorf("message %d of trade %d is not included in root %x", seq, tradeId, proof.Root)
+	}
+	return nil
+}
+
+func verifyResolution(resolution *Resolution, tradeId uint64, counter *contracts.Counter) error {
+	if resolution.TradeId != tradeId {
+		return fmt.Errorf("resolution of trade %d instead of trade %d", resolution.TradeId, tradeId)
+	}
+	if !counter.Set {
+		return fmt.Errorf("broker counter of trade %d is not set", tradeId)
+	}
+	if counter.Value == nil || !counter.Value.IsUint64() || counter.Value.Uint64() != resolution.Counter {
+		return fmt.Errorf("resolution counts %d messages, broker submitted %v", resolution.Counter, counter.Value)
+	}
+	return nil
+}
+
+func findSequence(ctx context.Context, messageService api.MessageServiceClient, tradeId uint64) (uint64, error) {
+	response, err := messageService.FindSequence(ctx, &api.FindSequenceRequest{TradeId: tradeId})
+	if err != nil {
+		return 0, fmt.Errorf("find sequence of trade %d: %w", tradeId, err)
+	}
+	return response.Seq, nil
+}
+
+// checkTradeScope rejects calls authenticated with an API key scoped to another device than the
+// key of the account, which acts as the device at the broker, or for trades the device isn't part of.
+func (c *cryptoMessageServiceImpl) checkTradeScope(ctx context.Context, key *keystore.Key, tradeId uint64) error {
+	apiKey, ok := ctx.Value("apiKey").(*model.ApiKey)
+	if !ok || apiKey.Device == "" {
+		return nil
+	}
+	if err := checkDeviceScope(ctx, key.Address); err != nil {
+		return err
+	}
+	callOpts := &bind.CallOpts{Context: ctx, From: key.Address}
+	trade, err := c.tradingContract.FindTradeById(callOpts, new(big.Int).SetUint64(tradeId))
+	if err != nil {
+		return fmt.Errorf("find trade by id %d: %w", tradeId, err)
+	}
+	if trade.Provider != key.Address && trade.Consumer != key.Address {
+		return status.Errorf(codes.PermissionDenied, "device %s is not part of trade %d", key.Address.Hex(), tradeId)
+	}
+	return nil
+}
+
+func (c *cryptoMessageServiceImpl) authenticate(
+	ctx context.Context,
+	conn *grpc.ClientConn,
+	key *keystore.Key,
+) (context.Context, error) {
+	authService := api.NewAuthServiceClient(conn)
+	response, err := authService.GetChallenge(ctx, &api.GetChallengeRequest{Address: key.Address.Hex()})
+	if err != nil {
+		return nil, fmt.Errorf("get challenge: %w", err)
+	}
+
+	signature, err := crypto.Sign(accounts.TextHash(response.Challenge), key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("sign challenge: %w", err)
+	}
+
+	return metadata.AppendToOutgoingContext(
+		ctx,
+		"challenge", hexutil.Encode(response.Challenge),
+		"signature", hexutil.Encode(signature),
+	), nil
+}
+
+func signMessage(key *keystore.Key, tradeId uint64, seq uint64, payload []byte) ([]byte, error) {
+	return crypto.Sign(marketplace.MessageHash(tradeId, seq, payload), key.PrivateKey)
 }
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/marketplace"
+	"math/big"
+	"testing"
+)
+
+func newTestInclusionProof(count int, index int) *InclusionProof {
+	leaves := make([][]byte, count)
+	for i := range leaves {
+		leaves[i] = marketplace.LeafHash(3, uint64(i+1), []byte{byte(i)}, nil)
+	}
+	return &InclusionProof{
+		TradeId: 3,
+		Seq:     uint64(index + 1),
+		Index:   uint64(index),
+		Count:   uint64(count),
+		Leaf:    leaves[index],
+		Root:    marketplace.MerkleRoot(leaves),
+		Proof:   marketplace.MerkleProof(leaves, uint64(index)),
+	}
+}
+
+func TestVerifyInclusionProof(t *testing.T) {
+	if err := verifyInclusionProof(newTestInclusionProof(5, 2), 3, 3, 0); err != nil {
+		t.Fatalf("verify inclusion proof: %v", err)
+	}
+	if err := verifyInclusionProof(newTestInclusionProof(5, 2), 3, 3, 5); err != nil {
+		t.Fatalf("verify inclusion proof within count: %v", err)
+	}
+
+	tampered := newTestInclusionProof(5, 2)
+	tampered.Leaf = marketplace.LeafHash(3, 3, []byte{0xff}, nil)
+	wrongRoot := newTestInclusionProof(5, 2)
+	wrongRoot.Root = newTestInclusionProof(4, 2).Root
+
+	tests := []struct {
+		name  string
+		proof *InclusionProof
+		trade uint64
+		seq   uint64
+		count uint64
+	}{
+		{"other trade", newTestInclusionProof(5, 2), 4, 3, 0},
+		{"other message", newTestInclusionProof(5, 2), 3, 4, 0},
+		{"beyond count", newTestInclusionProof(5, 2), 3, 3, 4},
+		{"tampered leaf", tampered, 3, 3, 0},
+		{"other root", wrongRoot, 3, 3, 0},
+	}
+	for _, test := range tests {
+		if err := verifyInclusionProof(test.proof, test.trade, test.seq, test.count); err == nil {
+			t.Errorf("%s: proof verified", test.name)
+		}
+	}
+}
+
+func TestVerifyResolution(t *testing.T) {
+	resolution := &Resolution{TradeId: 3, Counter: 5}
+	if err := verifyResolution(resolution, 3, &contracts.Counter{Value: big.NewInt(5), Set: true}); err != nil {
+		t.Fatalf("verify resolution: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		trade   uint64
+		counter *contracts.Counter
+	}{
+		{"other trade", 4, &contracts.Counter{Value: big.NewInt(5), Set: true}},
+		{"counter not set", 3, &contracts.Counter{Value: big.NewInt(0)}},
+		{"other counter", 3, &contracts.Counter{Value: big.NewInt(4), Set: true}},
+	}
+	for _, test := range tests {
+		if err := verifyResolution(resolution, test.trade, test.counter); err == nil {
+			t.Errorf("%s: resolution verified", test.name)
+		}
+	}
+}
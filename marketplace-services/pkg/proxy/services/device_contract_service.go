@@ -31,10 +31,11 @@ type DeviceContractService interface {
 }
 
 type deviceContractServiceImpl struct {
-	logger         logrus.FieldLogger
-	keyStore       *keystore.KeyStore
-	walletService  WalletService
-	deviceContract contracts.DeviceContract
+	logger          logrus.FieldLogger
+	keyStore        *keystore.KeyStore
+	walletService   WalletService
+	deviceContract  contracts.DeviceContract
+	locationService LocationService
 }
 
 func NewDeviceContractServiceImpl(
@@ -42,16 +43,27 @@ func NewDeviceContractServiceImpl(
 	walletService WalletService,
 	keyStore *keystore.KeyStore,
 	deviceContract contracts.DeviceContract,
+	locationService LocationService,
 ) *deviceContractServiceImpl {
 	return &deviceContractServiceImpl{
-		logger:         logger,
-		walletService:  walletService,
-		keyStore:       keyStore,
-		deviceContract: deviceContract,
+		logger:          logger,
+		walletService:   walletService,
+		keyStore:        keyStore,
+		deviceContract:  deviceContract,
+		locationService: locationService,
 	}
 }
 
 func (s deviceContractServiceImpl) CreateDevice(ctx context.Context, device *contracts.Device) (*types.Transaction, error) {
+	if err := checkDeviceScope(ctx, device.Addr); err != nil {
+		return nil, err
+	}
+	if device.GeoLocation != nil {
+		if err := device.GeoLocation.Validate(); err != nil {
+			return nil, fmt.Errorf("validate location: %w", err)
+		}
+	}
+
 	w, err := s.walletService.FindWalletByAuthenticatedAccount(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("find wallet of authenticated proxy account: %w", err)
@@ -75,10 +87,27 @@ func (s deviceContractServiceImpl) CreateDevice(ctx context.Context, device *con
 		}
 	}()
 
-	return s.deviceContract.CreateDevice(transactOpts, device)
+	tx, err := s.deviceContract.CreateDevice(transactOpts, device)
+	if err != nil {
+		return nil, err
+	}
+
+	if device.GeoLocation != nil {
+		s.locationService.SaveGeoLocationOnReceipt(tx, device.Addr, device.GeoLocation)
+	}
+	return tx, nil
 }
 
 func (s deviceContractServiceImpl) UpdateDevice(ctx context.Context, device *contracts.Device) (*types.Transaction, error) {
+	if err := checkDeviceScope(ctx, device.Addr); err != nil {
+		return nil, err
+	}
+	if device.GeoLocation != nil {
+		if err := device.GeoLocation.Validate(); err != nil {
+			return nil, fmt.Errorf("validate location: %w", err)
+		}
+	}
+
 	w, err := s.walletService.FindWalletByAuthenticatedAccount(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("find wallet of authenticated proxy account: %w", err)
@@ -102,10 +131,21 @@ func (s deviceContractServiceImpl) UpdateDevice(ctx context.Context, device *con
 		}
 	}()
 
-	return s.deviceContract.UpdateDevice(transactOpts, device)
+	tx, err := s.deviceContract.UpdateDevice(transactOpts, device)
+	if err != nil {
+		return nil, err
+	}
+
+	if device.GeoLocation != nil {
+		s.locationService.SaveGeoLocationOnReceipt(tx, device.Addr, device.GeoLocation)
+	}
+	return tx, nil
 }
 
 func (s deviceContractServiceImpl) RemoveDevice(ctx context.Context, address common.Address) (*types.Transaction, error) {
+	if err := checkDeviceScope(ctx, address); err != nil {
+		return nil, err
+	}
 	w, err := s.walletService.FindWalletByAuthenticatedAccount(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("find wallet of authenticated proxy account: %w", err)
@@ -138,7 +178,14 @@ func (s deviceContractServiceImpl) FindDeviceByIndex(ctx context.Context, index
 		return nil, fmt.Errorf("find wallet of authenticated proxy account: %w", err)
 	}
 	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(w.Address)}
-	return s.deviceContract.FindDeviceByIndex(callOpts, index)
+	device, err := s.deviceContract.FindDeviceByIndex(callOpts, index)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.locationService.ResolveDevice(device); err != nil {
+		return nil, err
+	}
+	return device, nil
 }
 
 func (s deviceContractServiceImpl) FindDeviceByAddress(ctx context.Context, address common.Address) (*contracts.Device, error) {
@@ -147,7 +194,14 @@ func (s deviceContractServiceImpl) FindDeviceByAddress(ctx context.Context, addr
 		return nil, fmt.Errorf("find wallet of authenticated proxy account: %w", err)
 	}
 	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(w.Address)}
-	return s.deviceContract.FindDeviceByAddress(callOpts, address)
+	device, err := s.deviceContract.FindDeviceByAddress(callOpts, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.locationService.ResolveDevice(device); err != nil {
+		return nil, err
+	}
+	return device, nil
 }
 
 func (s deviceContractServiceImpl) FindProductsOfDeviceByAddress(ctx context.Context, address common.Address) ([]*big.Int, error) {
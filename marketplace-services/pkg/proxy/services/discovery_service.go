@@ -7,38 +7,91 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"marketplace-services/pkg/broker/api"
 	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/marketplace"
 	"math/big"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type DiscoveryService interface {
 	SearchBroker(ctx context.Context, query *BrokerSearchQuery) (<-chan *contracts.Broker, <-chan error)
+	SearchProducts(
+		ctx context.Context,
+		brokerQuery *BrokerSearchQuery,
+		productQuery *marketplace.ProductSearchQuery,
+	) (<-chan *ProductSearchResult, <-chan error)
 }
 
+// BrokerSearchQuery matches brokers by name, region and country. If Near is set, brokers are
+// ordered by their distance to it and, if Radius is set, limited to those within Radius kilometres.
+// Brokers without a geographic location match the countries of their region and are placed at
+// its centre.
 type BrokerSearchQuery struct {
 	Name      string
 	Locations []contracts.Location
+	Countries []string
+	Near      *contracts.GeoLocation
+	Radius    float64
+	Limit     int
 }
 
+// ProductSearchResult is either a product found by a broker or, if Product is nil,
+// the failure of a broker to answer the query. The last product of a page has the token of the
+// next page, if there is one.
+type ProductSearchResult struct {
+	Broker        *contracts.Broker
+	Product       *contracts.Product
+	Err           error
+	TimedOut      bool
+	NextPageToken string
+}
+
+const (
+	defaultBrokerTimeout        = 10 * time.Second
+	defaultMaxConcurrentBrokers = 8
+)
+
 type discoveryServiceImpl struct {
-	logger         logrus.FieldLogger
-	walletService  WalletService
-	keyStore       *keystore.KeyStore
-	brokerContract contracts.BrokerContract
+	logger               logrus.FieldLogger
+	keyStore             *keystore.KeyStore
+	brokerContract       contracts.BrokerContract
+	brokerRegistry       BrokerRegistry
+	locationService      LocationService
+	brokerTimeout        time.Duration
+	maxConcurrentBrokers int
 }
 
 func NewDiscoveryServiceImpl(
 	logger logrus.FieldLogger,
-	walletService WalletService,
 	keyStore *keystore.KeyStore,
 	brokerContract contracts.BrokerContract,
+	brokerRegistry BrokerRegistry,
+	locationService LocationService,
+	brokerTimeout time.Duration,
+	maxConcurrentBrokers int,
 ) *discoveryServiceImpl {
+	if brokerTimeout <= 0 {
+		brokerTimeout = defaultBrokerTimeout
+	}
+	if maxConcurrentBrokers < 1 {
+		maxConcurrentBrokers = defaultMaxConcurrentBrokers
+	}
 	return &discoveryServiceImpl{
-		logger:         logger,
-		walletService:  walletService,
-		keyStore:       keyStore,
-		brokerContract: brokerContract,
+		logger:               logger,
+		keyStore:             keyStore,
+		brokerContract:       brokerContract,
+		brokerRegistry:       brokerRegistry,
+		locationService:      locationService,
+		brokerTimeout:        brokerTimeout,
+		maxConcurrentBrokers: maxConcurrentBrokers,
 	}
 }
 
@@ -52,47 +105,317 @@ func (s discoveryServiceImpl) SearchBroker(
 		defer close(sink)
 		defer close(errc)
 
-		w, err := s.walletService.FindWalletByAuthenticatedAccount(ctx)
-		if err != nil {
-			errc <- fmt.Errorf("find wallet of authenticated proxy account: %w", err)
+		if err := validateBrokerSearchQuery(query); err != nil {
+			errc <- err
+			return
 		}
-		callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(w.Address)}
 
-		count, err := s.brokerContract.CountBrokers(callOpts)
+		brokers, err := s.findBrokers(ctx)
 		if err != nil {
-			errc <- fmt.Errorf("count brokers: %w", err)
+			errc <- err
 			return
 		}
-		for i := uint64(0); i < count.Uint64(); i++ {
+		if err := s.locationService.ResolveBrokers(brokers); err != nil {
+			errc <- fmt.Errorf("resolve locations of brokers: %w", err)
+			return
+		}
+
+		matched := make([]*contracts.Broker, 0)
+		for _, broker := range brokers {
+			if MatchBrokerSearchQuery(query, broker) {
+				matched = append(matched, broker)
+			}
+		}
+
+		if query.Near != nil {
+			sort.SliceStable(matched, func(i, j int) bool {
+				return contracts.Distance(query.Near, brokerPosition(matched[i])) <
+					contracts.Distance(query.Near, brokerPosition(matched[j]))
+			})
+		}
+		if query.Limit > 0 && len(matched) > query.Limit {
+			matched = matched[:query.Limit]
+		}
+
+		for _, broker := range matched {
 			select {
+			case sink <- broker:
 			case <-ctx.Done():
 				errc <- ctx.Err()
 				return
-			default:
-				broker, err := s.brokerContract.FindBrokerByIndex(callOpts, big.NewInt(int64(i)))
-				if err != nil {
-					errc <- fmt.Errorf("find broker by index %d: %w", i, err)
-					return
-				}
-				if s.MatchSearchQuery(query, broker) {
-					sink <- broker
-				}
 			}
 		}
 	}()
 	return sink, errc
 }
 
-func (s discoveryServiceImpl) MatchSearchQuery(
+func (s discoveryServiceImpl) findBrokers(ctx context.Context) ([]*contracts.Broker, error) {
+	if s.brokerRegistry != nil {
+		if brokers, ok := s.brokerRegistry.Brokers(); ok {
+			return brokers, nil
+		}
+		s.logger.Debugf("Broker registry not ready, scanning broker contract")
+	}
+
+	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(nil)}
+
+	count, err := s.brokerContract.CountBrokers(callOpts)
+	if err != nil {
+		return nil, fmt.Errorf("count brokers: %w", err)
+	}
+
+	brokers := make([]*contracts.Broker, 0)
+	for i := uint64(0); i < count.Uint64(); i++ {
+		broker, err := s.brokerContract.FindBrokerByIndex(callOpts, big.NewInt(int64(i)))
+		if err != nil {
+			return nil, fmt.Errorf("find broker by index %d: %w", i, err)
+		}
+		if !broker.Deleted {
+			brokers = append(brokers, broker)
+		}
+	}
+	return brokers, nil
+}
+
+func MatchBrokerSearchQuery(
 	query *BrokerSearchQuery,
 	broker *contracts.Broker,
 ) bool {
 	matched := true
-	if !strings.Contains(broker.Name, query.Name) {
+	if broker.Deleted {
+		matched = false
+	}
+	if !strings.Contains(strings.ToLower(broker.Name), strings.ToLower(query.Name)) {
 		matched = false
 	}
-	if !contracts.ContainsLocation(query.Locations, broker.Location) {
+	if len(query.Locations) > 0 && !contracts.ContainsLocation(query.Locations, broker.Location) {
+		matched = false
+	}
+	if len(query.Countries) > 0 && !matchBrokerCountries(query.Countries, broker) {
+		matched = false
+	}
+	if query.Near != nil && brokerPosition(broker) == nil {
+		matched = false
+	} else if query.Near != nil && query.Radius > 0 && contracts.Distance(query.Near, brokerPosition(broker)) > query.Radius {
 		matched = false
 	}
 	return matched
 }
+
+func validateBrokerSearchQuery(query *BrokerSearchQuery) error {
+	for _, country := range query.Countries {
+		if !contracts.IsCountryCode(country) {
+			return status.Errorf(codes.InvalidArgument, "unknown country code %q", country)
+		}
+	}
+	if query.Near == nil {
+		if query.Radius != 0 {
+			return status.Errorf(codes.InvalidArgument, "radius requires a location to search near")
+		}
+		return nil
+	}
+	if !query.Near.HasCoordinates {
+		return status.Errorf(codes.InvalidArgument, "location to search near has no coordinates")
+	}
+	if query.Near.Latitude < -90 || query.Near.Latitude > 90 ||
+		query.Near.Longitude < -180 || query.Near.Longitude > 180 {
+		return status.Errorf(codes.InvalidArgument, "coordinates of location to search near out of range")
+	}
+	if query.Radius < 0 {
+		return status.Errorf(codes.InvalidArgument, "negative radius")
+	}
+	return nil
+}
+
+// matchBrokerCountries matches the country of a broker with a geographic location, and the
+// countries of the region of brokers without one.
+func matchBrokerCountries(countries []string, broker *contracts.Broker) bool {
+	for _, country := range countries {
+		if broker.GeoLocation != nil && strings.EqualFold(country, broker.GeoLocation.Country) {
+			return true
+		}
+		if broker.GeoLocation == nil && broker.Location.ContainsCountry(country) {
+			return true
+		}
+	}
+	return false
+}
+
+// brokerPosition returns the coordinates of a broker, or the centre of its region if it has none.
+func brokerPosition(broker *contracts.Broker) *contracts.GeoLocation {
+	if broker.GeoLocation != nil && broker.GeoLocation.HasCoordinates {
+		return broker.GeoLocation
+	}
+	return broker.Location.Centre()
+}
+
+// SearchProducts searches products with all brokers matching the broker query. Every broker sorts
+// and pages its own products, so the first pages of all brokers up to the requested one are merged,
+// sorted and paged again. Failures of brokers are sent as they occur, the products once all brokers
+// answered or timed out.
+func (s discoveryServiceImpl) SearchProducts(
+	ctx context.Context,
+	brokerQuery *BrokerSearchQuery,
+	productQuery *marketplace.ProductSearchQuery,
+) (<-chan *ProductSearchResult, <-chan error) {
+	sink := make(chan *ProductSearchResult)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(sink)
+		defer close(errc)
+
+		offset, err := marketplace.DecodePageToken(productQuery.PageToken)
+		if err != nil {
+			errc <- status.Errorf(codes.InvalidArgument, "invalid page token: %s", err)
+			return
+		}
+
+		brokers, err := s.searchBrokers(ctx, brokerQuery)
+		if err != nil {
+			errc <- fmt.Errorf("search broker: %w", err)
+			return
+		}
+		s.logger.Debugf("Searching products with %d brokers", len(brokers))
+
+		query := *productQuery
+		query.PageToken = ""
+		if query.PageSize > 0 {
+			query.PageSize += offset
+		}
+
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		products := make([]*contracts.Product, 0)
+		productBrokers := make(map[uint64]*contracts.Broker)
+		ratings := make(map[uint64]uint64)
+		truncated := false
+		semaphore := make(chan struct{}, s.maxConcurrentBrokers)
+
+		send := func(result *ProductSearchResult) bool {
+			select {
+			case sink <- result:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, broker := range brokers {
+			wg.Add(1)
+			go func(broker *contracts.Broker) {
+				defer wg.Done()
+				select {
+				case semaphore <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				defer func() { <-semaphore }()
+
+				brokerCtx, cancel := context.WithTimeout(ctx, s.brokerTimeout)
+				defer cancel()
+
+				err := s.searchProductWithBroker(brokerCtx, broker, &query, func(response *api.SearchProductResponse) {
+					product := api.ProductFromGrpcProduct(response.Product)
+					mutex.Lock()
+					defer mutex.Unlock()
+					if response.NextPageToken != "" {
+						truncated = true
+					}
+					if _, ok := productBrokers[product.Id.Uint64()]; ok {
+						return
+					}
+					productBrokers[product.Id.Uint64()] = broker
+					ratings[product.Id.Uint64()] = response.Rating
+					products = append(products, product)
+				})
+				if err == nil || ctx.Err() != nil {
+					return
+				}
+
+				timedOut := brokerCtx.Err() == context.DeadlineExceeded
+				s.logger.Warnf("search product with broker %s at %s: %v", broker.Addr.Hex(), broker.HostAddr, err)
+				send(&ProductSearchResult{Broker: broker, Err: err, TimedOut: timedOut})
+			}(broker)
+		}
+		wg.Wait()
+
+		if err := ctx.Err(); err != nil {
+			errc <- err
+			return
+		}
+
+		marketplace.SortProducts(products, ratings, query.SortBy, query.Descending)
+
+		if offset > uint64(len(products)) {
+			offset = uint64(len(products))
+		}
+		end := uint64(len(products))
+		if productQuery.PageSize > 0 && offset+productQuery.PageSize < end {
+			end = offset + productQuery.PageSize
+		}
+		more := end < uint64(len(products)) || truncated
+
+		for i := offset; i < end; i++ {
+			product := products[i]
+			result := &ProductSearchResult{Broker: productBrokers[product.Id.Uint64()], Product: product}
+			if i == end-1 && more {
+				result.NextPageToken = marketplace.EncodePageToken(end)
+			}
+			if !send(result) {
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return sink, errc
+}
+
+func (s discoveryServiceImpl) searchBrokers(ctx context.Context, query *BrokerSearchQuery) ([]*contracts.Broker, error) {
+	var brokers []*contracts.Broker
+	sink, errc := s.SearchBroker(ctx, query)
+	for broker := range sink {
+		if broker.HostAddr == "" {
+			continue
+		}
+		brokers = append(brokers, broker)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return brokers, nil
+}
+
+func (s discoveryServiceImpl) searchProductWithBroker(
+	ctx context.Context,
+	broker *contracts.Broker,
+	query *marketplace.ProductSearchQuery,
+	receive func(response *api.SearchProductResponse),
+) error {
+	conn, err := grpc.DialContext(ctx, broker.HostAddr, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("dial grpc %s: %w", broker.HostAddr, err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			s.logger.Errorf("close grpc connection: %v", err)
+		}
+	}()
+
+	discoveryServiceClient := api.NewDiscoveryServiceClient(conn)
+	stream, err := discoveryServiceClient.SearchProduct(ctx, &api.SearchProductRequest{
+		Query: api.ProductSearchQueryToGrpcProductSearchQuery(query),
+	})
+	if err != nil {
+		return fmt.Errorf("search product: %w", err)
+	}
+
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("receive product: %w", err)
+		}
+		receive(response)
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc"
+	"marketplace-services/pkg/broker/api"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/marketplace"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeBrokerRegistry struct {
+	BrokerRegistry
+	brokers []*contracts.Broker
+}
+
+func (r fakeBrokerRegistry) Brokers() ([]*contracts.Broker, bool) {
+	return r.brokers, true
+}
+
+// fakeBrokerDiscoveryService answers product searches with the first page of its products, or not
+// at all if it is slow.
+type fakeBrokerDiscoveryService struct {
+	products []*contracts.Product
+	slow     bool
+}
+
+func (s *fakeBrokerDiscoveryService) SearchProduct(request *api.SearchProductRequest, stream api.DiscoveryService_SearchProductServer) error {
+	if s.slow {
+		<-stream.Context().Done()
+		return stream.Context().Err()
+	}
+	products := s.products
+	truncated := request.Query.PageSize > 0 && uint64(len(products)) > request.Query.PageSize
+	if truncated {
+		products = products[:request.Query.PageSize]
+	}
+	for i, product := range products {
+		response := &api.SearchProductResponse{Product: api.ProductToGrpcProduct(product)}
+		if truncated && i == len(products)-1 {
+			response.NextPageToken = marketplace.EncodePageToken(uint64(len(products)))
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newTestBroker(t *testing.T, id int64, service *fakeBrokerDiscoveryService) (*contracts.Broker, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := grpc.NewServer()
+	api.RegisterDiscoveryServiceServer(server, service)
+	go server.Serve(listener)
+
+	return &contracts.Broker{
+		Addr:     common.BigToAddress(big.NewInt(id)),
+		Name:     "broker",
+		HostAddr: listener.Addr().String(),
+		Location: contracts.LocationEUW,
+	}, server.Stop
+}
+
+func newTestProducts(ids ...int64) []*contracts.Product {
+	products := make([]*contracts.Product, len(ids))
+	for i, id := range ids {
+		products[i] = &contracts.Product{Id: big.NewInt(id), Frequency: big.NewInt(1), Cost: big.NewInt(10 * id)}
+	}
+	return products
+}
+
+func newTestDiscoveryService(t *testing.T, brokers ...*contracts.Broker) *discoveryServiceImpl {
+	return NewDiscoveryServiceImpl(
+		newTestLogger(),
+		nil,
+		nil,
+		fakeBrokerRegistry{brokers: brokers},
+		NewLocationServiceImpl(newTestDb(t), newTestLogger(), nil),
+		200*time.Millisecond,
+		2,
+	)
+}
+
+func searchTestProducts(
+	t *testing.T,
+	s *discoveryServiceImpl,
+	query *marketplace.ProductSearchQuery,
+) ([]*ProductSearchResult, []*ProductSearchResult) {
+	sink, errc := s.SearchProducts(context.Background(), &BrokerSearchQuery{}, query)
+	var products, failures []*ProductSearchResult
+	for result := range sink {
+		if result.Product == nil {
+			failures = append(failures, result)
+		} else {
+			products = append(products, result)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("search products: %v", err)
+	}
+	return products, failures
+}
+
+func TestSearchProductsMergesBrokers(t *testing.T) {
+	first, stop := newTestBroker(t, 1, &fakeBrokerDiscoveryService{products: newTestProducts(1, 2, 3)})
+	defer stop()
+	second, stop := newTestBroker(t, 2, &fakeBrokerDiscoveryService{products: newTestProducts(2, 4)})
+	defer stop()
+	slow, stop := newTestBroker(t, 3, &fakeBrokerDiscoveryService{slow: true})
+	defer stop()
+	s := newTestDiscoveryService(t, first, second, slow)
+
+	products, failures := searchTestProducts(t, s, &marketplace.ProductSearchQuery{
+		SortBy:     marketplace.SortByCost,
+		Descending: true,
+	})
+	if len(failures) != 1 || failures[0].Broker.Addr != slow.Addr || !failures[0].TimedOut {
+		t.Fatalf("got failures %+v, want a time out of the slow broker", failures)
+	}
+	want := []int64{4, 3, 2, 1}
+	if len(products) != len(want) {
+		t.Fatalf("got %d products, want %d", len(products), len(want))
+	}
+	for i, result := range products {
+		if result.Product.Id.Int64() != want[i] {
+			t.Errorf("product %d at position %d, want %d", result.Product.Id, i, want[i])
+		}
+		if result.Product.Id.Int64() == 4 && result.Broker.Addr != second.Addr {
+			t.Errorf("product 4 found by broker %s, want %s", result.Broker.Addr.Hex(), second.Addr.Hex())
+		}
+	}
+}
+
+func TestSearchProductsPages(t *testing.T) {
+	first, stop := newTestBroker(t, 1, &fakeBrokerDiscoveryService{products: newTestProducts(1, 3, 5)})
+	defer stop()
+	second, stop := newTestBroker(t, 2, &fakeBrokerDiscoveryService{products: newTestProducts(2, 4)})
+	defer stop()
+	s := newTestDiscoveryService(t, first, second)
+
+	query := &marketplace.ProductSearchQuery{PageSize: 2}
+	var ids []int64
+	for page := 0; page < 4; page++ {
+		products, failures := searchTestProducts(t, s, query)
+		if len(failures) != 0 {
+			t.Fatalf("page %d: got failures %+v", page, failures)
+		}
+		for _, result := range products {
+			ids = append(ids, result.Product.Id.Int64())
+		}
+		query.PageToken = products[len(products)-1].NextPageToken
+		if query.PageToken == "" {
+			break
+		}
+	}
+
+	want := []int64{1, 2, 3, 4, 5}
+	if len(ids) != len(want) {
+		t.Fatalf("got products %v over all pages, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("got products %v over all pages, want %v", ids, want)
+		}
+	}
+}
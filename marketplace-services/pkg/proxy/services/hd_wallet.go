@@ -0,0 +1,103 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tyler-smith/go-bip39"
+	"math/big"
+)
+
+const mnemonicEntropyBits = 128
+
+var errInvalidChildKey = errors.New("invalid child key")
+
+type extendedKey struct {
+	key       []byte
+	chainCode []byte
+}
+
+func newMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(mnemonicEntropyBits)
+	if err != nil {
+		return "", fmt.Errorf("generate entropy: %w", err)
+	}
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		return "", fmt.Errorf("generate mnemonic: %w", err)
+	}
+	return mnemonic, nil
+}
+
+// devicePath returns the BIP-44 path of the index-th address derived from a wallet's mnemonic.
+// Index 0 is the address of the wallet itself.
+func devicePath(index uint32) accounts.DerivationPath {
+	path := make(accounts.DerivationPath, len(accounts.DefaultBaseDerivationPath))
+	copy(path, accounts.DefaultBaseDerivationPath)
+	path[len(path)-1] = index
+	return path
+}
+
+// deriveKey derives the private key at path from a BIP-39 mnemonic as specified by BIP-32.
+func deriveKey(mnemonic string, path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return nil, fmt.Errorf("derive seed from mnemonic: %w", err)
+	}
+	key, err := deriveExtendedKey(seed, path)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.ToECDSA(key.key)
+}
+
+// deriveExtendedKey derives the extended private key at path from the master key of seed.
+func deriveExtendedKey(seed []byte, path accounts.DerivationPath) (*extendedKey, error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	key := &extendedKey{key: sum[:32], chainCode: sum[32:]}
+
+	for _, index := range path {
+		var err error
+		key, err = key.child(index)
+		if err != nil {
+			return nil, fmt.Errorf("derive child %d of path %s: %w", index, path, err)
+		}
+	}
+	return key, nil
+}
+
+func (k *extendedKey) child(index uint32) (*extendedKey, error) {
+	var data []byte
+	if index >= 0x80000000 {
+		data = append([]byte{0}, k.key...)
+	} else {
+		data = crypto.CompressPubkey(&crypto.ToECDSAUnsafe(k.key).PublicKey)
+	}
+	indexBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(indexBytes, index)
+	data = append(data, indexBytes...)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	n := crypto.S256().Params().N
+	tweak := new(big.Int).SetBytes(sum[:32])
+	if tweak.Cmp(n) >= 0 {
+		return nil, errInvalidChildKey
+	}
+	childKey := tweak.Add(tweak, new(big.Int).SetBytes(k.key))
+	childKey.Mod(childKey, n)
+	if childKey.Sign() == 0 {
+		return nil, errInvalidChildKey
+	}
+	return &extendedKey{key: math.PaddedBigBytes(childKey, 32), chainCode: sum[32:]}, nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"encoding/hex"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tyler-smith/go-bip39"
+	"testing"
+)
+
+// TestDeriveExtendedKeyMatchesBip32 checks the derivation against test vector 1 of BIP-32.
+func TestDeriveExtendedKeyMatchesBip32(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	vectors := []struct {
+		path      string
+		key       string
+		chainCode string
+	}{
+		{
+			"m",
+			"e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35",
+			"873dff81c02f525623fd1fe5167eac3a55a049de3d314bb42ee227ffed37d508",
+		},
+		{
+			"m/0'",
+			"edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea",
+			"47fdacbd0f1097043b78c63c20c34ef4ed9a111d980047ad16282c7ae6236141",
+		},
+		{
+			"m/0'/1",
+			"3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368",
+			"2a7857631386ba23dacac34180dd1983734e444fdbf774041578e9b6adb37c19",
+		},
+		{
+			"m/0'/1/2'",
+			"cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca",
+			"04466b9cc8e161e966409ca52986c584f07e9dc81f735db683c3ff6ec7b1503f",
+		},
+		{
+			"m/0'/1/2'/2",
+			"0f479245fb19a38a1954c5c7c0ebab2f9bdfd96a17563ef28a6a4b1a2a764ef4",
+			"cfb71883f01676f587d023cc53a35bc7f88f724b1f8c2892ac1275ac822a3edd",
+		},
+		{
+			"m/0'/1/2'/2/1000000000",
+			"471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8",
+			"c783e67b921d2beb8f6b389cc646d7263b4145701dadd2161548a8b078e65e9e",
+		},
+	}
+	for _, v := range vectors {
+		var path accounts.DerivationPath
+		if v.path != "m" {
+			var err error
+			if path, err = accounts.ParseDerivationPath(v.path); err != nil {
+				t.Fatalf("parse path %s: %v", v.path, err)
+			}
+		}
+		key, err := deriveExtendedKey(seed, path)
+		if err != nil {
+			t.Fatalf("derive %s: %v", v.path, err)
+		}
+		if hex.EncodeToString(key.key) != v.key || hex.EncodeToString(key.chainCode) != v.chainCode {
+			t.Errorf("derived wrong key for %s", v.path)
+		}
+	}
+}
+
+// TestMnemonicSeedMatchesBip39 checks the seed of the first test vector of BIP-39.
+func TestMnemonicSeedMatchesBip39(t *testing.T) {
+	entropy := make([]byte, 16)
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		t.Fatalf("new mnemonic: %v", err)
+	}
+	want := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	if mnemonic != want {
+		t.Fatalf("mnemonic %q, want %q", mnemonic, want)
+	}
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "TREZOR")
+	if err != nil {
+		t.Fatalf("new seed: %v", err)
+	}
+	wantSeed := "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+	if hex.EncodeToString(seed) != wantSeed {
+		t.Fatalf("seed %x, want %s", seed, wantSeed)
+	}
+}
+
+// TestDeriveKeyMatchesEthereumWallets checks that wallets derive the same address as common
+// Ethereum wallets do for the same mnemonic.
+func TestDeriveKeyMatchesEthereumWallets(t *testing.T) {
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	key, err := deriveKey(mnemonic, devicePath(0))
+	if err != nil {
+		t.Fatalf("derive key: %v", err)
+	}
+	if address := crypto.PubkeyToAddress(key.PublicKey).Hex(); address != "0x9858EfFD232B4033E47d90003D41EC34EcaEda94" {
+		t.Fatalf("derived address %s", address)
+	}
+
+	if _, err := deriveKey("abandon abandon abandon", devicePath(0)); err == nil {
+		t.Fatal("derived key from invalid mnemonic")
+	}
+}
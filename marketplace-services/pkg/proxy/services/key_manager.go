@@ -0,0 +1,437 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/proxy/model"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmRS256 = "RS256"
+
+	rsaKeySize = 2048
+
+	// signingKeyLockID identifies the PostgreSQL advisory lock serializing key rotations of proxy
+	// replicas.
+	signingKeyLockID = 25567
+	// keyReloadInterval limits how often tokens with unknown key ids reload the signing keys.
+	keyReloadInterval = 10 * time.Second
+	// keyCheckInterval is the interval in which keys rotated by other replicas are loaded.
+	keyCheckInterval = time.Minute
+)
+
+// Jwk is a public key in the JSON Web Key format of RFC 7517.
+type Jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type KeyManager interface {
+	SigningKey() (string, jwt.SigningMethod, interface{}, error)
+	VerificationKey(token *jwt.Token) (interface{}, error)
+	RotateSigningKey(ctx context.Context) (string, error)
+	RotateSigningKeys(ctx context.Context, interval time.Duration) error
+	Jwks(ctx context.Context) ([]*Jwk, error)
+}
+
+type hmacKeyManager struct {
+	signingKey []byte
+}
+
+// NewHMACKeyManager creates a key manager signing tokens with a shared HS256 secret. Tokens signed
+// with it can't be verified without the secret, so no keys are published.
+func NewHMACKeyManager(signingKey []byte) *hmacKeyManager {
+	return &hmacKeyManager{signingKey: signingKey}
+}
+
+func (m *hmacKeyManager) SigningKey() (string, jwt.SigningMethod, interface{}, error) {
+	return "", jwt.SigningMethodHS256, m.signingKey, nil
+}
+
+func (m *hmacKeyManager) VerificationKey(token *jwt.Token) (interface{}, error) {
+	if token.Method != jwt.SigningMethodHS256 {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return m.signingKey, nil
+}
+
+func (m *hmacKeyManager) RotateSigningKey(_ context.Context) (string, error) {
+	return "", status.Errorf(codes.FailedPrecondition, "signing keys of %s can't be rotated", AlgorithmHS256)
+}
+
+func (m *hmacKeyManager) RotateSigningKeys(ctx context.Context, _ time.Duration) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (m *hmacKeyManager) Jwks(_ context.Context) ([]*Jwk, error) {
+	return nil, nil
+}
+
+type signingKey struct {
+	kid        string
+	method     jwt.SigningMethod
+	privateKey interface{}
+	publicKey  interface{}
+}
+
+type asymmetricKeyManager struct {
+	db        *gorm.DB
+	logger    logrus.FieldLogger
+	sealer    PassphraseSealer
+	algorithm string
+	retention time.Duration
+	active    *signingKey
+	keys      map[string]*signingKey
+	sync.RWMutex
+
+	reloadMutex sync.Mutex
+	reloadedAt  time.Time
+}
+
+// NewAsymmetricKeyManager creates a key manager signing tokens with ES256 or RS256 keys stored in
+// the database. The private keys are sealed with the given sealer. Retired keys are kept for the
+// retention time, so tokens signed before a rotation stay valid until they expire.
+func NewAsymmetricKeyManager(
+	db *gorm.DB,
+	logger logrus.FieldLogger,
+	sealer PassphraseSealer,
+	algorithm string,
+	retention time.Duration,
+) (*asymmetricKeyManager, error) {
+	if algorithm != AlgorithmES256 && algorithm != AlgorithmRS256 {
+		return nil, fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+	m := &asymmetricKeyManager{
+		db:        db,
+		logger:    logger,
+		sealer:    sealer,
+		algorithm: algorithm,
+		retention: retention,
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	if m.active == nil || m.active.method.Alg() != algorithm {
+		_, err := m.rotate(func(active *model.SigningKey) bool {
+			return active == nil || active.Algorithm != algorithm
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *asymmetricKeyManager) SigningKey() (string, jwt.SigningMethod, interface{}, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if m.active == nil {
+		return "", nil, nil, fmt.Errorf("no active signing key")
+	}
+	return m.active.kid, m.active.method, m.active.privateKey, nil
+}
+
+func (m *asymmetricKeyManager) VerificationKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("token has no key id")
+	}
+
+	key, err := m.verificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", token.Method.Alg(), kid)
+	}
+	return key.publicKey, nil
+}
+
+// verificationKey returns the cached key with the given id. Keys missing in the cache may have been
+// created by another replica, so the keys are reloaded, but at most once per keyReloadInterval.
+func (m *asymmetricKeyManager) verificationKey(kid string) (*signingKey, error) {
+	if key := m.cachedKey(kid); key != nil {
+		return key, nil
+	}
+
+	m.reloadMutex.Lock()
+	defer m.reloadMutex.Unlock()
+	if key := m.cachedKey(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(m.reloadedAt) < keyReloadInterval {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+	m.reloadedAt = time.Now()
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	if key := m.cachedKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %s", kid)
+}
+
+func (m *asymmetricKeyManager) cachedKey(kid string) *signingKey {
+	m.RLock()
+	defer m.RUnlock()
+	return m.keys[kid]
+}
+
+// RotateSigningKey generates a new signing key and retires the active one. Retired keys are still
+// published and accepted until their retention time has passed.
+func (m *asymmetricKeyManager) RotateSigningKey(_ context.Context) (string, error) {
+	return m.rotate(func(*model.SigningKey) bool { return true })
+}
+
+// RotateSigningKeys loads the keys rotated by other replicas and rotates the signing key once it is
+// older than the given interval, until the context is cancelled. Keys are not rotated if the
+// interval is not positive.
+func (m *asymmetricKeyManager) RotateSigningKeys(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(keyCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			var err error
+			if interval > 0 {
+				_, err = m.rotate(func(active *model.SigningKey) bool {
+					return active == nil || time.Since(active.CreatedAt) >= interval
+				})
+			} else {
+				err = m.load()
+			}
+			if err != nil {
+				m.logger.Errorf("rotate signing key: %v", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// rotate replaces the active signing key if due returns true for it. The decision is made while
+// holding the rotation lock on PostgreSQL, so replicas rotating at the same time rotate once and
+// the others load the new key. SQLite serializes writing transactions itself. It returns the id of
+// the active key.
+func (m *asymmetricKeyManager) rotate(due func(active *model.SigningKey) bool) (string, error) {
+	tx := m.db.Begin()
+	if tx.Error != nil {
+		return "", fmt.Errorf("begin transaction: %w", tx.Error)
+	}
+	if m.db.Dialect().GetName() == "postgres" {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", signingKeyLockID).Error; err != nil {
+			tx.Rollback()
+			return "", fmt.Errorf("acquire signing key lock: %w", err)
+		}
+	}
+
+	var active *model.SigningKey
+	var stored model.SigningKey
+	err := tx.Where("active = ?", true).Order("created_at desc").First(&stored).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		tx.Rollback()
+		return "", fmt.Errorf("get active signing key: %w", err)
+	}
+	if err == nil {
+		active = &stored
+	}
+	if !due(active) {
+		tx.Rollback()
+		if err := m.load(); err != nil {
+			return "", err
+		}
+		return active.KeyID, nil
+	}
+
+	privateKey, err := generatePrivateKey(m.algorithm)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	sealed, err := m.sealer.Seal(privateKey)
+	if err != nil {
+		tx.Rollback()
+		return "", fmt.Errorf("seal signing key: %w", err)
+	}
+
+	key := &model.SigningKey{
+		KeyID:      uuid.New(),
+		Algorithm:  m.algorithm,
+		PrivateKey: sealed,
+		Active:     true,
+	}
+	err = tx.Model(&model.SigningKey{}).
+		Where("active = ?", true).
+		Updates(map[string]interface{}{"active": false, "retired_at": time.Now()}).Error
+	if err != nil {
+		tx.Rollback()
+		return "", fmt.Errorf("retire active signing keys: %w", err)
+	}
+	if err := tx.Create(key).Error; err != nil {
+		tx.Rollback()
+		return "", fmt.Errorf("create signing key: %w", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return "", fmt.Errorf("commit transaction: %w", err)
+	}
+
+	m.logger.Infof("Rotated signing key, new key %s uses %s", key.KeyID, key.Algorithm)
+	if err := m.load(); err != nil {
+		return "", err
+	}
+	return key.KeyID, nil
+}
+
+func (m *asymmetricKeyManager) Jwks(_ context.Context) ([]*Jwk, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	jwks := make([]*Jwk, 0, len(m.keys))
+	for _, key := range m.keys {
+		jwk, err := publicKeyToJwk(key)
+		if err != nil {
+			return nil, err
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks, nil
+}
+
+// load deletes signing keys retired longer than the retention time and caches the remaining ones.
+func (m *asymmetricKeyManager) load() error {
+	expired := time.Now().Add(-m.retention)
+	err := m.db.Unscoped().Where("active = ? AND retired_at < ?", false, expired).Delete(&model.SigningKey{}).Error
+	if err != nil {
+		return fmt.Errorf("delete expired signing keys: %w", err)
+	}
+
+	var stored []*model.SigningKey
+	if err := m.db.Find(&stored).Error; err != nil {
+		return fmt.Errorf("get signing keys: %w", err)
+	}
+
+	keys := make(map[string]*signingKey, len(stored))
+	var active *signingKey
+	for _, s := range stored {
+		key, err := m.open(s)
+		if err != nil {
+			return err
+		}
+		keys[key.kid] = key
+		if s.Active {
+			active = key
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.keys = keys
+	m.active = active
+	return nil
+}
+
+func (m *asymmetricKeyManager) open(stored *model.SigningKey) (*signingKey, error) {
+	privateKey, err := m.sealer.Open(stored.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("open signing key %s: %w", stored.KeyID, err)
+	}
+
+	key := &signingKey{kid: stored.KeyID}
+	switch stored.Algorithm {
+	case AlgorithmES256:
+		ecdsaKey, err := jwt.ParseECPrivateKeyFromPEM([]byte(privateKey))
+		if err != nil {
+			return nil, fmt.Errorf("parse signing key %s: %w", stored.KeyID, err)
+		}
+		key.method, key.privateKey, key.publicKey = jwt.SigningMethodES256, ecdsaKey, &ecdsaKey.PublicKey
+	case AlgorithmRS256:
+		rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(privateKey))
+		if err != nil {
+			return nil, fmt.Errorf("parse signing key %s: %w", stored.KeyID, err)
+		}
+		key.method, key.privateKey, key.publicKey = jwt.SigningMethodRS256, rsaKey, &rsaKey.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s of signing key %s", stored.Algorithm, stored.KeyID)
+	}
+	return key, nil
+}
+
+func generatePrivateKey(algorithm string) (string, error) {
+	var block *pem.Block
+	switch algorithm {
+	case AlgorithmES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", fmt.Errorf("generate ecdsa key: %w", err)
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return "", fmt.Errorf("marshal ecdsa key: %w", err)
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	case AlgorithmRS256:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+		if err != nil {
+			return "", fmt.Errorf("generate rsa key: %w", err)
+		}
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	default:
+		return "", fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+func publicKeyToJwk(key *signingKey) (*Jwk, error) {
+	jwk := &Jwk{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+	switch publicKey := key.publicKey.(type) {
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = publicKey.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(padBytes(publicKey.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padBytes(publicKey.Y.Bytes(), size))
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	default:
+		return nil, fmt.Errorf("unsupported public key of signing key %s", key.kid)
+	}
+	return jwk, nil
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
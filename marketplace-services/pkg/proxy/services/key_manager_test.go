@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	"marketplace-services/pkg/proxy/model"
+	"testing"
+	"time"
+)
+
+func newTestKeyManager(t *testing.T, db *gorm.DB) *asymmetricKeyManager {
+	sealer, err := NewAESPassphraseSealer("test master key")
+	if err != nil {
+		t.Fatalf("new passphrase sealer: %v", err)
+	}
+	keyManager, err := NewAsymmetricKeyManager(db, newTestLogger(), sealer, AlgorithmES256, time.Hour)
+	if err != nil {
+		t.Fatalf("new key manager: %v", err)
+	}
+	return keyManager
+}
+
+func signTestToken(t *testing.T, keyManager KeyManager) *jwt.Token {
+	kid, method, key, err := keyManager.SigningKey()
+	if err != nil {
+		t.Fatalf("get signing key: %v", err)
+	}
+	token := jwt.NewWithClaims(method, jwt.StandardClaims{Subject: "test"})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	parsed, _, err := new(jwt.Parser).ParseUnverified(signed, &jwt.StandardClaims{})
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	return parsed
+}
+
+func countSigningKeys(t *testing.T, db *gorm.DB, active bool) int {
+	var count int
+	if err := db.Model(&model.SigningKey{}).Where("active = ?", active).Count(&count).Error; err != nil {
+		t.Fatalf("count signing keys: %v", err)
+	}
+	return count
+}
+
+func TestKeyManagersShareSigningKey(t *testing.T) {
+	db := newTestDb(t)
+	first := newTestKeyManager(t, db)
+	second := newTestKeyManager(t, db)
+
+	if countSigningKeys(t, db, true) != 1 || countSigningKeys(t, db, false) != 0 {
+		t.Fatal("second key manager created another signing key")
+	}
+	if _, err := second.VerificationKey(signTestToken(t, first)); err != nil {
+		t.Fatalf("verify token of other key manager: %v", err)
+	}
+}
+
+func TestVerificationKeyReloadsUnknownKeys(t *testing.T) {
+	db := newTestDb(t)
+	first := newTestKeyManager(t, db)
+	second := newTestKeyManager(t, db)
+
+	if _, err := first.RotateSigningKey(context.Background()); err != nil {
+		t.Fatalf("rotate signing key: %v", err)
+	}
+	token := signTestToken(t, first)
+	if _, err := second.VerificationKey(token); err != nil {
+		t.Fatalf("verify token signed with rotated key: %v", err)
+	}
+
+	if _, err := first.RotateSigningKey(context.Background()); err != nil {
+		t.Fatalf("rotate signing key: %v", err)
+	}
+	if _, err := second.VerificationKey(signTestToken(t, first)); err == nil {
+		t.Fatal("keys reloaded within the reload interval")
+	}
+	second.reloadedAt = time.Time{}
+	if _, err := second.VerificationKey(signTestToken(t, first)); err != nil {
+		t.Fatalf("verify token signed with rotated key: %v", err)
+	}
+}
+
+func TestRotateOnlyDueKeys(t *testing.T) {
+	db := newTestDb(t)
+	first := newTestKeyManager(t, db)
+	second := newTestKeyManager(t, db)
+
+	due := func(active *model.SigningKey) bool {
+		return active == nil || time.Since(active.CreatedAt) >= time.Hour
+	}
+	if _, err := first.RotateSigningKey(context.Background()); err != nil {
+		t.Fatalf("rotate signing key: %v", err)
+	}
+	kid, err := second.rotate(due)
+	if err != nil {
+		t.Fatalf("rotate signing key: %v", err)
+	}
+	if countSigningKeys(t, db, false) != 1 {
+		t.Fatal("recently rotated signing key rotated again")
+	}
+	if active, _, _, _ := second.SigningKey(); active != kid {
+		t.Fatalf("signing with key %s, want active key %s", active, kid)
+	}
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/proxy/model"
+	"strings"
+	"time"
+)
+
+// receiptTimeout limits how long a location waits for the transaction of its broker or device.
+const receiptTimeout = 10 * time.Minute
+
+// LocationService stores the geographic locations of brokers and devices in the database of the
+// proxy. They aren't part of the contracts, so brokers created with other proxies only have a region.
+type LocationService interface {
+	SaveGeoLocation(address common.Address, location *contracts.GeoLocation) error
+	SaveGeoLocationOnReceipt(tx *types.Transaction, address common.Address, location *contracts.GeoLocation)
+	FindGeoLocation(address common.Address) (*contracts.GeoLocation, error)
+	ResolveBroker(broker *contracts.Broker) error
+	ResolveBrokers(brokers []*contracts.Broker) error
+	ResolveDevice(device *contracts.Device) error
+}
+
+type locationServiceImpl struct {
+	db      *gorm.DB
+	logger  logrus.FieldLogger
+	backend bind.DeployBackend
+}
+
+func NewLocationServiceImpl(db *gorm.DB, logger logrus.FieldLogger, backend bind.DeployBackend) *locationServiceImpl {
+	return &locationServiceImpl{
+		db:      db,
+		logger:  logger,
+		backend: backend,
+	}
+}
+
+func (s *locationServiceImpl) SaveGeoLocation(address common.Address, location *contracts.GeoLocation) error {
+	if err := location.Validate(); err != nil {
+		return fmt.Errorf("validate location: %w", err)
+	}
+
+	var geoLocation model.GeoLocation
+	if err := s.db.Where(&model.GeoLocation{Address: address.Bytes()}).
+		Assign(model.GeoLocation{
+			Country:        strings.ToUpper(location.Country),
+			Latitude:       location.Latitude,
+			Longitude:      location.Longitude,
+			HasCoordinates: location.HasCoordinates,
+		}).
+		FirstOrCreate(&geoLocation).Error; err != nil {
+		return fmt.Errorf("save location of %s: %w", address.Hex(), err)
+	}
+	return nil
+}
+
+// SaveGeoLocationOnReceipt saves the location of a broker or device in the background once the
+// transaction creating or updating it succeeded, so failed transactions don't change the location.
+func (s *locationServiceImpl) SaveGeoLocationOnReceipt(
+	tx *types.Transaction,
+	address common.Address,
+	location *contracts.GeoLocation,
+) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), receiptTimeout)
+		defer cancel()
+		if err := s.saveGeoLocationOnReceipt(ctx, tx, address, location); err != nil {
+			s.logger.Errorf("save location of %s: %v", address.Hex(), err)
+		}
+	}()
+}
+
+func (s *locationServiceImpl) saveGeoLocationOnReceipt(
+	ctx context.Context,
+	tx *types.Transaction,
+	address common.Address,
+	location *contracts.GeoLocation,
+) error {
+	receipt, err := bind.WaitMined(ctx, s.backend, tx)
+	if err != nil {
+		return fmt.Errorf("wait for transaction %s: %w", tx.Hash().Hex(), err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return fmt.Errorf("transaction %s failed", tx.Hash().Hex())
+	}
+	return s.SaveGeoLocation(address, location)
+}
+
+func (s *locationServiceImpl) FindGeoLocation(address common.Address) (*contracts.GeoLocation, error) {
+	var geoLocation model.GeoLocation
+	err := s.db.Where(&model.GeoLocation{Address: address.Bytes()}).First(&geoLocation).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find location of %s: %w", address.Hex(), err)
+	}
+	return geoLocationFromModel(&geoLocation), nil
+}
+
+// ResolveBroker sets the geographic location saved by the proxy for a broker, if there is one.
+func (s *locationServiceImpl) ResolveBroker(broker *contracts.Broker) error {
+	return s.ResolveBrokers([]*contracts.Broker{broker})
+}
+
+// ResolveBrokers sets the geographic locations saved by the proxy for brokers with a single query.
+func (s *locationServiceImpl) ResolveBrokers(brokers []*contracts.Broker) error {
+	if len(brokers) == 0 {
+		return nil
+	}
+	addresses := make([][]byte, len(brokers))
+	for i, broker := range brokers {
+		addresses[i] = broker.Addr.Bytes()
+	}
+
+	var geoLocations []model.GeoLocation
+	if err := s.db.Where("address IN (?)", addresses).Find(&geoLocations).Error; err != nil {
+		return fmt.Errorf("find locations of %d brokers: %w", len(brokers), err)
+	}
+	byAddress := make(map[common.Address]*contracts.GeoLocation, len(geoLocations))
+	for i := range geoLocations {
+		byAddress[common.BytesToAddress(geoLocations[i].Address)] = geoLocationFromModel(&geoLocations[i])
+	}
+	for _, broker := range brokers {
+		broker.GeoLocation = byAddress[broker.Addr]
+	}
+	return nil
+}
+
+func (s *locationServiceImpl) ResolveDevice(device *contracts.Device) error {
+	location, err := s.FindGeoLocation(device.Addr)
+	if err != nil {
+		return err
+	}
+	device.GeoLocation = location
+	return nil
+}
+
+func geoLocationFromModel(geoLocation *model.GeoLocation) *contracts.GeoLocation {
+	return &contracts.GeoLocation{
+		Country:        geoLocation.Country,
+		Latitude:       geoLocation.Latitude,
+		Longitude:      geoLocation.Longitude,
+		HasCoordinates: geoLocation.HasCoordinates,
+	}
+}
+
+func applyGeoLocation(broker *contracts.Broker) error {
+	if err := broker.GeoLocation.Validate(); err != nil {
+		return fmt.Errorf("validate location: %w", err)
+	}
+	location, err := broker.GeoLocation.Location()
+	if err != nil {
+		return fmt.Errorf("find region of location: %w", err)
+	}
+	broker.Location = location
+	return nil
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/contracts"
+	"math/big"
+	"testing"
+)
+
+func TestResolveBrokers(t *testing.T) {
+	locationService := NewLocationServiceImpl(newTestDb(t), newTestLogger(), nil)
+	located := &contracts.Broker{Addr: common.HexToAddress("0x01"), Location: contracts.LocationEUW}
+	if err := locationService.SaveGeoLocation(located.Addr, &contracts.GeoLocation{Country: "de"}); err != nil {
+		t.Fatalf("save location: %v", err)
+	}
+	regionOnly := &contracts.Broker{Addr: common.HexToAddress("0x02"), Location: contracts.LocationEUW}
+
+	if err := locationService.ResolveBrokers([]*contracts.Broker{located, regionOnly}); err != nil {
+		t.Fatalf("resolve brokers: %v", err)
+	}
+	if located.GeoLocation == nil || located.GeoLocation.Country != "DE" {
+		t.Fatalf("resolved location %+v, want DE", located.GeoLocation)
+	}
+	if regionOnly.GeoLocation != nil {
+		t.Fatalf("resolved location %+v of broker without location", regionOnly.GeoLocation)
+	}
+}
+
+type fakeReceiptBackend struct {
+	receipts map[common.Hash]*types.Receipt
+}
+
+func (b fakeReceiptBackend) TransactionReceipt(_ context.Context, hash common.Hash) (*types.Receipt, error) {
+	return b.receipts[hash], nil
+}
+
+func (b fakeReceiptBackend) CodeAt(context.Context, common.Address, *big.Int) ([]byte, error) {
+	return nil, nil
+}
+
+func TestSaveGeoLocationOnReceipt(t *testing.T) {
+	succeeded := types.NewTransaction(1, common.Address{}, big.NewInt(0), 0, big.NewInt(0), nil)
+	failed := types.NewTransaction(2, common.Address{}, big.NewInt(0), 0, big.NewInt(0), nil)
+	backend := fakeReceiptBackend{receipts: map[common.Hash]*types.Receipt{
+		succeeded.Hash(): {Status: types.ReceiptStatusSuccessful},
+		failed.Hash():    {Status: types.ReceiptStatusFailed},
+	}}
+	locationService := NewLocationServiceImpl(newTestDb(t), newTestLogger(), backend)
+	ctx := context.Background()
+
+	address := common.HexToAddress("0x01")
+	if err := locationService.saveGeoLocationOnReceipt(ctx, failed, address, &contracts.GeoLocation{Country: "IN"}); err == nil {
+		t.Fatal("saved location of failed transaction")
+	}
+	if location, err := locationService.FindGeoLocation(address); err != nil || location != nil {
+		t.Fatalf("found location %+v, %v after failed transaction", location, err)
+	}
+
+	if err := locationService.saveGeoLocationOnReceipt(ctx, succeeded, address, &contracts.GeoLocation{Country: "IN"}); err != nil {
+		t.Fatalf("save location: %v", err)
+	}
+	if location, err := locationService.FindGeoLocation(address); err != nil || location == nil || location.Country != "IN" {
+		t.Fatalf("found location %+v, %v, want IN", location, err)
+	}
+}
+
+func TestMatchBrokerSearchQueryCountries(t *testing.T) {
+	located := &contracts.Broker{
+		Location:    contracts.LocationEUW,
+		GeoLocation: &contracts.GeoLocation{Country: "FR"},
+	}
+	regionOnly := &contracts.Broker{Location: contracts.LocationEUW}
+
+	tests := []struct {
+		countries  []string
+		located    bool
+		regionOnly bool
+	}{
+		{[]string{"FR"}, true, true},
+		{[]string{"de"}, false, true},
+		{[]string{"PL"}, false, false},
+		{[]string{"PL", "fr"}, true, true},
+	}
+	for _, test := range tests {
+		query := &BrokerSearchQuery{Countries: test.countries}
+		if matched := MatchBrokerSearchQuery(query, located); matched != test.located {
+			t.Errorf("countries %v matched located broker %t, want %t", test.countries, matched, test.located)
+		}
+		if matched := MatchBrokerSearchQuery(query, regionOnly); matched != test.regionOnly {
+			t.Errorf("countries %v matched region-only broker %t, want %t", test.countries, matched, test.regionOnly)
+		}
+	}
+}
+
+func TestValidateBrokerSearchQuery(t *testing.T) {
+	berlin := &contracts.GeoLocation{Latitude: 52.52, Longitude: 13.405, HasCoordinates: true}
+	queries := map[string]*BrokerSearchQuery{
+		"unknown country":       {Countries: []string{"XX"}},
+		"radius without near":   {Radius: 100},
+		"near without coords":   {Near: &contracts.GeoLocation{Latitude: 52.52}},
+		"latitude out of range": {Near: &contracts.GeoLocation{Latitude: 91, HasCoordinates: true}},
+		"negative radius":       {Near: berlin, Radius: -1},
+	}
+	for name, query := range queries {
+		if err := validateBrokerSearchQuery(query); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: validated with %v, want %s", name, err, codes.InvalidArgument)
+		}
+	}
+	if err := validateBrokerSearchQuery(&BrokerSearchQuery{Countries: []string{"de"}, Near: berlin, Radius: 100}); err != nil {
+		t.Errorf("valid query: %v", err)
+	}
+}
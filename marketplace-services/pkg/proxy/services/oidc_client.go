@@ -0,0 +1,352 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxOidcResponseSize limits the size of responses read from the identity provider.
+	maxOidcResponseSize = 1 << 20
+	// jwksRefreshInterval limits how often tokens with unknown key ids fetch the keys again.
+	jwksRefreshInterval = time.Minute
+)
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// OidcClient is a relying party of an OpenID Connect provider. It exchanges authorization codes for
+// ID tokens and verifies ID tokens against the published keys of the provider.
+type OidcClient struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	httpClient   *http.Client
+	discovery    *oidcDiscovery
+	keys         map[string]interface{}
+	fetchedAt    time.Time
+	sync.Mutex
+	// fetchMutex serializes requests for the discovery document and keys, so concurrent logins
+	// don't fetch them repeatedly. The embedded mutex is never held during requests.
+	fetchMutex sync.Mutex
+}
+
+func NewOidcClient(
+	issuer string,
+	clientID string,
+	clientSecret string,
+	redirectURL string,
+	scopes []string,
+	timeout time.Duration,
+) *OidcClient {
+	return &OidcClient{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		scopes:       scopes,
+		httpClient:   &http.Client{Timeout: timeout},
+	}
+}
+
+func (c *OidcClient) Issuer() string {
+	return c.issuer
+}
+
+// AuthCodeURL returns the URL of the provider the user is sent to for logging in. The code
+// challenge binds the authorization code to the verifier of the proxy (PKCE).
+func (c *OidcClient) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	discovery, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", c.clientID)
+	params.Set("redirect_uri", c.redirectURL)
+	params.Set("scope", strings.Join(c.scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code at the token endpoint and returns the raw ID token.
+func (c *OidcClient) Exchange(ctx context.Context, code string, verifier string) (string, error) {
+	discovery, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.redirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("create token request: %w", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token oidcTokenResponse
+	if err := decodeOidcResponse(resp.Body, &token); err != nil {
+		return "", fmt.Errorf("decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return "", fmt.Errorf("token endpoint responded with %s: %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("token response contains no id token")
+	}
+	return token.IDToken, nil
+}
+
+// Verify checks the signature, issuer, audience and lifetime of an ID token and returns its
+// claims. The nonce is only checked if it is not empty.
+func (c *OidcClient) Verify(ctx context.Context, rawIDToken string, nonce string) (jwt.MapClaims, error) {
+	if _, err := c.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return c.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parse id token: %w", err)
+	}
+
+	if !claims.VerifyIssuer(c.issuer, true) {
+		return nil, errors.New("id token issued by another issuer")
+	}
+	if !containsAudience(claims["aud"], c.clientID) {
+		return nil, errors.New("id token issued for another client")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("id token has no expiration time")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	if nonce != "" {
+		if claimed, _ := claims["nonce"].(string); claimed != nonce {
+			return nil, errors.New("id token nonce mismatch")
+		}
+	}
+	return claims, nil
+}
+
+func (c *OidcClient) discover(ctx context.Context) (*oidcDiscovery, error) {
+	if discovery := c.cachedDiscovery(); discovery != nil {
+		return discovery, nil
+	}
+
+	c.fetchMutex.Lock()
+	defer c.fetchMutex.Unlock()
+	if discovery := c.cachedDiscovery(); discovery != nil {
+		return discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := c.get(ctx, c.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("discover provider %s: %w", c.issuer, err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != c.issuer {
+		return nil, fmt.Errorf("provider %s announces issuer %s", c.issuer, discovery.Issuer)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.discovery = &discovery
+	return c.discovery, nil
+}
+
+func (c *OidcClient) cachedDiscovery() *oidcDiscovery {
+	c.Lock()
+	defer c.Unlock()
+	return c.discovery
+}
+
+// key returns the public key with the given id. The keys are fetched again if the id is unknown,
+// so keys rotated by the provider are picked up, but at most once per jwksRefreshInterval.
+func (c *OidcClient) key(ctx context.Context, kid string) (interface{}, error) {
+	if key := c.cachedKey(kid); key != nil {
+		return key, nil
+	}
+
+	c.fetchMutex.Lock()
+	defer c.fetchMutex.Unlock()
+	if key := c.cachedKey(kid); key != nil {
+		return key, nil
+	}
+
+	c.Lock()
+	fetchedAt, jwksURI := c.fetchedAt, c.discovery.JwksURI
+	c.Unlock()
+	if time.Since(fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+
+	var jwks struct {
+		Keys []*Jwk `json:"keys"`
+	}
+	if err := c.get(ctx, jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("get keys of provider %s: %w", c.issuer, err)
+	}
+
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwkToPublicKey(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	c.Lock()
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	c.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %s", kid)
+}
+
+func (c *OidcClient) cachedKey(kid string) interface{} {
+	c.Lock()
+	defer c.Unlock()
+	return c.keys[kid]
+}
+
+func (c *OidcClient) get(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+	return decodeOidcResponse(resp.Body, v)
+}
+
+func decodeOidcResponse(body io.Reader, v interface{}) error {
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxOidcResponseSize))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func containsAudience(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func jwkToPublicKey(jwk *Jwk) (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOidcClientLimitsKeyFetches(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	jwk, err := publicKeyToJwk(&signingKey{
+		kid:       "known",
+		method:    jwt.SigningMethodES256,
+		publicKey: &privateKey.PublicKey,
+	})
+	if err != nil {
+		t.Fatalf("convert key to jwk: %v", err)
+	}
+
+	var fetches int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(&oidcDiscovery{Issuer: server.URL, JwksURI: server.URL + "/jwks"})
+		case "/jwks":
+			atomic.AddInt32(&fetches, 1)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []*Jwk{jwk}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewOidcClient(server.URL, "client", "secret", server.URL+"/callback", nil, time.Second)
+	if _, err := client.discover(context.Background()); err != nil {
+		t.Fatalf("discover provider: %v", err)
+	}
+	if _, err := client.key(context.Background(), "known"); err != nil {
+		t.Fatalf("get known key: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := client.key(context.Background(), "unknown"); err == nil {
+			t.Fatal("got unknown key")
+		}
+	}
+	if fetches := atomic.LoadInt32(&fetches); fetches != 1 {
+		t.Fatalf("fetched keys %d times, want 1", fetches)
+	}
+
+	client.fetchedAt = time.Time{}
+	if _, err := client.key(context.Background(), "unknown"); err == nil {
+		t.Fatal("got unknown key")
+	}
+	if fetches := atomic.LoadInt32(&fetches); fetches != 2 {
+		t.Fatalf("fetched keys %d times, want 2", fetches)
+	}
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/proxy/model"
+	"time"
+)
+
+type OidcService interface {
+	BeginLogin(ctx context.Context) (string, string, error)
+	CompleteLogin(ctx context.Context, state string, code string) (*Tokens, error)
+	ExchangeIDToken(ctx context.Context, idToken string) (*Tokens, error)
+	LinkExternalIdentity(ctx context.Context, accountId uint, subject string) error
+	UnlinkExternalIdentity(ctx context.Context, accountId uint) error
+}
+
+// OidcMapping configures how verified identities of the provider are mapped to proxy accounts.
+// Identities with a value of AdminValues in their role claim become admins. All other identities
+// become users if UserValues is empty or contains one of their values and are rejected otherwise.
+type OidcMapping struct {
+	UsernameClaim string
+	RoleClaim     string
+	AdminValues   []string
+	UserValues    []string
+	AutoProvision bool
+	LoginTimeout  time.Duration
+}
+
+type oidcServiceImpl struct {
+	db          *gorm.DB
+	logger      logrus.FieldLogger
+	client      *OidcClient
+	authService AuthService
+	mapping     OidcMapping
+	bcryptCost  int
+}
+
+func NewOidcServiceImpl(
+	db *gorm.DB,
+	logger logrus.FieldLogger,
+	client *OidcClient,
+	authService AuthService,
+	mapping OidcMapping,
+	bcryptCost int,
+) *oidcServiceImpl {
+	return &oidcServiceImpl{
+		db:          db,
+		logger:      logger,
+		client:      client,
+		authService: authService,
+		mapping:     mapping,
+		bcryptCost:  bcryptCost,
+	}
+}
+
+// BeginLogin starts an authorization code login and returns the URL of the provider the user has
+// to visit and the state the login is completed with.
+func (s *oidcServiceImpl) BeginLogin(ctx context.Context) (string, string, error) {
+	now := time.Now()
+	err := s.db.Unscoped().Where("expires_at < ?", now).Delete(&model.OidcLogin{}).Error
+	if err != nil {
+		return "", "", fmt.Errorf("delete expired oidc logins: %w", err)
+	}
+
+	login := &model.OidcLogin{ExpiresAt: now.Add(s.mapping.LoginTimeout)}
+	for _, value := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		if *value, err = randomString(); err != nil {
+			return "", "", err
+		}
+	}
+
+	authURL, err := s.client.AuthCodeURL(ctx, login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		return "", "", status.Errorf(codes.Unavailable, "get authorization url: %s", err)
+	}
+	if err := s.db.Create(login).Error; err != nil {
+		return "", "", fmt.Errorf("create oidc login: %w", err)
+	}
+	return authURL, login.State, nil
+}
+
+// CompleteLogin redeems the authorization code the provider redirected the user with and issues
+// proxy tokens for the account of the identity. Each state can only be used once.
+func (s *oidcServiceImpl) CompleteLogin(ctx context.Context, state string, code string) (*Tokens, error) {
+	var login model.OidcLogin
+	err := s.db.Where(&model.OidcLogin{State: state}).First(&login).Error
+	if gorm.IsRecordNotFoundError(err) || state == "" {
+		return nil, status.Error(codes.Unauthenticated, "unknown oidc login state")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get oidc login: %w", err)
+	}
+	if err := s.db.Unscoped().Delete(&login).Error; err != nil {
+		return nil, fmt.Errorf("delete oidc login: %w", err)
+	}
+	if time.Now().After(login.ExpiresAt) {
+		return nil, status.Error(codes.Unauthenticated, "oidc login expired")
+	}
+
+	idToken, err := s.client.Exchange(ctx, code, login.Verifier)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "exchange authorization code: %s", err)
+	}
+	return s.login(ctx, idToken, login.Nonce)
+}
+
+// ExchangeIDToken issues proxy tokens for an ID token the client got from the provider itself. The
+// token must be issued for the client id of the proxy.
+func (s *oidcServiceImpl) ExchangeIDToken(ctx context.Context, idToken string) (*Tokens, error) {
+	return s.login(ctx, idToken, "")
+}
+
+func (s *oidcServiceImpl) LinkExternalIdentity(_ context.Context, accountId uint, subject string) error {
+	if subject == "" {
+		return status.Error(codes.InvalidArgument, "empty subject")
+	}
+
+	var account model.Account
+	err := s.db.First(&account, accountId).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return status.Errorf(codes.NotFound, "account %d not found", accountId)
+	}
+	if err != nil {
+		return fmt.Errorf("get account %d: %w", accountId, err)
+	}
+
+	identity := &model.ExternalIdentity{Issuer: s.client.Issuer(), Subject: subject, AccountID: accountId}
+	if err := s.db.Create(identity).Error; err != nil {
+		return status.Errorf(codes.AlreadyExists, "link identity %s to account %d: %s", subject, accountId, err)
+	}
+	s.logger.Infof("Linked identity %s of %s to account %s", subject, identity.Issuer, account.Name)
+	return nil
+}
+
+func (s *oidcServiceImpl) UnlinkExternalIdentity(_ context.Context, accountId uint) error {
+	if accountId == 0 {
+		return status.Error(codes.InvalidArgument, "account id missing")
+	}
+	s.logger.Infof("Unlinking external identities of account %d", accountId)
+	err := s.db.Unscoped().Where("account_id = ?", accountId).Delete(&model.ExternalIdentity{}).Error
+	if err != nil {
+		return fmt.Errorf("unlink external identities of account %d: %w", accountId, err)
+	}
+	return nil
+}
+
+func (s *oidcServiceImpl) login(ctx context.Context, idToken string, nonce string) (*Tokens, error) {
+	claims, err := s.client.Verify(ctx, idToken, nonce)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "verify id token: %s", err)
+	}
+
+	account, err := s.resolveAccount(claims)
+	if err != nil {
+		return nil, err
+	}
+	return s.authService.IssueTokens(ctx, account)
+}
+
+// resolveAccount returns the account linked to the identity of the claims and updates its role.
+// Unknown identities get a new account if auto provisioning is enabled.
+func (s *oidcServiceImpl) resolveAccount(claims jwt.MapClaims) (*model.Account, error) {
+	subject, _ := claims["sub"].(string)
+	role, ok := s.role(claims)
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "identity %s has no proxy role", subject)
+	}
+
+	var identity model.ExternalIdentity
+	err := s.db.Where(&model.ExternalIdentity{Issuer: s.client.Issuer(), Subject: subject}).First(&identity).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return s.provisionAccount(claims, subject, role)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get external identity %s: %w", subject, err)
+	}
+
+	var account model.Account
+	err = s.db.First(&account, identity.AccountID).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, status.Errorf(codes.PermissionDenied, "account of identity %s deleted", subject)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get account %d: %w", identity.AccountID, err)
+	}
+
+	if account.Role != role {
+		s.logger.Infof("Changing role of account %s from %d to %d", account.Name, account.Role, role)
+		if err := s.db.Model(&account).Update("role", role).Error; err != nil {
+			return nil, fmt.Errorf("update role of account %s: %w", account.Name, err)
+		}
+	}
+	return &account, nil
+}
+
+func (s *oidcServiceImpl) provisionAccount(claims jwt.MapClaims, subject string, role model.Role) (*model.Account, error) {
+	if !s.mapping.AutoProvision {
+		return nil, status.Errorf(codes.PermissionDenied, "no account linked to identity %s", subject)
+	}
+
+	name, _ := claims[s.mapping.UsernameClaim].(string)
+	account := &model.Account{Name: name, Role: role}
+	if err := account.ValidateUpdate(); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "provision account for identity %s: %s", subject, err)
+	}
+
+	var count int
+	if err := s.db.Unscoped().Model(&model.Account{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("count accounts with name %s: %w", name, err)
+	}
+	if count > 0 {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"account %s exists but is not linked to identity %s",
+			name,
+			subject,
+		)
+	}
+
+	// The account can only log in through the provider, so its password is an unknown random value.
+	password, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	account.Password, err = bcrypt.GenerateFromPassword([]byte(password), s.bcryptCost)
+	if err != nil {
+		return nil, fmt.Errorf("generate hash from password: %w", err)
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("begin transaction: %w", tx.Error)
+	}
+	if err := tx.Create(account).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("create account %s: %w", name, err)
+	}
+	identity := &model.ExternalIdentity{Issuer: s.client.Issuer(), Subject: subject, AccountID: account.ID}
+	if err := tx.Create(identity).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("create external identity %s: %w", subject, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	s.logger.Infof("Provisioned account %s for identity %s of %s", name, subject, identity.Issuer)
+	return account, nil
+}
+
+func (s *oidcServiceImpl) role(claims jwt.MapClaims) (model.Role, bool) {
+	var values []string
+	switch claim := claims[s.mapping.RoleClaim].(type) {
+	case string:
+		values = []string{claim}
+	case []interface{}:
+		for _, value := range claim {
+			if value, ok := value.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+
+	if containsAny(s.mapping.AdminValues, values) {
+		return model.RoleAdmin, true
+	}
+	if len(s.mapping.UserValues) == 0 || containsAny(s.mapping.UserValues, values) {
+		return model.RoleUser, true
+	}
+	return 0, false
+}
+
+func containsAny(allowed []string, values []string) bool {
+	for _, a := range allowed {
+		for _, v := range values {
+			if a == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate random string: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"context"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/proxy/model"
+	"testing"
+)
+
+func TestUnlinkExternalIdentity(t *testing.T) {
+	db := newTestDb(t)
+	service := NewOidcServiceImpl(db, newTestLogger(), nil, nil, OidcMapping{}, bcrypt.MinCost)
+	alice := createTestAccount(t, db, "alice", "correct password")
+	bob := createTestAccount(t, db, "bob", "correct password")
+	for _, account := range []*model.Account{alice, bob} {
+		identity := &model.ExternalIdentity{Issuer: "https://idp", Subject: account.Name, AccountID: account.ID}
+		if err := db.Create(identity).Error; err != nil {
+			t.Fatalf("create external identity: %v", err)
+		}
+	}
+
+	if err := service.UnlinkExternalIdentity(context.Background(), 0); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("unlinked identities of account 0 with %v, want %s", err, codes.InvalidArgument)
+	}
+	if err := service.UnlinkExternalIdentity(context.Background(), alice.ID); err != nil {
+		t.Fatalf("unlink external identity: %v", err)
+	}
+
+	var identities []*model.ExternalIdentity
+	if err := db.Unscoped().Find(&identities).Error; err != nil {
+		t.Fatalf("find external identities: %v", err)
+	}
+	if len(identities) != 1 || identities[0].AccountID != bob.ID {
+		t.Fatalf("%d external identities left, want only the one of bob", len(identities))
+	}
+}
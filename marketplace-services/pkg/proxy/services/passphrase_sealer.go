@@ -0,0 +1,74 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const sealedPrefix = "sealed:"
+
+type PassphraseSealer interface {
+	Seal(passphrase string) (string, error)
+	Open(sealed string) (string, error)
+	IsSealed(passphrase string) bool
+}
+
+type aesPassphraseSealer struct {
+	aead cipher.AEAD
+}
+
+// NewAESPassphraseSealer creates a sealer encrypting wallet passphrases with AES-256-GCM. The key is
+// derived from the master key, which should be a long random secret.
+func NewAESPassphraseSealer(masterKey string) (*aesPassphraseSealer, error) {
+	if masterKey == "" {
+		return nil, errors.New("empty master key")
+	}
+	key := sha256.Sum256([]byte(masterKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("new cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("new gcm: %w", err)
+	}
+	return &aesPassphraseSealer{aead: aead}, nil
+}
+
+func (s *aesPassphraseSealer) Seal(passphrase string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(passphrase), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *aesPassphraseSealer) Open(sealed string) (string, error) {
+	if !s.IsSealed(sealed) {
+		return "", errors.New("passphrase is not sealed")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("decode sealed passphrase: %w", err)
+	}
+	if len(data) < s.aead.NonceSize() {
+		return "", errors.New("sealed passphrase too short")
+	}
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	passphrase, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("open sealed passphrase: %w", err)
+	}
+	return string(passphrase), nil
+}
+
+func (s *aesPassphraseSealer) IsSealed(passphrase string) bool {
+	return strings.HasPrefix(passphrase, sealedPrefix)
+}
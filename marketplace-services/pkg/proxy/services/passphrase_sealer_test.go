@@ -0,0 +1,50 @@
+package services
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestPassphraseSealer(t *testing.T) {
+	sealer, err := NewAESPassphraseSealer("test master key")
+	if err != nil {
+		t.Fatalf("new passphrase sealer: %v", err)
+	}
+
+	sealed, err := sealer.Seal("wallet passphrase")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if !sealer.IsSealed(sealed) || strings.Contains(sealed, "wallet passphrase") {
+		t.Fatalf("sealed passphrase %q not sealed", sealed)
+	}
+	if again, _ := sealer.Seal("wallet passphrase"); again == sealed {
+		t.Fatal("passphrase sealed twice with the same nonce")
+	}
+	if passphrase, err := sealer.Open(sealed); err != nil || passphrase != "wallet passphrase" {
+		t.Fatalf("open: got %q, %v", passphrase, err)
+	}
+
+	other, err := NewAESPassphraseSealer("other master key")
+	if err != nil {
+		t.Fatalf("new passphrase sealer: %v", err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	data[len(data)-1] ^= 1
+	tampered := sealedPrefix + base64.StdEncoding.EncodeToString(data)
+	for name, open := range map[string]func() (string, error){
+		"other master key": func() (string, error) { return other.Open(sealed) },
+		"tampered":         func() (string, error) { return sealer.Open(tampered) },
+		"plaintext":        func() (string, error) { return sealer.Open("wallet passphrase") },
+		"truncated":        func() (string, error) { return sealer.Open(sealedPrefix + "AAAA") },
+	} {
+		if _, err := open(); err == nil {
+			t.Errorf("%s: opened", name)
+		}
+	}
+
+	if _, err := NewAESPassphraseSealer(""); err == nil {
+		t.Fatal("created sealer with empty master key")
+	}
+}
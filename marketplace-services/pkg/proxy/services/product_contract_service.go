@@ -10,6 +10,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/sirupsen/logrus"
 	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/proxy/model"
 	"math/big"
 )
 
@@ -47,6 +48,9 @@ func NewProductContractService(
 }
 
 func (s productContractServiceImpl) CreateProduct(ctx context.Context, product *contracts.Product) (*types.Transaction, error) {
+	if err := checkDeviceScope(ctx, product.Device); err != nil {
+		return nil, err
+	}
 	w, err := s.walletService.FindWalletByAuthenticatedAccount(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("find wallet of authenticated proxy account: %w", err)
@@ -74,6 +78,9 @@ func (s productContractServiceImpl) CreateProduct(ctx context.Context, product *
 }
 
 func (s productContractServiceImpl) UpdateProduct(ctx context.Context, product *contracts.Product) (*types.Transaction, error) {
+	if err := s.checkProductScope(ctx, product.Id); err != nil {
+		return nil, err
+	}
 	w, err := s.walletService.FindWalletByAuthenticatedAccount(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("find wallet of authenticated proxy account: %w", err)
@@ -101,6 +108,9 @@ func (s productContractServiceImpl) UpdateProduct(ctx context.Context, product *
 }
 
 func (s productContractServiceImpl) RemoveProduct(ctx context.Context, id *big.Int) (*types.Transaction, error) {
+	if err := s.checkProductScope(ctx, id); err != nil {
+		return nil, err
+	}
 	w, err := s.walletService.FindWalletByAuthenticatedAccount(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("find wallet of authenticated proxy account: %w", err)
@@ -127,6 +137,20 @@ func (s productContractServiceImpl) RemoveProduct(ctx context.Context, id *big.I
 	return s.productContract.RemoveProduct(transactOpts, id)
 }
 
+// checkProductScope rejects changes to products of other devices than the one the API key of the
+// call is scoped to.
+func (s productContractServiceImpl) checkProductScope(ctx context.Context, id *big.Int) error {
+	key, ok := ctx.Value("apiKey").(*model.ApiKey)
+	if !ok || key.Device == "" {
+		return nil
+	}
+	product, err := s.FindProductById(ctx, id)
+	if err != nil {
+		return fmt.Errorf("find product by id %d: %w", id, err)
+	}
+	return checkDeviceScope(ctx, product.Device)
+}
+
 func (s productContractServiceImpl) FindProductByIndex(ctx context.Context, index *big.Int) (*contracts.Product, error) {
 	w, err := s.walletService.FindWalletByAuthenticatedAccount(ctx)
 	if err != nil {
@@ -0,0 +1,473 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/contracts/bindings"
+	"marketplace-services/pkg/marketplace"
+	"marketplace-services/pkg/proxy/model"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ProductEventCreated = "created"
+	ProductEventUpdated = "updated"
+
+	notificationBufferSize = 16
+	webhookQueueSize       = 256
+)
+
+type ProductNotification struct {
+	SavedSearch uint
+	Event       string
+	Product     *contracts.Product
+}
+
+type SavedSearchService interface {
+	CreateSavedSearch(ctx context.Context, search *model.SavedSearch) (*model.SavedSearch, string, error)
+	FindSavedSearches(ctx context.Context) ([]*model.SavedSearch, error)
+	DeleteSavedSearch(ctx context.Context, id uint) error
+	WatchSavedSearch(ctx context.Context, id uint) (<-chan *ProductNotification, <-chan error)
+	NotifySavedSearches(ctx context.Context)
+}
+
+// WebhookPolicy configures the delivery of saved search webhooks. Webhooks are delivered by a fixed
+// number of workers, notifications exceeding the queue are dropped. Private targets should only be
+// allowed in test setups.
+type WebhookPolicy struct {
+	Timeout             time.Duration
+	Workers             int
+	AllowPrivateTargets bool
+}
+
+type webhookDelivery struct {
+	url          string
+	sealedSecret string
+	notification *ProductNotification
+}
+
+type savedSearchServiceImpl struct {
+	db              *gorm.DB
+	logger          logrus.FieldLogger
+	productContract contracts.ProductContract
+	deviceContract  contracts.DeviceContract
+	sealer          PassphraseSealer
+	policy          WebhookPolicy
+	resolver        *net.Resolver
+	httpClient      *http.Client
+	webhooks        chan *webhookDelivery
+	subscribers     map[uint]map[chan *ProductNotification]bool
+	sync.Mutex
+}
+
+func NewSavedSearchServiceImpl(
+	db *gorm.DB,
+	logger logrus.FieldLogger,
+	productContract contracts.ProductContract,
+	deviceContract contracts.DeviceContract,
+	sealer PassphraseSealer,
+	policy WebhookPolicy,
+) *savedSearchServiceImpl {
+	if policy.Workers < 1 {
+		policy.Workers = 1
+	}
+	return &savedSearchServiceImpl{
+		db:              db,
+		logger:          logger,
+		productContract: productContract,
+		deviceContract:  deviceContract,
+		sealer:          sealer,
+		policy:          policy,
+		resolver:        net.DefaultResolver,
+		httpClient:      newWebhookClient(policy.Timeout, policy.AllowPrivateTargets),
+		webhooks:        make(chan *webhookDelivery, webhookQueueSize),
+		subscribers:     make(map[uint]map[chan *ProductNotification]bool),
+	}
+}
+
+// CreateSavedSearch saves a search of the authenticated account. If the search has a webhook, the
+// returned secret signs its requests. It is only stored sealed and can't be retrieved later.
+func (s *savedSearchServiceImpl) CreateSavedSearch(
+	ctx context.Context,
+	search *model.SavedSearch,
+) (*model.SavedSearch, string, error) {
+	account, ok := ctx.Value("principal").(model.Account)
+	if !ok {
+		return nil, "", fmt.Errorf("extract account from context")
+	}
+
+	search.ID = 0
+	search.AccountID = account.ID
+	search.WebhookSecret = ""
+	if err := search.Validate(); err != nil {
+		return nil, "", status.Errorf(codes.InvalidArgument, "validate saved search: %s", err)
+	}
+
+	var secret string
+	if search.WebhookURL != "" {
+		if !s.policy.AllowPrivateTargets {
+			if err := checkWebhookURL(ctx, s.resolver, search.WebhookURL); err != nil {
+				return nil, "", status.Errorf(codes.InvalidArgument, "validate webhook url: %s", err)
+			}
+		}
+		var err error
+		if secret, err = randomString(); err != nil {
+			return nil, "", err
+		}
+		if search.WebhookSecret, err = s.sealer.Seal(secret); err != nil {
+			return nil, "", fmt.Errorf("seal webhook secret: %w", err)
+		}
+	}
+
+	if err := s.db.Create(search).Error; err != nil {
+		return nil, "", fmt.Errorf("create saved search for account %d: %w", account.ID, err)
+	}
+	return search, secret, nil
+}
+
+func (s *savedSearchServiceImpl) FindSavedSearches(ctx context.Context) ([]*model.SavedSearch, error) {
+	account, ok := ctx.Value("principal").(model.Account)
+	if !ok {
+		return nil, fmt.Errorf("extract account from context")
+	}
+
+	var searches []*model.SavedSearch
+	err := s.db.Where(&model.SavedSearch{AccountID: account.ID}).Find(&searches).Error
+	if err != nil {
+		return nil, fmt.Errorf("get saved searches of account %d: %w", account.ID, err)
+	}
+	return searches, nil
+}
+
+func (s *savedSearchServiceImpl) DeleteSavedSearch(ctx context.Context, id uint) error {
+	search, err := s.findOwnSavedSearch(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Delete(search).Error; err != nil {
+		return fmt.Errorf("delete saved search %d: %w", id, err)
+	}
+	return nil
+}
+
+func (s *savedSearchServiceImpl) WatchSavedSearch(
+	ctx context.Context,
+	id uint,
+) (<-chan *ProductNotification, <-chan error) {
+	sink := make(chan *ProductNotification)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(sink)
+		defer close(errc)
+
+		if _, err := s.findOwnSavedSearch(ctx, id); err != nil {
+			errc <- err
+			return
+		}
+
+		notifications := s.subscribe(id)
+		defer s.unsubscribe(id, notifications)
+
+		for {
+			select {
+			case notification := <-notifications:
+				select {
+				case sink <- notification:
+				case <-ctx.Done():
+					errc <- ctx.Err()
+					return
+				}
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return sink, errc
+}
+
+// NotifySavedSearches watches for created and updated products and notifies the subscribers and
+// webhooks of all saved searches matching them until the context is cancelled. Failed
+// subscriptions are renewed with backoff.
+func (s *savedSearchServiceImpl) NotifySavedSearches(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := 0; i < s.policy.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.deliverWebhooks(ctx)
+		}()
+	}
+
+	eventSync := &contracts.EventSync{
+		Name:   "saved search notifications",
+		Logger: s.logger,
+		Watches: []contracts.EventWatch{
+			func(opts *bind.WatchOpts) (interface{}, event.Subscription, error) {
+				sink := make(chan *bindings.ProductContractCreatedProduct)
+				sub, err := s.productContract.WatchCreatedProductEvent(opts, sink, nil)
+				return sink, sub, err
+			},
+			func(opts *bind.WatchOpts) (interface{}, event.Subscription, error) {
+				sink := make(chan *bindings.ProductContractUpdatedProduct)
+				sub, err := s.productContract.WatchUpdatedProductEvent(opts, sink, nil, nil)
+				return sink, sub, err
+			},
+		},
+		Apply: func(ctx context.Context, e interface{}) error {
+			switch e := e.(type) {
+			case *bindings.ProductContractCreatedProduct:
+				if err := s.notify(ctx, ProductEventCreated, e.Id); err != nil {
+					s.logger.Errorf("notify saved searches of created product %d: %v", e.Id, err)
+				}
+			case *bindings.ProductContractUpdatedProduct:
+				if err := s.notify(ctx, ProductEventUpdated, e.Id); err != nil {
+					s.logger.Errorf("notify saved searches of updated product %d: %v", e.Id, err)
+				}
+			}
+			return nil
+		},
+	}
+	eventSync.Run(ctx)
+}
+
+func (s *savedSearchServiceImpl) notify(ctx context.Context, event string, id *big.Int) error {
+	callOpts := &bind.CallOpts{Context: ctx, From: common.BytesToAddress(nil)}
+	product, err := s.productContract.FindProductById(callOpts, id)
+	if err != nil {
+		return fmt.Errorf("find product by id %d: %w", id, err)
+	}
+	if product.Deleted {
+		return nil
+	}
+
+	searches, err := s.findActiveSavedSearches()
+	if err != nil {
+		return err
+	}
+
+	var device *contracts.Device
+	for _, search := range searches {
+		query := SavedSearchToProductSearchQuery(search)
+		if !marketplace.MatchSearchQuery(query, product) {
+			continue
+		}
+		if query.MinRating > 0 || query.User != "" {
+			if device == nil {
+				device, err = s.deviceContract.FindDeviceByAddress(callOpts, product.Device)
+				if err != nil {
+					return fmt.Errorf("find device by address %s: %w", product.Device.Hex(), err)
+				}
+			}
+			if device.Rating.Uint64() < query.MinRating {
+				continue
+			}
+			if query.User != "" && common.HexToAddress(device.User) != common.HexToAddress(query.User) {
+				continue
+			}
+		}
+
+		notification := &ProductNotification{SavedSearch: search.ID, Event: event, Product: product}
+		s.publish(notification)
+		if search.WebhookURL != "" {
+			s.enqueueWebhook(&webhookDelivery{
+				url:          search.WebhookURL,
+				sealedSecret: search.WebhookSecret,
+				notification: notification,
+			})
+		}
+	}
+	return nil
+}
+
+// findActiveSavedSearches returns the saved searches of accounts that are neither disabled nor
+// deleted.
+func (s *savedSearchServiceImpl) findActiveSavedSearches() ([]*model.SavedSearch, error) {
+	var searches []*model.SavedSearch
+	err := s.db.Select("saved_searches.*").
+		Joins("JOIN accounts ON accounts.id = saved_searches.account_id").
+		Where("accounts.deleted_at IS NULL AND accounts.disabled = ?", false).
+		Find(&searches).Error
+	if err != nil {
+		return nil, fmt.Errorf("get saved searches of active accounts: %w", err)
+	}
+	return searches, nil
+}
+
+func (s *savedSearchServiceImpl) publish(notification *ProductNotification) {
+	s.Lock()
+	defer s.Unlock()
+	for subscriber := range s.subscribers[notification.SavedSearch] {
+		select {
+		case subscriber <- notification:
+		default:
+			s.logger.Warnf(
+				"Dropping notification of product %d for slow subscriber of saved search %d",
+				notification.Product.Id,
+				notification.SavedSearch,
+			)
+		}
+	}
+}
+
+func (s *savedSearchServiceImpl) enqueueWebhook(delivery *webhookDelivery) {
+	select {
+	case s.webhooks <- delivery:
+	default:
+		s.logger.Warnf(
+			"Dropping webhook of saved search %d for product %d, delivery queue full",
+			delivery.notification.SavedSearch,
+			delivery.notification.Product.Id,
+		)
+	}
+}
+
+func (s *savedSearchServiceImpl) deliverWebhooks(ctx context.Context) {
+	for {
+		select {
+		case delivery := <-s.webhooks:
+			s.callWebhook(ctx, delivery)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *savedSearchServiceImpl) callWebhook(ctx context.Context, delivery *webhookDelivery) {
+	notification := delivery.notification
+	body, err := json.Marshal(ProductNotificationToWebhookPayload(notification))
+	if err != nil {
+		s.logger.Errorf("marshal notification of saved search %d: %v", notification.SavedSearch, err)
+		return
+	}
+	if delivery.sealedSecret == "" {
+		s.logger.Warnf("Skipping webhook of saved search %d without signing secret", notification.SavedSearch)
+		return
+	}
+	secret, err := s.sealer.Open(delivery.sealedSecret)
+	if err != nil {
+		s.logger.Errorf("open webhook secret of saved search %d: %v", notification.SavedSearch, err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, delivery.url, bytes.NewReader(body))
+	if err != nil {
+		s.logger.Errorf("create webhook request of saved search %d: %v", notification.SavedSearch, err)
+		return
+	}
+	req = req.WithContext(ctx)
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, signWebhook(secret, timestamp, body))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		s.logger.Warnf("call webhook of saved search %d: %v", notification.SavedSearch, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		s.logger.Warnf("webhook of saved search %d responded with %s", notification.SavedSearch, resp.Status)
+	}
+}
+
+func (s *savedSearchServiceImpl) subscribe(id uint) chan *ProductNotification {
+	s.Lock()
+	defer s.Unlock()
+	notifications := make(chan *ProductNotification, notificationBufferSize)
+	if s.subscribers[id] == nil {
+		s.subscribers[id] = make(map[chan *ProductNotification]bool)
+	}
+	s.subscribers[id][notifications] = true
+	return notifications
+}
+
+func (s *savedSearchServiceImpl) unsubscribe(id uint, notifications chan *ProductNotification) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.subscribers[id], notifications)
+	if len(s.subscribers[id]) == 0 {
+		delete(s.subscribers, id)
+	}
+}
+
+func (s *savedSearchServiceImpl) findOwnSavedSearch(ctx context.Context, id uint) (*model.SavedSearch, error) {
+	account, ok := ctx.Value("principal").(model.Account)
+	if !ok {
+		return nil, fmt.Errorf("extract account from context")
+	}
+
+	var search model.SavedSearch
+	err := s.db.Where(&model.SavedSearch{AccountID: account.ID}).First(&search, id).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, status.Errorf(codes.NotFound, "saved search %d not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get saved search %d: %w", id, err)
+	}
+	return &search, nil
+}
+
+func SavedSearchToProductSearchQuery(search *model.SavedSearch) *marketplace.ProductSearchQuery {
+	return &marketplace.ProductSearchQuery{
+		DataType:     search.DataType,
+		MinCost:      search.MinCost,
+		MaxCost:      search.MaxCost,
+		MinFrequency: search.MinFrequency,
+		MaxFrequency: search.MaxFrequency,
+		Text:         search.Text,
+		MinRating:    search.MinRating,
+		Device:       search.Device,
+		User:         search.User,
+	}
+}
+
+type webhookPayload struct {
+	SavedSearch uint           `json:"savedSearch"`
+	Event       string         `json:"event"`
+	Product     webhookProduct `json:"product"`
+}
+
+type webhookProduct struct {
+	Id          uint64 `json:"id"`
+	Device      string `json:"device"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	DataType    string `json:"dataType"`
+	Frequency   uint64 `json:"frequency"`
+	Cost        uint64 `json:"cost"`
+}
+
+func ProductNotificationToWebhookPayload(notification *ProductNotification) *webhookPayload {
+	product := notification.Product
+	return &webhookPayload{
+		SavedSearch: notification.SavedSearch,
+		Event:       notification.Event,
+		Product: webhookProduct{
+			Id:          product.Id.Uint64(),
+			Device:      product.Device.Hex(),
+			Name:        product.Name,
+			Description: product.Description,
+			DataType:    product.DataType,
+			Frequency:   product.Frequency.Uint64(),
+			Cost:        product.Cost.Uint64(),
+		},
+	}
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"marketplace-services/pkg/proxy/model"
+	"testing"
+)
+
+func TestFindActiveSavedSearches(t *testing.T) {
+	db := newTestDb(t)
+	service := NewSavedSearchServiceImpl(db, newTestLogger(), nil, nil, nil, WebhookPolicy{})
+
+	for _, name := range []string{"alice", "bob", "carol"} {
+		account := createTestAccount(t, db, name, "correct password")
+		search := &model.SavedSearch{AccountID: account.ID, Name: name}
+		if err := db.Create(search).Error; err != nil {
+			t.Fatalf("create saved search: %v", err)
+		}
+		switch name {
+		case "bob":
+			if err := db.Model(account).Update("disabled", true).Error; err != nil {
+				t.Fatalf("disable account: %v", err)
+			}
+		case "carol":
+			if err := db.Delete(account).Error; err != nil {
+				t.Fatalf("delete account: %v", err)
+			}
+		}
+	}
+
+	searches, err := service.findActiveSavedSearches()
+	if err != nil {
+		t.Fatalf("find active saved searches: %v", err)
+	}
+	if len(searches) != 1 || searches[0].Name != "alice" {
+		t.Fatalf("found %d saved searches, want only the one of alice", len(searches))
+	}
+}
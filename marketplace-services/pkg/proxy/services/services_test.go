@@ -0,0 +1,74 @@
+package services
+
+import (
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"marketplace-services/pkg/proxy/model"
+	"testing"
+	"time"
+)
+
+func newTestLogger() logrus.FieldLogger {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	return logger
+}
+
+// newTestDb opens an in-memory database with the schema of the proxy. It is limited to a single
+// connection, because every connection to an in-memory database opens a database of its own.
+func newTestDb(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	db.DB().SetMaxOpenConns(1)
+	err = db.AutoMigrate(
+		&model.Account{},
+		&model.Wallet{},
+		&model.GeoLocation{},
+		&model.SavedSearch{},
+		&model.Token{},
+		&model.DerivedKey{},
+		&model.ApiKey{},
+		&model.SigningKey{},
+		&model.ExternalIdentity{},
+		&model.OidcLogin{},
+	).Error
+	if err != nil {
+		t.Fatalf("migrate db: %v", err)
+	}
+	return db
+}
+
+func newTestAccountService(t *testing.T, db *gorm.DB) *accountServiceImpl {
+	sealer, err := NewAESPassphraseSealer("test master key")
+	if err != nil {
+		t.Fatalf("new passphrase sealer: %v", err)
+	}
+	accountService, err := NewAccountServiceImpl(db, newTestLogger(), sealer, LoginPolicy{
+		BcryptCost:         bcrypt.MinCost,
+		MaxFailedLogins:    3,
+		LockoutDuration:    time.Minute,
+		MaxLockoutDuration: time.Hour,
+		TotpIssuer:         "test",
+	})
+	if err != nil {
+		t.Fatalf("new account service: %v", err)
+	}
+	return accountService
+}
+
+func createTestAccount(t *testing.T, db *gorm.DB, name string, password string) *model.Account {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	account := &model.Account{Name: name, Password: hash, Role: model.RoleUser}
+	if err := db.Create(account).Error; err != nil {
+		t.Fatalf("create account %s: %v", name, err)
+	}
+	return account
+}
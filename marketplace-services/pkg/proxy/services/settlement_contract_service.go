@@ -52,6 +52,9 @@ func (s settlementContractServiceImpl) Deposit(ctx context.Context, amount *big.
 	}
 
 	account := accounts.Account{Address: common.BytesToAddress(w.Address)}
+	if err := checkDeviceScope(ctx, account.Address); err != nil {
+		return nil, err
+	}
 	transactOpts, err := bind.NewKeyStoreTransactor(
 		s.keyStore,
 		account,
@@ -80,6 +83,9 @@ func (s settlementContractServiceImpl) SettleTrade(ctx context.Context, counter
 	}
 
 	account := accounts.Account{Address: common.BytesToAddress(w.Address)}
+	if err := checkDeviceScope(ctx, account.Address); err != nil {
+		return nil, err
+	}
 	transactOpts, err := bind.NewKeyStoreTransactor(
 		s.keyStore,
 		account,
@@ -107,6 +113,9 @@ func (s settlementContractServiceImpl) ResolveDispute(ctx context.Context, count
 	}
 
 	account := accounts.Account{Address: common.BytesToAddress(w.Address)}
+	if err := checkDeviceScope(ctx, account.Address); err != nil {
+		return nil, err
+	}
 	transactOpts, err := bind.NewKeyStoreTransactor(
 		s.keyStore,
 		account,
@@ -134,6 +143,9 @@ func (s settlementContractServiceImpl) ResolveTimeout(ctx context.Context) (*typ
 	}
 
 	account := accounts.Account{Address: common.BytesToAddress(w.Address)}
+	if err := checkDeviceScope(ctx, account.Address); err != nil {
+		return nil, err
+	}
 	transactOpts, err := bind.NewKeyStoreTransactor(
 		s.keyStore,
 		account,
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the secret of the test vectors of RFC 4226 and RFC 6238.
+const rfcSecret = "12345678901234567890"
+
+func TestTotpCodeMatchesRfc4226(t *testing.T) {
+	codes := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+	for counter, want := range codes {
+		if code := totpCode([]byte(rfcSecret), int64(counter)); code != want {
+			t.Errorf("code of counter %d is %s, want %s", counter, code, want)
+		}
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte(rfcSecret))
+	// The last six digits of the codes of RFC 6238 for SHA1.
+	now := time.Unix(1111111109, 0)
+	counter := now.Unix() / totpPeriod
+
+	if got, ok := validateTotp(secret, "081804", now, 0); !ok || got != counter {
+		t.Fatalf("valid code rejected, counter %d", got)
+	}
+	if _, ok := validateTotp(secret, " 081804 ", now, 0); !ok {
+		t.Fatal("code with surrounding spaces rejected")
+	}
+	if _, ok := validateTotp(secret, "081804", now.Add(totpPeriod*time.Second), 0); !ok {
+		t.Fatal("code of previous period rejected")
+	}
+	if _, ok := validateTotp(secret, "081804", now.Add(2*totpPeriod*time.Second), 0); ok {
+		t.Fatal("code older than the allowed skew accepted")
+	}
+	if _, ok := validateTotp(secret, "081804", now, counter); ok {
+		t.Fatal("used code accepted again")
+	}
+	if _, ok := validateTotp(secret, "000000", now, 0); ok {
+		t.Fatal("wrong code accepted")
+	}
+}
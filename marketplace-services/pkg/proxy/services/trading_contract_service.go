@@ -54,6 +54,9 @@ func (s tradingContractServiceImpl) RequestTrading(ctx context.Context, product
 	}
 
 	account := accounts.Account{Address: common.BytesToAddress(w.Address)}
+	if err := checkDeviceScope(ctx, account.Address); err != nil {
+		return nil, err
+	}
 	transactOpts, err := bind.NewKeyStoreTransactor(
 		s.keyStore,
 		account,
@@ -87,6 +90,9 @@ func (s tradingContractServiceImpl) AcceptTradingRequest(ctx context.Context, id
 	}
 
 	account := accounts.Account{Address: common.BytesToAddress(w.Address)}
+	if err := checkDeviceScope(ctx, account.Address); err != nil {
+		return nil, err
+	}
 	transactOpts, err := bind.NewKeyStoreTransactor(
 		s.keyStore,
 		account,
@@ -117,6 +123,9 @@ func (s tradingContractServiceImpl) DeclineTradingRequest(ctx context.Context, i
 	}
 
 	account := accounts.Account{Address: common.BytesToAddress(w.Address)}
+	if err := checkDeviceScope(ctx, account.Address); err != nil {
+		return nil, err
+	}
 	transactOpts, err := bind.NewKeyStoreTransactor(
 		s.keyStore,
 		account,
@@ -147,6 +156,9 @@ func (s tradingContractServiceImpl) CreateTrade(ctx context.Context, negotiation
 	}
 
 	account := accounts.Account{Address: common.BytesToAddress(w.Address)}
+	if err := checkDeviceScope(ctx, account.Address); err != nil {
+		return nil, err
+	}
 	transactOpts, err := bind.NewKeyStoreTransactor(
 		s.keyStore,
 		account,
@@ -1,59 +1,382 @@
 package services
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	validation "github.com/go-ozzo/ozzo-validation/v3"
 	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
+	"github.com/tyler-smith/go-bip39"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"io/ioutil"
 	"marketplace-services/pkg/proxy/model"
+	"strings"
 )
 
 type WalletService interface {
 	CreateWallet(ctx context.Context, wallet *model.Wallet) (*model.Wallet, error)
+	ImportKeyStore(ctx context.Context, accountId uint, keyJSON []byte, passphrase string) (*model.Wallet, error)
+	ImportPrivateKey(ctx context.Context, accountId uint, privateKey string) (*model.Wallet, error)
+	ExportKeyStore(ctx context.Context, accountId uint, passphrase string) ([]byte, error)
+	RecoverWallet(ctx context.Context, accountId uint, mnemonic string) (*model.Wallet, error)
+	DeriveDeviceKey(ctx context.Context) (*model.DerivedKey, error)
+	ExportDerivedKey(ctx context.Context, index uint32, passphrase string) ([]byte, error)
 	FindWalletById(ctx context.Context, id uint) (*model.Wallet, error)
 	FindWalletByAccountId(ctx context.Context, id uint) (*model.Wallet, error)
 	FindWalletByAuthenticatedAccount(ctx context.Context) (*model.Wallet, error)
 	FindKeyByAuthenticatedAccount(ctx context.Context) (*keystore.Key, error)
+	SealPassphrases(ctx context.Context) error
+	RotateMasterKey(ctx context.Context, sealer PassphraseSealer) error
 }
 
 type walletServiceImpl struct {
-	db       *gorm.DB
-	logger   logrus.FieldLogger
-	keyStore *keystore.KeyStore
+	db        *gorm.DB
+	logger    logrus.FieldLogger
+	keyStore  *keystore.KeyStore
+	sealer    PassphraseSealer
+	hdWallets bool
 }
 
-func NewWalletServiceImpl(db *gorm.DB, logger logrus.FieldLogger, keyStore *keystore.KeyStore) *walletServiceImpl {
+func NewWalletServiceImpl(
+	db *gorm.DB,
+	logger logrus.FieldLogger,
+	keyStore *keystore.KeyStore,
+	sealer PassphraseSealer,
+	hdWallets bool,
+) *walletServiceImpl {
 	return &walletServiceImpl{
-		db:       db,
-		logger:   logger,
-		keyStore: keyStore,
+		db:        db,
+		logger:    logger,
+		keyStore:  keyStore,
+		sealer:    sealer,
+		hdWallets: hdWallets,
 	}
 }
 
-func (s *walletServiceImpl) CreateWallet(ctx context.Context, wallet *model.Wallet) (*model.Wallet, error) {
+func (s *walletServiceImpl) CreateWallet(_ context.Context, wallet *model.Wallet) (*model.Wallet, error) {
+	if wallet.Passphrase == "" {
+		passphrase, err := generatePassphrase()
+		if err != nil {
+			return nil, err
+		}
+		wallet.Passphrase = passphrase
+	}
+
+	err := wallet.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("validate wallet: %w", err)
+	}
+
+	if s.hdWallets {
+		return s.createHDWallet(wallet)
+	}
+
+	account, err := s.keyStore.NewAccount(wallet.Passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("create wallet for account %d: %w", wallet.AccountID, err)
+	}
+
+	return s.storeWallet(wallet, account)
+}
+
+// ImportKeyStore imports a keystore JSON encrypted with passphrase into the wallet of an account.
+// The key is re-encrypted in the proxy keystore with a generated passphrase.
+func (s *walletServiceImpl) ImportKeyStore(
+	_ context.Context,
+	accountId uint,
+	keyJSON []byte,
+	passphrase string,
+) (*model.Wallet, error) {
+	key, err := keystore.DecryptKey(keyJSON, passphrase)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "decrypt key store: %s", err)
+	}
+	return s.importKey(&model.Wallet{AccountID: accountId}, key.PrivateKey)
+}
+
+func (s *walletServiceImpl) ImportPrivateKey(
+	_ context.Context,
+	accountId uint,
+	privateKey string,
+) (*model.Wallet, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(privateKey, "0x"))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "parse private key: %s", err)
+	}
+	return s.importKey(&model.Wallet{AccountID: accountId}, key)
+}
+
+// ExportKeyStore returns the key of an account's wallet as keystore JSON encrypted with passphrase.
+func (s *walletServiceImpl) ExportKeyStore(_ context.Context, accountId uint, passphrase string) ([]byte, error) {
+	err := validation.Validate(passphrase, validation.Required, validation.Length(8, 32))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validate passphrase: %s", err)
+	}
 
-	proxyAccount, ok := ctx.Value("principal").(model.Account)
+	wallet, err := s.openWalletByAccountId(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	account := accounts.Account{Address: common.BytesToAddress(wallet.Address)}
+	keyJSON, err := s.keyStore.Export(account, wallet.Passphrase, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("export key of wallet %d: %w", wallet.ID, err)
+	}
+
+	s.logger.Infof("Exported key of wallet %d", wallet.ID)
+	return keyJSON, nil
+}
+
+// RecoverWallet restores the wallet of an account from its mnemonic. If the account has no wallet,
+// a new one is created. Otherwise the keys of the wallet and its derived device keys are restored
+// to the keystore.
+func (s *walletServiceImpl) RecoverWallet(ctx context.Context, accountId uint, mnemonic string) (*model.Wallet, error) {
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, status.Error(codes.InvalidArgument, "invalid mnemonic")
+	}
+
+	key, err := deriveKey(mnemonic, devicePath(0))
+	if err != nil {
+		return nil, err
+	}
+	address := crypto.PubkeyToAddress(key.PublicKey)
+
+	sealedMnemonic, err := s.sealer.Seal(mnemonic)
+	if err != nil {
+		return nil, fmt.Errorf("seal mnemonic of account %d: %w", accountId, err)
+	}
+
+	wallet, err := s.openWalletByAccountId(accountId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.importKey(&model.Wallet{AccountID: accountId, HD: true, SealedMnemonic: sealedMnemonic}, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(wallet.Address, address.Bytes()) {
+		return nil, status.Errorf(codes.FailedPrecondition, "mnemonic does not belong to wallet %d", wallet.ID)
+	}
+
+	if !s.keyStore.HasAddress(address) {
+		account, err := s.keyStore.ImportECDSA(key, wallet.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("import key %s of wallet %d: %w", address.Hex(), wallet.ID, err)
+		}
+		wallet.FilePath = account.URL.Path
+	}
+
+	var derivedKeys []*model.DerivedKey
+	if err := s.db.Where(&model.DerivedKey{WalletID: wallet.ID}).Find(&derivedKeys).Error; err != nil {
+		return nil, fmt.Errorf("get derived keys of wallet %d: %w", wallet.ID, err)
+	}
+	for _, derivedKey := range derivedKeys {
+		if err := s.restoreDerivedKey(mnemonic, wallet.Passphrase, derivedKey); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.db.Model(wallet).Updates(map[string]interface{}{
+		"file_path":       wallet.FilePath,
+		"hd":              true,
+		"sealed_mnemonic": sealedMnemonic,
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("update wallet %d: %w", wallet.ID, err)
+	}
+
+	s.logger.Infof("Recovered wallet %d with %d derived keys", wallet.ID, len(derivedKeys))
+	wallet.Passphrase = ""
+	return wallet, nil
+}
+
+// DeriveDeviceKey derives the next key from the mnemonic of the authenticated account's wallet, so
+// each device of a user gets its own address.
+func (s *walletServiceImpl) DeriveDeviceKey(ctx context.Context) (*model.DerivedKey, error) {
+	principal, ok := ctx.Value("principal").(model.Account)
 	if !ok {
 		return nil, fmt.Errorf("extract account from context")
 	}
 
-	if !proxyAccount.HasRole(model.RoleAdmin) {
-		return nil, fmt.Errorf("role %d needed", model.RoleAdmin)
+	if err := s.checkAccountEnabled(principal.ID); err != nil {
+		return nil, err
 	}
 
-	err := wallet.Validate()
+	wallet, err := s.openWalletByAccountId(principal.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !wallet.HD {
+		return nil, status.Errorf(codes.FailedPrecondition, "wallet %d is not derived from a mnemonic", wallet.ID)
+	}
+
+	mnemonic, err := s.sealer.Open(wallet.SealedMnemonic)
+	if err != nil {
+		return nil, fmt.Errorf("open mnemonic of wallet %d: %w", wallet.ID, err)
+	}
+
+	path := devicePath(wallet.NextIndex)
+	derivedKey := &model.DerivedKey{WalletID: wallet.ID, Index: wallet.NextIndex, Path: path.String()}
+	if err := s.restoreDerivedKey(mnemonic, wallet.Passphrase, derivedKey); err != nil {
+		return nil, err
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("begin transaction: %w", tx.Error)
+	}
+	update := tx.Model(&model.Wallet{}).
+		Where("id = ? AND next_index = ?", wallet.ID, wallet.NextIndex).
+		Update("next_index", wallet.NextIndex+1)
+	if update.Error != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("update next index of wallet %d: %w", wallet.ID, update.Error)
+	}
+	if update.RowsAffected != 1 {
+		tx.Rollback()
+		return nil, status.Errorf(codes.Aborted, "concurrent derivation from wallet %d", wallet.ID)
+	}
+	if err := tx.Create(derivedKey).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("create derived key of wallet %d: %w", wallet.ID, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	s.logger.Infof("Derived key %s of wallet %d", path, wallet.ID)
+	return derivedKey, nil
+}
+
+// ExportDerivedKey returns a device key derived from the authenticated account's wallet as keystore
+// JSON encrypted with passphrase, so it can be installed on the device.
+func (s *walletServiceImpl) ExportDerivedKey(ctx context.Context, index uint32, passphrase string) ([]byte, error) {
+	err := validation.Validate(passphrase, validation.Required, validation.Length(8, 32))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validate passphrase: %s", err)
+	}
+	if index == 0 {
+		return nil, status.Error(codes.InvalidArgument, "index 0 is the key of the wallet itself")
+	}
+
+	principal, ok := ctx.Value("principal").(model.Account)
+	if !ok {
+		return nil, fmt.Errorf("extract account from context")
+	}
+	if err := s.checkAccountEnabled(principal.ID); err != nil {
+		return nil, err
+	}
+
+	wallet, err := s.openWalletByAccountId(principal.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var derivedKey model.DerivedKey
+	err = s.db.Where(&model.DerivedKey{WalletID: wallet.ID, Index: index}).First(&derivedKey).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, status.Errorf(codes.NotFound, "derived key %d of wallet %d not found", index, wallet.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get derived key %d of wallet %d: %w", index, wallet.ID, err)
+	}
+
+	account := accounts.Account{Address: common.BytesToAddress(derivedKey.Address)}
+	keyJSON, err := s.keyStore.Export(account, wallet.Passphrase, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("export derived key %s of wallet %d: %w", derivedKey.Path, wallet.ID, err)
+	}
+
+	s.logger.Infof("Exported derived key %s of wallet %d", derivedKey.Path, wallet.ID)
+	return keyJSON, nil
+}
+
+func (s *walletServiceImpl) createHDWallet(wallet *model.Wallet) (*model.Wallet, error) {
+	mnemonic, err := newMnemonic()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := deriveKey(mnemonic, devicePath(0))
+	if err != nil {
+		return nil, err
+	}
+
+	wallet.HD = true
+	wallet.SealedMnemonic, err = s.sealer.Seal(mnemonic)
 	if err != nil {
+		return nil, fmt.Errorf("seal mnemonic of account %d: %w", wallet.AccountID, err)
+	}
+
+	wallet, err = s.importKey(wallet, key)
+	if err != nil {
+		return nil, err
+	}
+	wallet.Mnemonic = mnemonic
+	return wallet, nil
+}
+
+// restoreDerivedKey derives the key at the path of derivedKey, sets its address and imports it into
+// the keystore unless it is already there.
+func (s *walletServiceImpl) restoreDerivedKey(mnemonic string, passphrase string, derivedKey *model.DerivedKey) error {
+	key, err := deriveKey(mnemonic, devicePath(derivedKey.Index))
+	if err != nil {
+		return err
+	}
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	derivedKey.Address = address.Bytes()
+
+	if s.keyStore.HasAddress(address) {
+		return nil
+	}
+	if _, err := s.keyStore.ImportECDSA(key, passphrase); err != nil {
+		return fmt.Errorf("import derived key %s: %w", derivedKey.Path, err)
+	}
+	return nil
+}
+
+// importKey stores a private key in the proxy keystore and makes it the wallet of an account. The
+// key is encrypted with a generated passphrase unless the wallet already has one.
+func (s *walletServiceImpl) importKey(wallet *model.Wallet, key *ecdsa.PrivateKey) (*model.Wallet, error) {
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	if s.keyStore.HasAddress(address) {
+		return nil, status.Errorf(codes.AlreadyExists, "key %s already in keystore", address.Hex())
+	}
+
+	if wallet.Passphrase == "" {
+		passphrase, err := generatePassphrase()
+		if err != nil {
+			return nil, err
+		}
+		wallet.Passphrase = passphrase
+	}
+	if err := wallet.Validate(); err != nil {
 		return nil, fmt.Errorf("validate wallet: %w", err)
 	}
 
-	account, err := s.keyStore.NewAccount(wallet.Passphrase)
+	account, err := s.keyStore.ImportECDSA(key, wallet.Passphrase)
 	if err != nil {
-		return nil, fmt.Errorf("create wallet for account %d: %w", wallet.AccountID, err)
+		return nil, fmt.Errorf("import key %s for account %d: %w", address.Hex(), wallet.AccountID, err)
 	}
 
+	s.logger.Infof("Imported key %s for account %d", address.Hex(), wallet.AccountID)
+	return s.storeWallet(wallet, account)
+}
+
+// storeWallet extracts the address and public key of a keystore account and stores it as the
+// wallet of an account. The keystore account is deleted if the wallet cannot be stored.
+func (s *walletServiceImpl) storeWallet(wallet *model.Wallet, account accounts.Account) (*model.Wallet, error) {
 	wallet.Address = account.Address.Bytes()
 	wallet.FilePath = account.URL.Path
 
@@ -69,13 +392,20 @@ func (s *walletServiceImpl) CreateWallet(ctx context.Context, wallet *model.Wall
 
 	wallet.PublicKey = crypto.FromECDSAPub(&key.PrivateKey.PublicKey)
 
+	passphrase := wallet.Passphrase
+	wallet.Passphrase, err = s.sealer.Seal(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("seal passphrase of wallet for account %d: %w", wallet.AccountID, err)
+	}
+
 	err = s.db.Create(wallet).Error
 	if err != nil {
-		if err := s.keyStore.Delete(account, wallet.Passphrase); err != nil {
+		if err := s.keyStore.Delete(account, passphrase); err != nil {
 			s.logger.Warnf("delete ethereum account %s", account.Address.Hex())
 		}
 		return nil, err
 	}
+	wallet.Passphrase = ""
 	return wallet, err
 }
 
@@ -85,16 +415,17 @@ func (s *walletServiceImpl) FindWalletById(ctx context.Context, id uint) (*model
 	if err != nil {
 		return nil, fmt.Errorf("get first wallet with id %d: %w", id, err)
 	}
+	wallet.Passphrase = ""
 	return &wallet, err
 }
 
 func (s *walletServiceImpl) FindWalletByAccountId(ctx context.Context, id uint) (*model.Wallet, error) {
-	var wallet model.Wallet
-	err := s.db.Where(&model.Wallet{AccountID: id}).First(&wallet).Error
+	wallet, err := s.findWalletByAccountId(id)
 	if err != nil {
-		return nil, fmt.Errorf("get first wallet of account %d: %w", id, err)
+		return nil, err
 	}
-	return &wallet, err
+	wallet.Passphrase = ""
+	return wallet, err
 }
 
 func (s *walletServiceImpl) FindKeyByAuthenticatedAccount(ctx context.Context) (*keystore.Key, error) {
@@ -103,10 +434,13 @@ func (s *walletServiceImpl) FindKeyByAuthenticatedAccount(ctx context.Context) (
 		return nil, fmt.Errorf("extract account from context")
 	}
 
-	var wallet model.Wallet
-	err := s.db.Where(&model.Wallet{AccountID: principal.ID}).First(&wallet).Error
+	if err := s.checkAccountEnabled(principal.ID); err != nil {
+		return nil, err
+	}
+
+	wallet, err := s.openWalletByAccountId(principal.ID)
 	if err != nil {
-		return nil, fmt.Errorf("get first wallet of account %d: %w", principal.ID, err)
+		return nil, err
 	}
 
 	keyFile, err := ioutil.ReadFile(wallet.FilePath)
@@ -128,9 +462,153 @@ func (s *walletServiceImpl) FindWalletByAuthenticatedAccount(ctx context.Context
 		return nil, fmt.Errorf("extract account from context")
 	}
 
-	wallet, err := s.FindWalletByAccountId(ctx, principal.ID)
+	if err := s.checkAccountEnabled(principal.ID); err != nil {
+		return nil, err
+	}
+
+	wallet, err := s.openWalletByAccountId(principal.ID)
 	if err != nil {
 		return nil, fmt.Errorf("find account by id %d: %w", principal.ID, err)
 	}
 	return wallet, nil
 }
+
+// SealPassphrases seals all passphrases still stored in plaintext.
+func (s *walletServiceImpl) SealPassphrases(_ context.Context) error {
+	var wallets []*model.Wallet
+	if err := s.db.Find(&wallets).Error; err != nil {
+		return fmt.Errorf("get all wallets: %w", err)
+	}
+
+	for _, wallet := range wallets {
+		if s.sealer.IsSealed(wallet.Passphrase) {
+			continue
+		}
+		sealed, err := s.sealer.Seal(wallet.Passphrase)
+		if err != nil {
+			return fmt.Errorf("seal passphrase of wallet %d: %w", wallet.ID, err)
+		}
+		if err := s.db.Model(wallet).Update("passphrase", sealed).Error; err != nil {
+			return fmt.Errorf("update passphrase of wallet %d: %w", wallet.ID, err)
+		}
+		s.logger.Infof("Sealed passphrase of wallet %d", wallet.ID)
+	}
+	return nil
+}
+
+// sealedColumns are the columns sealed with the master key. Wallet passphrases may still be stored
+// in plaintext, they are sealed by the rotation as well.
+var sealedColumns = []struct {
+	table  string
+	column string
+}{
+	{"wallets", "passphrase"},
+	{"wallets", "sealed_mnemonic"},
+	{"signing_keys", "private_key"},
+	{"accounts", "totp_secret"},
+	{"saved_searches", "webhook_secret"},
+}
+
+// RotateMasterKey re-seals all values sealed with the master key with the given sealer in a single
+// transaction. The wallet service must be configured with the sealer of the current master key.
+func (s *walletServiceImpl) RotateMasterKey(_ context.Context, sealer PassphraseSealer) error {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("begin transaction: %w", tx.Error)
+	}
+
+	count := 0
+	for _, sealed := range sealedColumns {
+		n, err := s.resealColumn(tx, sealed.table, sealed.column, sealer)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("reseal %s.%s: %w", sealed.table, sealed.column, err)
+		}
+		count += n
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	s.logger.Infof("Rotated master key of %d sealed values", count)
+	return nil
+}
+
+// resealColumn re-seals the values of a column of all rows, including soft deleted ones, with the
+// given sealer.
+func (s *walletServiceImpl) resealColumn(tx *gorm.DB, table string, column string, sealer PassphraseSealer) (int, error) {
+	var rows []struct {
+		ID    uint
+		Value string
+	}
+	err := tx.Table(table).Select(fmt.Sprintf("id, %s AS value", column)).
+		Where(fmt.Sprintf("%s <> ''", column)).Scan(&rows).Error
+	if err != nil {
+		return 0, fmt.Errorf("find values: %w", err)
+	}
+
+	for _, row := range rows {
+		value := row.Value
+		if s.sealer.IsSealed(value) {
+			if value, err = s.sealer.Open(value); err != nil {
+				return 0, fmt.Errorf("open value of row %d: %w", row.ID, err)
+			}
+		}
+		sealed, err := sealer.Seal(value)
+		if err != nil {
+			return 0, fmt.Errorf("seal value of row %d: %w", row.ID, err)
+		}
+		query := fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ?", table, column)
+		if err := tx.Exec(query, sealed, row.ID).Error; err != nil {
+			return 0, fmt.Errorf("update value of row %d: %w", row.ID, err)
+		}
+	}
+	return len(rows), nil
+}
+
+func (s *walletServiceImpl) findWalletByAccountId(id uint) (*model.Wallet, error) {
+	var wallet model.Wallet
+	err := s.db.Where(&model.Wallet{AccountID: id}).First(&wallet).Error
+	if err != nil {
+		return nil, fmt.Errorf("get first wallet of account %d: %w", id, err)
+	}
+	return &wallet, nil
+}
+
+// openWalletByAccountId returns the wallet of an account with its passphrase in plaintext. It must
+// only be used to unlock keys and never be returned to callers of the API.
+func (s *walletServiceImpl) openWalletByAccountId(id uint) (*model.Wallet, error) {
+	wallet, err := s.findWalletByAccountId(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.sealer.IsSealed(wallet.Passphrase) {
+		wallet.Passphrase, err = s.sealer.Open(wallet.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("open passphrase of wallet %d: %w", wallet.ID, err)
+		}
+	}
+	return wallet, nil
+}
+
+func generatePassphrase() (string, error) {
+	data := make([]byte, 24)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("generate passphrase: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// checkAccountEnabled prevents the wallets of disabled accounts from being used to sign transactions
+// and messages.
+func (s *walletServiceImpl) checkAccountEnabled(id uint) error {
+	var account model.Account
+	err := s.db.Select("disabled").First(&account, id).Error
+	if err != nil {
+		return fmt.Errorf("get account %d: %w", id, err)
+	}
+	if account.Disabled {
+		return status.Errorf(codes.PermissionDenied, "account %d is disabled", id)
+	}
+	return nil
+}
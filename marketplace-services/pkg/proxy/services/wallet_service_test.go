@@ -0,0 +1,283 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/jinzhu/gorm"
+	"github.com/pborman/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"marketplace-services/pkg/proxy/model"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestWalletService(t *testing.T, db *gorm.DB, keyStoreDir string) *walletServiceImpl {
+	sealer, err := NewAESPassphraseSealer("test master key")
+	if err != nil {
+		t.Fatalf("new passphrase sealer: %v", err)
+	}
+	ks := keystore.NewKeyStore(keyStoreDir, keystore.LightScryptN, keystore.LightScryptP)
+	return NewWalletServiceImpl(db, newTestLogger(), ks, sealer, true)
+}
+
+func newTestKeyStoreDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatalf("create keystore dir: %v", err)
+	}
+	return dir
+}
+
+func TestExportDerivedKey(t *testing.T) {
+	db := newTestDb(t)
+	dir := newTestKeyStoreDir(t)
+	defer os.RemoveAll(dir)
+	walletService := newTestWalletService(t, db, dir)
+	account := createTestAccount(t, db, "alice", "correct password")
+	ctx := context.WithValue(context.Background(), "principal", *account)
+
+	wallet, err := walletService.CreateWallet(ctx, &model.Wallet{AccountID: account.ID})
+	if err != nil {
+		t.Fatalf("create wallet: %v", err)
+	}
+	derivedKey, err := walletService.DeriveDeviceKey(ctx)
+	if err != nil {
+		t.Fatalf("derive device key: %v", err)
+	}
+
+	keyJSON, err := walletService.ExportDerivedKey(ctx, derivedKey.Index, "device passphrase")
+	if err != nil {
+		t.Fatalf("export derived key: %v", err)
+	}
+	key, err := keystore.DecryptKey(keyJSON, "device passphrase")
+	if err != nil {
+		t.Fatalf("decrypt exported key: %v", err)
+	}
+	if key.Address != common.BytesToAddress(derivedKey.Address) {
+		t.Fatalf("exported key %s, want %s", key.Address.Hex(), common.BytesToAddress(derivedKey.Address).Hex())
+	}
+
+	// The derived key is restored from the mnemonic if the keystore is lost.
+	lostDir := newTestKeyStoreDir(t)
+	defer os.RemoveAll(lostDir)
+	recovered := newTestWalletService(t, db, lostDir)
+	if _, err := recovered.RecoverWallet(ctx, account.ID, wallet.Mnemonic); err != nil {
+		t.Fatalf("recover wallet: %v", err)
+	}
+	if _, err := recovered.ExportDerivedKey(ctx, derivedKey.Index, "device passphrase"); err != nil {
+		t.Fatalf("export recovered derived key: %v", err)
+	}
+
+	for _, index := range []uint32{0, derivedKey.Index + 1} {
+		if _, err := walletService.ExportDerivedKey(ctx, index, "device passphrase"); status.Code(err) == codes.OK {
+			t.Fatalf("exported derived key %d", index)
+		}
+	}
+}
+
+func TestRotateMasterKey(t *testing.T) {
+	db := newTestDb(t)
+	dir := newTestKeyStoreDir(t)
+	defer os.RemoveAll(dir)
+	walletService := newTestWalletService(t, db, dir)
+	account := createTestAccount(t, db, "alice", "correct password")
+	ctx := context.WithValue(context.Background(), "principal", *account)
+	if _, err := walletService.CreateWallet(ctx, &model.Wallet{AccountID: account.ID}); err != nil {
+		t.Fatalf("create wallet: %v", err)
+	}
+	newTestKeyManager(t, db)
+	accountService := newTestAccountService(t, db)
+	enrollment, err := accountService.EnrollTotp(ctx)
+	if err != nil {
+		t.Fatalf("enroll totp: %v", err)
+	}
+
+	webhookSecret, err := walletService.sealer.Seal("webhook secret")
+	if err != nil {
+		t.Fatalf("seal webhook secret: %v", err)
+	}
+	search := &model.SavedSearch{AccountID: account.ID, Name: "search", WebhookSecret: webhookSecret}
+	if err := db.Create(search).Error; err != nil {
+		t.Fatalf("create saved search: %v", err)
+	}
+
+	sealer, err := NewAESPassphraseSealer("new master key")
+	if err != nil {
+		t.Fatalf("new passphrase sealer: %v", err)
+	}
+	if err := walletService.RotateMasterKey(context.Background(), sealer); err != nil {
+		t.Fatalf("rotate master key: %v", err)
+	}
+
+	rotated := NewWalletServiceImpl(db, newTestLogger(), walletService.keyStore, sealer, true)
+	wallet, err := rotated.openWalletByAccountId(account.ID)
+	if err != nil {
+		t.Fatalf("open wallet with new master key: %v", err)
+	}
+	if _, err := sealer.Open(wallet.SealedMnemonic); err != nil {
+		t.Fatalf("open mnemonic with new master key: %v", err)
+	}
+	if _, err := NewAsymmetricKeyManager(db, newTestLogger(), sealer, AlgorithmES256, time.Hour); err != nil {
+		t.Fatalf("load signing keys with new master key: %v", err)
+	}
+	accountService.sealer = sealer
+	key, err := totpEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("decode totp secret: %v", err)
+	}
+	now := time.Now()
+	code := totpCode(key, now.Unix()/totpPeriod)
+	if err := accountService.checkTotp(findTestAccount(t, accountService, account.ID), code, now); err != nil {
+		t.Fatalf("check totp with new master key: %v", err)
+	}
+	if err := db.First(search, search.ID).Error; err != nil {
+		t.Fatalf("find saved search: %v", err)
+	}
+	if secret, err := sealer.Open(search.WebhookSecret); err != nil || secret != "webhook secret" {
+		t.Fatalf("open webhook secret with new master key: %v", err)
+	}
+	if _, err := walletService.openWalletByAccountId(account.ID); err == nil {
+		t.Fatal("opened wallet with old master key")
+	}
+}
+
+func TestWalletPassphraseSealedAtRest(t *testing.T) {
+	db := newTestDb(t)
+	dir := newTestKeyStoreDir(t)
+	defer os.RemoveAll(dir)
+	walletService := newTestWalletService(t, db, dir)
+	account := createTestAccount(t, db, "alice", "correct password")
+	ctx := context.WithValue(context.Background(), "principal", *account)
+
+	wallet, err := walletService.CreateWallet(ctx, &model.Wallet{AccountID: account.ID, Passphrase: "wallet passphrase"})
+	if err != nil {
+		t.Fatalf("create wallet: %v", err)
+	}
+	if wallet.Passphrase != "" {
+		t.Fatal("created wallet returned with its passphrase")
+	}
+	for name, find := range map[string]func() (*model.Wallet, error){
+		"by id":         func() (*model.Wallet, error) { return walletService.FindWalletById(ctx, wallet.ID) },
+		"by account id": func() (*model.Wallet, error) { return walletService.FindWalletByAccountId(ctx, account.ID) },
+	} {
+		if found, err := find(); err != nil || found.Passphrase != "" {
+			t.Errorf("find wallet %s: got passphrase %q, %v", name, found.Passphrase, err)
+		}
+	}
+
+	var stored model.Wallet
+	if err := db.First(&stored, wallet.ID).Error; err != nil {
+		t.Fatalf("find stored wallet: %v", err)
+	}
+	if !walletService.sealer.IsSealed(stored.Passphrase) {
+		t.Fatal("passphrase stored in plaintext")
+	}
+
+	// Passphrases stored in plaintext before sealing was introduced are sealed on startup.
+	if err := db.Model(&stored).Update("passphrase", "wallet passphrase").Error; err != nil {
+		t.Fatalf("store plaintext passphrase: %v", err)
+	}
+	if err := walletService.SealPassphrases(context.Background()); err != nil {
+		t.Fatalf("seal passphrases: %v", err)
+	}
+	if err := db.First(&stored, wallet.ID).Error; err != nil || !walletService.sealer.IsSealed(stored.Passphrase) {
+		t.Fatalf("plaintext passphrase not sealed: %v", err)
+	}
+	if _, err := walletService.FindKeyByAuthenticatedAccount(ctx); err != nil {
+		t.Fatalf("find key with sealed passphrase: %v", err)
+	}
+}
+
+func TestImportPrivateKey(t *testing.T) {
+	db := newTestDb(t)
+	dir := newTestKeyStoreDir(t)
+	defer os.RemoveAll(dir)
+	walletService := newTestWalletService(t, db, dir)
+	account := createTestAccount(t, db, "alice", "correct password")
+	other := createTestAccount(t, db, "bob", "correct password")
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	wallet, err := walletService.ImportPrivateKey(context.Background(), account.ID, hexutil.Encode(crypto.FromECDSA(key)))
+	if err != nil {
+		t.Fatalf("import private key: %v", err)
+	}
+	if common.BytesToAddress(wallet.Address) != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("imported wallet %s, want %s", common.BytesToAddress(wallet.Address).Hex(), crypto.PubkeyToAddress(key.PublicKey).Hex())
+	}
+	if !bytes.Equal(wallet.PublicKey, crypto.FromECDSAPub(&key.PublicKey)) {
+		t.Fatal("imported wallet has another public key")
+	}
+
+	ctx := context.WithValue(context.Background(), "principal", *account)
+	found, err := walletService.FindKeyByAuthenticatedAccount(ctx)
+	if err != nil {
+		t.Fatalf("find imported key: %v", err)
+	}
+	if found.Address != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("found key %s, want the imported one", found.Address.Hex())
+	}
+
+	if _, err := walletService.ImportPrivateKey(context.Background(), other.ID, hexutil.Encode(crypto.FromECDSA(key))); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("import key twice: got %v, want AlreadyExists", err)
+	}
+	if _, err := walletService.ImportPrivateKey(context.Background(), other.ID, "0x1234"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("import invalid key: got %v, want InvalidArgument", err)
+	}
+}
+
+func TestImportAndExportKeyStore(t *testing.T) {
+	db := newTestDb(t)
+	dir := newTestKeyStoreDir(t)
+	defer os.RemoveAll(dir)
+	walletService := newTestWalletService(t, db, dir)
+	account := createTestAccount(t, db, "alice", "correct password")
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	keyJSON, err := keystore.EncryptKey(&keystore.Key{
+		Id:         uuid.NewRandom(),
+		Address:    crypto.PubkeyToAddress(key.PublicKey),
+		PrivateKey: key,
+	}, "device passphrase", keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatalf("encrypt key: %v", err)
+	}
+
+	if _, err := walletService.ImportKeyStore(context.Background(), account.ID, keyJSON, "wrong passphrase"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("import key store with wrong passphrase: got %v, want InvalidArgument", err)
+	}
+	wallet, err := walletService.ImportKeyStore(context.Background(), account.ID, keyJSON, "device passphrase")
+	if err != nil {
+		t.Fatalf("import key store: %v", err)
+	}
+	if common.BytesToAddress(wallet.Address) != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("imported wallet %s, want %s", common.BytesToAddress(wallet.Address).Hex(), crypto.PubkeyToAddress(key.PublicKey).Hex())
+	}
+
+	if _, err := walletService.ExportKeyStore(context.Background(), account.ID, "short"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("export with short passphrase: got %v, want InvalidArgument", err)
+	}
+	exported, err := walletService.ExportKeyStore(context.Background(), account.ID, "export passphrase")
+	if err != nil {
+		t.Fatalf("export key store: %v", err)
+	}
+	decrypted, err := keystore.DecryptKey(exported, "export passphrase")
+	if err != nil {
+		t.Fatalf("decrypt exported key: %v", err)
+	}
+	if !bytes.Equal(crypto.FromECDSA(decrypted.PrivateKey), crypto.FromECDSA(key)) {
+		t.Fatal("exported another key than the imported one")
+	}
+}
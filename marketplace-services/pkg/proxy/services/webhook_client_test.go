@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"io/ioutil"
+	"marketplace-services/pkg/contracts"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIsBlockedIP(t *testing.T) {
+	blocked := []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "::1", "fd00::1", "fe80::1", "::ffff:127.0.0.1", "0.0.0.0"}
+	for _, ip := range blocked {
+		if !isBlockedIP(net.ParseIP(ip)) {
+			t.Errorf("%s not blocked", ip)
+		}
+	}
+	public := []string{"1.1.1.1", "8.8.8.8", "2606:4700:4700::1111"}
+	for _, ip := range public {
+		if isBlockedIP(net.ParseIP(ip)) {
+			t.Errorf("%s blocked", ip)
+		}
+	}
+}
+
+func TestCheckWebhookURLRejectsPrivateHosts(t *testing.T) {
+	for _, rawURL := range []string{"http://127.0.0.1:8080/hook", "http://localhost/hook", "https://[::1]/hook", "http:///hook"} {
+		if err := checkWebhookURL(context.Background(), net.DefaultResolver, rawURL); err == nil {
+			t.Errorf("%s accepted", rawURL)
+		}
+	}
+}
+
+func TestWebhookClientRefusesPrivateTargets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer server.Close()
+
+	if _, err := newWebhookClient(time.Second, false).Post(server.URL, "application/json", nil); err == nil {
+		t.Fatal("webhook delivered to loopback address")
+	}
+	resp, err := newWebhookClient(time.Second, true).Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("deliver webhook with private targets allowed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestWebhookSignature(t *testing.T) {
+	sealer, err := NewAESPassphraseSealer("test master key")
+	if err != nil {
+		t.Fatalf("new passphrase sealer: %v", err)
+	}
+	sealed, err := sealer.Seal("secret")
+	if err != nil {
+		t.Fatalf("seal secret: %v", err)
+	}
+
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	service := NewSavedSearchServiceImpl(nil, newTestLogger(), nil, nil, sealer, WebhookPolicy{
+		Timeout:             time.Second,
+		AllowPrivateTargets: true,
+	})
+	service.callWebhook(context.Background(), &webhookDelivery{
+		url:          server.URL,
+		sealedSecret: sealed,
+		notification: &ProductNotification{SavedSearch: 1, Event: ProductEventCreated, Product: &contracts.Product{
+			Id:        big.NewInt(1),
+			Name:      "temperature",
+			Frequency: big.NewInt(60),
+			Cost:      big.NewInt(10),
+		}},
+	})
+
+	r, body := <-received, <-bodies
+	timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("parse timestamp: %v", err)
+	}
+	want := signWebhook("secret", timestamp, body)
+	if !hmac.Equal([]byte(r.Header.Get(WebhookSignatureHeader)), []byte(want)) {
+		t.Fatalf("signature %s, want %s", r.Header.Get(WebhookSignatureHeader), want)
+	}
+	if signWebhook("other", timestamp, body) == want {
+		t.Fatal("signature does not depend on the secret")
+	}
+}
@@ -0,0 +1,191 @@
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/sirupsen/logrus"
+	"marketplace-services/pkg/contracts"
+	"marketplace-services/pkg/contracts/bindings"
+)
+
+type Counter struct {
+	Value uint64
+	Set   bool
+}
+
+type Settlement struct {
+	ActualCost uint64
+	Provider   uint64
+	Consumer   uint64
+	Broker     uint64
+}
+
+type SettlementBackend interface {
+	GetProviderCounter(ctx context.Context, contract string) (*Counter, error)
+	GetConsumerCounter(ctx context.Context, contract string) (*Counter, error)
+	GetSettlement(ctx context.Context, contract string) (*Settlement, error)
+	ResolveTimeout(ctx context.Context, contract string) (string, error)
+	WatchSettled(ctx context.Context, contract string) (<-chan *Settlement, <-chan error)
+}
+
+type contractBackend struct {
+	logger     logrus.FieldLogger
+	keyStore   *keystore.KeyStore
+	ethClient  *ethclient.Client
+	account    string
+	passphrase string
+}
+
+func NewContractBackend(
+	logger logrus.FieldLogger,
+	keyStore *keystore.KeyStore,
+	ethClient *ethclient.Client,
+	account string,
+	passphrase string,
+) *contractBackend {
+	return &contractBackend{
+		logger:     logger,
+		keyStore:   keyStore,
+		ethClient:  ethClient,
+		account:    account,
+		passphrase: passphrase,
+	}
+}
+
+func (b *contractBackend) GetProviderCounter(ctx context.Context, contract string) (*Counter, error) {
+	settlementContract, err := b.settlementContract(contract)
+	if err != nil {
+		return nil, err
+	}
+	counter, err := settlementContract.GetProviderCounter(b.callOpts(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &Counter{Value: counter.Value.Uint64(), Set: counter.Set}, nil
+}
+
+func (b *contractBackend) GetConsumerCounter(ctx context.Context, contract string) (*Counter, error) {
+	settlementContract, err := b.settlementContract(contract)
+	if err != nil {
+		return nil, err
+	}
+	counter, err := settlementContract.GetConsumerCounter(b.callOpts(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &Counter{Value: counter.Value.Uint64(), Set: counter.Set}, nil
+}
+
+func (b *contractBackend) GetSettlement(ctx context.Context, contract string) (*Settlement, error) {
+	settlementContract, err := b.settlementContract(contract)
+	if err != nil {
+		return nil, err
+	}
+	settlement, err := settlementContract.GetSettlement(b.callOpts(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &Settlement{
+		ActualCost: settlement.ActualCost.Uint64(),
+		Provider:   settlement.Provider.Uint64(),
+		Consumer:   settlement.Consumer.Uint64(),
+		Broker:     settlement.Broker.Uint64(),
+	}, nil
+}
+
+func (b *contractBackend) ResolveTimeout(ctx context.Context, contract string) (string, error) {
+	settlementContract, err := b.settlementContract(contract)
+	if err != nil {
+		return "", err
+	}
+
+	account := accounts.Account{Address: common.HexToAddress(b.account)}
+	transactOpts, err := bind.NewKeyStoreTransactor(
+		b.keyStore,
+		account,
+	)
+	if err != nil {
+		return "", fmt.Errorf("new keystore transactor: %w", err)
+	}
+	transactOpts.Context = ctx
+
+	if err := b.keyStore.Unlock(account, b.passphrase); err != nil {
+		return "", fmt.Errorf("unlock account %s: %w", account.Address.Hex(), err)
+	}
+	defer func() {
+		if err := b.keyStore.Lock(account.Address); err != nil {
+			b.logger.Warnf("lock account %s: %v", account.Address.Hex(), err)
+		}
+	}()
+
+	tx, err := settlementContract.ResolveTimeout(transactOpts)
+	if err != nil {
+		return "", err
+	}
+	return tx.Hash().Hex(), nil
+}
+
+func (b *contractBackend) WatchSettled(ctx context.Context, contract string) (<-chan *Settlement, <-chan error) {
+	sink := make(chan *Settlement)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(sink)
+		defer close(errc)
+
+		settlementContract, err := b.settlementContract(contract)
+		if err != nil {
+			errc <- err
+			return
+		}
+
+		events := make(chan *bindings.SettlementContractSettled)
+		sub, err := settlementContract.WatchSettledEvent(&bind.WatchOpts{Context: ctx}, events)
+		if err != nil {
+			errc <- fmt.Errorf("watch settled event of contract %s: %w", contract, err)
+			return
+		}
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case e := <-events:
+				settlement := &Settlement{
+					ActualCost: e.ActualCost.Uint64(),
+					Provider:   e.Provider.Uint64(),
+					Consumer:   e.Consumer.Uint64(),
+					Broker:     e.Broker.Uint64(),
+				}
+				select {
+				case sink <- settlement:
+				case <-ctx.Done():
+					errc <- ctx.Err()
+					return
+				}
+			case err := <-sub.Err():
+				errc <- err
+				return
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return sink, errc
+}
+
+func (b *contractBackend) settlementContract(contract string) (contracts.SettlementContract, error) {
+	settlementContract, err := contracts.NewSettlementContractImpl(common.HexToAddress(contract), b.ethClient)
+	if err != nil {
+		return nil, fmt.Errorf("new settlement contract with address %s: %w", contract, err)
+	}
+	return settlementContract, nil
+}
+
+func (b *contractBackend) callOpts(ctx context.Context) *bind.CallOpts {
+	return &bind.CallOpts{Context: ctx, From: common.HexToAddress(b.account)}
+}
@@ -0,0 +1,103 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"marketplace-services/pkg/proxy/api"
+	"marketplace-services/pkg/watchdog"
+)
+
+type settlementBackend struct {
+	settlementContractServiceClient api.SettlementContractServiceClient
+}
+
+func NewSettlementBackend(settlementContractServiceClient api.SettlementContractServiceClient) *settlementBackend {
+	return &settlementBackend{settlementContractServiceClient: settlementContractServiceClient}
+}
+
+func (b *settlementBackend) GetProviderCounter(ctx context.Context, contract string) (*watchdog.Counter, error) {
+	response, err := b.settlementContractServiceClient.GetProviderCounter(ctx, &api.GetProviderCounterRequest{
+		ContractAddress: contract,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &watchdog.Counter{Value: response.Counter.Value, Set: response.Counter.Set}, nil
+}
+
+func (b *settlementBackend) GetConsumerCounter(ctx context.Context, contract string) (*watchdog.Counter, error) {
+	response, err := b.settlementContractServiceClient.GetConsumerCounter(ctx, &api.GetConsumerCounterRequest{
+		ContractAddress: contract,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &watchdog.Counter{Value: response.Counter.Value, Set: response.Counter.Set}, nil
+}
+
+func (b *settlementBackend) GetSettlement(ctx context.Context, contract string) (*watchdog.Settlement, error) {
+	response, err := b.settlementContractServiceClient.GetSettlement(ctx, &api.GetSettlementRequest{
+		ContractAddress: contract,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &watchdog.Settlement{
+		ActualCost: response.Settlement.ActualCost,
+		Provider:   response.Settlement.Provider,
+		Consumer:   response.Settlement.Consumer,
+		Broker:     response.Settlement.Broke,
+	}, nil
+}
+
+func (b *settlementBackend) ResolveTimeout(ctx context.Context, contract string) (string, error) {
+	response, err := b.settlementContractServiceClient.ResolveTimeout(ctx, &api.ResolveTimeoutRequest{
+		ContractAddress: contract,
+	})
+	if err != nil {
+		return "", err
+	}
+	return response.Transaction.Hash, nil
+}
+
+func (b *settlementBackend) WatchSettled(ctx context.Context, contract string) (<-chan *watchdog.Settlement, <-chan error) {
+	sink := make(chan *watchdog.Settlement)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(sink)
+		defer close(errc)
+
+		stream, err := b.settlementContractServiceClient.WatchSettledEvent(ctx, &api.WatchSettledEventRequest{
+			ContractAddress: contract,
+		})
+		if err != nil {
+			errc <- fmt.Errorf("watch settled event of contract %s: %w", contract, err)
+			return
+		}
+
+		for {
+			response, err := stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			settlement := &watchdog.Settlement{
+				ActualCost: response.Event.ActualCost,
+				Provider:   response.Event.Provider,
+				Consumer:   response.Event.Consumer,
+				Broker:     response.Event.Broker,
+			}
+			select {
+			case sink <- settlement:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return sink, errc
+}
@@ -0,0 +1,242 @@
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"marketplace-services/pkg/contracts"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	ReasonProviderCounter = "provider counter"
+	ReasonConsumerCounter = "consumer counter"
+	ReasonNoCounter       = "no counter"
+)
+
+type Trade struct {
+	Id                 uint64
+	SettlementContract string
+	EndTime            uint64
+}
+
+type Report struct {
+	TradeId            uint64
+	SettlementContract string
+	Transaction        string
+	Reason             string
+	Settlement         *Settlement
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf(
+		"resolved timeout of trade %d by %s in transaction %s, provider received %d, consumer reclaimed %d, broker received %d",
+		r.TradeId,
+		r.Reason,
+		r.Transaction,
+		r.Settlement.Provider,
+		r.Settlement.Consumer,
+		r.Settlement.Broker,
+	)
+}
+
+type SettlementWatchdog interface {
+	Watch(trade *Trade)
+	Run(ctx context.Context) (<-chan *Report, <-chan error)
+	Await(ctx context.Context, trade *Trade) (*Report, error)
+}
+
+type settlementWatchdogImpl struct {
+	logger         logrus.FieldLogger
+	backend        SettlementBackend
+	interval       time.Duration
+	settledTimeout time.Duration
+	trades         map[uint64]*Trade
+	sync.Mutex
+}
+
+func NewSettlementWatchdogImpl(
+	logger logrus.FieldLogger,
+	backend SettlementBackend,
+	interval time.Duration,
+	settledTimeout time.Duration,
+) *settlementWatchdogImpl {
+	return &settlementWatchdogImpl{
+		logger:         logger,
+		backend:        backend,
+		interval:       interval,
+		settledTimeout: settledTimeout,
+		trades:         make(map[uint64]*Trade),
+	}
+}
+
+func (w *settlementWatchdogImpl) Watch(trade *Trade) {
+	w.Lock()
+	defer w.Unlock()
+	if _, ok := w.trades[trade.Id]; ok {
+		return
+	}
+	w.logger.Debugf("Watching settlement of trade %d until %s", trade.Id, w.validTime(trade))
+	w.trades[trade.Id] = trade
+}
+
+func (w *settlementWatchdogImpl) Run(ctx context.Context) (<-chan *Report, <-chan error) {
+	sink := make(chan *Report)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(sink)
+		defer close(errc)
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				for _, trade := range w.dueTrades(now) {
+					report, err := w.resolveTimeout(ctx, trade)
+					if err != nil {
+						w.logger.Errorf("resolve timeout of trade %d: %v", trade.Id, err)
+						continue
+					}
+					w.Lock()
+					delete(w.trades, trade.Id)
+					w.Unlock()
+					if report == nil {
+						continue
+					}
+					select {
+					case sink <- report:
+					case <-ctx.Done():
+						errc <- ctx.Err()
+						return
+					}
+				}
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return sink, errc
+}
+
+func (w *settlementWatchdogImpl) Await(ctx context.Context, trade *Trade) (*Report, error) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if !now.After(w.validTime(trade)) {
+				settled, err := w.settled(ctx, trade)
+				if err != nil {
+					w.logger.Errorf("check settlement of trade %d: %v", trade.Id, err)
+				}
+				if settled {
+					return nil, nil
+				}
+				continue
+			}
+			report, err := w.resolveTimeout(ctx, trade)
+			if err != nil {
+				w.logger.Errorf("resolve timeout of trade %d: %v", trade.Id, err)
+				continue
+			}
+			return report, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (w *settlementWatchdogImpl) dueTrades(now time.Time) []*Trade {
+	w.Lock()
+	defer w.Unlock()
+	var trades []*Trade
+	for _, trade := range w.trades {
+		if now.After(w.validTime(trade)) {
+			trades = append(trades, trade)
+		}
+	}
+	return trades
+}
+
+// validTime returns the time after which the settlement contract accepts resolving the timeout.
+func (w *settlementWatchdogImpl) validTime(trade *Trade) time.Time {
+	return contracts.SettlementValidTime(new(big.Int).SetUint64(trade.EndTime))
+}
+
+func (w *settlementWatchdogImpl) settled(ctx context.Context, trade *Trade) (bool, error) {
+	providerCounter, err := w.backend.GetProviderCounter(ctx, trade.SettlementContract)
+	if err != nil {
+		return false, fmt.Errorf("get provider counter: %w", err)
+	}
+	consumerCounter, err := w.backend.GetConsumerCounter(ctx, trade.SettlementContract)
+	if err != nil {
+		return false, fmt.Errorf("get consumer counter: %w", err)
+	}
+	return providerCounter.Set && consumerCounter.Set, nil
+}
+
+func (w *settlementWatchdogImpl) resolveTimeout(ctx context.Context, trade *Trade) (*Report, error) {
+	providerCounter, err := w.backend.GetProviderCounter(ctx, trade.SettlementContract)
+	if err != nil {
+		return nil, fmt.Errorf("get provider counter: %w", err)
+	}
+	consumerCounter, err := w.backend.GetConsumerCounter(ctx, trade.SettlementContract)
+	if err != nil {
+		return nil, fmt.Errorf("get consumer counter: %w", err)
+	}
+	if providerCounter.Set && consumerCounter.Set {
+		w.logger.Debugf("Trade %d settled or disputed, no timeout to resolve", trade.Id)
+		return nil, nil
+	}
+
+	settlement, err := w.backend.GetSettlement(ctx, trade.SettlementContract)
+	if err != nil {
+		return nil, fmt.Errorf("get settlement: %w", err)
+	}
+	if *settlement != (Settlement{}) {
+		w.logger.Debugf("Timeout of trade %d already resolved", trade.Id)
+		return nil, nil
+	}
+
+	reason := ReasonNoCounter
+	if providerCounter.Set {
+		reason = ReasonProviderCounter
+	} else if consumerCounter.Set {
+		reason = ReasonConsumerCounter
+	}
+
+	settledCtx, cancel := context.WithTimeout(ctx, w.settledTimeout)
+	defer cancel()
+	settled, settledErrc := w.backend.WatchSettled(settledCtx, trade.SettlementContract)
+
+	w.logger.Infof("Resolving timeout of trade %d by %s", trade.Id, reason)
+	tx, err := w.backend.ResolveTimeout(ctx, trade.SettlementContract)
+	if err != nil {
+		return nil, fmt.Errorf("resolve timeout with contract %s: %w", trade.SettlementContract, err)
+	}
+
+	report := &Report{
+		TradeId:            trade.Id,
+		SettlementContract: trade.SettlementContract,
+		Transaction:        tx,
+		Reason:             reason,
+	}
+	select {
+	case report.Settlement = <-settled:
+	case err := <-settledErrc:
+		w.logger.Warnf("watch settlement of trade %d: %v", trade.Id, err)
+	}
+	if report.Settlement == nil {
+		report.Settlement, err = w.backend.GetSettlement(ctx, trade.SettlementContract)
+		if err != nil {
+			return nil, fmt.Errorf("get settlement: %w", err)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,84 @@
+package watchdog
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+type fakeBackend struct {
+	providerCounter Counter
+	consumerCounter Counter
+	resolved        int
+}
+
+func (b *fakeBackend) GetProviderCounter(ctx context.Context, contract string) (*Counter, error) {
+	return &b.providerCounter, nil
+}
+
+func (b *fakeBackend) GetConsumerCounter(ctx context.Context, contract string) (*Counter, error) {
+	return &b.consumerCounter, nil
+}
+
+func (b *fakeBackend) GetSettlement(ctx context.Context, contract string) (*Settlement, error) {
+	if b.resolved > 0 {
+		return &Settlement{ActualCost: b.providerCounter.Value, Provider: b.providerCounter.Value}, nil
+	}
+	return &Settlement{}, nil
+}
+
+func (b *fakeBackend) ResolveTimeout(ctx context.Context, contract string) (string, error) {
+	b.resolved++
+	return "0x01", nil
+}
+
+func (b *fakeBackend) WatchSettled(ctx context.Context, contract string) (<-chan *Settlement, <-chan error) {
+	errc := make(chan error, 1)
+	errc <- context.Canceled
+	return nil, errc
+}
+
+func newTestWatchdog(backend SettlementBackend) *settlementWatchdogImpl {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	return NewSettlementWatchdogImpl(logger, backend, time.Minute, time.Minute)
+}
+
+// TestDueTradesWaitForContractTimeout checks that timeouts are only resolved once the settlement
+// contract accepts them, one hour after the end of a trade.
+func TestDueTradesWaitForContractTimeout(t *testing.T) {
+	w := newTestWatchdog(&fakeBackend{})
+	end := time.Unix(1600000000, 0)
+	w.Watch(&Trade{Id: 1, EndTime: uint64(end.Unix())})
+
+	if trades := w.dueTrades(end.Add(59 * time.Minute)); len(trades) != 0 {
+		t.Fatalf("%d trades due before the contract timeout", len(trades))
+	}
+	if trades := w.dueTrades(end.Add(time.Hour + time.Second)); len(trades) != 1 {
+		t.Fatalf("%d trades due after the contract timeout, want 1", len(trades))
+	}
+}
+
+func TestResolveTimeout(t *testing.T) {
+	trade := &Trade{Id: 1, SettlementContract: "0x01"}
+
+	disputed := &fakeBackend{providerCounter: Counter{5, true}, consumerCounter: Counter{4, true}}
+	if report, err := newTestWatchdog(disputed).resolveTimeout(context.Background(), trade); err != nil || report != nil {
+		t.Fatalf("resolved timeout of disputed trade, report %v, err %v", report, err)
+	}
+
+	backend := &fakeBackend{providerCounter: Counter{5, true}}
+	w := newTestWatchdog(backend)
+	report, err := w.resolveTimeout(context.Background(), trade)
+	if err != nil {
+		t.Fatalf("resolve timeout: %v", err)
+	}
+	if report.Reason != ReasonProviderCounter || report.Settlement.Provider != 5 {
+		t.Fatalf("report %s, want provider counter 5", report)
+	}
+	if report, err := w.resolveTimeout(context.Background(), trade); err != nil || report != nil || backend.resolved != 1 {
+		t.Fatalf("resolved timeout twice, report %v, err %v", report, err)
+	}
+}
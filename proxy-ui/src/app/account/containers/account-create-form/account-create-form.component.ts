@@ -40,10 +40,10 @@ export class AccountCreateFormComponent implements OnInit {
         const createAccountResponse: CreateAccountResponse.AsObject = await this.accountService.createAccount(createAccountRequest);
         const wallet: domain_wallet_pb.Wallet = new domain_wallet_pb.Wallet();
         wallet.setUserid(createAccountResponse.account.id);
-        wallet.setPassphrase(form.passphrase);
 
         const createWalletRequest = new CreateWalletRequest();
         createWalletRequest.setWallet(wallet);
+        createWalletRequest.setPassphrase(form.passphrase);
 
         await this.walletService.createWallet(createWalletRequest);
         await this.router.navigateByUrl('accounts/management');
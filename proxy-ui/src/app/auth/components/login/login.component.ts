@@ -21,6 +21,7 @@ export class LoginComponent {
       const request = new GetTokenRequest();
       request.setUsername(form.username);
       request.setPassword(new TextEncoder().encode(form.password));
+      request.setTotpcode(form.totpCode || '');
       const response: GetTokenResponse.AsObject = await this.authService.authenticate(request);
       this.authService.setAccessToken(response.token);
       console.log(this.authService.getPrincipal());